@@ -62,9 +62,10 @@ interface SearchUser {
   name: string;
   user_id: string;
   username: string;
-  email: string;
+  email?: string; // only sent for friends
   status?: { valid: boolean; string: string };
   profile_picture?: { valid: boolean; string: string };
+  mutual_friends?: number;
 }
 
 interface SelectOption {
@@ -75,6 +76,7 @@ interface SelectOption {
   email: string;
   status: string | null;
   profilePicture: string | null;
+  mutualFriends: number;
 }
 
 interface AsyncMultiSelectProps {
@@ -84,7 +86,7 @@ interface AsyncMultiSelectProps {
 }
 
 const fetchUsers = async (input: string): Promise<SearchUser[]> => {
-  const res = await fetch(`http://localhost:9000/search?q=${input}`, {
+  const res = await fetch(`http://localhost:9000/api/v1/search?q=${input}`, {
     credentials: "include",
   });
   if (!res.ok) throw new Error("Failed to fetch users");
@@ -109,9 +111,10 @@ const AsyncMultiSelect: React.FC<AsyncMultiSelectProps> = ({ excludeUserIds = []
         value: item.user_id,
         userId: item.user_id,
         username: item.username,
-        email: item.email,
+        email: item.email ?? "",
         status: item.status?.valid ? item.status.string : null,
         profilePicture: item.profile_picture?.valid ? item.profile_picture.string : null,
+        mutualFriends: item.mutual_friends ?? 0,
       }));
     } catch (err) {
       console.error("Search failed:", err);
@@ -145,6 +148,11 @@ const AsyncMultiSelect: React.FC<AsyncMultiSelectProps> = ({ excludeUserIds = []
         <div>
           <div className="font-medium">{data.label}</div>
           <div className="text-blue-500 text-sm">@{data.username}</div>
+          {data.mutualFriends > 0 && (
+            <div className="text-neutral-500 text-xs">
+              {data.mutualFriends} mutual friend{data.mutualFriends === 1 ? "" : "s"}
+            </div>
+          )}
         </div>
       </div>
     );
@@ -183,7 +191,7 @@ export function AppSidebar() {
   //
   const [chatroomsData, chatroomsDataIsLoading] = useDAL<{ chatrooms: UserChatroom[] }>(DAL["chatroom"]["get"])
   const [friendsData, friendsDataIsLoading] = useDAL<Array<Friend>>(DAL["friends"]["get"], 5000)
-  const [notificationsData, notificationsDataIsLoading] = useDAL<Array<Friend>>(DAL["notifications"]["get"], 5000)
+  const [notificationsData, notificationsDataIsLoading] = useDAL<Array<Friend>>(DAL["friends"]["requests"], 5000)
 
   const [friendRequestPatchFn, friendRequestPatchKey] = DAL["friends"]["patch"]
 
@@ -195,7 +203,7 @@ export function AppSidebar() {
         queryKey: DAL["friends"]["get"][1]
       })
       queryClient.invalidateQueries({
-        queryKey: DAL["notifications"]["get"][1]
+        queryKey: DAL["friends"]["requests"][1]
       });
     },
   })
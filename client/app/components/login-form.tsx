@@ -15,7 +15,7 @@ export function LoginForm({
   function onGoogleLoginHandler(e: any) {
     e.preventDefault();
     console.log("Making Request...");
-    window.location.href = "http://localhost:9000/login/oauth";
+    window.location.href = "http://localhost:9000/api/v1/login/oauth/google";
   }
 
   return (
@@ -1,7 +1,36 @@
-import { get } from "@/lib/utils";
+import { get, patch } from "@/lib/utils";
 
 export async function getUserDetails() {
   return get("user");
 }
 
+export async function patchUser(body: { name?: string; username?: string; bio?: string }) {
+  return patch("user", body);
+}
+
+export async function uploadAvatar(file: File) {
+  const form = new FormData();
+  form.append("file", file);
+  const res = await fetch("http://localhost:9000/api/v1/user/avatar", {
+    method: "PUT",
+    credentials: "include",
+    body: form,
+  });
+  return res.json();
+}
+
+// The account is deleted after a grace period, logging in again cancels it
+export async function deleteAccount(confirm: string) {
+  const res = await fetch("http://localhost:9000/api/v1/user", {
+    method: "DELETE",
+    credentials: "include",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ confirm }),
+  });
+  return res.json();
+}
+
+export const exportUrl = "http://localhost:9000/api/v1/user/export";
+
 export const queryKey = ["auth", "/user"];
+export const patchQueryKey = ["auth", "patch"];
@@ -1,12 +1,42 @@
-import { get } from "@/lib/utils";
+import { get, patch } from "@/lib/utils";
 export async function getUserChatrooms() {
-  return get("chatroom");
+  return get("chatrooms");
 }
 
 export async function getChatroomHistory(params: Record<string, string>) {
-  const queryString = new URLSearchParams(params).toString();
-  return get(`chatroom/history?${queryString}`);
+  const { cid, ...query } = params;
+  const queryString = new URLSearchParams(query).toString();
+  return get(`chatrooms/${cid}/history?${queryString}`);
+}
+
+export async function getChatroom(params: Record<string, string>) {
+  return get(`chatrooms/${params.cid}`);
+}
+
+export async function patchChatroom(
+  cid: string,
+  body: {
+    name?: string;
+    description?: string;
+    topic?: string;
+    default_start_url?: string;
+    stream_quality?: "low" | "medium" | "high";
+  }
+) {
+  return patch(`chatrooms/${cid}`, body);
+}
+
+export async function uploadChatroomAvatar(cid: string, file: File) {
+  const form = new FormData();
+  form.append("file", file);
+  const res = await fetch(`http://localhost:9000/api/v1/chatrooms/${cid}/avatar`, {
+    method: "PUT",
+    credentials: "include",
+    body: form,
+  });
+  return res.json();
 }
 
 export const queryKey = ["chatrooms", "get"];
 export const getChatroomHistoryKey = ["chatrooms", "history"]
+export const getChatroomKey = ["chatrooms", "settings"];
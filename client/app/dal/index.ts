@@ -4,12 +4,16 @@ import {
   getChatroomHistory,
   queryKey as getUserChatroomsKey,
   getChatroomHistoryKey,
+  getChatroom,
+  getChatroomKey,
 } from "./chatrooms";
 import {
   getUserFriends,
   queryKey as getUserFriendsKey,
   patchFriendRequest,
   patchQueryKey as patchFriendRequestKeys,
+  getFriendRequests,
+  requestsQueryKey as getFriendRequestsKey,
 } from "./friends";
 import {
   getNotifications,
@@ -28,10 +32,12 @@ export const DAL = {
   chatroom: {
     get: [getUserChatrooms, getUserChatroomsKey],
     history: [getChatroomHistory, getChatroomHistoryKey],
+    settings: [getChatroom, getChatroomKey],
   },
   friends: {
     get: [getUserFriends, getUserFriendsKey],
     patch: [patchFriendRequest, patchFriendRequestKeys],
+    requests: [getFriendRequests, getFriendRequestsKey],
   },
   notifications: {
     get: [getNotifications, getNotificationsKey],
@@ -1,10 +1,10 @@
 import { get, patch } from "@/lib/utils";
 export async function getRemote(chatroomId: string) {
-  return get("remote?cid=" + chatroomId);
+  return get(`chatrooms/${chatroomId}/remote`);
 }
 
-export async function patchRemote(data: object) {
-  return patch(`remote`, data);
+export async function patchRemote(chatroomId: string, data: object) {
+  return patch(`chatrooms/${chatroomId}/remote`, data);
 }
 
 export const getQueryKey = ["remote", "get"];
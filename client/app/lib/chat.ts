@@ -1,22 +1,62 @@
 import type { ChatMessage, ChatMessagePayload } from "./types";
 import type { Message } from "./types";
+import { post } from "./utils";
 
+const RECONNECT_DELAY_MS = 1000;
+
+// Opens the socket and reopens it when it drops, asking for the room events
+// missed since the last seq. Close it with code 1000 to stop reconnecting,
+// the server's auth close codes (4xxx) stop it as well.
 export const createSocket = (
   wsUrl: string,
-  messageHandler: (msg: Message<unknown>) => void
+  messageHandler: (msg: Message<unknown>) => void,
+  onReconnect?: (ws: WebSocket) => void
 ) => {
-  const ws = new WebSocket(wsUrl);
-  ws.onopen = () => console.log("Connected to WebSocket");
-  ws.onmessage = (e: { data: string }) => {
-    const newMsg: Message<unknown> = JSON.parse(e.data);
-    messageHandler(newMsg);
+  let lastSeq = 0;
+
+  const connect = () => {
+    const ws = new WebSocket(lastSeq > 0 ? `${wsUrl}?since=${lastSeq}` : wsUrl);
+    ws.onopen = () => console.log("Connected to WebSocket");
+    ws.onmessage = (e: { data: string }) => {
+      const newMsg: Message<unknown> = JSON.parse(e.data);
+      if (newMsg.seq && newMsg.seq > lastSeq) {
+        lastSeq = newMsg.seq;
+      }
+      if (newMsg.subject === "auth.expiring") {
+        refreshSocketAuth(ws);
+        return;
+      }
+      messageHandler(newMsg);
+    };
+    ws.onerror = (err: Event) => console.error("Error:", err);
+    ws.onclose = (e: CloseEvent) => {
+      console.log("Disconnected from WebSocket");
+      if (e.code === 1000 || e.code >= 4000) return;
+      setTimeout(() => onReconnect?.(connect()), RECONNECT_DELAY_MS);
+    };
+    return ws;
   };
-  ws.onerror = (err: Event) => console.error("Error:", err);
-  ws.onclose = () => console.log("Disconnected from WebSocket");
 
-  return ws;
+  return connect();
 };
 
+// The server closes sockets whose access token expired. The socket can't
+// read the refreshed cookie, it is handed a ticket for it instead.
+async function refreshSocketAuth(ws: WebSocket) {
+  const refreshed = await post("auth/refresh", {});
+  if (!refreshed?.user_id) return;
+  const res = await post("auth/ws-ticket", {});
+  if (!res?.ticket || ws.readyState !== WebSocket.OPEN) return;
+
+  ws.send(
+    JSON.stringify({
+      sender: refreshed.user_id,
+      subject: "auth.refresh",
+      payload: { ticket: res.ticket },
+    })
+  );
+}
+
 export function NewStreamMessage(
   senderId: string,
   chatroomId: string,
@@ -17,6 +17,13 @@ export type Message<T> = {
   subject: string;
   sender: string;
   payload: T;
+  // Set on room events, the socket resumes after the last one it got
+  seq?: number;
+};
+
+export type RoomResumedPayload = {
+  since: number;
+  gap: boolean;
 };
 
 export type ChatMessage = {
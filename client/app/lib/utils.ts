@@ -6,7 +6,7 @@ export function cn(...inputs: ClassValue[]) {
 }
 
 export async function get(endpoint: string) {
-  const host = "http://localhost:9000";
+  const host = "http://localhost:9000/api/v1";
   try {
     const res = await fetch(host + "/" + endpoint, {
       method: "GET",
@@ -21,7 +21,7 @@ export async function get(endpoint: string) {
 }
 
 export async function post(endpoint: string, body: object) {
-  const host = "http://localhost:9000";
+  const host = "http://localhost:9000/api/v1";
   try {
     const res = await fetch(`${host}/${endpoint}`, {
       method: "POST",
@@ -42,7 +42,7 @@ export async function post(endpoint: string, body: object) {
 }
 
 export async function patch<T extends object>(endpoint: string, body: T) {
-  const host = "http://localhost:9000";
+  const host = "http://localhost:9000/api/v1";
   try {
     const res = await fetch(`${host}/${endpoint}`, {
       method: "PATCH",
@@ -25,8 +25,8 @@ export const links: Route.LinksFunction = () => [
   },
 ];
 
-export const WS_URL = "ws://localhost:9000/chat";
-export const API_URL = "http://localhost:9000";
+export const WS_URL = "ws://localhost:9000/api/v1";
+export const API_URL = "http://localhost:9000/api/v1";
 export const queryClient = new QueryClient();
 
 export function Layout({ children }: { children: React.ReactNode }) {
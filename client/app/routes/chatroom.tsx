@@ -1,11 +1,17 @@
 import React from "react";
 import { InteractivityPad } from "@/components/interactivity-pad";
-import { get } from "@/lib/utils";
+import { post } from "@/lib/utils";
 import { Anchor, Phone, PhoneOff } from "lucide-react";
 import type { Route } from "./+types/home";
 import { createSocket, NewChatMessage } from "@/lib/chat";
 
-import type { ChatMessage, Message, RemoteResponse, User } from "@/lib/types";
+import type {
+  ChatMessage,
+  Message,
+  RemoteResponse,
+  RoomResumedPayload,
+  User,
+} from "@/lib/types";
 import { Button } from "@/components/ui/button";
 import { useEffect, useState, useRef } from "react";
 import { Chat } from "@/components/chat";
@@ -96,7 +102,7 @@ export default function Page() {
     useState<boolean>(false);
   const { data: streamResponse, isLoading: streamResponseIsLoading } = useQuery(
     {
-      queryFn: () => get("stream?cid=" + chatroomId),
+      queryFn: () => post(`chatrooms/${chatroomId}/stream`, {}),
       queryKey: ["stream", chatroomId],
       enabled: false,
     }
@@ -179,6 +185,14 @@ export default function Page() {
       if (!socket.current) return;
       if (!userId) return;
 
+      // Some events expired before the socket came back, reload the history
+      if (sub === "room.resumed") {
+        if ((msg as Message<RoomResumedPayload>).payload.gap) {
+          queryClient.invalidateQueries({ queryKey: chk });
+        }
+        return;
+      }
+
       if (sub.startsWith("chat")) {
         const chatroomId = msg.subject.split(".")[1];
         if (!chatroomId || chatroomId == "") {
@@ -439,14 +453,17 @@ export default function Page() {
       typeof window !== "undefined"
     ) {
       socket.current = createSocket(
-        WS_URL + "?cid=" + chatroomId,
-        messageHandler
+        `${WS_URL}/ws`,
+        messageHandler,
+        (ws) => {
+          socket.current = ws;
+        }
       );
     }
 
     return () => {
       if (socket.current) {
-        socket.current.close();
+        socket.current.close(1000);
         rtcPeerConn.current = null;
       }
     };
@@ -12,7 +12,7 @@ export function meta({ }: Route.MetaArgs) {
 }
 
 async function fetchHealth() {
-  const res = await fetch("http://localhost:9000/health")
+  const res = await fetch("http://localhost:9000/api/v1/health")
   const body = await res.json()
   return body
 }
@@ -2,25 +2,56 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io/fs"
 	"log"
 	"os"
 	"sideDesert/shiba/internal/server"
 	"sideDesert/shiba/internal/server/services"
+	"strconv"
 
 	"github.com/joho/godotenv"
+	"github.com/nats-io/nats.go"
 )
 
 func main() {
 	ctx := context.Background()
+	// The environment can come from the process alone, e.g. when several
+	// nodes run side by side
 	err := godotenv.Load(".env")
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		panic(err)
 	}
 
 	var dbUrl = os.Getenv("DB_URL")
 
+	// shiba migrate up|down [steps]|status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, dbUrl, os.Args[2:]); err != nil {
+			log.Fatal("❌ migrate: ", err)
+		}
+		return
+	}
+
+	// Migrations run on startup unless DB_AUTO_MIGRATE=false
+	autoMigrate, err := strconv.ParseBool(os.Getenv("DB_AUTO_MIGRATE"))
+	if err != nil {
+		autoMigrate = true
+	}
+
+	addr := os.Getenv("SHIBA_ADDR")
+	if addr == "" {
+		addr = ":9000"
+	}
+	natsUrl := os.Getenv("NATS_URL")
+	if natsUrl == "" {
+		natsUrl = nats.DefaultURL
+	}
+
 	config := &services.ServerConfig{
-		DbUrl: dbUrl,
+		DbUrl:       dbUrl,
+		AutoMigrate: autoMigrate,
+		NatsUrl:     natsUrl,
 	}
 
 	server, err := server.NewServer(ctx, config)
@@ -29,7 +60,7 @@ func main() {
 		panic(err)
 	}
 
-	defer server.CloseDbConn(ctx)
-	server.Run(":9000")
+	defer server.Close(ctx)
+	server.Run(addr)
 	log.Println("✅ main() exited successfully")
 }
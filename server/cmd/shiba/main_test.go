@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+)
+
+// Runs two shiba processes against the same database and NATS server, the way
+// they run behind a load balancer. Set SHIBA_TEST_DB_URL and
+// SHIBA_TEST_NATS_URL (a server with JetStream) to run it.
+func TestMultipleNodes(t *testing.T) {
+	dbUrl, natsUrl := os.Getenv("SHIBA_TEST_DB_URL"), os.Getenv("SHIBA_TEST_NATS_URL")
+	if dbUrl == "" || natsUrl == "" {
+		t.Skip("SHIBA_TEST_DB_URL or SHIBA_TEST_NATS_URL not set")
+	}
+
+	bin := filepath.Join(t.TempDir(), "shiba")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	nodeA := startNode(t, bin, "node-a", dbUrl, natsUrl)
+	nodeB := startNode(t, bin, "node-b", dbUrl, natsUrl)
+
+	suffix := fmt.Sprint(time.Now().UnixNano() % 1_000_000_000)
+	ada := newNodeClient(t, nodeA, "ada"+suffix)
+	bob := newNodeClient(t, nodeB, "bob"+suffix)
+
+	// A friend request sent on node A notifies bob on node B
+	bobSocket := bob.dial(t)
+	sent := dto.FriendResponse{}
+	ada.do(t, http.MethodPost, "/friends", dto.SendFriendRequest{FriendId: bob.userId}, &sent)
+	readUntil(t, bobSocket, func(msg dto.Message[json.RawMessage]) bool { return msg.Subject == "notification" })
+
+	accepted := dto.FriendResponse{}
+	bob.do(t, http.MethodPatch, "/friends", dto.FriendStatusRequest{Id: sent.RequestId, Status: "accepted"}, &accepted)
+	if accepted.ChatroomId == "" {
+		t.Fatalf("accepting the request gave %+v", accepted)
+	}
+
+	// A chat message sent on node A reaches bob on node B. The sockets are
+	// opened after the room exists so they watch it.
+	bobSocket = bob.dial(t)
+	adaSocket := ada.dial(t)
+	adaSocket.WriteJSON(dto.Message[dto.ChatMessagePayload]{
+		Subject: "chat." + accepted.ChatroomId,
+		Payload: dto.ChatMessagePayload{Content: "hello from node a"},
+	})
+	readUntil(t, bobSocket, func(msg dto.Message[json.RawMessage]) bool {
+		return strings.HasPrefix(msg.Subject, "chat") && strings.Contains(string(msg.Payload), "hello from node a")
+	})
+
+	// A stream started on node A is registered for every node, and the stream
+	// commands of bob's socket on node B reach the worker streaming the room
+	worker := startTestWorker(t, natsUrl, "worker-"+suffix)
+	room := dto.CreateChatRoomResponse{}
+	ada.do(t, http.MethodPost, "/chatrooms", dto.CreateChatRoomRequest{Name: "movies", Participants: []string{bob.userId}}, &room)
+	bobSocket = bob.dial(t)
+	commands := worker.commands(t, room.ChatRoomId)
+
+	// The nodes learn of the worker from its status
+	deadline := time.Now().Add(10 * time.Second)
+	for ada.status(t, http.MethodPost, "/chatrooms/"+room.ChatRoomId+"/stream", nil) != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("node A never started the stream")
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	adaOnB := &nodeClient{addr: nodeB, jar: ada.jar, http: ada.http, userId: ada.userId}
+	if status := adaOnB.status(t, http.MethodPost, "/chatrooms/"+room.ChatRoomId+"/stream", nil); status != http.StatusConflict {
+		t.Errorf("starting the stream again on node B gave %d, want %d", status, http.StatusConflict)
+	}
+
+	// Every socket of bob joins, the worker offers each of them a peer
+	readUntil(t, bobSocket, func(msg dto.Message[json.RawMessage]) bool {
+		return msg.Subject == "stream.offer."+room.ChatRoomId+"."+bob.userId
+	})
+	bobSocket.WriteJSON(dto.Message[map[string]string]{
+		Subject: "stream.answer." + room.ChatRoomId,
+		Payload: map[string]string{"sdp": "v=0"},
+	})
+	readCommand(t, commands, func(cmd dto.StreamCommand) bool { return cmd.Type == "answer" && cmd.UserId == bob.userId })
+}
+
+// testWorker stands in for cmd/vbrowser, it takes every request and streams
+// nothing
+type testWorker struct {
+	id string
+	nc *nats.Conn
+
+	mu    sync.Mutex
+	rooms []string
+}
+
+func startTestWorker(t *testing.T, natsUrl string, id string) *testWorker {
+	t.Helper()
+	nc, err := nats.Connect(natsUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	w := &testWorker{id: id, nc: nc}
+
+	_, err = nc.Subscribe(lib.WorkerSubject(id, "*"), func(msg *nats.Msg) {
+		if strings.HasSuffix(msg.Subject, ".start") {
+			start := dto.WorkerStartRequest{}
+			json.Unmarshal(msg.Data, &start)
+			w.mu.Lock()
+			w.rooms = append(w.rooms, start.ChatroomId)
+			w.mu.Unlock()
+
+			join, _ := json.Marshal(dto.StreamJoinRequest{ChatroomId: start.ChatroomId, MemberIds: start.MemberIds})
+			defer nc.Publish(lib.StreamJoinSubject(start.ChatroomId), join)
+		}
+		reply, _ := json.Marshal(dto.WorkerReply{})
+		msg.Respond(reply)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			w.mu.Lock()
+			status, _ := json.Marshal(dto.WorkerStatus{WorkerId: id, Capacity: 1, Rooms: w.rooms, Healthy: true})
+			w.mu.Unlock()
+			nc.Publish(lib.WorkerStatusSubject, status)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return w
+}
+
+// commands forwards the stream commands of chatroomId to a channel and
+// offers a peer to every socket that joins
+func (w *testWorker) commands(t *testing.T, chatroomId string) chan *nats.Msg {
+	t.Helper()
+	ch := make(chan *nats.Msg, 64)
+	_, err := w.nc.Subscribe(lib.StreamCommandsSubject(chatroomId), func(msg *nats.Msg) {
+		cmd := dto.StreamCommand{}
+		if json.Unmarshal(msg.Data, &cmd) == nil && cmd.Type == "join" {
+			w.offer(cmd)
+		}
+		ch <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+// offer answers a join with an offer on the subject of the joining socket
+func (w *testWorker) offer(cmd dto.StreamCommand) {
+	offer, _ := json.Marshal(dto.Message[map[string]string]{
+		Sender:  "server",
+		Subject: "stream.offer." + cmd.ChatroomId + "." + cmd.UserId,
+		Payload: map[string]string{"sdp": "v=0"},
+	})
+	w.nc.Publish(lib.ConnSubject(cmd.ConnId), offer)
+}
+
+func readCommand(t *testing.T, ch chan *nats.Msg, match func(cmd dto.StreamCommand) bool) dto.StreamCommand {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg := <-ch:
+			cmd := dto.StreamCommand{}
+			if json.Unmarshal(msg.Data, &cmd) == nil && match(cmd) {
+				return cmd
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a stream command")
+		}
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startNode runs a shiba process and returns its address once it is healthy
+func startNode(t *testing.T, bin string, name string, dbUrl string, natsUrl string) string {
+	t.Helper()
+	addr := freeAddr(t)
+	cmd := exec.Command(bin)
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(),
+		"DB_URL="+dbUrl,
+		"NATS_URL="+natsUrl,
+		"SHIBA_ADDR="+addr,
+	)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		res, err := http.Get("http://" + addr + "/api/v1/health")
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return addr
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not come up on %s: %v", name, addr, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// nodeClient is a user signed in on one node
+type nodeClient struct {
+	addr   string
+	jar    http.CookieJar
+	http   *http.Client
+	userId string
+}
+
+func newNodeClient(t *testing.T, addr string, username string) *nodeClient {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	c := &nodeClient{addr: addr, jar: jar, http: &http.Client{Jar: jar}}
+
+	signup := dto.SignupUserRequest{
+		Name:     username,
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "correct horse battery",
+	}
+	c.do(t, http.MethodPost, "/signup", signup, nil)
+	login := dto.LoginResponse{}
+	c.do(t, http.MethodPost, "/login", dto.LoginRequest{Email: signup.Email, Password: signup.Password}, &login)
+	c.userId = login.UserId
+	return c
+}
+
+func (c *nodeClient) send(t *testing.T, method string, path string, body any) *http.Response {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(method, "http://"+c.addr+"/api/v1"+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.http.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func (c *nodeClient) status(t *testing.T, method string, path string, body any) int {
+	t.Helper()
+	res := c.send(t, method, path, body)
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func (c *nodeClient) do(t *testing.T, method string, path string, body any, out any) {
+	t.Helper()
+	res := c.send(t, method, path, body)
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s gave %s", method, path, res.Status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (c *nodeClient) dial(t *testing.T) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Jar: c.jar, HandshakeTimeout: 5 * time.Second}
+	conn, _, err := dialer.Dial("ws://"+c.addr+"/api/v1/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readUntil(t *testing.T, conn *websocket.Conn, match func(msg dto.Message[json.RawMessage]) bool) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for a message: %v", err)
+		}
+		msg := dto.Message[json.RawMessage]{}
+		if json.Unmarshal(data, &msg) == nil && match(msg) {
+			return
+		}
+	}
+}
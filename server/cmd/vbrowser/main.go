@@ -1,71 +1,65 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"io/fs"
 	"log"
-
-	// "net"
 	"os"
-	"os/exec"
 	"os/signal"
-	vb "sideDesert/shiba/internal/vbrowser"
+	"strconv"
 	"syscall"
-	// "github.com/pion/rtp"
-	// "github.com/pion/webrtc/v4"
+
+	"sideDesert/shiba/internal/server/lib"
+	vb "sideDesert/shiba/internal/vbrowser"
+	"sideDesert/shiba/internal/worker"
+
+	"github.com/joho/godotenv"
+	"github.com/nats-io/nats.go"
 )
 
+// A streaming worker: it runs the virtual browser and the media pipeline for
+// the chatrooms the API nodes hand it over NATS.
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "--pipeline" {
-		runPipeline()
-		return
+	err := godotenv.Load(".env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		panic(err)
 	}
 
-	fmt.Println("Starting Browser Session...")
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Spawn pipeline subprocess
-	cmd := exec.Command(os.Args[0], "--pipeline")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Start(); err != nil {
-		log.Fatal("❌ Failed to start pipeline subprocess:", err)
+	natsUrl := os.Getenv("NATS_URL")
+	if natsUrl == "" {
+		natsUrl = nats.DefaultURL
+	}
+	// The X display the browser runs on
+	display, err := strconv.Atoi(os.Getenv("WORKER_DISPLAY"))
+	if err != nil {
+		display = 99
 	}
 
-	// Only kill subprocess on Ctrl+C
-	<-sigChan
-	fmt.Println("Shutting Down...")
-	_ = cmd.Process.Kill()
-
-}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-func runPipeline() {
-	manager := vb.NewManager(99)
+	nc, err := nats.Connect(natsUrl, append(lib.NatsAuth(), nats.Name("shiba-vbrowser"))...)
+	if err != nil {
+		log.Fatal("❌ Failed to connect to NATS: ", err)
+	}
+	defer nc.Close()
 
-	err := manager.SetupPipeline()
+	// The API nodes check the url a user opens, the proxy checks every
+	// address the page connects to
+	proxy, err := worker.ListenEgressProxy("127.0.0.1:0", os.Getenv("BROWSER_URL_ALLOW_PRIVATE") == "true")
 	if err != nil {
-		log.Fatal("❌ Pipeline setup failed:", err)
+		log.Fatal("❌ Failed to start the egress proxy: ", err)
 	}
+	defer proxy.Close()
 
-	log.Println("✅ Pipeline Running...")
+	browser := vb.NewManager(display)
+	browser.ProxyUrl = proxy.Url()
 
-	// ⏸️ Keep this process alive while the pipeline runs
-	select {}
+	// Generated from the hostname when WORKER_ID is empty
+	w := worker.New(nc, os.Getenv("WORKER_ID"), browser)
+	if err := w.Run(ctx); err != nil {
+		log.Fatal("❌ Worker stopped: ", err)
+	}
+	log.Println("✅ main() exited successfully")
 }
-
-// func main() {
-// 	fmt.Println("Starting Browser Session...")
-
-// 	sigChan := make(chan os.Signal, 1)
-// 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-
-// 	manager := vb.NewManager(99)
-
-// 	// go manager.StartVirtualBrowser(ctx)
-// 	manager.SetupPipeline()
-
-// 	<-sigChan
-// 	fmt.Println("Shutting Down...")
-// }
@@ -13,6 +13,7 @@ require (
 	github.com/nats-io/nats.go v1.40.0
 	github.com/pion/rtp v1.8.13
 	github.com/pion/webrtc/v4 v4.0.14
+	golang.org/x/net v0.35.0
 )
 
 require (
@@ -39,7 +40,6 @@ require (
 	github.com/pion/turn/v4 v4.0.0 // indirect
 	github.com/wlynxg/anet v0.0.5 // indirect
 	golang.org/x/exp v0.0.0-20240909161429-701f63a606c0 // indirect
-	golang.org/x/net v0.35.0 // indirect
 	golang.org/x/sync v0.12.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
 )
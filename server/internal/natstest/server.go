@@ -0,0 +1,194 @@
+// Package natstest runs a NATS server for tests in process
+package natstest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Server speaks enough of the core NATS protocol for the tests: SUB (with
+// queue groups), UNSUB, PUB with a reply subject and PING. There is no
+// JetStream.
+type Server struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	subs map[*client]map[string]subscription // sid -> subscription
+}
+
+type subscription struct {
+	subject string
+	queue   string
+}
+
+type client struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (c *client) send(format string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.conn, format, args...)
+}
+
+// NewServer listens on a free local port until the test ends
+func NewServer(t *testing.T) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{ln: ln, subs: make(map[*client]map[string]subscription)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// Connect opens a connection closed at the end of the test
+func (s *Server) Connect(t *testing.T) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect("nats://" + s.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// Subscriptions counts the subscriptions the server holds for all clients
+func (s *Server) Subscriptions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, subs := range s.subs {
+		n += len(subs)
+	}
+	return n
+}
+
+func (s *Server) serve(conn net.Conn) {
+	c := &client{conn: conn}
+	s.mu.Lock()
+	s.subs[c] = make(map[string]subscription)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, c)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	// Without headers the client sends no-responders requests the old way
+	c.send("INFO {\"server_id\":\"test\",\"version\":\"2.10.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n")
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			c.send("PONG\r\n")
+		case "SUB":
+			// SUB <subject> [queue] <sid>
+			sub := subscription{subject: fields[1]}
+			if len(fields) == 4 {
+				sub.queue = fields[2]
+			}
+			s.mu.Lock()
+			s.subs[c][fields[len(fields)-1]] = sub
+			s.mu.Unlock()
+		case "UNSUB":
+			s.mu.Lock()
+			delete(s.subs[c], fields[1])
+			s.mu.Unlock()
+		case "PUB":
+			// PUB <subject> [reply] <size>
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			reply := ""
+			if len(fields) == 4 {
+				reply = fields[2]
+			}
+			s.publish(c, fields[1], reply, payload[:size])
+		}
+	}
+}
+
+// publish delivers to every matching subscription and one member of each
+// queue group. A request nobody listens to gets a no-responders status back.
+func (s *Server) publish(from *client, subject string, reply string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivered := false
+	queues := make(map[string]bool)
+	for c, subs := range s.subs {
+		for sid, sub := range subs {
+			if !subjectMatches(sub.subject, subject) {
+				continue
+			}
+			if sub.queue != "" {
+				if queues[sub.queue] {
+					continue
+				}
+				queues[sub.queue] = true
+			}
+			delivered = true
+			if reply != "" {
+				c.send("MSG %s %s %s %d\r\n%s\r\n", subject, sid, reply, len(payload), payload)
+			} else {
+				c.send("MSG %s %s %d\r\n%s\r\n", subject, sid, len(payload), payload)
+			}
+		}
+	}
+
+	if !delivered && reply != "" {
+		for sid, sub := range s.subs[from] {
+			if subjectMatches(sub.subject, reply) {
+				header := "NATS/1.0 503\r\n\r\n"
+				from.send("HMSG %s %s %d %d\r\n%s\r\n", reply, sid, len(header), len(header), header)
+			}
+		}
+	}
+}
+
+func subjectMatches(pattern string, subject string) bool {
+	p, s := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, token := range p {
+		if token == ">" {
+			return len(s) > i
+		}
+		if i >= len(s) || (token != "*" && token != s[i]) {
+			return false
+		}
+	}
+	return len(p) == len(s)
+}
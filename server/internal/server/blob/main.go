@@ -0,0 +1,45 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store is the storage backend used for user uploaded files (attachments,
+// thumbnails, avatars). Keys are slash separated paths such as
+// "attachments/<chatroomId>/<id>".
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStoreFromEnv picks the backend from BLOB_BACKEND ("local" or "s3").
+// Local disk is the default so that a dev setup works without extra config.
+func NewStoreFromEnv() (Store, error) {
+	backend := os.Getenv("BLOB_BACKEND")
+
+	switch backend {
+	case "s3":
+		log.Println("Using S3 blob store with bucket", os.Getenv("S3_BUCKET"))
+		return NewS3Store(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "./data/blobs"
+		}
+		log.Println("Using local blob store at", dir)
+		return NewLocalStore(dir)
+	}
+}
@@ -0,0 +1,185 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 keeps objects in memory and checks the SigV4 signature of every
+// request the way S3 does, from what arrives on the wire
+type fakeS3 struct {
+	t         *testing.T
+	accessKey string
+	secretKey string
+	region    string
+
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.verify(r) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("SignatureDoesNotMatch"))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = string(data)
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(data))
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) verify(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	amzDate := r.Header.Get("x-amz-date")
+	when, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(when).Abs() > 15*time.Minute {
+		return false
+	}
+	date := amzDate[:8]
+	scope := date + "/" + f.region + "/s3/aws4_request"
+	prefix := "AWS4-HMAC-SHA256 Credential=" + f.accessKey + "/" + scope + ", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="
+	signature, ok := strings.CutPrefix(auth, prefix)
+	if !ok {
+		return false
+	}
+
+	canonical := r.Method + "\n" +
+		canonicalPath(r.URL.Path) + "\n" +
+		r.URL.RawQuery + "\n" +
+		"host:" + r.Host + "\n" +
+		"x-amz-content-sha256:" + r.Header.Get("x-amz-content-sha256") + "\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"\n" +
+		"host;x-amz-content-sha256;x-amz-date\n" +
+		r.Header.Get("x-amz-content-sha256")
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac(mac(mac(mac([]byte("AWS4"+f.secretKey), date), f.region), "s3"), "aws4_request")
+	return hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac(key, toSign))))
+}
+
+// canonicalPath encodes every byte but the unreserved characters and "/",
+// as the SigV4 documentation spells out
+func canonicalPath(path string) string {
+	const unreserved = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._~/"
+	b := strings.Builder{}
+	for _, c := range []byte(path) {
+		if strings.IndexByte(unreserved, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, string) {
+	f := &fakeS3{t: t, accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG", region: "eu-west-1", objects: make(map[string]string)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv.URL
+}
+
+func TestS3Store(t *testing.T) {
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+	store, err := NewS3Store(S3Config{
+		Endpoint:  endpoint + "/",
+		Region:    fake.region,
+		Bucket:    "shiba",
+		AccessKey: fake.accessKey,
+		SecretKey: fake.secretKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys are escaped the same way in the url and the signature
+	key := "attachments/room 1/photo+1@2x.png"
+	if err := store.Put(ctx, key, strings.NewReader("hello"), 5, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["/shiba/"+key]; !ok {
+		t.Errorf("the object landed at %v", fake.objects)
+	}
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "hello" {
+		t.Errorf("Get = %q", data)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete gave %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3StoreWrongSecret(t *testing.T) {
+	fake, endpoint := newFakeS3(t)
+	store, err := NewS3Store(S3Config{
+		Endpoint:  endpoint,
+		Region:    fake.region,
+		Bucket:    "shiba",
+		AccessKey: fake.accessKey,
+		SecretKey: "not-the-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(context.Background(), "a", strings.NewReader("x"), 1, "text/plain")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("a request signed with the wrong secret gave %v", err)
+	}
+}
+
+func TestNewS3Store(t *testing.T) {
+	if _, err := NewS3Store(S3Config{}); err == nil {
+		t.Error("a store without a bucket was created")
+	}
+	store, err := NewS3Store(S3Config{Bucket: "shiba"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.objectURL("/a/b"); got != "https://s3.amazonaws.com/shiba/a/b" {
+		t.Errorf("objectURL = %q", got)
+	}
+	if store.config.Region != "us-east-1" {
+		t.Errorf("region = %q", store.config.Region)
+	}
+}
@@ -0,0 +1,282 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var pathParamRe = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+
+// OpenAPI builds an OpenAPI 3 document for the route table. Schemas are
+// generated from the dto types of each route by reflection, using the json
+// tags of the fields.
+func OpenAPI(title string, version string, prefix string, routes []*Route) map[string]any {
+	g := &schemaGen{schemas: map[string]any{}}
+	paths := map[string]map[string]any{}
+
+	for _, route := range routes {
+		path := pathParamRe.ReplaceAllString(prefix+route.Path, "{$1}")
+		op := map[string]any{
+			"operationId": operationId(route.Method, route.Path),
+			"responses":   g.responses(route),
+		}
+		if route.Summary != "" {
+			op["summary"] = route.Summary
+		}
+		if tag := strings.Split(strings.TrimPrefix(route.Path, "/"), "/")[0]; tag != "" {
+			op["tags"] = []string{tag}
+		}
+
+		params := []map[string]any{}
+		for _, m := range pathParamRe.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, q := range route.Query {
+			params = append(params, map[string]any{
+				"name": q, "in": "query",
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if route.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(route.Request))},
+				},
+			}
+		}
+		if route.Protected {
+			op["security"] = []map[string][]string{{"cookieAuth": {}}}
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": title, "version": version},
+		"servers": []map[string]any{{"url": "/"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"cookieAuth": map[string]any{"type": "apiKey", "in": "cookie", "name": "shiba-auth-token"},
+			},
+		},
+	}
+}
+
+func operationId(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.Split(path, "/") {
+		part = pathParamRe.ReplaceAllString(part, "by_$1")
+		part = strings.NewReplacer("-", "_", ".", "_").Replace(part)
+		if part != "" {
+			id += "_" + part
+		}
+	}
+	return id
+}
+
+type schemaGen struct {
+	schemas map[string]any
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (g *schemaGen) responses(route *Route) map[string]any {
+	ok := map[string]any{"description": "OK"}
+	if route.Response != nil {
+		ok["content"] = map[string]any{
+			"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(route.Response))},
+		}
+	}
+
+	errorBody := map[string]any{
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/ApiError"}},
+		},
+	}
+	g.schemas["ApiError"] = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"status":  map[string]any{"type": "integer"},
+			"code":    map[string]any{"type": "string"},
+			"error":   map[string]any{"type": "string"},
+			"details": map[string]any{"type": "object", "additionalProperties": true},
+		},
+	}
+
+	res := map[string]any{
+		strconv.Itoa(http.StatusOK): ok,
+		"default":                   withDescription(errorBody, "Error"),
+	}
+	if route.Protected {
+		res[strconv.Itoa(http.StatusUnauthorized)] = withDescription(errorBody, "Not logged in or session expired")
+	}
+	return res
+}
+
+func withDescription(m map[string]any, description string) map[string]any {
+	c := map[string]any{"description": description}
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawJSONType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structRef(t)
+	}
+	return map[string]any{}
+}
+
+// structRef registers a named struct under components and refers to it, so
+// recursive types terminate
+func (g *schemaGen) structRef(t reflect.Type) map[string]any {
+	name := schemaName(t)
+	if name == "" {
+		return g.structSchema(t)
+	}
+	if _, ok := g.schemas[name]; !ok {
+		g.schemas[name] = map[string]any{}
+		g.schemas[name] = g.structSchema(t)
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	required := []string{}
+	g.addFields(t, props, &required)
+
+	schema := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// applyRules documents the lib.Validate rules of a field
+func applyRules(schema map[string]any, tag string) bool {
+	required, dived := false, false
+	target := schema
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = required || !dived
+		case "dive":
+			items, ok := schema["items"].(map[string]any)
+			if !ok {
+				return required
+			}
+			target, dived = items, true
+		case "email":
+			target["format"] = "email"
+		case "uuid":
+			target["format"] = "uuid"
+		case "oneof":
+			target["enum"] = strings.Fields(arg)
+		case "min", "max":
+			n, _ := strconv.Atoi(arg)
+			key := map[string]string{"string": "Length", "array": "Items"}[fmt.Sprint(target["type"])]
+			if key == "" {
+				key = map[string]string{"min": "minimum", "max": "maximum"}[name]
+			} else {
+				key = name + key
+			}
+			target[key] = n
+		}
+	}
+	return required
+}
+
+func (g *schemaGen) addFields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(ft, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		schema := g.schema(f.Type)
+		if tag := f.Tag.Get("validate"); tag != "" && schema["$ref"] == nil {
+			if applyRules(schema, tag) {
+				*required = append(*required, name)
+			}
+		}
+		props[name] = schema
+	}
+}
+
+func schemaName(t reflect.Type) string {
+	if t.Name() == "" {
+		return ""
+	}
+	return strings.NewReplacer("[", "_", "]", "", "/", "_", ".", "_", "*", "", ",", "_").Replace(t.Name())
+}
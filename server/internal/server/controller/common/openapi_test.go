@@ -0,0 +1,77 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	Id      string    `json:"id"`
+	Count   int       `json:"count,omitempty"`
+	Created time.Time `json:"created_at"`
+	Secret  string    `json:"-"`
+	Child   *testItem `json:"child"`
+}
+
+type testRequest struct {
+	Name  string     `json:"name"`
+	Items []testItem `json:"items"`
+}
+
+func noop(w http.ResponseWriter, r *http.Request) error { return nil }
+
+func TestOpenAPI(t *testing.T) {
+	routes := []*Route{
+		NewRoute(http.MethodGet, "/items/{id}", noop, true).Params("page").Returns(testItem{}),
+		NewRoute(http.MethodPost, "/items", noop, false).Body(testRequest{}).Returns([]testItem{}),
+	}
+
+	doc := OpenAPI("Test", "1", "/api/v1", routes)
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	paths := doc["paths"].(map[string]map[string]any)
+	get, ok := paths["/api/v1/items/{id}"]["get"].(map[string]any)
+	if !ok {
+		t.Fatalf("missing GET /api/v1/items/{id}: %s", out)
+	}
+	if _, ok := get["security"]; !ok {
+		t.Error("protected route has no security requirement")
+	}
+	params := get["parameters"].([]map[string]any)
+	if len(params) != 2 || params[0]["in"] != "path" || params[1]["name"] != "page" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+
+	post := paths["/api/v1/items"]["post"].(map[string]any)
+	if _, ok := post["security"]; ok {
+		t.Error("public route has a security requirement")
+	}
+	if _, ok := post["requestBody"]; !ok {
+		t.Error("missing request body")
+	}
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	item, ok := schemas["testItem"].(map[string]any)
+	if !ok {
+		t.Fatalf("missing testItem schema: %s", out)
+	}
+	props := item["properties"].(map[string]any)
+	for _, name := range []string{"id", "count", "created_at", "child"} {
+		if _, ok := props[name]; !ok {
+			t.Errorf("testItem is missing %q", name)
+		}
+	}
+	if _, ok := props["Secret"]; ok {
+		t.Error(`field tagged json:"-" is documented`)
+	}
+	if !strings.Contains(out, `"format":"date-time"`) {
+		t.Error("time.Time is not a date-time string")
+	}
+}
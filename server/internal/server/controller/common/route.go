@@ -0,0 +1,54 @@
+package common
+
+import (
+	"net/http"
+)
+
+type _Handler func(http.ResponseWriter, *http.Request) error
+
+// Route is one entry of the route table. Request and Response are zero
+// values of the dto types sent and returned, they are only used to document
+// the route in the OpenAPI document.
+type Route struct {
+	Method    string
+	Path      string
+	Handler   _Handler
+	Protected bool
+	Summary   string
+	Query     []string
+	Request   any
+	Response  any
+}
+
+func NewRoute(method string, path string, handler _Handler, protected bool) *Route {
+	return &Route{
+		Method:    method,
+		Path:      path,
+		Handler:   handler,
+		Protected: protected,
+	}
+}
+
+// Doc sets the summary shown in the OpenAPI document
+func (r *Route) Doc(summary string) *Route {
+	r.Summary = summary
+	return r
+}
+
+// Params documents the query parameters of the route
+func (r *Route) Params(names ...string) *Route {
+	r.Query = names
+	return r
+}
+
+// Body documents the JSON body of the request
+func (r *Route) Body(v any) *Route {
+	r.Request = v
+	return r
+}
+
+// Returns documents the JSON body of the response
+func (r *Route) Returns(v any) *Route {
+	r.Response = v
+	return r
+}
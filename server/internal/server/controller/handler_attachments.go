@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"sideDesert/shiba/internal/server/lib"
+)
+
+// POST /chatrooms/{id}/attachments with a multipart "file" field
+func (c *Controller) handleAttachmentUpload(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+	chatroomId := pathParam(r, "id")
+
+	// Leave some room for the multipart headers on top of the file itself
+	maxBytes := c.s.AttachmentLimits().MaxBytes
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+(1<<20))
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		log.Println("Error in handleAttachmentUpload[FormFile]:", err)
+		return lib.BadRequest(fmt.Sprintf("Missing file or file is larger than %d bytes", maxBytes))
+	}
+	defer file.Close()
+
+	attachment, err := c.s.UploadAttachment(userId, chatroomId, header.Filename, file)
+	if err != nil {
+		log.Println("Error in handleAttachmentUpload[UploadAttachment]:", err)
+		return err
+	}
+
+	return lib.WriteJSON(w, r, http.StatusCreated, attachment)
+}
+
+// GET /attachments/{id}[?thumb=1]
+func (c *Controller) handleAttachment(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+	attachmentId := pathParam(r, "id")
+	thumbnail, _ := strconv.ParseBool(r.URL.Query().Get("thumb"))
+
+	file, attachment, err := c.s.OpenAttachment(userId, attachmentId, thumbnail)
+	if err != nil {
+		log.Println("Error in handleAttachment[OpenAttachment]:", err)
+		return err
+	}
+	defer file.Close()
+
+	contentType := attachment.ContentType
+	if thumbnail {
+		contentType = "image/jpeg"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", attachment.FileName))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := io.Copy(w, file); err != nil {
+		log.Println("Error in handleAttachment[Copy]:", err)
+	}
+	return nil
+}
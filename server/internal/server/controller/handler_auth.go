@@ -1,97 +1,70 @@
 package controller
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
 	server "sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/services"
 
 	"sideDesert/shiba/internal/server/dto"
 )
 
 func (c *Controller) handleOAuthCallback(w http.ResponseWriter, r *http.Request) error {
-	// TODO: Check CSRF Token Validity
 	log.Println("handleOAuthCallback[URL]:", r.URL)
 
+	provider := oauthProviderFromRequest(r)
 	state := r.URL.Query().Get("state")
 	code := r.URL.Query().Get("code")
 
-	stateCookie, err := r.Cookie("shiba-state-token")
-
-	if err != nil || state != stateCookie.Value {
+	stateCookie, err := r.Cookie(services.OAuthStateCookie)
+	if err != nil {
 		log.Println("Error in handleOAuthCallback[Cookie]:", err)
-		return err
+		return server.BadRequest("Missing OAuth state cookie")
 	}
+	clearStateCookie(w)
 
-	if code == "" {
-		err := fmt.Errorf("No code in URL, code recieved is \"\"")
-		log.Println("Error in handleOAuthCallback[code]:", err)
-		return err
-	}
-
-	// For Debugging
-	fmt.Println("Code for token", code)
-
-	token, err := c.s.ExchangeCodeForToken(code)
+	oauthUser, st, err := c.s.CompleteOAuthLogin(provider, state, code, stateCookie.Value)
 	if err != nil {
-		log.Println("Error in handleOAuthCallback[ExchangeCodeForToken]:", err)
+		log.Println("Error in handleOAuthCallback[CompleteOAuthLogin]:", err)
 		return err
 	}
 
-	// Used for logs
-	userData, err := c.s.GetOAuthUserData(token)
-
+	user, isNewUser, err := c.s.LoginWithOAuth(oauthUser, st.LinkUserId)
 	if err != nil {
-		log.Println("Error in handleOAuthCallback[GetOAuthUserData]:", err)
+		log.Println("Error in handleOAuthCallback[LoginWithOAuth]:", err)
 		return err
 	}
 
-	// Check if Email is empty
-	user, isNewUser, err := c.s.Store.GetOrCreateUser(c.s.Ctx, userData)
 	clientUrl := os.Getenv("CLIENT_URL")
 
-	if err != nil {
-		log.Println("Error in handleOAuthCallback[GetOrCreateUserByEmail]:", err)
-		return err
+	// Linking adds the provider to the account already signed in, the
+	// session it came from carries on
+	if st.LinkUserId != "" {
+		http.Redirect(w, r, clientUrl+"/settings", http.StatusFound)
+		return nil
 	}
 
-	jwtToken, err := server.CreateToken(user.UserId)
-
-	if err != nil {
-		log.Println("Error in handleOAuthCallback[createToken]:", err)
+	if err := c.startSession(w, r, user.UserId); err != nil {
+		log.Println("Error in handleOAuthCallback[startSession]:", err)
 		return err
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "shiba-auth-token",
-		Value:    jwtToken,
-		Expires:  time.Now().Add(5 * time.Hour),
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		Path:     "/",
-	})
-
-	if isNewUser {
-		http.Redirect(w, r, clientUrl+"/signup", http.StatusAccepted)
+	switch {
+	case isNewUser:
+		http.Redirect(w, r, clientUrl+"/signup", http.StatusFound)
+	default:
+		http.Redirect(w, r, clientUrl+"/dashboard", http.StatusFound)
 	}
 
-	http.Redirect(w, r, clientUrl+"/dashboard", http.StatusFound)
-
 	return nil
 }
 
 func (c *Controller) handleSignup(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodPost {
-		return fmt.Errorf("Method not allowed")
-	}
-
 	userReq := dto.SignupUserRequest{}
-	if err := json.NewDecoder(r.Body).Decode(&userReq); err != nil {
+	if err := server.DecodeJSON(w, r, &userReq); err != nil {
 		log.Println("Error in controller.handleSignup[Decode()]:", err)
 		return err
 	}
@@ -107,6 +80,93 @@ func (c *Controller) handleSignup(w http.ResponseWriter, r *http.Request) error
 	return server.WriteJSON(w, r, http.StatusOK, userDetails)
 }
 
+// POST /logout revokes the current session and clears the cookies. It
+// works without a valid access token so a stale tab can always log out.
 func (c *Controller) handleLogout(w http.ResponseWriter, r *http.Request) error {
+	if userId, sessionId, ok := server.AuthenticatedSession(nil, r); ok {
+		if err := c.s.Logout(userId, sessionId); err != nil {
+			log.Println("Error in handleLogout[Logout]:", err)
+			return err
+		}
+	} else if refresh, err := r.Cookie(server.RefreshCookie); err == nil {
+		// Access token expired, rotate once to find the session and revoke it
+		if tokens, err := c.s.RefreshSession(refresh.Value); err == nil {
+			c.s.Logout(tokens.UserId, tokens.SessionId)
+		}
+	}
+
+	server.ClearSessionCookies(w)
+	return server.WriteJSON(w, r, http.StatusOK, map[string]bool{"logged_out": true})
+}
+
+// POST /logout/all revokes every session of the user
+func (c *Controller) handleLogoutAll(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+	if err := c.s.LogoutEverywhere(userId); err != nil {
+		log.Println("Error in handleLogoutAll[LogoutEverywhere]:", err)
+		return err
+	}
+
+	server.ClearSessionCookies(w)
+	return server.WriteJSON(w, r, http.StatusOK, map[string]bool{"logged_out": true})
+}
+
+// POST /auth/refresh rotates the refresh cookie and issues a new access token
+func (c *Controller) handleRefresh(w http.ResponseWriter, r *http.Request) error {
+	refresh, err := r.Cookie(server.RefreshCookie)
+	if err != nil {
+		return services.ErrSessionExpired
+	}
+
+	tokens, err := c.s.RefreshSession(refresh.Value)
+	if err != nil {
+		server.ClearSessionCookies(w)
+		return err
+	}
+
+	server.SetSessionCookies(w, tokens)
+	return server.WriteJSON(w, r, http.StatusOK, dto.RefreshResponse{
+		UserId:    tokens.UserId,
+		ExpiresAt: tokens.ExpiresAt,
+	})
+}
+
+// POST /auth/ws-ticket issues a ticket for the access token of the cookie,
+// an open websocket is handed it in an "auth.refresh" message
+func (c *Controller) handleWsTicket(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+	sessionId, _ := r.Context().Value("sessionId").(string)
+	expiresAt, _ := r.Context().Value("tokenExpiresAt").(time.Time)
+
+	ticket, err := server.CreateWsTicket(&server.AccessClaims{UserId: userId, SessionId: sessionId, ExpiresAt: expiresAt})
+	if err != nil {
+		log.Println("Error in handleWsTicket[CreateWsTicket]:", err)
+		return err
+	}
+	return server.WriteJSON(w, r, http.StatusOK, dto.WsTicketResponse{
+		Ticket:    ticket,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// GET /sessions lists the devices the user is logged in on
+func (c *Controller) handleSessions(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	sessions, err := c.s.GetSessions(userId)
+	if err != nil {
+		return err
+	}
+	return server.WriteJSON(w, r, http.StatusOK, sessions)
+}
+
+// startSession logs userId in on this browser
+func (c *Controller) startSession(w http.ResponseWriter, r *http.Request, userId string) error {
+	tokens, err := c.s.StartSession(userId, r.UserAgent(), server.ClientIP(r))
+	if err != nil {
+		return err
+	}
+
+	server.SetSessionCookies(w, tokens)
 	return nil
 }
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/services"
+)
+
+// handleBrowserNavigate handles "stream.navigate.<chatroomId>" - the remote
+// holder opening a url (usually taken from a chat message) in the shared
+// browser of a room that is currently streaming.
+func (c *Controller) handleBrowserNavigate(out *wsHub, cmd dto.StreamCommand) {
+	payload := dto.BrowserNavigatePayload{}
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+		log.Println("🔴 Error in handleBrowserNavigate[Unmarshal]:", err)
+		c.sendStreamError(out, cmd.ChatroomId, "Invalid navigate payload")
+		return
+	}
+
+	workerId, err := c.s.StreamRoomWorker(cmd.ChatroomId)
+	if err != nil || workerId == "" {
+		c.sendStreamError(out, cmd.ChatroomId, "Chatroom is not streaming")
+		return
+	}
+
+	url, err := c.s.CheckBrowserUrl(cmd.UserId, cmd.ChatroomId, payload.Url)
+	if err != nil {
+		log.Println("🔴 Error in handleBrowserNavigate[CheckBrowserUrl]:", err)
+		c.sendStreamError(out, cmd.ChatroomId, err.Error())
+		return
+	}
+
+	req := dto.WorkerNavigateRequest{ChatroomId: cmd.ChatroomId, Url: url}
+	if err := c.requestWorker(workerId, "navigate", req, workerRequestTimeout); err != nil {
+		log.Println("🔴 Error in handleBrowserNavigate[requestWorker]:", err)
+		c.sendStreamError(out, cmd.ChatroomId, lib.AsApiError(err).Message)
+		return
+	}
+
+	data, err := json.Marshal(dto.Message[dto.BrowserNavigatedPayload]{
+		Sender:  "server",
+		Subject: "stream.navigated." + cmd.ChatroomId,
+		Payload: dto.BrowserNavigatedPayload{Url: url, UserId: cmd.UserId},
+	})
+	if err != nil {
+		log.Println("🔴 Error in handleBrowserNavigate[Marshal]:", err)
+		return
+	}
+	if _, err := c.js.Publish(c.s.Ctx, services.ChatroomSubject(cmd.ChatroomId), data); err != nil {
+		log.Println("🔴 Error in handleBrowserNavigate[Publish]:", err)
+	}
+}
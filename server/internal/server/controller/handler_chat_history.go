@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -10,44 +9,40 @@ import (
 	server "sideDesert/shiba/internal/server/lib"
 )
 
+// GET /chatrooms/{id}/history?page=0[&mentions=1]
 func (c *Controller) handleChatHistory(w http.ResponseWriter, r *http.Request) error {
 	log.Println("Fetching Chat History...")
-	if r.Method != http.MethodGet {
-		return fmt.Errorf("Method Not Allowed, Method: %s", r.Method)
-	}
 
 	query := r.URL.Query()
-	sender := query.Get("sender")
-	if sender == "" {
-		return fmt.Errorf("Please Include sender - s")
-	}
-
-	chatroomId := query.Get("cid")
-	if chatroomId == "" {
-		return fmt.Errorf("Please Include chatroomId - cid")
-	}
+	userId := r.Context().Value("userId").(string)
+	chatroomId := pathParam(r, "id")
 
 	offset := query.Get("page")
-	if chatroomId == "" {
-		return fmt.Errorf("Please Include offset - page")
+	if offset == "" {
+		return server.BadRequest("Please Include offset - page")
 	}
 
 	offsetInt, err := strconv.Atoi(offset)
-	if err != nil {
-		log.Println("Error in handleChatHistory:", err)
-		return fmt.Errorf("page is not valid number")
+	if err != nil || offsetInt < 0 {
+		log.Println("Error in handleChatHistory:", offset, err)
+		return server.BadRequest("page is not valid number")
 	}
 
-	// DEBUGGING
-	log.Println("sender", sender, "chatroomId", chatroomId, "offset", offset)
+	// mentions=1 only returns messages that mention the requesting user
+	mentions := query.Get("mentions")
+	onlyMentions := mentions == "me"
+	if !onlyMentions {
+		onlyMentions, _ = strconv.ParseBool(mentions)
+	}
 
 	history := dto.ChatHistoryRequest{
-		Sender:     sender,
+		Sender:     userId,
 		ChatroomId: chatroomId,
 		Offset:     offsetInt,
+		Mentions:   onlyMentions,
 	}
 
-	chat, err := c.s.GetChatroomHistory(history.ChatroomId, history.Offset)
+	chat, err := c.s.GetChatroomHistory(history.Sender, history.ChatroomId, history.Offset, history.Mentions)
 
 	if err != nil {
 		log.Println("Error in handleChatHistory", err)
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/store"
+
+	"github.com/gorilla/mux"
+)
+
+func TestChatHistoryMembersOnly(t *testing.T) {
+	s := newTestService(store.NewMemoryStore())
+	c := &Controller{s: s}
+	ada, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "eve")
+	chatroomId := newTestChatroom(t, s, ada, "room")
+
+	get := func(userId string, query string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodGet, "/chatrooms/"+chatroomId+"/history"+query, nil)
+		r = r.WithContext(context.WithValue(r.Context(), "userId", userId))
+		r = mux.SetURLVars(r, map[string]string{"id": chatroomId})
+		w := httptest.NewRecorder()
+		return w, c.handleChatHistory(w, r)
+	}
+
+	if w, err := get(ada, "?page=0&mentions=me"); err != nil || w.Code != http.StatusOK {
+		t.Errorf("history of a member gave %d, %v", w.Code, err)
+	}
+	if _, err := get(eve, "?page=0"); lib.AsApiError(err).Status != http.StatusForbidden {
+		t.Errorf("history of a non member gave %v, want forbidden", err)
+	}
+	if _, err := get(ada, "?page=-1"); lib.AsApiError(err).Status != http.StatusBadRequest {
+		t.Errorf("a negative page gave %v, want a bad request", err)
+	}
+}
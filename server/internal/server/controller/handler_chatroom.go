@@ -1,56 +1,138 @@
 package controller
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"sideDesert/shiba/internal/server/dto"
 	server "sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/services"
 )
 
-func (c *Controller) handleChatRoom(w http.ResponseWriter, r *http.Request) error {
-	// This is for GET Requeests - We Get all chatrooms
-	if r.Method == http.MethodGet {
-
-		userId := r.Context().Value("userId").(string)
-		if userId == "" {
-			log.Println("Error in handleCreateChatRoom[userId] No userId")
-			return fmt.Errorf("No User ID in request context")
-		}
-
-		cs, err := c.s.GetUserChatRooms(userId)
-		if err != nil {
-			log.Println("Error in handleCreateChatRoom[GetUserChatRooms]", err)
-			return fmt.Errorf("Could not get User Chat Rooms")
-		}
-		return server.WriteJSON(w, r, http.StatusOK, struct {
-			Chatrooms []server.Chatroom `json:"chatrooms"`
-		}{
-			Chatrooms: cs,
-		})
-	}
-
-	// This is for POST Requests - We Create
-	if r.Method == http.MethodPost {
-		createChatRequest := dto.CreateChatRoomRequest{}
-		err := json.NewDecoder(r.Body).Decode(&createChatRequest)
-		if err != nil {
-			log.Println("Error in handleCreateChatRoom[decodeChatRequest]", err)
-			return err
-		}
-
-		chatRoomId, err := c.s.CreateChatRoom(createChatRequest)
-
-		if err != nil {
-			log.Println("Error in handleCreateChatRoom[createChatRoom]", err)
-			return err
-		}
-
-		return server.WriteJSON(w, r, http.StatusOK, dto.CreateChatRoomResponse{
-			ChatRoomId: chatRoomId,
-		})
-	}
-
-	return fmt.Errorf("Error: Method not allowed %s", r.Method)
+// GET /chatrooms
+func (c *Controller) handleGetChatRooms(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+	if userId == "" {
+		log.Println("Error in handleGetChatRooms[userId] No userId")
+		return server.Unauthorized("No User ID in request context")
+	}
+
+	cs, err := c.s.GetUserChatRooms(userId)
+	if err != nil {
+		log.Println("Error in handleGetChatRooms[GetUserChatRooms]", err)
+		return err
+	}
+	return server.WriteJSON(w, r, http.StatusOK, dto.ChatroomsResponse{
+		Chatrooms: cs,
+	})
+}
+
+// POST /chatrooms
+func (c *Controller) handleCreateChatRoom(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+	createChatRequest := dto.CreateChatRoomRequest{}
+	err := server.DecodeJSON(w, r, &createChatRequest)
+	if err != nil {
+		log.Println("Error in handleCreateChatRoom[decodeChatRequest]", err)
+		return err
+	}
+
+	chatRoomId, err := c.s.CreateChatRoom(userId, createChatRequest)
+
+	if err != nil {
+		log.Println("Error in handleCreateChatRoom[createChatRoom]", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, dto.CreateChatRoomResponse{
+		ChatRoomId: chatRoomId,
+	})
+}
+
+// GET /chatrooms/{id}
+func (c *Controller) handleGetChatRoom(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	chatroom, err := c.s.GetChatroom(userId, pathParam(r, "id"))
+	if err != nil {
+		log.Println("Error in handleGetChatRoom:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, chatroom)
+}
+
+// PATCH /chatrooms/{id}
+func (c *Controller) handleUpdateChatRoom(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	body := dto.UpdateChatroomRequest{}
+	if err := server.DecodeJSON(w, r, &body); err != nil {
+		log.Println("Error in handleUpdateChatRoom[Decode]:", err)
+		return err
+	}
+
+	chatroom, err := c.s.UpdateChatroom(userId, pathParam(r, "id"), body)
+	if err != nil {
+		log.Println("Error in handleUpdateChatRoom:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, chatroom)
+}
+
+// PUT /chatrooms/{id}/avatar with a multipart "file" field
+func (c *Controller) handleUploadChatRoomAvatar(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	r.Body = http.MaxBytesReader(w, r.Body, services.AvatarMaxBytes+(1<<20))
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		log.Println("Error in handleUploadChatRoomAvatar[FormFile]:", err)
+		return server.BadRequest(fmt.Sprintf("Missing file or file is larger than %d bytes", services.AvatarMaxBytes))
+	}
+	defer file.Close()
+
+	chatroom, err := c.s.UploadChatroomAvatar(userId, pathParam(r, "id"), file)
+	if err != nil {
+		log.Println("Error in handleUploadChatRoomAvatar:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, chatroom)
+}
+
+// DELETE /chatrooms/{id}/avatar
+func (c *Controller) handleRemoveChatRoomAvatar(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	chatroom, err := c.s.RemoveChatroomAvatar(userId, pathParam(r, "id"))
+	if err != nil {
+		log.Println("Error in handleRemoveChatRoomAvatar:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, chatroom)
+}
+
+// GET /chatrooms/{id}/avatar/{avatarId}
+func (c *Controller) handleChatRoomAvatar(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	file, err := c.s.OpenChatroomAvatar(userId, pathParam(r, "id"), pathParam(r, "avatarId"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := io.Copy(w, file); err != nil {
+		log.Println("Error in handleChatRoomAvatar[Copy]:", err)
+	}
+	return nil
 }
@@ -1,60 +1,170 @@
 package controller
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+
 	"sideDesert/shiba/internal/server/dto"
 	server "sideDesert/shiba/internal/server/lib"
 )
 
-func (c *Controller) handleFriends(w http.ResponseWriter, r *http.Request) error {
+// GET /friends
+func (c *Controller) handleGetFriends(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	friends, err := c.s.GetFriends(userId)
+	if err != nil {
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, friends)
+}
+
+// GET /friends/suggestions
+func (c *Controller) handleFriendSuggestions(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	suggestions, err := c.s.GetFriendSuggestions(userId, 20)
+	if err != nil {
+		log.Println("Error in handleFriendSuggestions:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, suggestions)
+}
+
+// POST /friends
+func (c *Controller) handleSendFriendRequest(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	body := dto.SendFriendRequest{}
+	err := server.DecodeJSON(w, r, &body)
+	if err != nil {
+		log.Println("Error in handleSendFriendRequest[Decode]:", err)
+		return err
+	}
+
+	response, err := c.s.SendFriendRequest(userId, body.FriendId)
+
+	if err != nil {
+		log.Println("Error in handleSendFriendRequest[SendFriendRequest]:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// PATCH /friends
+func (c *Controller) handleRespondFriendRequest(w http.ResponseWriter, r *http.Request) error {
 	userId := r.Context().Value("userId").(string)
-	// GET Friends
-	if r.Method == http.MethodGet {
-		friends, err := c.s.GetFriends(userId)
-		if err != nil {
-			return err
-		}
 
-		return server.WriteJSON(w, r, http.StatusOK, friends)
+	body := dto.FriendStatusRequest{}
+	err := server.DecodeJSON(w, r, &body)
+	if err != nil {
+		log.Println("Error in handleRespondFriendRequest[Decode]:", err)
+		return err
 	}
+	response, err := c.s.HandleFriendRequest(userId, body.Id, body.Status)
 
-	if r.Method == http.MethodPost {
-		body := dto.SendFriendRequest{}
-		err := json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			log.Println("Error in handleFriendsPOST[Decode]:", err)
-			return fmt.Errorf("Body Is not of correct format")
-		}
+	if err != nil {
+		log.Println("Error in handleRespondFriendRequest[HandleFriendRequest]:", err)
+		return err
+	}
 
-		err = c.s.SendFriendRequest(userId, body.FriendId)
+	return server.WriteJSON(w, r, http.StatusOK, response)
+}
 
-		if err != nil {
-			log.Println("Error in handleFriendsPOST[AcceptFriendRequest]:", err)
-			return fmt.Errorf("Friend Request Could not be sent")
-		}
+// DELETE /friends/{userId}
+func (c *Controller) handleUnfriend(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
 
-		return server.WriteJSON(w, r, http.StatusOK, dto.FriendResponse{Status: "Sent"})
+	if err := c.s.Unfriend(userId, pathParam(r, "userId")); err != nil {
+		log.Println("Error in handleUnfriend:", err)
+		return err
 	}
 
-	if r.Method == http.MethodPatch {
-		body := dto.FriendStatusRequest{}
-		err := json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			log.Println("Error in handleFriendsPATCH[Decode]:", err)
-			return fmt.Errorf("Body Is not of correct format")
-		}
-		err = c.s.HandleFriendRequest(userId, body.Id, body.Status)
+	return server.WriteJSON(w, r, http.StatusOK, dto.FriendResponse{Status: "removed"})
+}
+
+// POST /friends/{userId}/dm
+func (c *Controller) handleOpenDirectMessage(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	chatroomId, err := c.s.OpenDirectMessage(userId, pathParam(r, "userId"))
+	if err != nil {
+		log.Println("Error in handleOpenDirectMessage:", err)
+		return err
+	}
 
-		if err != nil {
-			log.Println("Error in handleFriendsPATCH[AcceptFriendRequest]:", err)
-			return fmt.Errorf("Friend Request Could not be accepted")
-		}
+	return server.WriteJSON(w, r, http.StatusOK, dto.CreateChatRoomResponse{ChatRoomId: chatroomId})
+}
+
+// GET /friends/requests, sent=true lists the requests the user sent
+func (c *Controller) handleFriendRequests(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+	sent, _ := strconv.ParseBool(r.URL.Query().Get("sent"))
+
+	requests, err := c.s.GetFriendRequests(userId, sent)
+	if err != nil {
+		log.Println("Error in handleFriendRequests:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, requests)
+}
+
+// DELETE /friends/requests/{id}
+func (c *Controller) handleCancelFriendRequest(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	if err := c.s.CancelFriendRequest(userId, pathParam(r, "id")); err != nil {
+		log.Println("Error in handleCancelFriendRequest:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, dto.FriendResponse{Status: "cancelled"})
+}
+
+// GET /blocks
+func (c *Controller) handleGetBlocks(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	blocked, err := c.s.GetBlockedUsers(userId)
+	if err != nil {
+		log.Println("Error in handleGetBlocks:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, blocked)
+}
+
+// POST /blocks
+func (c *Controller) handleBlockUser(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	body := dto.BlockUserRequest{}
+	if err := server.DecodeJSON(w, r, &body); err != nil {
+		log.Println("Error in handleBlockUser[Decode]:", err)
+		return err
+	}
+
+	if err := c.s.BlockUser(userId, body.UserId); err != nil {
+		log.Println("Error in handleBlockUser:", err)
+		return err
+	}
+
+	return server.WriteJSON(w, r, http.StatusOK, dto.FriendResponse{Status: "blocked"})
+}
+
+// DELETE /blocks/{userId}
+func (c *Controller) handleUnblockUser(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
 
-		return server.WriteJSON(w, r, http.StatusOK, dto.FriendResponse{Status: "accepted"})
+	if err := c.s.UnblockUser(userId, pathParam(r, "userId")); err != nil {
+		log.Println("Error in handleUnblockUser:", err)
+		return err
 	}
 
-	return nil
+	return server.WriteJSON(w, r, http.StatusOK, dto.FriendResponse{Status: "unblocked"})
 }
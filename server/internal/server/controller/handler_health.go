@@ -8,8 +8,13 @@ import (
 
 func (c *Controller) handleHealth(w http.ResponseWriter, r *http.Request) error {
 	str, _ := c.s.Health()
+	workers, free := c.s.StreamCapacity()
 	fmt.Println("Health Check!")
 	return lib.WriteJSON(w, r, http.StatusOK, struct {
 		Msg string `json:"msg"`
-	}{Msg: str})
+		// Streaming workers this node heard from and how many more rooms
+		// they can stream
+		StreamWorkers int `json:"stream_workers"`
+		FreeStreams   int `json:"free_streams"`
+	}{Msg: str, StreamWorkers: workers, FreeStreams: free})
 }
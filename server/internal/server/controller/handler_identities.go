@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+// GET /user/identities lists the linked providers
+func (c *Controller) handleGetIdentities(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	identities, err := c.s.GetIdentities(userId)
+	if err != nil {
+		log.Println("Error in handleGetIdentities:", err)
+		return err
+	}
+	return lib.WriteJSON(w, r, http.StatusOK, identities)
+}
+
+// DELETE /user/identities/{provider} unlinks one
+func (c *Controller) handleUnlinkIdentity(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+	provider := pathParam(r, "provider")
+
+	if err := c.s.UnlinkIdentity(userId, provider); err != nil {
+		log.Println("Error in handleUnlinkIdentity:", err)
+		return err
+	}
+	return lib.WriteJSON(w, r, http.StatusOK, map[string]string{"unlinked": provider})
+}
@@ -1,20 +1,40 @@
 package controller
 
 import (
+	"log"
 	"net/http"
+	"sideDesert/shiba/internal/server/dto"
 	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/services"
+	"strconv"
 	"time"
 )
 
+// GET /login/oauth/{provider}, with ?link=1 a signed in user adds the
+// provider to their account instead of logging in
 func (c *Controller) handleLogin(w http.ResponseWriter, r *http.Request) error {
-	oauthUrl := c.s.GenerateOAuthLoginURL("google")
-	csrfToken := lib.CreateCSRFToken()
+	provider := oauthProviderFromRequest(r)
+
+	linkUserId := ""
+	if link, _ := strconv.ParseBool(r.URL.Query().Get("link")); link {
+		userId, ok := lib.AuthenticatedUserId(r)
+		if !ok {
+			return lib.Unauthorized("Sign in before linking another account")
+		}
+		linkUserId = userId
+	}
+
+	oauthUrl, stateCookie, err := c.s.BeginOAuthLogin(provider, linkUserId)
+	if err != nil {
+		log.Println("Error in handleLogin[BeginOAuthLogin]:", err)
+		return err
+	}
 
 	http.SetCookie(w, &http.Cookie{
-		Name: "shiba-state-token",
-		// TODO: Change this
-		Value:    csrfToken,
-		Expires:  time.Now().Add(10 * time.Hour),
+		Name:     services.OAuthStateCookie,
+		Value:    stateCookie,
+		Expires:  time.Now().Add(services.OAuthStateLifetime),
+		MaxAge:   int(services.OAuthStateLifetime.Seconds()),
 		HttpOnly: true,  // Prevent JavaScript access
 		Secure:   false, // Only send over HTTPS
 		SameSite: http.SameSiteLaxMode,
@@ -24,3 +44,53 @@ func (c *Controller) handleLogin(w http.ResponseWriter, r *http.Request) error {
 	http.Redirect(w, r, oauthUrl, http.StatusFound)
 	return nil
 }
+
+func oauthProviderFromRequest(r *http.Request) string {
+	return pathParam(r, "provider")
+}
+
+// GET /oauth/providers
+func (c *Controller) handleOAuthProviders(w http.ResponseWriter, r *http.Request) error {
+	return lib.WriteJSON(w, r, http.StatusOK, dto.OAuthProvidersResponse{
+		Providers: c.s.OAuthProviders(),
+	})
+}
+
+// clearStateCookie makes sure a state can only be used for one callback
+func clearStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     services.OAuthStateCookie,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	})
+}
+
+// POST /login with an email and password
+func (c *Controller) handlePasswordLogin(w http.ResponseWriter, r *http.Request) error {
+	body := dto.LoginRequest{}
+	if err := lib.DecodeJSON(w, r, &body); err != nil {
+		log.Println("Error in handlePasswordLogin[Decode]:", err)
+		return err
+	}
+
+	user, err := c.s.LoginWithPassword(body.Email, body.Password, lib.ClientIP(r))
+	if err != nil {
+		log.Println("Error in handlePasswordLogin[LoginWithPassword]:", err)
+		return err
+	}
+
+	if err := c.startSession(w, r, user.UserId); err != nil {
+		log.Println("Error in handlePasswordLogin[startSession]:", err)
+		return err
+	}
+
+	return lib.WriteJSON(w, r, http.StatusOK, dto.LoginResponse{
+		UserId: user.UserId,
+		Name:   user.Name,
+		Email:  user.Email,
+	})
+}
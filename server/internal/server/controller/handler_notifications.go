@@ -1,28 +1,51 @@
 package controller
 
 import (
-	"fmt"
 	"log"
 	"net/http"
+	"sideDesert/shiba/internal/server/dto"
 	"sideDesert/shiba/internal/server/lib"
+	"strconv"
 )
 
-func (c *Controller) handleNotifications(w http.ResponseWriter, r *http.Request) error {
-	userId, ok := r.Context().Value("userId").(string)
-	if !ok {
-		http.Error(w, "Invalid user ID", http.StatusUnauthorized)
-		return fmt.Errorf("Invalid user ID")
+// GET /notifications?unread=1&page=0
+func (c *Controller) handleGetNotifications(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	query := r.URL.Query()
+	unreadOnly, _ := strconv.ParseBool(query.Get("unread"))
+	page := 0
+	if p := query.Get("page"); p != "" {
+		var err error
+		if page, err = strconv.Atoi(p); err != nil || page < 0 {
+			return lib.BadRequest("page is not valid number")
+		}
+	}
+
+	notifications, err := c.s.GetNotifications(userId, unreadOnly, page)
+	if err != nil {
+		log.Println("Error in handleGetNotifications:", err)
+		return err
 	}
 
-	if r.Method != http.MethodGet {
-		return fmt.Errorf("Method not allowed: %s", r.Method)
+	return lib.WriteJSON(w, r, http.StatusOK, notifications)
+}
+
+// PATCH /notifications {"ids": [...]} or {"all": true}
+func (c *Controller) handleMarkNotificationsRead(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	body := dto.MarkNotificationsReadRequest{}
+	if err := lib.DecodeJSON(w, r, &body); err != nil {
+		log.Println("Error in handleMarkNotificationsRead[Decode]:", err)
+		return err
 	}
 
-	friends, err := c.s.GetFriendRequests(userId)
+	updated, err := c.s.MarkNotificationsRead(userId, body)
 	if err != nil {
-		log.Println("Error in handleNotifications:", err)
+		log.Println("Error in handleMarkNotificationsRead:", err)
 		return err
 	}
 
-	return lib.WriteJSON(w, r, http.StatusOK, friends)
+	return lib.WriteJSON(w, r, http.StatusOK, dto.MarkNotificationsReadResponse{Updated: updated})
 }
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/store"
+)
+
+func TestGetNotificationsPage(t *testing.T) {
+	s := newTestService(store.NewMemoryStore())
+	c := &Controller{s: s}
+	userId := newTestUser(t, s, "ada")
+
+	get := func(query string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodGet, "/notifications"+query, nil)
+		r = r.WithContext(context.WithValue(r.Context(), "userId", userId))
+		w := httptest.NewRecorder()
+		return w, c.handleGetNotifications(w, r)
+	}
+
+	for _, query := range []string{"", "?page=0", "?page=2&unread=1"} {
+		if w, err := get(query); err != nil || w.Code != http.StatusOK {
+			t.Errorf("%q gave %d, %v", query, w.Code, err)
+		}
+	}
+	// A negative page would be a negative OFFSET
+	for _, query := range []string{"?page=-1", "?page=one"} {
+		if _, err := get(query); lib.AsApiError(err).Status != http.StatusBadRequest {
+			t.Errorf("%q gave %v, want a bad request", query, err)
+		}
+	}
+}
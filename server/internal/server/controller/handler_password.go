@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/services"
+)
+
+// PUT /user/password
+func (c *Controller) handleChangePassword(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+	sessionId, _ := r.Context().Value("sessionId").(string)
+
+	body := dto.ChangePasswordRequest{}
+	if err := lib.DecodeJSON(w, r, &body); err != nil {
+		log.Println("Error in handleChangePassword[Decode]:", err)
+		return err
+	}
+
+	if err := c.s.ChangePassword(userId, sessionId, body.CurrentPassword, body.NewPassword); err != nil {
+		log.Println("Error in handleChangePassword[ChangePassword]:", err)
+		return err
+	}
+
+	return lib.WriteJSON(w, r, http.StatusOK, dto.PatchOKResponse{Status: "Success"})
+}
+
+// POST /password/forgot - always answers the same way so it can't be used
+// to find out which emails have an account
+func (c *Controller) handleForgotPassword(w http.ResponseWriter, r *http.Request) error {
+	body := dto.ForgotPasswordRequest{}
+	if err := lib.DecodeJSON(w, r, &body); err != nil {
+		log.Println("Error in handleForgotPassword[Decode]:", err)
+		return err
+	}
+
+	if err := c.s.RequestPasswordReset(body.Email, lib.ClientIP(r)); err != nil {
+		log.Println("Error in handleForgotPassword[RequestPasswordReset]:", err)
+		// Limited whether or not the email has an account, so this tells
+		// nothing either
+		if err == services.ErrTooManyResets {
+			return err
+		}
+	}
+
+	return lib.WriteJSON(w, r, http.StatusAccepted, dto.PatchOKResponse{Status: "Sent"})
+}
+
+// POST /password/reset
+func (c *Controller) handleResetPassword(w http.ResponseWriter, r *http.Request) error {
+	body := dto.ResetPasswordRequest{}
+	if err := lib.DecodeJSON(w, r, &body); err != nil {
+		log.Println("Error in handleResetPassword[Decode]:", err)
+		return err
+	}
+
+	if err := c.s.ResetPassword(body.Token, body.NewPassword); err != nil {
+		log.Println("Error in handleResetPassword[ResetPassword]:", err)
+		return err
+	}
+
+	return lib.WriteJSON(w, r, http.StatusOK, dto.PatchOKResponse{Status: "Success"})
+}
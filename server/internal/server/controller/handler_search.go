@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 	"sideDesert/shiba/internal/server/lib"
@@ -10,8 +9,7 @@ import (
 func (c *Controller) handleSearch(w http.ResponseWriter, r *http.Request) error {
 	userId, ok := r.Context().Value("userId").(string)
 	if !ok {
-		http.Error(w, "Invalid user ID", http.StatusUnauthorized)
-		return fmt.Errorf("Invalid user ID")
+		return lib.Unauthorized("Invalid user ID")
 	}
 
 	query := r.URL.Query().Get("q")
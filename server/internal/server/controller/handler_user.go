@@ -1,15 +1,135 @@
 package controller
 
 import (
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+
+	"sideDesert/shiba/internal/server/dto"
 	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/services"
 )
 
 func (c *Controller) handleUser(w http.ResponseWriter, r *http.Request) error {
-	userResponse, err := c.s.GetUserResponse(w, r)
+	userId := r.Context().Value("userId").(string)
+	userResponse, err := c.s.GetUserResponse(userId)
 	if err != nil {
 		return err
 	}
 
 	return lib.WriteJSON(w, r, http.StatusAccepted, userResponse)
 }
+
+// PATCH /user
+func (c *Controller) handleUpdateUser(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	body := dto.UpdateUserRequest{}
+	if err := lib.DecodeJSON(w, r, &body); err != nil {
+		log.Println("Error in handleUpdateUser[Decode]:", err)
+		return err
+	}
+
+	user, err := c.s.UpdateProfile(userId, body)
+	if err != nil {
+		log.Println("Error in handleUpdateUser:", err)
+		return err
+	}
+
+	return lib.WriteJSON(w, r, http.StatusOK, user)
+}
+
+// DELETE /user schedules the deletion of the account and logs it out
+func (c *Controller) handleDeleteUser(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	body := dto.DeleteAccountRequest{}
+	if err := lib.DecodeJSON(w, r, &body); err != nil {
+		log.Println("Error in handleDeleteUser[Decode]:", err)
+		return err
+	}
+
+	response, err := c.s.ScheduleAccountDeletion(userId, body.Confirm)
+	if err != nil {
+		log.Println("Error in handleDeleteUser:", err)
+		return err
+	}
+
+	lib.ClearSessionCookies(w)
+	return lib.WriteJSON(w, r, http.StatusAccepted, response)
+}
+
+// GET /user/export
+func (c *Controller) handleExportUser(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	export, err := c.s.ExportUserData(userId)
+	if err != nil {
+		log.Println("Error in handleExportUser:", err)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName()))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Headers are sent with the first write, a failure past it can only be logged
+	if err := export.WriteZip(w); err != nil {
+		log.Println("Error in handleExportUser[WriteZip]:", err)
+	}
+	return nil
+}
+
+// PUT /user/avatar with a multipart "file" field
+func (c *Controller) handleUploadAvatar(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	r.Body = http.MaxBytesReader(w, r.Body, services.AvatarMaxBytes+(1<<20))
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		log.Println("Error in handleUploadAvatar[FormFile]:", err)
+		return lib.BadRequest(fmt.Sprintf("Missing file or file is larger than %d bytes", services.AvatarMaxBytes))
+	}
+	defer file.Close()
+
+	user, err := c.s.UploadAvatar(userId, file)
+	if err != nil {
+		log.Println("Error in handleUploadAvatar:", err)
+		return err
+	}
+
+	return lib.WriteJSON(w, r, http.StatusOK, user)
+}
+
+// DELETE /user/avatar
+func (c *Controller) handleRemoveAvatar(w http.ResponseWriter, r *http.Request) error {
+	userId := r.Context().Value("userId").(string)
+
+	user, err := c.s.RemoveAvatar(userId)
+	if err != nil {
+		log.Println("Error in handleRemoveAvatar:", err)
+		return err
+	}
+
+	return lib.WriteJSON(w, r, http.StatusOK, user)
+}
+
+// GET /users/{userId}/avatar/{avatarId}
+func (c *Controller) handleAvatar(w http.ResponseWriter, r *http.Request) error {
+	file, err := c.s.OpenAvatar(pathParam(r, "userId"), pathParam(r, "avatarId"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := io.Copy(w, file); err != nil {
+		log.Println("Error in handleAvatar[Copy]:", err)
+	}
+	return nil
+}
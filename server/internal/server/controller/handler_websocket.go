@@ -2,34 +2,24 @@ package controller
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"sideDesert/shiba/internal/server/dto"
 	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/services"
 	"strings"
+	"time"
 
-	"github.com/go-gst/go-gst/gst"
 	"github.com/gorilla/websocket"
-	"github.com/nats-io/nats.go"
-	"github.com/pion/webrtc/v4"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
+// GET /ws, one socket carries the events of every chatroom of the user
 func (c *Controller) handleWebsocket(w http.ResponseWriter, r *http.Request) error {
 	// Extract user ID from request context
 	userId, ok := r.Context().Value("userId").(string)
-	chatroomId := r.URL.Query().Get("cid")
-
-	if chatroomId == "" {
-		log.Println("Nothing provided as chatroomId")
-		return fmt.Errorf("invalid chatroomId")
-	}
-
-	log.Println("Chatroom ID:", chatroomId)
-
 	if !ok {
-		http.Error(w, "Invalid user ID", http.StatusUnauthorized)
-		return fmt.Errorf("invalid user ID")
+		return lib.Unauthorized("Invalid user ID")
 	}
 
 	// Fetch user chat rooms
@@ -38,6 +28,23 @@ func (c *Controller) handleWebsocket(w http.ResponseWriter, r *http.Request) err
 		log.Println("Error in handleChatWebsocket[GetUserChatRooms]:", err)
 		return err
 	}
+	membership := newChatroomMembership(chatrooms)
+
+	since, err := roomEventsSince(r)
+	if err != nil {
+		return err
+	}
+
+	// Messages are sent under this name, whatever the client claims
+	user, err := c.s.Store.GetUserById(c.s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in handleChatWebsocket[GetUserById]:", err)
+		return err
+	}
+
+	auth := &wsAuth{userId: userId}
+	auth.sessionId, _ = r.Context().Value("sessionId").(string)
+	auth.expiresAt, _ = r.Context().Value("tokenExpiresAt").(time.Time)
 
 	// Upgrade HTTP to WebSocket
 	upgrader := websocket.Upgrader{
@@ -51,79 +58,60 @@ func (c *Controller) handleWebsocket(w http.ResponseWriter, r *http.Request) err
 		log.Println("Error in handleChatWebsocket[upgrader]:", err)
 		return err
 	}
-
-	// Store client connection - this creates the peer connection as well
-	connsVal, err := lib.NewConnMap(userId)
-	if err != nil {
-		log.Println("Error in handleChatWebsocket[upgrader]", err)
-	}
-	c.conns[conn] = connsVal
+	// Same cap as a JSON request body, a bigger frame closes the connection
+	conn.SetReadLimit(lib.MaxJSONBodyBytes)
+	out := newWsHub(conn, userId)
 
 	userTag := strings.Split(userId, "-")[0]
-	log.Println("✅ Established WebSocket connection with", userTag)
 
 	// CLEANUP : DO NOT REMOVE
 	defer func() {
 		log.Println("🚀 Starting cleanup for", userTag)
 
 		c.mu.Lock()
-		delete(c.conns, conn)
+		delete(c.conns, out.id)
 		c.mu.Unlock()
 
-		conn.Close()
+		// Unsubscribes from NATS, notifications of the user included
+		out.shutdown()
+		c.leaveStreams(out, membership)
 		log.Println("❌ Connection closed with", userTag)
 	}()
 
-	if err != nil {
-		log.Println("❌ Error creating participant:", err)
-		return fmt.Errorf("Error creating participant")
-	}
+	c.mu.Lock()
+	c.conns[out.id] = out
+	active := len(c.conns)
+	c.mu.Unlock()
 
-	log.Println("🫂 Total active connections:", len(c.conns))
-
-	// Subscribe to chat rooms
-	for _, room := range chatrooms {
-		chatroomId := room.Id
-		_, err := c.nats.Subscribe("chatrooms."+chatroomId, func(msg *nats.Msg) {
-			log.Println("Received Message:", string(msg.Data))
-			err := conn.WriteMessage(websocket.TextMessage, msg.Data)
+	log.Println("✅ Established WebSocket connection with", userTag)
+	log.Println("🫂 Total active connections:", active)
 
-			if err != nil {
-				log.Println("❌ Error writing WebSocket message:", err)
-				// Remove connection from cache safely
-				c.mu.Lock()
-				delete(c.conns, conn)
-				c.mu.Unlock()
-
-				conn.Close()
-				return
-			}
-		})
+	go c.watchWebsocketAuth(out, auth, out.Done())
 
-		if err != nil {
-			log.Println("❌ Error subscribing to NATS[chatrooms.*]:", err)
-			continue
-		}
+	// Room events come from the chatroom stream so a reconnecting client can
+	// pick up where it left off
+	if err := c.consumeRoomEvents(r.Context(), out, chatrooms, since); err != nil {
+		log.Println("❌ Error consuming room events:", err)
+		return nil
+	}
 
-		_, err = c.nats.Subscribe("webrtc.*."+chatroomId, func(msg *nats.Msg) {
-			err := conn.WriteMessage(websocket.TextMessage, msg.Data)
-			if err != nil {
-				log.Println("❌ Error writing WebSocket Webrtc Message:", err)
-				// Remove connection from cache safely
-				c.mu.Lock()
-				delete(c.conns, conn)
-				c.mu.Unlock()
-
-				conn.Close()
-				return
-			}
-		})
-		if err != nil {
+	// WebRTC signalling is only relevant to the peers connected right now
+	for _, room := range chatrooms {
+		if err := out.subscribe(c.nats, "webrtc.*."+room.Id); err != nil {
 			log.Println("❌ Error subscribing to NATS[webrtc.*]:", err)
-			continue
 		}
 	}
 
+	// Replies of the node streaming a room this socket watches
+	if err := out.subscribe(c.nats, lib.ConnSubject(out.id)); err != nil {
+		log.Println("❌ Error subscribing to NATS[conns.*]:", err)
+	}
+
+	// Notifications of this user, delivered to every open socket
+	if err := out.subscribe(c.nats, services.UserNotificationsSubject(userId)); err != nil {
+		log.Println("❌ Error subscribing to NATS[users.*.notifications]:", err)
+	}
+
 	// Listen for messages
 	for {
 		_, msg, err := conn.ReadMessage()
@@ -135,11 +123,23 @@ func (c *Controller) handleWebsocket(w http.ResponseWriter, r *http.Request) err
 		// Parse message
 		var initMsgObj dto.Message[any]
 		err = json.Unmarshal(msg, &initMsgObj)
-		fmt.Println("📥 Received:", string(initMsgObj.Subject))
-
 		if err != nil {
 			log.Println("Error Unmarshalling initMsgObj: ", err)
+			continue
+		}
+
+		if initMsgObj.Subject == "auth.refresh" {
+			c.handleAuthRefresh(out, auth, msg)
+			continue
+		}
+
+		// Every message is bound to the user the socket was opened by
+		if initMsgObj.Sender != "" && initMsgObj.Sender != userId {
+			log.Println("🔴 Rejected message with spoofed sender", initMsgObj.Sender, "from", userTag)
+			sendAuthMessage(out, "auth.error", dto.WsAuthPayload{Error: "Sender does not match the authenticated user"})
+			continue
 		}
+		initMsgObj.Sender = userId
 
 		if strings.HasPrefix(initMsgObj.Subject, "chat") {
 			msgObj := dto.Message[dto.ChatMessagePayload]{}
@@ -147,23 +147,43 @@ func (c *Controller) handleWebsocket(w http.ResponseWriter, r *http.Request) err
 
 			if len(s) < 2 {
 				log.Println("❌ Error chat message is not correct format, got:", string(msg))
+				continue
 			}
 
 			chatroomId := s[1]
-			json.Unmarshal(msg, &msgObj)
-
-			if !ok {
-				log.Println("❌ Failed to assert Payload as dto.ChatMessagePayload")
+			if err := json.Unmarshal(msg, &msgObj); err != nil {
+				log.Println("❌ Failed to unmarshal Payload as dto.ChatMessagePayload")
 				log.Println("Payload", string(msg))
-				return fmt.Errorf("invalid payload type")
+				continue
 			}
 
-			c.nats.Publish("chatrooms."+chatroomId, msg)
+			if !c.isChatroomMember(membership, userId, chatroomId) {
+				log.Println("🔴 Rejected chat message from", userTag, "to foreign chatroom", chatroomId)
+				continue
+			}
 
-			// Store message
-			if err := c.s.StoreChatMessage(initMsgObj.Sender, chatroomId, msgObj.Payload); err != nil {
-				log.Println("❌ Error storing chat message:", err)
-				break
+			if ok, err := c.s.CanMessage(userId, chatroomId); err != nil || !ok {
+				log.Println("🔴 Rejected chat message from", userTag, "to blocked direct message", chatroomId)
+				continue
+			}
+
+			msgObj.Sender = userId
+			msgObj.Payload.SenderName = user.Name
+			// The id makes a resent message a duplicate for the stream and
+			// the chat persister
+			if msgObj.Payload.Id == "" {
+				msgObj.Payload.Id, _ = lib.GenerateSecureRandomID(16)
+			}
+			bound, err := json.Marshal(msgObj)
+			if err != nil {
+				log.Println("❌ Error in chat message[Marshal]:", err)
+				continue
+			}
+
+			// Stored by the chat persister once the stream has it
+			msgId := services.ChatMessageId(userId, msgObj.Payload.Id)
+			if _, err := c.js.Publish(r.Context(), services.ChatroomSubject(chatroomId), bound, jetstream.WithMsgID(msgId)); err != nil {
+				log.Println("❌ Error publishing chat message:", err)
 			}
 		}
 
@@ -175,14 +195,21 @@ func (c *Controller) handleWebsocket(w http.ResponseWriter, r *http.Request) err
 				break
 			}
 			chatroomId := s[2]
-			c.nats.Publish("chatrooms."+chatroomId, msg)
+			if !c.isChatroomMember(membership, userId, chatroomId) {
+				log.Println("🔴 Rejected webrtc message from", userTag, "to foreign chatroom", chatroomId)
+				continue
+			}
+
+			bound, err := json.Marshal(initMsgObj)
+			if err != nil {
+				log.Println("❌ Error in webrtc message[Marshal]:", err)
+				continue
+			}
+			c.nats.Publish(initMsgObj.Subject, bound)
 		}
 
 		if strings.HasPrefix(initMsgObj.Subject, "stream") {
 			// The message form will be - stream.[type].[chatroomId]
-			// DEBUG
-			// log.Println("✅Message received in stream socket subject")
-
 			sp := strings.Split(initMsgObj.Subject, ".")
 			if len(sp) != 3 {
 				log.Println("❌ Error in msg[subject] length:(not 3)")
@@ -190,102 +217,25 @@ func (c *Controller) handleWebsocket(w http.ResponseWriter, r *http.Request) err
 			}
 			msgType := sp[1]
 			chatroomId := sp[2]
-			userId := initMsgObj.Sender
-			if userId == "" {
-				log.Println("🔴 No senderId provided in message")
-				log.Println("Sender", string(msg))
-				break
-			}
-
-			if msgType == "answer" {
-				payloadMap, ok := initMsgObj.Payload.(map[string]any)
-				if !ok {
-					log.Println("🔴 Payload is not a valid map[string]interface{}")
-					break
-				}
-
-				jsonBytes, err := json.Marshal(payloadMap)
-				if err != nil {
-					log.Println("🔴 Failed to marshal payload map to JSON:", err)
-					break
-				}
-
-				var desc webrtc.SessionDescription
-				err = json.Unmarshal(jsonBytes, &desc)
-				if err != nil {
-					log.Println("🔴Failed to unmarshal JSON to SessionDescription:", err)
-					break
-				}
-
-				c.mu.Lock()
-				err = c.conns[conn].StreamConfig.PeerConnection.SetRemoteDescription(desc)
-				c.mu.Unlock()
-				if err != nil {
-					log.Println("🔴Error setting remote description:", err)
-					break
-				}
-				log.Println("✅ Remote description set for", userId, ":", chatroomId)
-				log.Println("🔥 Webrtc Connection Established with", userId, ":", chatroomId)
-			}
-			if msgType == "ice" {
-				payloadMap, ok := initMsgObj.Payload.(map[string]any)
-				if !ok {
-					log.Println("Ice Candidate payload is not of type map[string]any")
-					break
-				}
-
-				jsonBytes, err := json.Marshal(payloadMap)
-				if err != nil {
-					log.Println("🔴 Failed to marshal payload map to JSON:", err)
-					break
-				}
-
-				var _candidate webrtc.ICECandidateInit
-				err = json.Unmarshal(jsonBytes, &_candidate)
-				if err != nil {
-					log.Println("🔴Failed to unmarshal JSON to ICECandidateInit:", err)
-					break
-				}
-
-				c.mu.Lock()
-				c.conns[conn].StreamConfig.PeerConnection.AddICECandidate(_candidate)
-				c.mu.Unlock()
-				// log.Println("✅ ICE candidate added for", userId, ":", chatroomId)
-			}
-
-			if msgType == "disconnected" {
-				log.Println("⭕User", userId, "disconnected from", chatroomId)
-				c.mu.Lock()
-				c.conns[conn].StreamConfig.PeerConnection.Close()
-				delete(c.conns, conn)
-				c.mu.Unlock()
+			if !c.isChatroomMember(membership, userId, chatroomId) {
+				log.Println("🔴 Rejected stream message from", userTag, "to foreign chatroom", chatroomId)
+				continue
 			}
 
-			if msgType == "stop-stream" {
-				log.Println("⛔ Stopping Stream")
-
-				err := c.browserManager.Pipeline.SetState(gst.StateNull)
-				if err != nil {
-					log.Println("Error stopping stream[Pipeline.SetState(gst.StateNull)]", err)
-					return err
-				}
-
-				userIds, err := c.s.Store.GetUsersByChatroomId(c.s.Ctx, chatroomId)
-				if err != nil {
-					log.Println("Error getting users by chatroom id:", err)
-					return err
-				}
-
-				for _, conn := range c.conns {
-					if lib.Contains(userIds, conn.UserId) {
-						conn.StreamConfig.PeerConnection.Close()
-					}
-				}
-
-				c.chatroomCtx[chatroomId].cancel()
-				delete(c.chatroomCtx, chatroomId)
-				log.Println("⛔👍Stream Ended")
+			// The peer connection lives on the worker streaming the room,
+			// the command goes there over NATS
+			streamMsg := dto.Message[json.RawMessage]{}
+			if err := json.Unmarshal(msg, &streamMsg); err != nil {
+				log.Println("🔴 Error in stream message[Unmarshal]:", err)
+				continue
 			}
+			c.routeStreamCommand(out, dto.StreamCommand{
+				ConnId:     out.id,
+				UserId:     userId,
+				ChatroomId: chatroomId,
+				Type:       msgType,
+				Payload:    streamMsg.Payload,
+			})
 		}
 	}
 
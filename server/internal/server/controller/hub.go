@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// Messages waiting for a slow socket. A client that falls this far behind
+	// is disconnected, it resumes from its last seq when it reconnects.
+	wsSendQueueSize = 256
+	wsWriteTimeout  = 10 * time.Second
+)
+
+var (
+	errWsClosed    = errors.New("websocket is closed")
+	errWsQueueFull = errors.New("websocket send queue is full")
+)
+
+type wsFrame struct {
+	messageType int
+	data        []byte
+}
+
+// wsHub owns one websocket connection. Everything sent to the client goes
+// through its queue and is written by a single goroutine, gorilla/websocket
+// supports only one concurrent writer. The NATS subscriptions and stream
+// consumers feeding the socket are tracked and removed when it closes.
+type wsHub struct {
+	// Other nodes reach the socket on services.ConnSubject(id)
+	id     string
+	userId string
+	conn   *websocket.Conn
+	send   chan wsFrame
+	done   chan struct{}
+	once   sync.Once
+
+	mu        sync.Mutex
+	closed    bool
+	subs      []*nats.Subscription
+	consumers []jetstream.ConsumeContext
+}
+
+func newWsHub(conn *websocket.Conn, userId string) *wsHub {
+	id, _ := lib.GenerateSecureRandomID(16)
+	h := &wsHub{
+		id:     id,
+		userId: userId,
+		conn:   conn,
+		send:   make(chan wsFrame, wsSendQueueSize),
+		done:   make(chan struct{}),
+	}
+	go h.writeLoop()
+	return h
+}
+
+func (h *wsHub) writeLoop() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case frame := <-h.send:
+			h.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := h.conn.WriteMessage(frame.messageType, frame.data); err != nil {
+				log.Println("❌ Error writing WebSocket message:", err)
+				h.shutdown()
+				return
+			}
+			if frame.messageType == websocket.CloseMessage {
+				h.shutdown()
+				return
+			}
+		}
+	}
+}
+
+func (h *wsHub) enqueue(frame wsFrame) error {
+	select {
+	case <-h.done:
+		return errWsClosed
+	default:
+	}
+
+	select {
+	case h.send <- frame:
+		return nil
+	case <-h.done:
+		return errWsClosed
+	default:
+		log.Println("❌ WebSocket send queue is full, closing the connection")
+		h.shutdown()
+		return errWsQueueFull
+	}
+}
+
+// Write queues a text message for the client
+func (h *wsHub) Write(data []byte) error {
+	return h.enqueue(wsFrame{messageType: websocket.TextMessage, data: data})
+}
+
+func (h *wsHub) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return h.Write(data)
+}
+
+// Close sends a close frame with code and reason after the messages already
+// queued and then closes the socket, which ends the read loop of the
+// connection.
+func (h *wsHub) Close(code int, reason string) {
+	frame := wsFrame{messageType: websocket.CloseMessage, data: websocket.FormatCloseMessage(code, reason)}
+	if err := h.enqueue(frame); err != nil {
+		h.shutdown()
+	}
+}
+
+// Done is closed once the hub is shut down
+func (h *wsHub) Done() <-chan struct{} {
+	return h.done
+}
+
+// subscribe forwards the messages of a NATS subject to the client until the
+// hub shuts down
+func (h *wsHub) subscribe(nc *nats.Conn, subject string) error {
+	sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		h.Write(msg.Data)
+	})
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		sub.Unsubscribe()
+		return errWsClosed
+	}
+	h.subs = append(h.subs, sub)
+	return nil
+}
+
+// track stops a stream consumer feeding the client when the hub shuts down
+func (h *wsHub) track(cc jetstream.ConsumeContext) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		cc.Stop()
+		return
+	}
+	h.consumers = append(h.consumers, cc)
+}
+
+// shutdown removes the subscriptions and consumers of the hub, stops the
+// writer and closes the socket. It is safe to call more than once.
+func (h *wsHub) shutdown() {
+	h.once.Do(func() {
+		h.mu.Lock()
+		h.closed = true
+		subs, consumers := h.subs, h.consumers
+		h.subs, h.consumers = nil, nil
+		h.mu.Unlock()
+
+		for _, sub := range subs {
+			if err := sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+				log.Println("❌ Error in wsHub.shutdown[Unsubscribe]:", sub.Subject, err)
+			}
+		}
+		for _, cc := range consumers {
+			cc.Stop()
+		}
+
+		close(h.done)
+		h.conn.Close()
+	})
+}
@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sideDesert/shiba/internal/natstest"
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/services"
+	"sideDesert/shiba/internal/server/store"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// dialTestWebsocket serves handle on a test server and returns the client
+// end of a websocket to it
+func dialTestWebsocket(t *testing.T, handle func(conn *websocket.Conn)) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		handle(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newTestService runs a service on st, the nodes of a test share one store
+func newTestService(st store.Store) *services.Service {
+	return services.NewServiceWithStore(context.Background(), &services.ServerConfig{}, st, nil)
+}
+
+func newTestUser(t *testing.T, s *services.Service, username string) string {
+	t.Helper()
+	userId, err := s.Store.CreateUser(s.Ctx, &dto.SignupUserRequest{
+		Name:     username,
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "correct horse battery",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *userId
+}
+
+// newTestChatroom creates a group chatroom owned by ownerId, who holds its
+// remote
+func newTestChatroom(t *testing.T, s *services.Service, ownerId string, name string, participants ...string) string {
+	t.Helper()
+	chatroomId, err := s.CreateChatRoom(ownerId, dto.CreateChatRoomRequest{Name: name, Participants: participants})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chatroomId
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWsHubSingleWriter(t *testing.T) {
+	const writers, perWriter = 8, 25
+
+	client := dialTestWebsocket(t, func(conn *websocket.Conn) {
+		hub := newWsHub(conn, "ada")
+		wg := sync.WaitGroup{}
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perWriter; i++ {
+					hub.Write([]byte(fmt.Sprintf("%d.%d", w, i)))
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	// Messages of one writer arrive in the order they were written
+	next := make([]int, writers)
+	for n := 0; n < writers*perWriter; n++ {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("after %d messages: %v", n, err)
+		}
+		var w, i int
+		fmt.Sscanf(string(data), "%d.%d", &w, &i)
+		if i != next[w] {
+			t.Fatalf("writer %d sent %d, want %d", w, i, next[w])
+		}
+		next[w]++
+	}
+}
+
+func TestWsHubCloseFlushesQueue(t *testing.T) {
+	client := dialTestWebsocket(t, func(conn *websocket.Conn) {
+		hub := newWsHub(conn, "ada")
+		hub.Write([]byte("bye"))
+		hub.Close(wsCloseSessionRevoked, "session revoked")
+		<-hub.Done()
+		if err := hub.Write([]byte("late")); !errors.Is(err, errWsClosed) {
+			t.Errorf("writing to a closed hub gave %v", err)
+		}
+	})
+
+	if _, data, err := client.ReadMessage(); err != nil || string(data) != "bye" {
+		t.Fatalf("first message = %q, %v", data, err)
+	}
+	_, _, err := client.ReadMessage()
+	if !websocket.IsCloseError(err, wsCloseSessionRevoked) {
+		t.Errorf("read after close gave %v, want close code %d", err, wsCloseSessionRevoked)
+	}
+}
+
+func TestWsHubDisconnectsSlowClient(t *testing.T) {
+	result := make(chan error, 1)
+	dialTestWebsocket(t, func(conn *websocket.Conn) {
+		hub := newWsHub(conn, "ada")
+		// The client never reads, the socket buffers fill up and then the queue
+		chunk := make([]byte, 64*1024)
+		for {
+			if err := hub.Write(chunk); err != nil {
+				result <- err
+				break
+			}
+		}
+		<-hub.Done()
+	})
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, errWsQueueFull) {
+			t.Errorf("writing to a slow client gave %v, want a full queue", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the queue never filled up")
+	}
+}
+
+func TestWsHubReleasesSubscriptions(t *testing.T) {
+	natsServer := natstest.NewServer(t)
+	nc, publisher := natsServer.Connect(t), natsServer.Connect(t)
+
+	hubs := make(chan *wsHub, 1)
+	client := dialTestWebsocket(t, func(conn *websocket.Conn) {
+		hub := newWsHub(conn, "ada")
+		for _, subject := range []string{"webrtc.*.a", "webrtc.*.b", "users.ada.notifications"} {
+			if err := hub.subscribe(nc, subject); err != nil {
+				t.Error(err)
+			}
+		}
+		hubs <- hub
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				break
+			}
+		}
+		hub.shutdown()
+	})
+	hub := <-hubs
+
+	waitFor(t, "the subscriptions", func() bool { return natsServer.Subscriptions() == 3 })
+	publisher.Publish("webrtc.offer.b", []byte("offer"))
+	if _, data, err := client.ReadMessage(); err != nil || string(data) != "offer" {
+		t.Fatalf("forwarded message = %q, %v", data, err)
+	}
+
+	client.Close()
+	<-hub.Done()
+	if n := nc.NumSubscriptions(); n != 0 {
+		t.Errorf("the connection still has %d subscriptions", n)
+	}
+	waitFor(t, "the server to drop the subscriptions", func() bool { return natsServer.Subscriptions() == 0 })
+
+	// A subscription racing with the disconnect is not kept either
+	if err := hub.subscribe(nc, "late"); !errors.Is(err, errWsClosed) {
+		t.Errorf("subscribing on a closed hub gave %v", err)
+	}
+	if n := nc.NumSubscriptions(); n != 0 {
+		t.Errorf("the connection has %d subscriptions after a late subscribe", n)
+	}
+}
+
+// testJetStream hands out ordered consumers that deliver nothing, counts how
+// many are still consuming and keeps the subjects published to
+type testJetStream struct {
+	jetstream.JetStream
+	consuming atomic.Int32
+
+	mu        sync.Mutex
+	published []string
+}
+
+func (js *testJetStream) Publish(ctx context.Context, subject string, payload []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.published = append(js.published, subject)
+	return &jetstream.PubAck{Stream: "test", Sequence: uint64(len(js.published))}, nil
+}
+
+func (js *testJetStream) publishedTo(subject string) bool {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return slices.Contains(js.published, subject)
+}
+
+type testConsumer struct {
+	jetstream.Consumer
+	js *testJetStream
+}
+
+type testConsumeContext struct {
+	jetstream.ConsumeContext
+	js   *testJetStream
+	once sync.Once
+}
+
+func (js *testJetStream) OrderedConsumer(ctx context.Context, stream string, cfg jetstream.OrderedConsumerConfig) (jetstream.Consumer, error) {
+	return &testConsumer{js: js}, nil
+}
+
+func (c *testConsumer) Consume(handler jetstream.MessageHandler, opts ...jetstream.PullConsumeOpt) (jetstream.ConsumeContext, error) {
+	c.js.consuming.Add(1)
+	return &testConsumeContext{js: c.js}, nil
+}
+
+func (cc *testConsumeContext) Stop() {
+	cc.once.Do(func() { cc.js.consuming.Add(-1) })
+}
+
+func TestWebsocketDisconnectReleasesSubscriptions(t *testing.T) {
+	const sockets = 3
+
+	natsServer := natstest.NewServer(t)
+	nc, publisher := natsServer.Connect(t), natsServer.Connect(t)
+	js := &testJetStream{}
+
+	s := newTestService(store.NewMemoryStore())
+	userId := newTestUser(t, s, "ada")
+	for _, name := range []string{"one", "two"} {
+		newTestChatroom(t, s, userId, name)
+	}
+
+	c := NewController(s, nc, js)
+	// The subscriptions of the node to stream joins and worker status
+	waitFor(t, "the node subscriptions", func() bool { return natsServer.Subscriptions() == 3 })
+	baseline := natsServer.Subscriptions()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), "userId", userId))
+		c.handleWebsocket(w, r)
+	}))
+	defer srv.Close()
+
+	clients := make([]*websocket.Conn, 0, sockets)
+	for i := 0; i < sockets; i++ {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+
+	// The subject of the socket, one webrtc subscription per chatroom and one
+	// for notifications
+	waitFor(t, "the subscriptions", func() bool { return natsServer.Subscriptions() == baseline+sockets*4 })
+	if n := js.consuming.Load(); n != sockets {
+		t.Errorf("%d room event consumers, want %d", n, sockets)
+	}
+
+	publisher.Publish(services.UserNotificationsSubject(userId), []byte(`{"subject":"notification"}`))
+	for _, client := range clients {
+		if _, data, err := client.ReadMessage(); err != nil || !strings.Contains(string(data), "notification") {
+			t.Fatalf("notification = %q, %v", data, err)
+		}
+	}
+
+	for _, client := range clients {
+		client.Close()
+	}
+	waitFor(t, "the server to drop the subscriptions", func() bool { return natsServer.Subscriptions() == baseline })
+	waitFor(t, "the connections to be forgotten", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.conns) == 0
+	})
+	if n := nc.NumSubscriptions(); n != baseline {
+		t.Errorf("the connection still has %d subscriptions", n-baseline)
+	}
+	if n := js.consuming.Load(); n != 0 {
+		t.Errorf("%d room event consumers are still running", n)
+	}
+}
+
+func TestControllerCloseReleasesSubscriptions(t *testing.T) {
+	natsServer := natstest.NewServer(t)
+	c := NewController(newTestService(store.NewMemoryStore()), natsServer.Connect(t), &testJetStream{})
+	waitFor(t, "the node subscriptions", func() bool { return natsServer.Subscriptions() == 3 })
+
+	c.Close(context.Background())
+	waitFor(t, "the node to unsubscribe", func() bool { return natsServer.Subscriptions() == 0 })
+}
@@ -4,84 +4,91 @@ import (
 	"context"
 	"log"
 	"net/http"
-	"sideDesert/shiba/internal/server/controller/common"
 	"sideDesert/shiba/internal/server/lib"
 	"sideDesert/shiba/internal/server/services"
-	vb "sideDesert/shiba/internal/vbrowser"
 
 	"sync"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 type Controller struct {
-	s              *services.Service
-	nats           *nats.Conn
-	conns          map[*websocket.Conn]*lib.ConnMap
-	chatroomCtx    map[string]ChatroomCtx
-	mu             sync.Mutex
-	browserManager *vb.VbrowserManager
+	s    *services.Service
+	nats *nats.Conn
+	js   jetstream.JetStream
+	// Websockets open on this node, keyed by hub id
+	conns map[string]*wsHub
+	mu    sync.Mutex
+	// Subjects the node listens on for the workers, released by Close
+	subs []*nats.Subscription
 }
 
-type ChatroomCtx struct {
-	ctx       context.Context
-	cancel    context.CancelFunc
-	Streaming bool
-}
-
-func (c *Controller) CloseDbConn(ctx context.Context) {
+// Close stops listening to the workers and closes the database pool
+func (c *Controller) Close(ctx context.Context) {
+	for _, sub := range c.subs {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Println("Error in Close[Unsubscribe]:", sub.Subject, err)
+		}
+	}
+	c.subs = nil
 	c.s.Store.Close(ctx)
 }
 
-func NewController(s *services.Service, nats *nats.Conn, browserManager *vb.VbrowserManager) *Controller {
-	return &Controller{
-		s:              s,
-		nats:           nats,
-		conns:          make(map[*websocket.Conn]*lib.ConnMap),
-		chatroomCtx:    make(map[string]ChatroomCtx),
-		browserManager: browserManager,
+func NewController(s *services.Service, nats *nats.Conn, js jetstream.JetStream) *Controller {
+	c := &Controller{
+		s:     s,
+		nats:  nats,
+		js:    js,
+		conns: make(map[string]*wsHub),
+	}
+
+	// Whichever worker streams a room asks every node for its watchers
+	if sub, err := nats.Subscribe(lib.StreamJoinSubject("*"), c.handleStreamJoin); err != nil {
+		log.Println("Error in NewController[Subscribe(join)]:", err)
+	} else {
+		c.subs = append(c.subs, sub)
 	}
+	// Every node knows the workers, one of them keeps their rooms claimed
+	if sub, err := nats.Subscribe(lib.WorkerStatusSubject, c.handleWorkerStatus); err != nil {
+		log.Println("Error in NewController[Subscribe(status)]:", err)
+	} else {
+		c.subs = append(c.subs, sub)
+	}
+	if sub, err := nats.QueueSubscribe(lib.WorkerStatusSubject, "stream-rooms", c.refreshStreamRooms); err != nil {
+		log.Println("Error in NewController[QueueSubscribe(status)]:", err)
+	} else {
+		c.subs = append(c.subs, sub)
+	}
+	return c
 }
 
 func (c *Controller) Run(port string) {
 	router := mux.NewRouter()
 	router.Use(lib.AllowCors)
 
-	controllerMap := map[string]*common.ControllerMapValue{
-		"health":         common.NewCMV(c.handleHealth, false),
-		"signup":         common.NewCMV(c.handleSignup, false),
-		"logout":         common.NewCMV(c.handleLogout, false),
-		"user":           common.NewCMV(c.handleUser, false),
-		"login/oauth":    common.NewCMV(c.handleLogin, false),
-		"oauth/callback": common.NewCMV(c.handleOAuthCallback, false),
-
-		// These are protected
-		"chat":             common.NewCMV(c.handleWebsocket, true),
-		"chatroom":         common.NewCMV(c.handleChatRoom, true),
-		"chatroom/history": common.NewCMV(c.handleChatHistory, true),
-		"friends":          common.NewCMV(c.handleFriends, true),
-		"notifications":    common.NewCMV(c.handleNotifications, true),
-		"search":           common.NewCMV(c.handleSearch, true),
-		"stream":           common.NewCMV(c.handleStream, true),
-		"remote":           common.NewCMV(c.handleRemote, true),
-	}
-
-	for key, value := range controllerMap {
-		ep := "/" + key
-		handler := lib.CreateHTTPHandleFunc(value.Handler)
+	api := router.PathPrefix(lib.ApiPrefix).Subrouter()
+	// Preflight requests are answered by AllowCors whatever the path
+	api.Methods(http.MethodOptions).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
-		if value.Protected {
-			// Wrap handler with middleware properly
-			wrappedHandler := lib.AuthenticateMiddleware(http.HandlerFunc(handler))
-			handler = func(w http.ResponseWriter, r *http.Request) {
-				wrappedHandler.ServeHTTP(w, r)
-			}
+	for _, route := range c.routes() {
+		handler := http.Handler(http.HandlerFunc(lib.CreateHTTPHandleFunc(route.Handler)))
+		if route.Protected {
+			handler = lib.AuthenticateMiddleware(handler)
 		}
-		router.HandleFunc(ep, handler)
+		api.Handle(route.Path, handler).Methods(route.Method)
 	}
 
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lib.WriteError(w, r, lib.NotFound("No route for "+r.URL.Path))
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lib.WriteError(w, r, lib.MethodNotAllowed(r.Method))
+	})
+	api.NotFoundHandler = router.NotFoundHandler
+	api.MethodNotAllowedHandler = router.MethodNotAllowedHandler
+
 	log.Println("API Server Running on port", port)
 	err := http.ListenAndServe(port, router)
 
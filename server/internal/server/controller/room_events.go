@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/services"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// roomEventsSince reads ?since= of a websocket request, the seq of the last
+// room event the client got before it was disconnected
+func roomEventsSince(r *http.Request) (uint64, error) {
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(since, 10, 64)
+	if err != nil {
+		return 0, lib.BadRequest("since must be a sequence number")
+	}
+	return seq, nil
+}
+
+// consumeRoomEvents forwards the events of the given chatrooms from the
+// chatroom stream to a websocket, each with its seq. A new connection gets
+// the events published from now on, a resumed one first gets those after
+// since. The consumer is stopped when the hub shuts down.
+func (c *Controller) consumeRoomEvents(ctx context.Context, out *wsHub, chatrooms []lib.Chatroom, since uint64) error {
+	// Without a filter the consumer would get every room
+	if len(chatrooms) == 0 {
+		return nil
+	}
+
+	config := jetstream.OrderedConsumerConfig{DeliverPolicy: jetstream.DeliverNewPolicy}
+	for _, room := range chatrooms {
+		config.FilterSubjects = append(config.FilterSubjects, services.ChatroomSubject(room.Id))
+	}
+	if since > 0 {
+		config.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		config.OptStartSeq = since + 1
+		if err := c.sendRoomResumed(ctx, out, since); err != nil {
+			return err
+		}
+	}
+
+	consumer, err := c.js.OrderedConsumer(ctx, services.ChatroomStream, config)
+	if err != nil {
+		log.Println("Error in consumeRoomEvents[OrderedConsumer]:", err)
+		return err
+	}
+
+	cc, err := consumer.Consume(func(msg jetstream.Msg) {
+		data, err := withRoomSeq(msg)
+		if err != nil {
+			log.Println("❌ Error in consumeRoomEvents[withRoomSeq]:", err)
+			return
+		}
+		out.Write(data)
+	})
+	if err != nil {
+		log.Println("Error in consumeRoomEvents[Consume]:", err)
+		return err
+	}
+	out.track(cc)
+	return nil
+}
+
+// sendRoomResumed tells a resuming client whether events after since have
+// already expired from the stream
+func (c *Controller) sendRoomResumed(ctx context.Context, out *wsHub, since uint64) error {
+	stream, err := c.js.Stream(ctx, services.ChatroomStream)
+	if err != nil {
+		log.Println("Error in sendRoomResumed[Stream]:", err)
+		return err
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		log.Println("Error in sendRoomResumed[Info]:", err)
+		return err
+	}
+
+	return out.WriteJSON(dto.Message[dto.RoomResumedPayload]{
+		Sender:  "server",
+		Subject: "room.resumed",
+		Payload: dto.RoomResumedPayload{Since: since, Gap: info.State.FirstSeq > since+1},
+	})
+}
+
+func withRoomSeq(msg jetstream.Msg) ([]byte, error) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	event := dto.Message[json.RawMessage]{}
+	if err := json.Unmarshal(msg.Data(), &event); err != nil {
+		return nil, err
+	}
+	event.Seq = meta.Sequence.Stream
+	return json.Marshal(event)
+}
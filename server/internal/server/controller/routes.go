@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"net/http"
+
+	"sideDesert/shiba/internal/server/controller/common"
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/store"
+
+	"github.com/gorilla/mux"
+)
+
+// routes is the route table of the API, every path is served under
+// lib.ApiPrefix. Order matters where two patterns can match the same path,
+// the first one wins.
+func (c *Controller) routes() []*common.Route {
+	return []*common.Route{
+		common.NewRoute(http.MethodGet, "/health", c.handleHealth, false).
+			Doc("Health check"),
+		common.NewRoute(http.MethodGet, "/openapi.json", c.handleOpenAPI, false).
+			Doc("This document"),
+
+		// Auth
+		common.NewRoute(http.MethodPost, "/signup", c.handleSignup, false).
+			Doc("Create an account").Body(dto.SignupUserRequest{}).Returns(dto.SignupUserResponse{}),
+		common.NewRoute(http.MethodPost, "/login", c.handlePasswordLogin, false).
+			Doc("Log in with email and password").Body(dto.LoginRequest{}).Returns(dto.LoginResponse{}),
+		common.NewRoute(http.MethodPost, "/logout", c.handleLogout, false).
+			Doc("Log out of this session"),
+		common.NewRoute(http.MethodPost, "/auth/refresh", c.handleRefresh, false).
+			Doc("Rotate the refresh cookie and issue a new access token").Returns(dto.RefreshResponse{}),
+		common.NewRoute(http.MethodPost, "/auth/ws-ticket", c.handleWsTicket, true).
+			Doc("Issue a ticket that extends an open websocket to the current access token").Returns(dto.WsTicketResponse{}),
+		common.NewRoute(http.MethodPost, "/password/forgot", c.handleForgotPassword, false).
+			Doc("Email a password reset link").Body(dto.ForgotPasswordRequest{}).Returns(dto.PatchOKResponse{}),
+		common.NewRoute(http.MethodPost, "/password/reset", c.handleResetPassword, false).
+			Doc("Set a new password with a reset token").Body(dto.ResetPasswordRequest{}).Returns(dto.PatchOKResponse{}),
+		common.NewRoute(http.MethodGet, "/oauth/providers", c.handleOAuthProviders, false).
+			Doc("List the configured OAuth providers").Returns(dto.OAuthProvidersResponse{}),
+		common.NewRoute(http.MethodGet, "/login/oauth/{provider}", c.handleLogin, false).
+			Doc("Redirect to the OAuth provider, link=1 adds it to the signed in account").Params("link"),
+		common.NewRoute(http.MethodGet, "/oauth/callback/{provider}", c.handleOAuthCallback, false).
+			Doc("OAuth redirect target").Params("state", "code"),
+
+		// User
+		common.NewRoute(http.MethodGet, "/user", c.handleUser, true).
+			Doc("The signed in user").Returns(dto.UserResponse{}),
+		common.NewRoute(http.MethodPatch, "/user", c.handleUpdateUser, true).
+			Doc("Change the name, username or bio").Body(dto.UpdateUserRequest{}).Returns(dto.UserResponse{}),
+		common.NewRoute(http.MethodDelete, "/user", c.handleDeleteUser, true).
+			Doc("Delete the account after a grace period, logging in again cancels it").Body(dto.DeleteAccountRequest{}).Returns(dto.DeleteAccountResponse{}),
+		common.NewRoute(http.MethodGet, "/user/export", c.handleExportUser, true).
+			Doc("Download a zip archive of the user's profile, friends, rooms and messages"),
+		common.NewRoute(http.MethodPut, "/user/avatar", c.handleUploadAvatar, true).
+			Doc("Upload an avatar as a multipart file field, it is cropped to a square").Returns(dto.UserResponse{}),
+		common.NewRoute(http.MethodDelete, "/user/avatar", c.handleRemoveAvatar, true).
+			Doc("Remove the avatar").Returns(dto.UserResponse{}),
+		common.NewRoute(http.MethodGet, "/users/{userId}/avatar/{avatarId}", c.handleAvatar, true).
+			Doc("An uploaded avatar"),
+		common.NewRoute(http.MethodPut, "/user/password", c.handleChangePassword, true).
+			Doc("Change the password").Body(dto.ChangePasswordRequest{}).Returns(dto.PatchOKResponse{}),
+		common.NewRoute(http.MethodGet, "/user/identities", c.handleGetIdentities, true).
+			Doc("List the linked OAuth providers").Returns([]lib.UserIdentity{}),
+		common.NewRoute(http.MethodDelete, "/user/identities/{provider}", c.handleUnlinkIdentity, true).
+			Doc("Unlink an OAuth provider"),
+		common.NewRoute(http.MethodGet, "/sessions", c.handleSessions, true).
+			Doc("List the active sessions").Returns([]lib.Session{}),
+		common.NewRoute(http.MethodPost, "/logout/all", c.handleLogoutAll, true).
+			Doc("Revoke every session"),
+		common.NewRoute(http.MethodGet, "/search", c.handleSearch, true).
+			Doc("Search users").Params("q").Returns([]dto.SearchUserResponse{}),
+
+		// Friends
+		common.NewRoute(http.MethodGet, "/friends", c.handleGetFriends, true).
+			Doc("List friends").Returns([]store.UserFriend{}),
+		common.NewRoute(http.MethodPost, "/friends", c.handleSendFriendRequest, true).
+			Doc("Send a friend request").Body(dto.SendFriendRequest{}).Returns(dto.FriendResponse{}),
+		common.NewRoute(http.MethodPatch, "/friends", c.handleRespondFriendRequest, true).
+			Doc("Accept, reject or block an incoming friend request").Body(dto.FriendStatusRequest{}).Returns(dto.FriendResponse{}),
+		common.NewRoute(http.MethodGet, "/friends/suggestions", c.handleFriendSuggestions, true).
+			Doc("People the user may know, ranked by mutual friends and shared chatrooms").Returns([]dto.FriendSuggestionResponse{}),
+		common.NewRoute(http.MethodGet, "/friends/requests", c.handleFriendRequests, true).
+			Doc("List pending friend requests, sent=true for the ones sent").Params("sent").Returns([]dto.FriendRequestResponse{}),
+		common.NewRoute(http.MethodDelete, "/friends/requests/{id}", c.handleCancelFriendRequest, true).
+			Doc("Cancel a sent friend request").Returns(dto.FriendResponse{}),
+		common.NewRoute(http.MethodDelete, "/friends/{userId}", c.handleUnfriend, true).
+			Doc("Unfriend a user").Returns(dto.FriendResponse{}),
+		common.NewRoute(http.MethodPost, "/friends/{userId}/dm", c.handleOpenDirectMessage, true).
+			Doc("Get or create the direct message room with a friend").Returns(dto.CreateChatRoomResponse{}),
+		common.NewRoute(http.MethodGet, "/blocks", c.handleGetBlocks, true).
+			Doc("List blocked users").Returns([]dto.BlockedUserResponse{}),
+		common.NewRoute(http.MethodPost, "/blocks", c.handleBlockUser, true).
+			Doc("Block a user").Body(dto.BlockUserRequest{}).Returns(dto.FriendResponse{}),
+		common.NewRoute(http.MethodDelete, "/blocks/{userId}", c.handleUnblockUser, true).
+			Doc("Unblock a user").Returns(dto.FriendResponse{}),
+
+		// Notifications
+		common.NewRoute(http.MethodGet, "/notifications", c.handleGetNotifications, true).
+			Doc("List notifications").Params("unread", "page").Returns(dto.NotificationsResponse{}),
+		common.NewRoute(http.MethodPatch, "/notifications", c.handleMarkNotificationsRead, true).
+			Doc("Mark notifications as read").Body(dto.MarkNotificationsReadRequest{}).Returns(dto.MarkNotificationsReadResponse{}),
+
+		// Chatrooms
+		common.NewRoute(http.MethodGet, "/chatrooms", c.handleGetChatRooms, true).
+			Doc("List the chatrooms of the user").Returns(dto.ChatroomsResponse{}),
+		common.NewRoute(http.MethodPost, "/chatrooms", c.handleCreateChatRoom, true).
+			Doc("Create a chatroom").Body(dto.CreateChatRoomRequest{}).Returns(dto.CreateChatRoomResponse{}),
+		common.NewRoute(http.MethodGet, "/chatrooms/{id}", c.handleGetChatRoom, true).
+			Doc("Settings of a chatroom and the role of the user in it").Returns(dto.ChatroomResponse{}),
+		common.NewRoute(http.MethodPatch, "/chatrooms/{id}", c.handleUpdateChatRoom, true).
+			Doc("Change the settings of a chatroom, owners only").Body(dto.UpdateChatroomRequest{}).Returns(dto.ChatroomResponse{}),
+		common.NewRoute(http.MethodPut, "/chatrooms/{id}/avatar", c.handleUploadChatRoomAvatar, true).
+			Doc("Upload the chatroom avatar as multipart field \"file\", owners only").Returns(dto.ChatroomResponse{}),
+		common.NewRoute(http.MethodDelete, "/chatrooms/{id}/avatar", c.handleRemoveChatRoomAvatar, true).
+			Doc("Remove the chatroom avatar, owners only").Returns(dto.ChatroomResponse{}),
+		common.NewRoute(http.MethodGet, "/chatrooms/{id}/avatar/{avatarId}", c.handleChatRoomAvatar, true).
+			Doc("Download a chatroom avatar"),
+		common.NewRoute(http.MethodGet, "/chatrooms/{id}/history", c.handleChatHistory, true).
+			Doc("Page through the messages of a chatroom").Params("page", "mentions").Returns([]lib.Message{}),
+		common.NewRoute(http.MethodPost, "/chatrooms/{id}/attachments", c.handleAttachmentUpload, true).
+			Doc("Upload a file as multipart field \"file\"").Returns(lib.Attachment{}),
+		common.NewRoute(http.MethodGet, "/chatrooms/{id}/remote", c.handleGetRemote, true).
+			Doc("Who holds the remote of the chatroom").Returns(dto.RemoteResponse{}),
+		common.NewRoute(http.MethodPatch, "/chatrooms/{id}/remote", c.handleChangeRemote, true).
+			Doc("Hand the remote to another member").Body(dto.ChangeChatroomRemoteRequest{}).Returns(dto.PatchOKResponse{}),
+		common.NewRoute(http.MethodPost, "/chatrooms/{id}/stream", c.handleStream, true).
+			Doc("Start streaming the shared browser"),
+		common.NewRoute(http.MethodGet, "/ws", c.handleWebsocket, true).
+			Doc("Websocket carrying the events of every chatroom of the user").Params("since"),
+		common.NewRoute(http.MethodGet, "/attachments/{id}", c.handleAttachment, true).
+			Doc("Download an attachment, thumb=1 for the image thumbnail").Params("thumb"),
+	}
+}
+
+// GET /openapi.json
+func (c *Controller) handleOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	return lib.WriteJSON(w, r, http.StatusOK, common.OpenAPI("Shiba API", "1.0.0", lib.ApiPrefix, c.routes()))
+}
+
+func pathParam(r *http.Request, name string) string {
+	return mux.Vars(r)[name]
+}
@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Starting waits for the browser and the pipeline of the worker. It stays
+	// below services.StreamRoomTTL so the claim is still fresh when it ends.
+	workerStartTimeout   = 25 * time.Second
+	workerRequestTimeout = 15 * time.Second
+)
+
+// requestWorker sends a start, stop or navigate request to a streaming worker
+// and turns a failed reply into an ApiError
+func (c *Controller) requestWorker(workerId string, op string, req any, timeout time.Duration) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	msg, err := c.nats.Request(lib.WorkerSubject(workerId, op), data, timeout)
+	if errors.Is(err, nats.ErrNoResponders) || errors.Is(err, nats.ErrTimeout) {
+		log.Println("Error in requestWorker[Request]:", workerId, op, err)
+		return lib.Unavailable("Streaming worker is not responding").Wrap(err)
+	}
+	if err != nil {
+		log.Println("Error in requestWorker[Request]:", workerId, op, err)
+		return err
+	}
+
+	res := dto.WorkerReply{}
+	if err := json.Unmarshal(msg.Data, &res); err != nil {
+		return err
+	}
+	if res.Error != "" {
+		if res.Status == 0 {
+			return lib.Unavailable(res.Error)
+		}
+		return lib.NewApiError(res.Status, res.Code, res.Error)
+	}
+	return nil
+}
+
+// handleWorkerStatus keeps the registry of this node up to date
+func (c *Controller) handleWorkerStatus(msg *nats.Msg) {
+	status := dto.WorkerStatus{}
+	if err := json.Unmarshal(msg.Data, &status); err != nil {
+		log.Println("Error in handleWorkerStatus[Unmarshal]:", err)
+		return
+	}
+	c.s.UpdateWorker(status)
+}
+
+// refreshStreamRooms keeps the claims of a worker alive. One node of the
+// queue group handles each status.
+func (c *Controller) refreshStreamRooms(msg *nats.Msg) {
+	status := dto.WorkerStatus{}
+	if err := json.Unmarshal(msg.Data, &status); err != nil {
+		log.Println("Error in refreshStreamRooms[Unmarshal]:", err)
+		return
+	}
+	c.s.RefreshStreamRooms(status.WorkerId, status.Rooms)
+}
+
+// handleStreamJoin answers a StreamJoinRequest with a "join" command for each
+// websocket of a member open on this node
+func (c *Controller) handleStreamJoin(msg *nats.Msg) {
+	req := dto.StreamJoinRequest{}
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		log.Println("Error in handleStreamJoin[Unmarshal]:", err)
+		return
+	}
+
+	c.mu.Lock()
+	joining := make([]*wsHub, 0)
+	for _, hub := range c.conns {
+		if slices.Contains(req.MemberIds, hub.userId) {
+			joining = append(joining, hub)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, hub := range joining {
+		c.publishStreamCommand(dto.StreamCommand{
+			ConnId:     hub.id,
+			UserId:     hub.userId,
+			ChatroomId: req.ChatroomId,
+			Type:       "join",
+		})
+	}
+}
+
+// routeStreamCommand handles a stream command of a websocket on this node.
+// Signalling goes straight to the worker streaming the chatroom, the
+// commands of the remote holder are checked here first.
+func (c *Controller) routeStreamCommand(out *wsHub, cmd dto.StreamCommand) {
+	switch cmd.Type {
+	case "navigate":
+		// Navigating waits for the page, the read loop goes on meanwhile
+		go c.handleBrowserNavigate(out, cmd)
+
+	case "stop-stream":
+		go c.stopStream(out, cmd)
+
+	case "input":
+		ev := dto.StreamInputPayload{}
+		if err := json.Unmarshal(cmd.Payload, &ev); err != nil {
+			c.sendStreamError(out, cmd.ChatroomId, "Invalid input payload")
+			return
+		}
+		if err := lib.Validate(ev); err != nil {
+			c.sendStreamError(out, cmd.ChatroomId, "Invalid input payload")
+			return
+		}
+		if !c.s.CheckUserIsRemoteForChatroom(cmd.UserId, cmd.ChatroomId) {
+			c.sendStreamError(out, cmd.ChatroomId, "Only the remote holder can control the browser")
+			return
+		}
+		c.publishStreamCommand(cmd)
+
+	default:
+		c.publishStreamCommand(cmd)
+	}
+}
+
+// stopStream asks the worker streaming a chatroom to stop and gives up the
+// room
+func (c *Controller) stopStream(out *wsHub, cmd dto.StreamCommand) {
+	if !c.s.CheckUserIsRemoteForChatroom(cmd.UserId, cmd.ChatroomId) {
+		log.Println("🔴 Rejected stop-stream from", cmd.UserId, "who is not remote for", cmd.ChatroomId)
+		c.sendStreamError(out, cmd.ChatroomId, "Only the remote holder can stop the stream")
+		return
+	}
+
+	workerId, err := c.s.StreamRoomWorker(cmd.ChatroomId)
+	if err != nil || workerId == "" {
+		c.sendStreamError(out, cmd.ChatroomId, "Chatroom is not streaming")
+		return
+	}
+
+	log.Println("⛔ Stopping Stream")
+	err = c.requestWorker(workerId, "stop", dto.WorkerStopRequest{ChatroomId: cmd.ChatroomId}, workerRequestTimeout)
+	// A worker that doesn't stream the room anymore lost it, the claim goes
+	// either way
+	if err != nil && lib.AsApiError(err).Status != http.StatusNotFound {
+		c.sendStreamError(out, cmd.ChatroomId, lib.AsApiError(err).Message)
+		return
+	}
+	c.s.ReleaseStreamRoom(cmd.ChatroomId, workerId)
+	log.Println("⛔👍Stream Ended")
+}
+
+func (c *Controller) publishStreamCommand(cmd dto.StreamCommand) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		log.Println("Error in publishStreamCommand[Marshal]:", err)
+		return
+	}
+	if err := c.nats.Publish(lib.StreamCommandsSubject(cmd.ChatroomId), data); err != nil {
+		log.Println("Error in publishStreamCommand[Publish]:", err)
+	}
+}
+
+// leaveStreams drops the stream peers of a closed websocket wherever its
+// chatrooms are streamed
+func (c *Controller) leaveStreams(out *wsHub, membership *chatroomMembership) {
+	membership.mu.Lock()
+	chatroomIds := make([]string, 0, len(membership.rooms))
+	for chatroomId := range membership.rooms {
+		chatroomIds = append(chatroomIds, chatroomId)
+	}
+	membership.mu.Unlock()
+
+	for _, chatroomId := range chatroomIds {
+		c.publishStreamCommand(dto.StreamCommand{
+			ConnId:     out.id,
+			UserId:     out.userId,
+			ChatroomId: chatroomId,
+			Type:       "disconnected",
+		})
+	}
+}
+
+func (c *Controller) sendStreamError(out *wsHub, chatroomId string, msg string) {
+	err := out.WriteJSON(dto.Message[dto.StreamErrorPayload]{
+		Sender:  "server",
+		Subject: "stream.error." + chatroomId,
+		Payload: dto.StreamErrorPayload{Error: msg},
+	})
+	if err != nil {
+		log.Println("❌ Error in sendStreamError[WriteJSON]:", err)
+	}
+}
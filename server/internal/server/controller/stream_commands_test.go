@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sideDesert/shiba/internal/natstest"
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/services"
+	"sideDesert/shiba/internal/server/store"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+)
+
+// readSubject reads messages from client until one has subject
+func readSubject(t *testing.T, client *websocket.Conn, subject string) dto.Message[json.RawMessage] {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer client.SetReadDeadline(time.Time{})
+	for {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", subject, err)
+		}
+		msg := dto.Message[json.RawMessage]{}
+		if err := json.Unmarshal(data, &msg); err == nil && msg.Subject == subject {
+			return msg
+		}
+	}
+}
+
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+// testWorker answers the requests of the API nodes the way cmd/vbrowser does
+// and records them
+type testWorker struct {
+	id       string
+	nc       *nats.Conn
+	requests chan *nats.Msg
+}
+
+func newTestWorker(t *testing.T, natsServer *natstest.Server, id string) *testWorker {
+	t.Helper()
+	w := &testWorker{id: id, nc: natsServer.Connect(t), requests: make(chan *nats.Msg, 16)}
+	_, err := w.nc.Subscribe(lib.WorkerSubject(id, "*"), func(msg *nats.Msg) {
+		w.requests <- msg
+		data, _ := json.Marshal(dto.WorkerReply{})
+		msg.Respond(data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func (w *testWorker) publishStatus(t *testing.T, rooms ...string) {
+	t.Helper()
+	data, _ := json.Marshal(dto.WorkerStatus{
+		WorkerId:  w.id,
+		Capacity:  1,
+		Rooms:     rooms,
+		Healthy:   true,
+		StartedAt: time.Now(),
+	})
+	if err := w.nc.Publish(lib.WorkerStatusSubject, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startStream calls POST /chatrooms/{id}/stream on c as userId
+func startStream(c *Controller, userId string, chatroomId string) (*httptest.ResponseRecorder, error) {
+	r := httptest.NewRequest(http.MethodPost, "/chatrooms/"+chatroomId+"/stream", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "userId", userId))
+	r = mux.SetURLVars(r, map[string]string{"id": chatroomId})
+	w := httptest.NewRecorder()
+	return w, c.handleStream(w, r)
+}
+
+// Two API nodes share the database and NATS, the stream runs on a worker.
+// The remote holder starts it through node A while a member watches over a
+// websocket open on node B.
+func TestStreamCommandsRouteToWorker(t *testing.T) {
+	natsServer := natstest.NewServer(t)
+	st := store.NewMemoryStore()
+	js := &testJetStream{}
+
+	newNode := func() *Controller {
+		return NewController(newTestService(st), natsServer.Connect(t), js)
+	}
+	a, b := newNode(), newNode()
+	waitFor(t, "the node subscriptions", func() bool { return natsServer.Subscriptions() == 6 })
+
+	ada, bob := newTestUser(t, a.s, "ada"), newTestUser(t, a.s, "bob")
+	sent, err := a.s.SendFriendRequest(ada, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.s.HandleFriendRequest(bob, sent.RequestId, services.FriendAccepted); err != nil {
+		t.Fatal(err)
+	}
+	chatroomId := newTestChatroom(t, a.s, ada, "room", bob)
+
+	dial := func(c *Controller, userId string) *websocket.Conn {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), "userId", userId))
+			c.handleWebsocket(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	adaSocket, bobSocket := dial(a, ada), dial(b, bob)
+	// The socket subject, a webrtc subscription per room (accepting the
+	// friend request opened a direct message) and notifications
+	rooms, _ := b.s.GetUserChatRooms(bob)
+	waitFor(t, "the socket subscriptions", func() bool { return natsServer.Subscriptions() == 6+2*(len(rooms)+2) })
+
+	if _, err := startStream(a, ada, chatroomId); lib.AsApiError(err).Status != http.StatusServiceUnavailable {
+		t.Errorf("starting without a worker gave %v", err)
+	}
+
+	worker := newTestWorker(t, natsServer, "w1")
+	commands := make(chan dto.StreamCommand, 16)
+	worker.nc.Subscribe(lib.StreamCommandsSubject(chatroomId), func(msg *nats.Msg) {
+		cmd := dto.StreamCommand{}
+		json.Unmarshal(msg.Data, &cmd)
+		commands <- cmd
+	})
+	worker.publishStatus(t)
+	waitFor(t, "the nodes to know the worker", func() bool {
+		return len(a.s.StreamWorkers()) == 1 && len(b.s.StreamWorkers()) == 1
+	})
+
+	if _, err := startStream(a, bob, chatroomId); lib.AsApiError(err).Status != http.StatusForbidden {
+		t.Errorf("starting as a viewer gave %v", err)
+	}
+	res, err := startStream(a, ada, chatroomId)
+	if err != nil || !strings.Contains(res.Body.String(), "started") {
+		t.Fatalf("starting the stream gave %v %s", err, res.Body)
+	}
+	start := dto.WorkerStartRequest{}
+	json.Unmarshal(receive(t, worker.requests, "the start request").Data, &start)
+	if start.ChatroomId != chatroomId || !slices.Contains(start.MemberIds, bob) {
+		t.Errorf("the worker was asked to start %+v", start)
+	}
+	if workerId, _ := b.s.StreamRoomWorker(chatroomId); workerId != "w1" {
+		t.Errorf("the room is streamed by %q, want w1", workerId)
+	}
+	if _, err := startStream(b, ada, chatroomId); lib.AsApiError(err).Status != http.StatusConflict {
+		t.Errorf("starting the stream twice gave %v", err)
+	}
+
+	// Each node answers the join request of the worker for its sockets
+	data, _ := json.Marshal(dto.StreamJoinRequest{ChatroomId: chatroomId, MemberIds: []string{ada, bob}})
+	worker.nc.Publish(lib.StreamJoinSubject(chatroomId), data)
+	joins := map[string]string{}
+	for len(joins) < 2 {
+		join := receive(t, commands, "the joins")
+		if join.Type != "join" || join.ConnId == "" {
+			t.Fatalf("got %+v, want a join", join)
+		}
+		joins[join.UserId] = join.ConnId
+	}
+
+	// The offer of the worker reaches bob's socket on node B and the answer
+	// comes back bound to it
+	offer, _ := json.Marshal(dto.Message[string]{Sender: "server", Subject: "stream.offer." + chatroomId + "." + bob, Payload: "v=0"})
+	worker.nc.Publish(lib.ConnSubject(joins[bob]), offer)
+	readSubject(t, bobSocket, "stream.offer."+chatroomId+"."+bob)
+	bobSocket.WriteJSON(dto.Message[map[string]string]{
+		Subject: "stream.answer." + chatroomId,
+		Payload: map[string]string{"type": "answer", "sdp": "v=0"},
+	})
+	answer := receive(t, commands, "the answer")
+	if answer.Type != "answer" || answer.ConnId != joins[bob] || answer.UserId != bob {
+		t.Errorf("the worker got %+v", answer)
+	}
+
+	// Only the remote holder controls the browser
+	move := dto.StreamInputPayload{Type: "mousemove", X: 0.5, Y: 0.5}
+	bobSocket.WriteJSON(dto.Message[dto.StreamInputPayload]{Subject: "stream.input." + chatroomId, Payload: move})
+	readSubject(t, bobSocket, "stream.error."+chatroomId)
+	adaSocket.WriteJSON(dto.Message[dto.StreamInputPayload]{Subject: "stream.input." + chatroomId, Payload: move})
+	if input := receive(t, commands, "the input"); input.Type != "input" || input.UserId != ada {
+		t.Errorf("the worker got %+v", input)
+	}
+
+	bobSocket.WriteJSON(dto.Message[dto.BrowserNavigatePayload]{
+		Subject: "stream.navigate." + chatroomId,
+		Payload: dto.BrowserNavigatePayload{Url: "https://93.184.216.34/"},
+	})
+	streamErr := readSubject(t, bobSocket, "stream.error."+chatroomId)
+	if !strings.Contains(string(streamErr.Payload), "not remote") {
+		t.Errorf("navigating as a viewer gave %s", streamErr.Payload)
+	}
+	adaSocket.WriteJSON(dto.Message[dto.BrowserNavigatePayload]{
+		Subject: "stream.navigate." + chatroomId,
+		Payload: dto.BrowserNavigatePayload{Url: "https://93.184.216.34/"},
+	})
+	navigate := dto.WorkerNavigateRequest{}
+	json.Unmarshal(receive(t, worker.requests, "the navigate request").Data, &navigate)
+	if navigate.Url != "https://93.184.216.34/" {
+		t.Errorf("the worker was asked to navigate %+v", navigate)
+	}
+	waitFor(t, "stream.navigated", func() bool { return js.publishedTo(services.ChatroomSubject(chatroomId)) })
+
+	adaSocket.WriteJSON(dto.Message[any]{Subject: "stream.stop-stream." + chatroomId})
+	if msg := receive(t, worker.requests, "the stop request"); !strings.HasSuffix(msg.Subject, ".stop") {
+		t.Errorf("the worker got %s, want a stop", msg.Subject)
+	}
+	waitFor(t, "the room to be released", func() bool {
+		workerId, _ := a.s.StreamRoomWorker(chatroomId)
+		return workerId == ""
+	})
+	adaSocket.WriteJSON(dto.Message[any]{Subject: "stream.stop-stream." + chatroomId})
+	streamErr = readSubject(t, adaSocket, "stream.error."+chatroomId)
+	if !strings.Contains(string(streamErr.Payload), "not streaming") {
+		t.Errorf("stopping a room nobody streams gave %s", streamErr.Payload)
+	}
+
+	// The socket leaving drops its peer on the worker
+	bobSocket.Close()
+	if left := receive(t, commands, "the disconnect"); left.Type != "disconnected" || left.ConnId != joins[bob] {
+		t.Errorf("the worker got %+v", left)
+	}
+}
+
+// A worker that stopped answering is skipped and its claim given up
+func TestStreamStartWithoutResponder(t *testing.T) {
+	natsServer := natstest.NewServer(t)
+	s := newTestService(store.NewMemoryStore())
+	c := NewController(s, natsServer.Connect(t), &testJetStream{})
+	userId := newTestUser(t, s, "ada")
+	chatroomId := newTestChatroom(t, s, userId, "room")
+
+	s.UpdateWorker(dto.WorkerStatus{WorkerId: "gone", Capacity: 1, Healthy: true})
+	_, err := startStream(c, userId, chatroomId)
+	if lib.AsApiError(err).Status != http.StatusServiceUnavailable {
+		t.Errorf("starting on a worker that is gone gave %v", err)
+	}
+	if workerId, _ := s.StreamRoomWorker(chatroomId); workerId != "" {
+		t.Errorf("the room is still claimed by %q", workerId)
+	}
+}
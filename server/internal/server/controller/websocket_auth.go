@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+const (
+	// How often an open socket checks its session and token
+	wsAuthCheckInterval = 15 * time.Second
+	// "auth.expiring" is sent this long before the access token runs out
+	wsAuthExpiryWarning = 2 * time.Minute
+
+	wsCloseTokenExpired   = 4001
+	wsCloseSessionRevoked = 4003
+)
+
+// wsAuth is who a websocket was opened by. The user never changes for the
+// lifetime of the socket, the access token can be replaced in-band.
+type wsAuth struct {
+	mu        sync.Mutex
+	userId    string
+	sessionId string
+	expiresAt time.Time
+	warned    bool
+}
+
+func (a *wsAuth) snapshot() (string, time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sessionId, a.expiresAt, a.warned
+}
+
+// refresh accepts the ticket of a new access token for the same user
+func (a *wsAuth) refresh(ticket string) (time.Time, error) {
+	claims, err := lib.ParseWsTicket(ticket)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid ticket")
+	}
+	if claims.UserId != a.userId {
+		return time.Time{}, fmt.Errorf("Ticket belongs to another user")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sessionId = claims.SessionId
+	a.expiresAt = claims.ExpiresAt
+	a.warned = false
+	return claims.ExpiresAt, nil
+}
+
+func sendAuthMessage(out *wsHub, subject string, payload dto.WsAuthPayload) {
+	err := out.WriteJSON(dto.Message[dto.WsAuthPayload]{
+		Sender:  "server",
+		Subject: subject,
+		Payload: payload,
+	})
+	if err != nil {
+		log.Println("❌ Error in sendAuthMessage[WriteJSON]:", err)
+	}
+}
+
+// handleAuthRefresh handles "auth.refresh"
+func (c *Controller) handleAuthRefresh(out *wsHub, auth *wsAuth, raw []byte) {
+	msgObj := dto.Message[dto.WsAuthRefreshPayload]{}
+	if err := json.Unmarshal(raw, &msgObj); err != nil {
+		sendAuthMessage(out, "auth.error", dto.WsAuthPayload{Error: "Invalid refresh payload"})
+		return
+	}
+
+	expiresAt, err := auth.refresh(msgObj.Payload.Ticket)
+	if err != nil {
+		log.Println("🔴 Error in handleAuthRefresh:", err)
+		sendAuthMessage(out, "auth.error", dto.WsAuthPayload{Error: err.Error()})
+		return
+	}
+
+	sendAuthMessage(out, "auth.refreshed", dto.WsAuthPayload{ExpiresAt: expiresAt})
+}
+
+// watchWebsocketAuth closes the socket once its session is revoked or its
+// access token expired without being refreshed. It returns when done is
+// closed.
+func (c *Controller) watchWebsocketAuth(out *wsHub, auth *wsAuth, done <-chan struct{}) {
+	ticker := time.NewTicker(wsAuthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		sessionId, expiresAt, warned := auth.snapshot()
+
+		if !c.s.SessionActive(sessionId) {
+			log.Println("⛔ Closing websocket of revoked session", sessionId)
+			sendAuthMessage(out, "auth.revoked", dto.WsAuthPayload{})
+			out.Close(wsCloseSessionRevoked, "session revoked")
+			return
+		}
+
+		if time.Now().After(expiresAt) {
+			log.Println("⛔ Closing websocket with expired token, session", sessionId)
+			sendAuthMessage(out, "auth.expired", dto.WsAuthPayload{ExpiresAt: expiresAt})
+			out.Close(wsCloseTokenExpired, "token expired")
+			return
+		}
+
+		if !warned && time.Until(expiresAt) < wsAuthExpiryWarning {
+			auth.mu.Lock()
+			auth.warned = true
+			auth.mu.Unlock()
+			sendAuthMessage(out, "auth.expiring", dto.WsAuthPayload{ExpiresAt: expiresAt})
+		}
+	}
+}
+
+// chatroomMembership remembers the rooms a socket's user belongs to. Rooms
+// joined after the socket opened are looked up once and then remembered.
+type chatroomMembership struct {
+	mu    sync.Mutex
+	rooms map[string]bool
+}
+
+func newChatroomMembership(chatrooms []lib.Chatroom) *chatroomMembership {
+	m := &chatroomMembership{rooms: make(map[string]bool)}
+	for _, room := range chatrooms {
+		m.rooms[room.Id] = true
+	}
+	return m
+}
+
+func (c *Controller) isChatroomMember(m *chatroomMembership, userId string, chatroomId string) bool {
+	m.mu.Lock()
+	member := m.rooms[chatroomId]
+	m.mu.Unlock()
+	if member {
+		return true
+	}
+
+	member, err := c.s.Store.IsUserInChatroom(c.s.Ctx, userId, chatroomId)
+	if err != nil {
+		log.Println("Error in isChatroomMember[IsUserInChatroom]:", err)
+		return false
+	}
+	if member {
+		m.mu.Lock()
+		m.rooms[chatroomId] = true
+		m.mu.Unlock()
+	}
+	return member
+}
@@ -1,54 +1,227 @@
 package dto
 
+import (
+	"encoding/json"
+	"time"
+
+	"sideDesert/shiba/internal/server/lib"
+)
+
 type SignupUserRequest struct {
-	Name     string `json:"name"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name     string `json:"name" validate:"required,max=255"`
+	Username string `json:"username" validate:"username"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=8,maxbytes=72"`
+}
+
+// UpdateUserRequest changes the profile fields that are present
+type UpdateUserRequest struct {
+	Name     *string `json:"name" validate:"max=255"`
+	Username *string `json:"username" validate:"username"`
+	Bio      *string `json:"bio" validate:"max=500"`
 }
 
 type ChatHistoryRequest struct {
 	Sender     string `json:"sender"`
 	ChatroomId string `json:"chatroom_id"`
 	Offset     int    `json:"offset"`
+	Mentions   bool   `json:"mentions"`
 }
 
 type CreateChatRoomRequest struct {
-	Name           string   `json:"name"`
-	ProfilePicture string   `json:"profile_picture"`
+	Name           string   `json:"name" validate:"max=255"`
+	ProfilePicture string   `json:"profile_picture" validate:"max=255"`
 	DirectMessage  bool     `json:"direct_message"`
-	Participants   []string `json:"participants"`
+	Participants   []string `json:"participants" validate:"max=100,dive,required,max=255"`
+}
+
+// DeleteAccountRequest confirms the deletion by repeating the username, or the
+// email of an account without one
+type DeleteAccountRequest struct {
+	Confirm string `json:"confirm" validate:"required,max=255"`
+}
+
+// UpdateChatroomRequest changes the chatroom settings that are present
+type UpdateChatroomRequest struct {
+	Name            *string `json:"name" validate:"max=255"`
+	Description     *string `json:"description" validate:"max=1000"`
+	Topic           *string `json:"topic" validate:"max=255"`
+	DefaultStartUrl *string `json:"default_start_url" validate:"max=2048"`
+	StreamQuality   *string `json:"stream_quality" validate:"oneof=low medium high"`
 }
 
 type Message[T any] struct {
 	Sender  string `json:"sender"`
 	Subject string `json:"subject"`
 	Payload T      `json:"payload"`
+	// Position of a room event in the chatroom stream, clients resume from it
+	Seq uint64 `json:"seq,omitempty"`
 }
 
 type ChatMessagePayload struct {
-	Id         string `json:"id"`
-	SenderName string `json:"sender_name"`
-	Content    string `json:"content"`
-	CreatedAt  string `json:"created_at"`
+	Id          string           `json:"id"`
+	SenderName  string           `json:"sender_name"`
+	Content     string           `json:"content"`
+	CreatedAt   string           `json:"created_at"`
+	Attachments []ChatAttachment `json:"attachments,omitempty"`
+}
+
+// ChatAttachment is what the client got back from the upload endpoint. It is
+// relayed as is to the room, only Id is used when the message is stored.
+type ChatAttachment struct {
+	Id           string `json:"id"`
+	FileName     string `json:"file_name"`
+	ContentType  string `json:"content_type"`
+	Url          string `json:"url"`
+	ThumbnailUrl string `json:"thumbnail_url,omitempty"`
 }
 
 type FriendStatusRequest struct {
-	Id     string `json:"id"`
-	Status string `json:"status"`
+	Id     string `json:"id" validate:"required,uuid"`
+	Status string `json:"status" validate:"required,oneof=accepted rejected blocked"`
 }
 
 type SendFriendRequest struct {
-	FriendId string `json:"friend_id"`
+	FriendId string `json:"friend_id" validate:"required,max=255"`
+}
+
+type BlockUserRequest struct {
+	UserId string `json:"user_id" validate:"required,max=255"`
 }
 
 type GetChatroomRemote struct {
 	ChatroomId string `json:"chatroom_id"`
 }
 
+// ChatroomId is taken from the path, it is only kept for older clients
 type PatchChatroomRemoteRequest struct {
 	ChatroomId string `json:"chatroom_id"`
-	UserId     string `json:"user_id"`
+	UserId     string `json:"user_id" validate:"required,max=255"`
 }
 
 type ChangeChatroomRemoteRequest = PatchChatroomRemoteRequest
+
+// Pushed to the room as "chat.preview" once the links in a stored message
+// have been unfurled. ClientMessageId is the id the sender generated.
+type ChatPreviewPayload struct {
+	MessageId       string            `json:"message_id"`
+	ClientMessageId string            `json:"client_message_id"`
+	ChatroomId      string            `json:"chatroom_id"`
+	Previews        []lib.LinkPreview `json:"previews"`
+}
+
+// Payload of "stream.navigate.<chatroomId>", sent by the remote holder
+type BrowserNavigatePayload struct {
+	Url string `json:"url"`
+}
+
+// Pushed to the room as "stream.navigated.<chatroomId>"
+type BrowserNavigatedPayload struct {
+	Url    string `json:"url"`
+	UserId string `json:"user_id"`
+}
+
+// Sent to a connection opened with ?since= before the room events it missed.
+// Gap is set when some of them expired from the stream, the client then
+// reloads the history instead.
+type RoomResumedPayload struct {
+	Since uint64 `json:"since"`
+	Gap   bool   `json:"gap"`
+}
+
+// Sent back to a single connection as "stream.error.<chatroomId>"
+type StreamErrorPayload struct {
+	Error string `json:"error"`
+}
+
+// StreamCommand is a "stream.<type>.<chatroomId>" message of a websocket on
+// its way to the worker streaming the chatroom. Replies for that websocket
+// are published on lib.ConnSubject(ConnId).
+type StreamCommand struct {
+	ConnId     string          `json:"conn_id"`
+	UserId     string          `json:"user_id"`
+	ChatroomId string          `json:"chatroom_id"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+// StreamJoinRequest is sent to every API node when a stream starts, each
+// answers with a "join" command for the open websockets of the members
+type StreamJoinRequest struct {
+	ChatroomId string   `json:"chatroom_id"`
+	MemberIds  []string `json:"member_ids"`
+}
+
+// Payload of "stream.input.<chatroomId>", the remote holder controlling the
+// browser. X and Y are fractions of the width and height of the video, the
+// worker clamps them to the screen.
+type StreamInputPayload struct {
+	Type   string  `json:"type" validate:"required,oneof=mousemove mousedown mouseup wheel keydown keyup text"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Button string  `json:"button" validate:"oneof=left middle right"`
+	DeltaX float64 `json:"delta_x"`
+	DeltaY float64 `json:"delta_y"`
+	Key    string  `json:"key" validate:"max=32"`
+	Code   string  `json:"code" validate:"max=32"`
+	Text   string  `json:"text" validate:"max=1000"`
+}
+
+// WorkerStartRequest asks a worker on lib.WorkerSubject(id, "start") to
+// stream a chatroom. It replies once the stream runs and has asked the API
+// nodes for the websockets of MemberIds.
+type WorkerStartRequest struct {
+	ChatroomId string   `json:"chatroom_id"`
+	StartUrl   string   `json:"start_url"`
+	Quality    string   `json:"quality"`
+	MemberIds  []string `json:"member_ids"`
+}
+
+// WorkerStopRequest is sent on lib.WorkerSubject(id, "stop")
+type WorkerStopRequest struct {
+	ChatroomId string `json:"chatroom_id"`
+}
+
+// WorkerNavigateRequest is sent on lib.WorkerSubject(id, "navigate") with a
+// url that already passed the browser url policy
+type WorkerNavigateRequest struct {
+	ChatroomId string `json:"chatroom_id"`
+	Url        string `json:"url"`
+}
+
+// Payload of "auth.refresh", a ticket from POST /auth/ws-ticket that extends
+// the lifetime of an open websocket to that of the refreshed access token
+type WsAuthRefreshPayload struct {
+	Ticket string `json:"ticket"`
+}
+
+type MarkNotificationsReadRequest struct {
+	Ids []string `json:"ids" validate:"max=500,dive,uuid"`
+	All bool     `json:"all"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required,maxbytes=72"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required,maxbytes=72"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,maxbytes=72"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required,max=255"`
+	NewPassword string `json:"new_password" validate:"required,min=8,maxbytes=72"`
+}
+
+// Sent to a single connection as "auth.expiring", "auth.refreshed",
+// "auth.expired", "auth.revoked" or "auth.error"
+type WsAuthPayload struct {
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
@@ -3,6 +3,8 @@ package dto
 import (
 	"database/sql"
 	"time"
+
+	"sideDesert/shiba/internal/server/lib"
 )
 
 type UserResponse struct {
@@ -11,9 +13,20 @@ type UserResponse struct {
 	Username       string    `json:"username"`
 	Email          string    `json:"email"`
 	ProfilePicture string    `json:"profile_picture"`
+	Bio            string    `json:"bio"`
 	CreatedAt      time.Time `json:"created_at"`
 }
 
+// UserProfileResponse is what other users see of a profile, it is also the
+// payload of user.updated events
+type UserProfileResponse struct {
+	UserId         string `json:"user_id"`
+	Name           string `json:"name"`
+	Username       string `json:"username"`
+	ProfilePicture string `json:"profile_picture"`
+	Bio            string `json:"bio"`
+}
+
 type SignupUserResponse struct {
 	Name   string `json:"name"`
 	Email  string `json:"email"`
@@ -30,21 +43,60 @@ type OAuthGoogleUserDataResponse struct {
 	Picture       string `json:"picture"`
 }
 
+// OAuthUser is the profile every OAuth provider is mapped to
+type OAuthUser struct {
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Username      string `json:"username"`
+	Picture       string `json:"picture"`
+}
+
+type OAuthProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+type DeleteAccountResponse struct {
+	DeletionScheduledFor time.Time `json:"deletion_scheduled_for"`
+}
+
+// ChatroomResponse is a chatroom with the role the caller has in it
+type ChatroomResponse struct {
+	lib.Chatroom
+	Role string `json:"role"`
+}
+
 type CreateChatRoomResponse struct {
 	ChatRoomId string `json:"chatroom_id"`
 }
 
 type SearchUserResponse struct {
-	Name           string         `json:"first_name"`
-	UserId         string         `json:"user_id"`
-	Username       string         `json:"username"`
-	Email          string         `json:"email"`
+	Name     string `json:"first_name"`
+	UserId   string `json:"user_id"`
+	Username string `json:"username"`
+	// Only set for friends of the caller
+	Email          string         `json:"email,omitempty"`
 	Status         sql.NullString `json:"status"`
 	ProfilePicture sql.NullString `json:"profile_picture"`
+	MutualFriends  int            `json:"mutual_friends"`
+}
+
+type FriendSuggestionResponse struct {
+	UserId          string         `json:"user_id"`
+	Name            string         `json:"name"`
+	Username        string         `json:"username"`
+	Status          sql.NullString `json:"status"`
+	ProfilePicture  sql.NullString `json:"profile_picture"`
+	MutualFriends   int            `json:"mutual_friends"`
+	SharedChatrooms int            `json:"shared_chatrooms"`
 }
 
 type FriendResponse struct {
-	Status string `json:"status"`
+	Status     string `json:"status"`
+	RequestId  string `json:"request_id,omitempty"`
+	ChatroomId string `json:"chatroom_id,omitempty"`
 }
 
 type FriendRequestResponse struct {
@@ -57,6 +109,14 @@ type FriendRequestResponse struct {
 	Status         string         `json:"status"`
 }
 
+type BlockedUserResponse struct {
+	UserId         string         `json:"user_id"`
+	Name           string         `json:"name"`
+	Username       string         `json:"username"`
+	ProfilePicture sql.NullString `json:"profile_picture"`
+	BlockedAt      time.Time      `json:"blocked_at"`
+}
+
 type RemoteResponse struct {
 	Name     string         `json:"name"`
 	Username string         `json:"username"`
@@ -67,3 +127,56 @@ type RemoteResponse struct {
 type PatchOKResponse struct {
 	Status string `json:"status"`
 }
+
+type NotificationsResponse struct {
+	Notifications []lib.Notification `json:"notifications"`
+	UnreadCount   int                `json:"unread_count"`
+}
+
+type MarkNotificationsReadResponse struct {
+	Updated int64 `json:"updated"`
+}
+
+type LoginResponse struct {
+	UserId string `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+// RefreshResponse leaves the access token to the cookie
+type RefreshResponse struct {
+	UserId    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// WsTicketResponse carries the ticket of an "auth.refresh" message
+type WsTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ChatroomsResponse struct {
+	Chatrooms []lib.Chatroom `json:"chatrooms"`
+}
+
+// WorkerReply answers the requests of the API to a worker, Error is empty
+// when it succeeded. Status and Code are those of the lib.ApiError the
+// failure maps to.
+type WorkerReply struct {
+	Status int    `json:"status,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// WorkerStatus is published by every streaming worker on
+// lib.WorkerStatusSubject
+type WorkerStatus struct {
+	WorkerId string `json:"worker_id"`
+	// How many chatrooms it can stream at the same time
+	Capacity int `json:"capacity"`
+	// The chatrooms it streams right now
+	Rooms []string `json:"rooms"`
+	// False once it shuts down, it takes no new streams
+	Healthy   bool      `json:"healthy"`
+	StartedAt time.Time `json:"started_at"`
+}
@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	CodeBadRequest       = "bad_request"
+	CodeValidation       = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodePayloadTooLarge  = "payload_too_large"
+	CodeUnsupportedType  = "unsupported_media_type"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "service_unavailable"
+)
+
+// ApiError is an error that knows how it is answered over HTTP. Handlers,
+// services and the store return them, CreateHTTPHandleFunc writes them as
+//
+//	{"status": 404, "code": "not_found", "error": "...", "details": {...}}
+//
+// Any other error is answered with a 500 and its message is only logged.
+type ApiError struct {
+	Status  int            `json:"status"`
+	Code    string         `json:"code"`
+	Message string         `json:"error"`
+	Details map[string]any `json:"details,omitempty"`
+
+	cause error
+}
+
+func NewApiError(status int, code string, message string) *ApiError {
+	return &ApiError{Status: status, Code: code, Message: message}
+}
+
+func (e *ApiError) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *ApiError) Unwrap() error {
+	return e.cause
+}
+
+// Is makes errors.Is match a copy made by WithDetails or Wrap against the
+// error it was made from
+func (e *ApiError) Is(target error) bool {
+	t, ok := target.(*ApiError)
+	return ok && t.Code == e.Code && t.Message == e.Message && t.Status == e.Status
+}
+
+// WithDetails returns a copy of e carrying details for the client
+func (e *ApiError) WithDetails(details map[string]any) *ApiError {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// Wrap returns a copy of e with err as the cause. The cause is logged but
+// never sent to the client.
+func (e *ApiError) Wrap(err error) *ApiError {
+	c := *e
+	c.cause = err
+	return &c
+}
+
+func BadRequest(message string) *ApiError {
+	return NewApiError(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+func Unauthorized(message string) *ApiError {
+	return NewApiError(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *ApiError {
+	return NewApiError(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(message string) *ApiError {
+	return NewApiError(http.StatusNotFound, CodeNotFound, message)
+}
+
+func MethodNotAllowed(method string) *ApiError {
+	return NewApiError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed: "+method)
+}
+
+func Conflict(message string) *ApiError {
+	return NewApiError(http.StatusConflict, CodeConflict, message)
+}
+
+func TooManyRequests(message string) *ApiError {
+	return NewApiError(http.StatusTooManyRequests, CodeTooManyRequests, message)
+}
+
+func Unavailable(message string) *ApiError {
+	return NewApiError(http.StatusServiceUnavailable, CodeUnavailable, message)
+}
+
+func Internal(err error) *ApiError {
+	return NewApiError(http.StatusInternalServerError, CodeInternal, "Internal server error").Wrap(err)
+}
+
+// AsApiError finds the ApiError in err. Well known errors of the db driver
+// and net/http are mapped, anything else becomes an internal error.
+func AsApiError(err error) *ApiError {
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NotFound("Not found").Wrap(err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505": // unique_violation
+			return Conflict("Already exists").Wrap(err)
+		case "23503": // foreign_key_violation
+			return NotFound("Referenced resource does not exist").Wrap(err)
+		case "23514": // check_violation
+			return BadRequest("Invalid value").Wrap(err)
+		case "22P02": // invalid_text_representation, e.g. a malformed id
+			return BadRequest("Invalid id").Wrap(err)
+		}
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return NewApiError(http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Request body too large").Wrap(err)
+	}
+
+	return Internal(err)
+}
+
+// WriteError answers a request with err
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := AsApiError(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Println("Error in", r.Method, r.URL.Path+":", err)
+	}
+	WriteJSON(w, r, apiErr.Status, apiErr)
+}
+
+// IsNoRows reports whether a store lookup found nothing
+func IsNoRows(err error) bool {
+	return errors.Is(err, pgx.ErrNoRows)
+}
+
+// IsUniqueViolation reports whether err is a unique violation of constraint
+func IsUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
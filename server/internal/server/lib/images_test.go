@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func solidImage(w int, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		wantW, wantH int
+	}{
+		{"landscape", 800, 400, 320, 160},
+		{"portrait", 300, 900, 106, 320},
+		{"small", 100, 50, 100, 50},
+		{"sliver", 2000, 1, 320, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, w, h, err := Thumbnail(bytes.NewReader(encodePNG(t, solidImage(tt.w, tt.h, color.White))), 320)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w != tt.wantW || h != tt.wantH {
+				t.Errorf("Thumbnail is %dx%d, want %dx%d", w, h, tt.wantW, tt.wantH)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := img.Bounds(); b.Dx() != w || b.Dy() != h {
+				t.Errorf("the JPEG is %dx%d, the returned size %dx%d", b.Dx(), b.Dy(), w, h)
+			}
+		})
+	}
+
+	if _, _, _, err := Thumbnail(bytes.NewReader([]byte("not an image")), 320); err == nil {
+		t.Error("a text file gave a thumbnail")
+	}
+}
+
+func TestResizeImageAverages(t *testing.T) {
+	// Black and white columns average to grey
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	dst := ResizeImage(src, 2, 2)
+	if r, _, _, a := dst.At(0, 0).RGBA(); r>>8 < 120 || r>>8 > 135 || a>>8 != 255 {
+		t.Errorf("the resized pixel is %v, want grey", dst.At(0, 0))
+	}
+}
+
+func TestAvatarCropsCenter(t *testing.T) {
+	// Red bands left and right of a green square in the middle
+	src := solidImage(300, 100, color.RGBA{R: 255, A: 255})
+	for x := 100; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			src.Set(x, y, color.RGBA{G: 255, A: 255})
+		}
+	}
+	data, err := Avatar(bytes.NewReader(encodePNG(t, src)), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 64 {
+		t.Fatalf("avatar is %dx%d", b.Dx(), b.Dy())
+	}
+	for _, p := range []image.Point{{2, 2}, {61, 61}, {32, 32}} {
+		if r, g, _, _ := img.At(p.X, p.Y).RGBA(); g>>8 < 200 || r>>8 > 60 {
+			t.Errorf("pixel %v is %v, want the green center", p, img.At(p.X, p.Y))
+		}
+	}
+}
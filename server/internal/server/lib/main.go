@@ -2,10 +2,12 @@ package lib
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	mrand "math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -17,6 +19,9 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ApiPrefix is the path every API route is served under
+const ApiPrefix = "/api/v1"
+
 func Init() {
 	mrand.Seed(time.Now().UnixNano())
 }
@@ -31,15 +36,15 @@ func WriteJSON(w http.ResponseWriter, r *http.Request, statusCode int, v any) er
 	return json.NewEncoder(w).Encode(v)
 }
 
-func CreateCSRFToken() string {
-	return "state"
+// CreateCSRFToken returns a random value to be used as the OAuth state
+func CreateCSRFToken() (string, error) {
+	return GenerateSecureRandomID(32)
 }
 
 func CreateHTTPHandleFunc(f func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			v := ApiError{Error: err.Error()}
-			WriteJSON(w, r, http.StatusBadRequest, v)
+			WriteError(w, r, err)
 		}
 	}
 }
@@ -67,12 +72,39 @@ func HashPassword(pass string) (string, error) {
 	return string(bytes), nil
 }
 
-func CreateToken(userId string) (string, error) {
+func CheckPassword(hash string, pass string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}
+
+// HashToken is used for single use tokens (password resets) which are random
+// enough that a fast hash is fine, unlike passwords.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClientIP returns the ip of the caller. X-Forwarded-For is only trusted when
+// TRUST_PROXY is set, otherwise anyone could pick their own ip.
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") != "" {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func CreateToken(userId string, sessionId string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"sub": userId,
+		"sid": sessionId,
 		"iss": "shiba",
 		"aud": "user",
-		"exp": time.Now().Add(24 * time.Hour).Unix(),
+		"exp": time.Now().Add(AccessTokenLifetime).Unix(),
 		"iat": time.Now().Unix(),
 	})
 
@@ -90,11 +122,10 @@ func VerifyToken(tokenString string) (*jwt.Token, error) {
 	secretKey := []byte(os.Getenv("JWT_SECRET"))
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		return secretKey, nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
 
 	// Check for verification errors
 	if err != nil {
-		fmt.Println("Error in verifyToken:", err)
 		return nil, err
 	}
 
@@ -107,13 +138,82 @@ func VerifyToken(tokenString string) (*jwt.Token, error) {
 	return token, nil
 }
 
-func extractTokenString(tokenString string) (string, bool) {
-	parts := strings.Split(tokenString, "=")
-	if len(parts) >= 2 {
-		return parts[1], true
+type AccessClaims struct {
+	UserId    string
+	SessionId string
+	ExpiresAt time.Time
+}
+
+// ParseAccessToken verifies an access token and returns its user and session
+func ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims, err := verifyClaims(tokenString, "user")
+	if err != nil {
+		return nil, err
+	}
+
+	userId, _ := claims["sub"].(string)
+	sessionId, _ := claims["sid"].(string)
+	if userId == "" || sessionId == "" {
+		return nil, fmt.Errorf("token has no user or session")
 	}
 
-	return "", false
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+
+	return &AccessClaims{UserId: userId, SessionId: sessionId, ExpiresAt: exp.Time}, nil
+}
+
+// verifyClaims verifies a token meant for audience
+func verifyClaims(tokenString string, audience string) (jwt.MapClaims, error) {
+	token, err := VerifyToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	aud, err := claims.GetAudience()
+	if err != nil || !slices.Contains(aud, audience) {
+		return nil, fmt.Errorf("token is not meant for %s", audience)
+	}
+	return claims, nil
+}
+
+// CreateWsTicket vouches for the access token of access to an open
+// websocket. It expires after WsTicketLifetime and is no access token
+// itself.
+func CreateWsTicket(access *AccessClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": access.UserId,
+		"sid": access.SessionId,
+		"iss": "shiba",
+		"aud": "ws",
+		"axp": access.ExpiresAt.Unix(),
+		"exp": time.Now().Add(WsTicketLifetime).Unix(),
+		"iat": time.Now().Unix(),
+	})
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// ParseWsTicket verifies a websocket ticket and returns the access token it
+// vouches for
+func ParseWsTicket(tokenString string) (*AccessClaims, error) {
+	claims, err := verifyClaims(tokenString, "ws")
+	if err != nil {
+		return nil, err
+	}
+
+	userId, _ := claims["sub"].(string)
+	sessionId, _ := claims["sid"].(string)
+	axp, _ := claims["axp"].(float64)
+	if userId == "" || sessionId == "" || axp == 0 {
+		return nil, fmt.Errorf("ticket has no user, session or expiry")
+	}
+	return &AccessClaims{UserId: userId, SessionId: sessionId, ExpiresAt: time.Unix(int64(axp), 0)}, nil
 }
 
 func Client(path string) string {
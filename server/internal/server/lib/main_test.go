@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWsTicket(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	access, err := CreateToken("ada", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseAccessToken(access)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket, err := CreateWsTicket(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseWsTicket(ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserId != "ada" || got.SessionId != "7" || !got.ExpiresAt.Equal(claims.ExpiresAt) {
+		t.Errorf("ParseWsTicket = %+v, want the claims of the access token %+v", got, claims)
+	}
+
+	// Neither passes for the other
+	if _, err := ParseAccessToken(ticket); err == nil {
+		t.Error("a ticket was accepted as an access token")
+	}
+	if _, err := ParseWsTicket(access); err == nil {
+		t.Error("an access token was accepted as a ticket")
+	}
+
+	// The ticket runs out long before the access token it vouches for
+	token, err := VerifyToken(ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, _ := token.Claims.GetExpirationTime(); exp == nil || time.Until(exp.Time) > WsTicketLifetime {
+		t.Errorf("the ticket expires at %v", exp)
+	}
+
+	t.Setenv("JWT_SECRET", "another-secret")
+	if _, err := ParseWsTicket(ticket); err == nil {
+		t.Error("a ticket signed with another secret was accepted")
+	}
+}
@@ -0,0 +1,22 @@
+package lib
+
+import (
+	"regexp"
+	"strings"
+)
+
+var mentionRegex = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.\-]{1,64})`)
+
+// ParseMentions returns the unique, lowercased usernames mentioned with
+// @username in content. Email addresses are not treated as mentions.
+func ParseMentions(content string) []string {
+	usernames := make([]string, 0)
+	for _, match := range mentionRegex.FindAllStringSubmatch(content, -1) {
+		username := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if username == "" || Contains(usernames, username) {
+			continue
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
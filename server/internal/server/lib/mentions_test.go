@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"hi @ada", []string{"ada"}},
+		{"@ada, @bob: look", []string{"ada", "bob"}},
+		// Trailing punctuation ends the sentence, not the username
+		{"thanks @ada.", []string{"ada"}},
+		{"ask @bob-!", []string{"bob"}},
+		{"(@ada) and @ada_2?", []string{"ada", "ada_2"}},
+		{"@first.last is here", []string{"first.last"}},
+		// Unique and lowercased
+		{"@Ada @ada @ADA", []string{"ada"}},
+		// Email addresses and doubled @ are not mentions
+		{"mail ada@example.com", []string{}},
+		{"@@ada a@@bob", []string{}},
+		{"an @ alone, @. and @-", []string{}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := ParseMentions(tt.content); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseMentions(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
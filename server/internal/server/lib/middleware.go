@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 )
 
 func AllowCors(next http.Handler) http.Handler {
@@ -27,46 +28,51 @@ func AllowCors(next http.Handler) http.Handler {
 }
 
 func AuthenticateMiddleware(next http.Handler) http.Handler {
-	// Retrieve the token from the cookie
-	// log.Println("Authenticate Middleware Enabled")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		tokenCoookie, err := r.Cookie("shiba-auth-token")
-		tokenString := tokenCoookie.String()
-		// fmt.Println("TOKEN STRING:", tokenString)
-
-		tokenString, ok := extractTokenString(tokenString)
+		claims, ok := authenticate(w, r)
 		if !ok {
-			log.Println("Invalid token string format")
-			http.Redirect(w, r, Client("/login"), http.StatusSeeOther)
-			return
-		}
-
-		if err != nil {
-			log.Println("Token missing in cookie")
-			http.Redirect(w, r, Client("/login"), http.StatusUnauthorized)
+			log.Println("AuthenticateMiddleware: no valid session for", r.URL.Path)
+			if isBrowserNavigation(r) {
+				http.Redirect(w, r, Client("/login"), http.StatusSeeOther)
+				return
+			}
+			WriteError(w, r, Unauthorized("Not logged in or session expired"))
 			return
 		}
 
-		// Verify the token
-		token, err := VerifyToken(tokenString)
+		ctx := context.WithValue(r.Context(), "userId", claims.UserId)
+		ctx = context.WithValue(ctx, "sessionId", claims.SessionId)
+		ctx = context.WithValue(ctx, "tokenExpiresAt", claims.ExpiresAt)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		if err != nil {
-			log.Printf("Token verification failed: %v\\n", err)
-			http.Redirect(w, r, Client("/login"), http.StatusSeeOther)
-			return
-		}
+// AuthenticatedUserId returns the user of the auth cookie on routes that
+// work both signed in and signed out.
+func AuthenticatedUserId(r *http.Request) (string, bool) {
+	userId, _, ok := AuthenticatedSession(nil, r)
+	return userId, ok
+}
 
-		sub, err := token.Claims.GetSubject()
-		if err != nil {
-			log.Println("userId not found in token")
-			http.Redirect(w, r, Client("/login"), http.StatusUnauthorized)
-			return
-		}
-		// log.Println("User:", sub)
+// AuthenticatedSession is AuthenticatedUserId that also returns the session.
+// When w is not nil an expired access token is refreshed like in
+// AuthenticateMiddleware.
+func AuthenticatedSession(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	claims, ok := authenticate(w, r)
+	if !ok {
+		return "", "", false
+	}
+	return claims.UserId, claims.SessionId, true
+}
 
-		ctx := context.WithValue(r.Context(), "userId", sub)
-		// Print information about the verified token
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// isBrowserNavigation tells a user opening a url in the browser apart from
+// fetch/XHR and websocket requests of the client app
+func isBrowserNavigation(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	if mode := r.Header.Get("Sec-Fetch-Mode"); mode != "" {
+		return mode == "navigate"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
 }
@@ -5,6 +5,9 @@ import (
 	"time"
 )
 
+// The schema is created by the migrations in internal/server/migrations,
+// the table definitions below are a copy for reference.
+
 /*
 CREATE TABLE users (
 
@@ -26,10 +29,12 @@ type User struct {
 	UserId         string         `json:"user_id"`
 	Username       string         `json:"username"`
 	Email          string         `json:"email"`
+	EmailVerified  bool           `json:"email_verified"`
 	PasswordHash   string         `json:"password_hash"`
 	CreatedAt      time.Time      `json:"created_at"`
 	Status         sql.NullString `json:"status"`
 	ProfilePicture sql.NullString `json:"profile_picture"`
+	Bio            string         `json:"bio"`
 }
 
 /*
@@ -37,40 +42,74 @@ CREATE TABLE session (
 
 	id SERIAL PRIMARY KEY,
 	user_id VARCHAR(255) NOT NULL,
-	token VARCHAR(255) NOT NULL,
+	token VARCHAR(255) UNIQUE NOT NULL,
+	previous_token VARCHAR(255) NULL,
+	user_agent VARCHAR(255) NULL,
+	ip VARCHAR(64) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	rotated_at TIMESTAMP NULL,
 	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP NULL,
 	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
 
 );
 */
+// Token and PreviousToken are sha256 hashes of the refresh tokens, the
+// previous one is kept to detect a stolen refresh token being replayed.
 type Session struct {
-	Id        int32     `json:"id"`
-	UserId    string    `json:"user_id"`
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_ai"`
+	Id            int32          `json:"id"`
+	UserId        string         `json:"user_id"`
+	Token         string         `json:"-"`
+	PreviousToken sql.NullString `json:"-"`
+	UserAgent     sql.NullString `json:"user_agent"`
+	Ip            sql.NullString `json:"ip"`
+	CreatedAt     time.Time      `json:"created_at"`
+	RotatedAt     sql.NullTime   `json:"rotated_at"`
+	ExpiresAt     time.Time      `json:"expires_at"`
+	RevokedAt     sql.NullTime   `json:"revoked_at"`
 }
 
 type Chatroom struct {
-	Id             string         `json:"id"`
-	Name           string         `json:"name"`
-	ProfilePicture sql.NullString `json:"profile_picture"`
-	DirectMessage  bool           `json:"direct_message"`
-	CreatedAt      time.Time      `json:"created_at"`
+	Id              string         `json:"id"`
+	Name            string         `json:"name"`
+	ProfilePicture  sql.NullString `json:"profile_picture"`
+	DirectMessage   bool           `json:"direct_message"`
+	Description     string         `json:"description"`
+	Topic           string         `json:"topic"`
+	DefaultStartUrl string         `json:"default_start_url"`
+	StreamQuality   string         `json:"stream_quality"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
 }
 
+const (
+	ChatroomOwner  = "owner"
+	ChatroomMember = "member"
+)
+
+// DeletedUserName is shown as the sender of messages whose author deleted
+// their account
+const DeletedUserName = "Deleted user"
+
 type Message struct {
-	Id         string         `json:"id"`
-	Sender     string         `json:"sender"`
-	SenderName string         `json:"sender_name"`
-	ChatroomId string         `json:"chatroom_id"`
-	Content    string         `json:"content"`
-	Status     sql.NullString `json:"status"`
-	CreatedAt  time.Time      `json:"created_at"`
+	Id string `json:"id"`
+	// The id the sender's client gave the message, empty for old messages
+	ClientId    string         `json:"client_id,omitempty"`
+	Sender      string         `json:"sender"`
+	SenderName  string         `json:"sender_name"`
+	ChatroomId  string         `json:"chatroom_id"`
+	Content     string         `json:"content"`
+	Status      sql.NullString `json:"status"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Attachments []Attachment   `json:"attachments"`
+	Previews    []LinkPreview  `json:"previews"`
+	Mentions    []string       `json:"mentions"`
 }
 
 type UserChatroom struct {
 	UserId     string `json:"user_id"`
 	ChatroomId string `json:"chatroom_id"`
+	Role       string `json:"role"`
 }
 
 type UserChatrooms = []UserChatroom
@@ -97,3 +136,162 @@ type Remote struct {
 	ChatroomId string `json:"chatroom_id"`
 	UserId     string `json:"user_id"`
 }
+
+/*
+CREATE TABLE attachments (
+
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	message_id UUID NULL REFERENCES messages(id) ON DELETE CASCADE,
+	chatroom_id UUID NOT NULL REFERENCES chatrooms(id) ON DELETE CASCADE,
+	uploader VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	file_name VARCHAR(255) NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	size BIGINT NOT NULL,
+	storage_key VARCHAR(512) NOT NULL,
+	thumbnail_key VARCHAR(512) NULL,
+	width INT NULL,
+	height INT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+
+);
+*/
+type Attachment struct {
+	Id           string         `json:"id"`
+	MessageId    sql.NullString `json:"-"`
+	ChatroomId   string         `json:"chatroom_id"`
+	Uploader     string         `json:"uploader"`
+	FileName     string         `json:"file_name"`
+	ContentType  string         `json:"content_type"`
+	Size         int64          `json:"size"`
+	StorageKey   string         `json:"-"`
+	ThumbnailKey sql.NullString `json:"-"`
+	Width        sql.NullInt32  `json:"width"`
+	Height       sql.NullInt32  `json:"height"`
+	CreatedAt    time.Time      `json:"created_at"`
+
+	// Filled in by the service, not stored
+	Url          string `json:"url"`
+	ThumbnailUrl string `json:"thumbnail_url,omitempty"`
+}
+
+/*
+CREATE TABLE link_previews (
+
+	message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	url TEXT NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT NULL,
+	image TEXT NULL,
+	site_name VARCHAR(255) NULL,
+	type VARCHAR(64) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (message_id, url)
+
+);
+*/
+type LinkPreview struct {
+	MessageId   string `json:"message_id"`
+	Url         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+	Type        string `json:"type,omitempty"`
+}
+
+const (
+	NotificationFriendRequest  = "friend_request"
+	NotificationFriendAccepted = "friend_accepted"
+	NotificationRoomInvite     = "room_invite"
+	NotificationMention        = "mention"
+	NotificationRemoteGranted  = "remote_granted"
+	NotificationStreamStarted  = "stream_started"
+)
+
+/*
+CREATE TABLE notifications (
+
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	type VARCHAR(50) NOT NULL,
+	actor_id VARCHAR(255) NULL REFERENCES users(user_id) ON DELETE SET NULL,
+	chatroom_id UUID NULL REFERENCES chatrooms(id) ON DELETE CASCADE,
+	data JSONB NOT NULL DEFAULT '{}',
+	read_at TIMESTAMP NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+
+);
+
+CREATE INDEX notifications_user_created_idx ON notifications (user_id, created_at DESC);
+*/
+type Notification struct {
+	Id         string         `json:"id"`
+	UserId     string         `json:"user_id"`
+	Type       string         `json:"type"`
+	ActorId    sql.NullString `json:"actor_id"`
+	ChatroomId sql.NullString `json:"chatroom_id"`
+	Data       map[string]any `json:"data"`
+	ReadAt     sql.NullTime   `json:"read_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+/*
+CREATE TABLE message_mentions (
+
+	message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	PRIMARY KEY (message_id, user_id)
+
+);
+
+CREATE INDEX message_mentions_user_idx ON message_mentions (user_id);
+*/
+type MessageMention struct {
+	MessageId string `json:"message_id"`
+	UserId    string `json:"user_id"`
+	Username  string `json:"username"`
+}
+
+/*
+CREATE TABLE password_resets (
+
+	id SERIAL PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+
+);
+*/
+type PasswordReset struct {
+	Id        int32        `json:"id"`
+	UserId    string       `json:"user_id"`
+	TokenHash string       `json:"-"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+/*
+CREATE TABLE user_identities (
+
+	id SERIAL PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	provider VARCHAR(50) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (provider, subject),
+	UNIQUE (user_id, provider)
+
+);
+*/
+type UserIdentity struct {
+	Id        int32          `json:"id"`
+	UserId    string         `json:"user_id"`
+	Provider  string         `json:"provider"`
+	Subject   string         `json:"-"`
+	Email     sql.NullString `json:"email"`
+	CreatedAt time.Time      `json:"created_at"`
+}
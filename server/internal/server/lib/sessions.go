@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	AuthCookie    = "shiba-auth-token"
+	RefreshCookie = "shiba-refresh-token"
+
+	// Access tokens are short lived so a revoked session stops working
+	// quickly even on a node whose cache has not caught up yet
+	AccessTokenLifetime  = 15 * time.Minute
+	RefreshTokenLifetime = 30 * 24 * time.Hour
+
+	// An open websocket can't read the refreshed cookie, it is handed a
+	// ticket instead that is only good for a moment
+	WsTicketLifetime = 30 * time.Second
+)
+
+// SessionTokens is what a login or refresh hands to the browser.
+// RefreshToken is empty when the current refresh cookie stays valid.
+type SessionTokens struct {
+	UserId       string
+	SessionId    string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// SessionManager is implemented by the service. The middleware uses it to
+// reject revoked sessions and to refresh expired access tokens.
+type SessionManager interface {
+	SessionActive(sessionId string) bool
+	RefreshSession(refreshToken string) (*SessionTokens, error)
+}
+
+var sessionManager SessionManager
+
+func SetSessionManager(m SessionManager) {
+	sessionManager = m
+}
+
+func SetSessionCookies(w http.ResponseWriter, tokens *SessionTokens) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     AuthCookie,
+		Value:    tokens.AccessToken,
+		Expires:  time.Now().Add(AccessTokenLifetime),
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	})
+
+	if tokens.RefreshToken == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookie,
+		Value:    tokens.RefreshToken,
+		Expires:  time.Now().Add(RefreshTokenLifetime),
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	})
+}
+
+func ClearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{AuthCookie, RefreshCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   false,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/",
+		})
+	}
+}
+
+// authenticate resolves the user of a request. A valid access token of an
+// active session is used as is, otherwise the refresh cookie is rotated and
+// the new cookies are set on w.
+func authenticate(w http.ResponseWriter, r *http.Request) (*AccessClaims, bool) {
+	if cookie, err := r.Cookie(AuthCookie); err == nil {
+		claims, err := ParseAccessToken(cookie.Value)
+		if err == nil && (sessionManager == nil || sessionManager.SessionActive(claims.SessionId)) {
+			return claims, true
+		}
+	}
+
+	if sessionManager == nil || w == nil {
+		return nil, false
+	}
+
+	refresh, err := r.Cookie(RefreshCookie)
+	if err != nil || refresh.Value == "" {
+		return nil, false
+	}
+
+	tokens, err := sessionManager.RefreshSession(refresh.Value)
+	if err != nil {
+		ClearSessionCookies(w)
+		return nil, false
+	}
+
+	SetSessionCookies(w, tokens)
+	return &AccessClaims{UserId: tokens.UserId, SessionId: tokens.SessionId, ExpiresAt: tokens.ExpiresAt}, true
+}
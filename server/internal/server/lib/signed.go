@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func signature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignValue encodes data so it can be handed to the client (e.g. in a
+// cookie) and later verified with VerifySignedValue. It is not encrypted.
+func SignValue(data []byte) string {
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signature(payload)
+}
+
+func VerifySignedValue(value string) ([]byte, error) {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed signed value")
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(payload))) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return base64.RawURLEncoding.DecodeString(payload)
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Subjects shared by the API nodes and the streaming workers. They carry no
+// credentials of their own: whoever can publish on workers.> poses as a
+// worker, on streams.> or conns.> as an API node. The NATS server must only
+// let the nodes and the workers in, both connect with NatsAuth.
+
+// Commands of the websockets watching a chatroom go to the worker streaming it
+func StreamCommandsSubject(chatroomId string) string {
+	return "streams." + chatroomId + ".commands"
+}
+
+// Every API node subscribes to StreamJoinSubject("*") and answers for the
+// websockets it holds
+func StreamJoinSubject(chatroomId string) string {
+	return "streams." + chatroomId + ".join"
+}
+
+// Every open websocket subscribes to the subject of its id, the worker
+// publishes the offers and ICE candidates of its peer there
+func ConnSubject(connId string) string {
+	return "conns." + connId
+}
+
+// Workers publish their status here every WorkerStatusInterval
+const WorkerStatusSubject = "workers.status"
+
+const WorkerStatusInterval = 5 * time.Second
+
+// WorkerSubject is where a worker takes the requests of an operation: start,
+// stop or navigate
+func WorkerSubject(workerId string, op string) string {
+	return "workers." + workerId + "." + op
+}
+
+// NatsAuth returns the credentials to connect to NATS with, the creds file
+// of NATS_CREDS or else NATS_USER and NATS_PASSWORD.
+func NatsAuth() []nats.Option {
+	if creds := os.Getenv("NATS_CREDS"); creds != "" {
+		return []nats.Option{nats.UserCredentials(creds)}
+	}
+	if user := os.Getenv("NATS_USER"); user != "" {
+		return []nats.Option{nats.UserInfo(user, os.Getenv("NATS_PASSWORD"))}
+	}
+	log.Println("⚠️ NATS_CREDS and NATS_USER are empty, anyone reaching NATS can pose as a worker")
+	return nil
+}
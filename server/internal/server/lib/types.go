@@ -6,10 +6,6 @@ import (
 	"github.com/pion/webrtc/v4"
 )
 
-type ApiError struct {
-	Error string `json:"error"`
-}
-
 type StreamConfig struct {
 	PeerConnection *webrtc.PeerConnection         `json:"peer_connection"`
 	IceCandidates  []*webrtc.ICECandidate         `json:"ice_candidates"`
@@ -17,25 +13,6 @@ type StreamConfig struct {
 	AudioTrack     *webrtc.TrackLocalStaticSample `json:"audio_track"`
 }
 
-type ConnMap struct {
-	UserId       string        `json:"user_id"`
-	StreamConfig *StreamConfig `json:"stream_config"`
-	Chatrooms    []Chatroom    `json:"chatrooms"`
-}
-
-func NewConnMap(userId string) (*ConnMap, error) {
-	config, err := NewStreamConfig(userId)
-	if err != nil {
-		log.Println("Error in NewConnMap[StreamConfig]:", err)
-		return nil, err
-	}
-	return &ConnMap{
-		UserId:       userId,
-		StreamConfig: config,
-		Chatrooms:    []Chatroom{},
-	}, nil
-}
-
 func NewStreamConfig(streamId string) (*StreamConfig, error) {
 	peerConn, err := NewRTCPeerConnection(streamId)
 	if err != nil {
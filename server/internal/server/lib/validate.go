@@ -0,0 +1,237 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxJSONBodyBytes caps every JSON request body, uploads set their own limit
+const MaxJSONBodyBytes = 1 << 20
+
+var uuidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var usernameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.]{2,29}$`)
+
+// DecodeJSON reads the JSON body of r into v and validates it. Bodies over
+// MaxJSONBodyBytes, malformed JSON and failed rules are all answered with
+// an ApiError.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxJSONBodyBytes)
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+	case errors.As(err, &maxBytesErr):
+		return NewApiError(http.StatusRequestEntityTooLarge, CodePayloadTooLarge,
+			fmt.Sprintf("Body is larger than %d bytes", MaxJSONBodyBytes))
+	case errors.Is(err, io.EOF):
+		return BadRequest("Body is empty")
+	case errors.As(err, &typeErr):
+		return NewApiError(http.StatusBadRequest, CodeValidation, "Body is not valid").
+			WithDetails(map[string]any{"fields": map[string]string{typeErr.Field: "must be a " + typeErr.Type.String()}})
+	default:
+		return BadRequest("Body Is not of correct format")
+	}
+
+	return Validate(v)
+}
+
+// Validate checks the `validate` struct tags of v. Rules are comma
+// separated:
+//
+//	required      not the zero value, not blank for strings, not empty for slices
+//	email         a single email address
+//	uuid          a canonical UUID
+//	username      3 to 30 letters, digits, dots or underscores
+//	oneof=a b c   one of the space separated values
+//	min=n, max=n  length of strings (in characters) and slices, value of numbers
+//	maxbytes=n    length of strings in bytes, for limits like bcrypt's
+//
+// Rules other than required are skipped for empty values. Pointers are
+// checked against what they point to, a nil pointer is empty. The rules after
+// "dive" are applied to each element of a slice, for example
+// `validate:"required,max=50,dive,uuid"`.
+//
+// Failures are collected per json field into a 400 validation_failed error
+// with details {"fields": {"email": "must be a valid email"}}.
+func Validate(v any) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := map[string]string{}
+	validateStruct(rv, "", fields)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return NewApiError(http.StatusBadRequest, CodeValidation, validationMessage(fields)).
+		WithDetails(map[string]any{"fields": fields})
+}
+
+func validationMessage(fields map[string]string) string {
+	if len(fields) == 1 {
+		for name, msg := range fields {
+			return name + " " + msg
+		}
+	}
+	return "Some fields are not valid"
+}
+
+func validateStruct(rv reflect.Value, prefix string, fields map[string]string) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = f.Name
+		}
+		name = prefix + name
+
+		fv := rv.Field(i)
+		if tag := f.Tag.Get("validate"); tag != "" {
+			if msg := validateValue(fv, strings.Split(tag, ",")); msg != "" {
+				fields[name] = msg
+				continue
+			}
+		}
+
+		if fv.Kind() == reflect.Struct {
+			validateStruct(fv, name+".", fields)
+		}
+	}
+}
+
+func validateValue(v reflect.Value, rules []string) string {
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	empty := v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0) ||
+		(v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "")
+
+	for i, rule := range rules {
+		if rule == "required" {
+			if empty {
+				return "is required"
+			}
+			continue
+		}
+		if empty {
+			// Optional and empty, the other rules don't apply
+			return ""
+		}
+
+		if rule == "dive" {
+			if v.Kind() != reflect.Slice {
+				return ""
+			}
+			for j := 0; j < v.Len(); j++ {
+				if msg := validateValue(v.Index(j), rules[i+1:]); msg != "" {
+					return fmt.Sprintf("item %d %s", j, msg)
+				}
+			}
+			return ""
+		}
+
+		if msg := checkRule(v, rule); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func checkRule(v reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+
+	switch name {
+	case "email":
+		if v.Kind() == reflect.String && !isEmail(v.String()) {
+			return "must be a valid email"
+		}
+	case "uuid":
+		if v.Kind() == reflect.String && !uuidRe.MatchString(v.String()) {
+			return "must be a valid uuid"
+		}
+	case "username":
+		if v.Kind() == reflect.String && !usernameRe.MatchString(v.String()) {
+			return "must be 3 to 30 letters, digits, dots or underscores, starting with a letter or digit"
+		}
+	case "oneof":
+		if v.Kind() == reflect.String && !Contains(strings.Fields(arg), v.String()) {
+			return "must be one of " + strings.Join(strings.Fields(arg), ", ")
+		}
+	case "min", "max":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("validate: bad %s rule %q", name, rule))
+		}
+		return checkBound(v, name, n)
+	case "maxbytes":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("validate: bad %s rule %q", name, rule))
+		}
+		if v.Kind() == reflect.String && len(v.String()) > n {
+			return fmt.Sprintf("must have at most %d bytes", n)
+		}
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", rule))
+	}
+	return ""
+}
+
+func checkBound(v reflect.Value, rule string, n int) string {
+	var size int
+	unit := ""
+	switch v.Kind() {
+	case reflect.String:
+		size, unit = utf8.RuneCountInString(v.String()), " characters"
+	case reflect.Slice, reflect.Map:
+		size, unit = v.Len(), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = int(v.Int())
+	default:
+		return ""
+	}
+
+	if rule == "min" && size < n {
+		if unit == "" {
+			return fmt.Sprintf("must be at least %d", n)
+		}
+		return fmt.Sprintf("must have at least %d%s", n, unit)
+	}
+	if rule == "max" && size > n {
+		if unit == "" {
+			return fmt.Sprintf("must be at most %d", n)
+		}
+		return fmt.Sprintf("must have at most %d%s", n, unit)
+	}
+	return ""
+}
+
+func isEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s && strings.Contains(s, "@")
+}
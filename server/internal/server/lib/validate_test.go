@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type validateRequest struct {
+	Email   string   `json:"email" validate:"required,email"`
+	Status  string   `json:"status" validate:"oneof=accepted rejected"`
+	Name    string   `json:"name" validate:"min=2,max=5"`
+	Ids     []string `json:"ids" validate:"max=2,dive,uuid"`
+	Count   int      `json:"count" validate:"max=10"`
+	Handle  *string  `json:"handle" validate:"username"`
+	Secret  string   `json:"secret" validate:"max=4,maxbytes=4"`
+	Ignored string   `json:"ignored"`
+}
+
+func validationFields(t *testing.T, err error) map[string]string {
+	t.Helper()
+	var apiErr *ApiError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an ApiError, got %v", err)
+	}
+	if apiErr.Status != http.StatusBadRequest || apiErr.Code != CodeValidation {
+		t.Fatalf("unexpected status %d code %s", apiErr.Status, apiErr.Code)
+	}
+	return apiErr.Details["fields"].(map[string]string)
+}
+
+func TestValidate(t *testing.T) {
+	valid := validateRequest{
+		Email: "shiba@example.com",
+		Ids:   []string{"4b8f9a52-5c3e-4c4f-9a7e-2d7c1b1e0f11"},
+	}
+	if err := Validate(&valid); err != nil {
+		t.Fatalf("valid request failed: %v", err)
+	}
+
+	handle := "shiba.inu_1"
+	valid.Handle = &handle
+	if err := Validate(&valid); err != nil {
+		t.Fatalf("valid username failed: %v", err)
+	}
+
+	badHandle := ".shiba"
+	fields := validationFields(t, Validate(validateRequest{
+		Email:  "not-an-email",
+		Status: "maybe",
+		Name:   "x",
+		Ids:    []string{"4b8f9a52-5c3e-4c4f-9a7e-2d7c1b1e0f11", "nope"},
+		Count:  11,
+		Handle: &badHandle,
+		Secret: "ééé",
+	}))
+	want := map[string]string{
+		"email":  "must be a valid email",
+		"status": "must be one of accepted, rejected",
+		"name":   "must have at least 2 characters",
+		"ids":    "item 1 must be a valid uuid",
+		"count":  "must be at most 10",
+		"handle": "must be 3 to 30 letters, digits, dots or underscores, starting with a letter or digit",
+		"secret": "must have at most 4 bytes",
+	}
+	for field, msg := range want {
+		if fields[field] != msg {
+			t.Errorf("%s: got %q, want %q", field, fields[field], msg)
+		}
+	}
+	if len(fields) != len(want) {
+		t.Errorf("unexpected fields %v", fields)
+	}
+
+	fields = validationFields(t, Validate(validateRequest{Email: "  "}))
+	if fields["email"] != "is required" {
+		t.Errorf("blank email: got %q", fields["email"])
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	decode := func(body string) error {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		return DecodeJSON(httptest.NewRecorder(), r, &validateRequest{})
+	}
+
+	if err := decode(`{"email": "shiba@example.com"}`); err != nil {
+		t.Fatalf("valid body failed: %v", err)
+	}
+	if fields := validationFields(t, decode(`{}`)); fields["email"] != "is required" {
+		t.Errorf("missing email: got %v", fields)
+	}
+	if fields := validationFields(t, decode(`{"email": "a@b.c", "count": "ten"}`)); fields["count"] == "" {
+		t.Errorf("wrong type: got %v", fields)
+	}
+
+	var apiErr *ApiError
+	if err := decode(`{"email": `); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("malformed body: got %v", err)
+	}
+	big := `{"name": "` + strings.Repeat("a", MaxJSONBodyBytes) + `"}`
+	if err := decode(big); !errors.As(err, &apiErr) || apiErr.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: got %v", err)
+	}
+}
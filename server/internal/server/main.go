@@ -5,11 +5,12 @@ import (
 	"log"
 
 	"sideDesert/shiba/internal/server/controller"
+	"sideDesert/shiba/internal/server/lib"
 	s "sideDesert/shiba/internal/server/services"
-	vb "sideDesert/shiba/internal/vbrowser"
 
 	"github.com/joho/godotenv"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 func init() {
@@ -25,13 +26,31 @@ func NewServer(ctx context.Context, config *s.ServerConfig) (*controller.Control
 		return nil, err
 	}
 
-	nc, err := nats.Connect(nats.DefaultURL)
+	nc, err := nats.Connect(config.NatsUrl, lib.NatsAuth()...)
 	if err != nil {
 		log.Print("Error in NewServer[NatsConnect()]: ", err)
 		return nil, err
 	}
 
-	controller := controller.NewController(service, nc, vb.NewManager(99))
+	js, err := jetstream.New(nc)
+	if err != nil {
+		log.Print("Error in NewServer[jetstream.New()]: ", err)
+		return nil, err
+	}
+	if _, err := s.SetupChatroomStream(ctx, js); err != nil {
+		log.Print("Error in NewServer[SetupChatroomStream()]: ", err)
+		return nil, err
+	}
+
+	service.SetPublisher(nc)
+	service.SetRoomPublisher(s.NewJetStreamPublisher(ctx, js))
+	go service.RunAccountPurger(ctx)
+	go service.RunAttemptSweeper(ctx)
+	go service.RunChatPersister(ctx, js)
+	lib.SetSessionManager(service)
+
+	// Streams run on the workers of cmd/vbrowser
+	controller := controller.NewController(service, nc, js)
 
 	return controller, nil
 }
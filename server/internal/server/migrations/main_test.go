@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range migrations {
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Errorf("migration %d_%s is out of order", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadRejectsIncompletePairs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0001_users.up.sql":   {Data: []byte("CREATE TABLE users ();")},
+		"sql/0001_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"sql/0002_chats.up.sql":   {Data: []byte("CREATE TABLE chats ();")},
+	}
+	if _, err := load(fsys, "sql"); err == nil {
+		t.Error("a migration without a down file was accepted")
+	}
+
+	delete(fsys, "sql/0002_chats.up.sql")
+	fsys["sql/two_chats.up.sql"] = &fstest.MapFile{Data: []byte("")}
+	if _, err := load(fsys, "sql"); err == nil {
+		t.Error("a migration without a version was accepted")
+	}
+}
+
+var (
+	tableRefRe  = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE|JOIN)\s+([a-z_]+)\b`)
+	funcCallRe  = regexp.MustCompile(`(?i)\bFROM\s+([a-z_]+)\(`)
+	createdRe   = regexp.MustCompile(`(?i)CREATE\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([a-z_]+)`)
+	createdFnRe = regexp.MustCompile(`(?i)CREATE\s+(?:OR\s+REPLACE\s+)?FUNCTION\s+([a-z_]+)\(`)
+	// ON CONFLICT ... DO UPDATE SET names no table
+	upsertRe = regexp.MustCompile(`(?i)\bDO\s+UPDATE\s+SET\b`)
+)
+
+// Every table and function the store queries has to be created by a migration
+func TestMigrationsCoverStore(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := map[string]bool{}
+	for _, m := range migrations {
+		for _, match := range createdRe.FindAllStringSubmatch(m.Up, -1) {
+			created[strings.ToLower(match[1])] = true
+		}
+		for _, match := range createdFnRe.FindAllStringSubmatch(m.Up, -1) {
+			created[strings.ToLower(match[1])] = true
+		}
+	}
+
+	files, err := filepath.Glob("../store/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only look inside string literals, the Go code has FROM-like words too
+		for _, literal := range regexp.MustCompile("(?s)`[^`]*`|\"[^\"\n]*\"").FindAllString(string(data), -1) {
+			literal = upsertRe.ReplaceAllString(literal, "")
+			refs := append(tableRefRe.FindAllStringSubmatch(literal, -1), funcCallRe.FindAllStringSubmatch(literal, -1)...)
+			for _, match := range refs {
+				name := strings.ToLower(match[1])
+				if !created[name] {
+					t.Errorf("%s uses %q which no migration creates", filepath.Base(file), name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+DROP FUNCTION IF EXISTS search_users(TEXT, INT);
+DROP TABLE IF EXISTS session;
+DROP TABLE IF EXISTS remote;
+DROP TABLE IF EXISTS friends;
+DROP FUNCTION IF EXISTS set_updated_at();
+DROP TABLE IF EXISTS read_receipts;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS user_chatrooms;
+DROP TABLE IF EXISTS chatrooms;
+DROP TABLE IF EXISTS users;
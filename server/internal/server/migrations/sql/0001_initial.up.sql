@@ -0,0 +1,124 @@
+-- Base schema. Tables use IF NOT EXISTS so databases created by hand
+-- before migrations existed can be adopted as is.
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	user_id VARCHAR(255) UNIQUE NOT NULL DEFAULT gen_random_uuid()::text,
+	name VARCHAR(255) NOT NULL,
+	email VARCHAR(255) UNIQUE NOT NULL,
+	password_hash VARCHAR(255) NOT NULL,
+	username VARCHAR(255) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	profile_picture VARCHAR(255) NULL,
+	status VARCHAR(50) NULL
+);
+
+CREATE TABLE IF NOT EXISTS chatrooms (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	name VARCHAR(255) NOT NULL,
+	profile_picture VARCHAR(255) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	direct_message BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS user_chatrooms (
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	chatroom_id UUID NOT NULL REFERENCES chatrooms(id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, chatroom_id)
+);
+
+CREATE INDEX IF NOT EXISTS user_chatrooms_chatroom_idx ON user_chatrooms (chatroom_id);
+
+-- recipient is the chatroom the message was sent to
+CREATE TABLE IF NOT EXISTS messages (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	sender VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	recipient UUID NOT NULL REFERENCES chatrooms(id) ON DELETE CASCADE,
+	content TEXT NOT NULL,
+	status VARCHAR(50) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS messages_recipient_created_idx ON messages (recipient, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS read_receipts (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	read_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (message_id, user_id)
+);
+
+-- user_id1 sent the request to user_id2
+CREATE TABLE IF NOT EXISTS friends (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id1 VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	user_id2 VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	status VARCHAR(50) NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_id1, user_id2)
+);
+
+CREATE INDEX IF NOT EXISTS friends_user_id2_idx ON friends (user_id2);
+
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+	NEW.updated_at = CURRENT_TIMESTAMP;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS friends_set_updated_at ON friends;
+CREATE TRIGGER friends_set_updated_at BEFORE UPDATE ON friends
+	FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+-- The member of a chatroom holding the remote of the shared browser
+CREATE TABLE IF NOT EXISTS remote (
+	chatroom_id UUID PRIMARY KEY REFERENCES chatrooms(id) ON DELETE CASCADE,
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS session (
+	id SERIAL PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	token VARCHAR(255) UNIQUE NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+-- Added with refresh token rotation, older databases only have the columns above
+ALTER TABLE session ADD COLUMN IF NOT EXISTS previous_token VARCHAR(255) NULL;
+ALTER TABLE session ADD COLUMN IF NOT EXISTS user_agent VARCHAR(255) NULL;
+ALTER TABLE session ADD COLUMN IF NOT EXISTS ip VARCHAR(64) NULL;
+ALTER TABLE session ADD COLUMN IF NOT EXISTS created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE session ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP NULL;
+ALTER TABLE session ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP NULL;
+
+CREATE INDEX IF NOT EXISTS session_user_idx ON session (user_id);
+CREATE INDEX IF NOT EXISTS session_previous_token_idx ON session (previous_token);
+
+-- Users whose name, username or email contains the query, best matches first
+CREATE OR REPLACE FUNCTION search_users(query TEXT, max_results INT)
+RETURNS TABLE (
+	name VARCHAR(255),
+	user_id VARCHAR(255),
+	username VARCHAR(255),
+	email VARCHAR(255),
+	status VARCHAR(50),
+	profile_picture VARCHAR(255)
+) AS $$
+	SELECT u.name, u.user_id, COALESCE(u.username, ''), u.email, u.status, u.profile_picture
+	FROM users u
+	WHERE query <> '' AND (
+		strpos(lower(u.name), lower(query)) > 0
+		OR strpos(lower(COALESCE(u.username, '')), lower(query)) > 0
+		OR strpos(lower(u.email), lower(query)) = 1
+	)
+	ORDER BY
+		lower(COALESCE(u.username, '')) = lower(query) DESC,
+		strpos(lower(COALESCE(u.username, '')), lower(query)) = 1 DESC,
+		strpos(lower(u.name), lower(query)) = 1 DESC,
+		u.name
+	LIMIT max_results;
+$$ LANGUAGE sql STABLE;
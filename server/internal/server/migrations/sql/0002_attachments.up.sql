@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS attachments (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	message_id UUID NULL REFERENCES messages(id) ON DELETE CASCADE,
+	chatroom_id UUID NOT NULL REFERENCES chatrooms(id) ON DELETE CASCADE,
+	uploader VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	file_name VARCHAR(255) NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	size BIGINT NOT NULL,
+	storage_key VARCHAR(512) NOT NULL,
+	thumbnail_key VARCHAR(512) NULL,
+	width INT NULL,
+	height INT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS attachments_message_idx ON attachments (message_id);
+
+CREATE TABLE IF NOT EXISTS link_previews (
+	message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	url TEXT NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT NULL,
+	image TEXT NULL,
+	site_name VARCHAR(255) NULL,
+	type VARCHAR(64) NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (message_id, url)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_mentions;
+DROP TABLE IF EXISTS notifications;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS notifications (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	type VARCHAR(50) NOT NULL,
+	actor_id VARCHAR(255) NULL REFERENCES users(user_id) ON DELETE SET NULL,
+	chatroom_id UUID NULL REFERENCES chatrooms(id) ON DELETE CASCADE,
+	data JSONB NOT NULL DEFAULT '{}',
+	read_at TIMESTAMP NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_created_idx ON notifications (user_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS message_mentions (
+	message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_mentions_user_idx ON message_mentions (user_id);
@@ -0,0 +1,9 @@
+ALTER TABLE friends DROP CONSTRAINT IF EXISTS friends_not_self;
+DROP INDEX IF EXISTS friends_pair_idx;
+
+-- Blocks go back to being relations with status 'blocked'
+INSERT INTO friends (user_id1, user_id2, status)
+SELECT blocked_id, blocker_id, 'blocked' FROM blocks
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS blocks;
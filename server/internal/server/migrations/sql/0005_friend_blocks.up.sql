@@ -0,0 +1,37 @@
+-- A user blocking another one. Blocked users don't see each other in search
+-- and can't befriend, message or invite each other.
+CREATE TABLE IF NOT EXISTS blocks (
+	blocker_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	blocked_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (blocker_id, blocked_id),
+	CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX IF NOT EXISTS blocks_blocked_idx ON blocks (blocked_id);
+
+-- Requests answered with status 'blocked' become blocks by their recipient
+INSERT INTO blocks (blocker_id, blocked_id, created_at)
+SELECT user_id2, user_id1, updated_at FROM friends
+WHERE status = 'blocked' AND user_id1 <> user_id2
+ON CONFLICT DO NOTHING;
+
+DELETE FROM friends WHERE status = 'blocked' OR user_id1 = user_id2;
+
+-- One relation per pair of users whoever sent the request. Pairs with a row
+-- in both directions keep the accepted one, or else the oldest.
+DELETE FROM friends f
+USING friends o
+WHERE f.user_id1 = o.user_id2 AND f.user_id2 = o.user_id1
+AND (
+	(COALESCE(o.status, '') = 'accepted') > (COALESCE(f.status, '') = 'accepted')
+	OR (
+		(COALESCE(o.status, '') = 'accepted') = (COALESCE(f.status, '') = 'accepted')
+		AND (o.created_at, o.id::text) < (f.created_at, f.id::text)
+	)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS friends_pair_idx ON friends (LEAST(user_id1, user_id2), GREATEST(user_id1, user_id2));
+
+ALTER TABLE friends DROP CONSTRAINT IF EXISTS friends_not_self;
+ALTER TABLE friends ADD CONSTRAINT friends_not_self CHECK (user_id1 <> user_id2);
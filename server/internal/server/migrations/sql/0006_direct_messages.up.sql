@@ -0,0 +1,29 @@
+-- The direct message chatroom of a pair of users, user_low < user_high so a
+-- pair can only ever have one
+CREATE TABLE IF NOT EXISTS direct_messages (
+	chatroom_id UUID PRIMARY KEY REFERENCES chatrooms(id) ON DELETE CASCADE,
+	user_low VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	user_high VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_low, user_high),
+	CHECK (user_low < user_high)
+);
+
+-- Adopt existing direct message rooms with exactly two members, the oldest
+-- one wins for pairs with several
+INSERT INTO direct_messages (chatroom_id, user_low, user_high, created_at)
+SELECT DISTINCT ON (pair.user_low, pair.user_high) pair.chatroom_id, pair.user_low, pair.user_high, pair.created_at
+FROM (
+	SELECT c.id AS chatroom_id, MIN(uc.user_id) AS user_low, MAX(uc.user_id) AS user_high, c.created_at
+	FROM chatrooms c
+	JOIN user_chatrooms uc ON uc.chatroom_id = c.id
+	WHERE c.direct_message
+	GROUP BY c.id, c.created_at
+	HAVING COUNT(*) = 2
+) pair
+ORDER BY pair.user_low, pair.user_high, pair.created_at
+ON CONFLICT DO NOTHING;
+
+-- Any other room flagged as a direct message becomes a regular room
+UPDATE chatrooms SET direct_message = FALSE
+WHERE direct_message AND id NOT IN (SELECT chatroom_id FROM direct_messages);
@@ -0,0 +1,3 @@
+DROP FUNCTION IF EXISTS friend_suggestions(VARCHAR, INT);
+DROP FUNCTION IF EXISTS mutual_friend_count(VARCHAR, VARCHAR);
+DROP FUNCTION IF EXISTS friend_ids(VARCHAR);
@@ -0,0 +1,56 @@
+-- The accepted friends of a user, whichever of them sent the request
+CREATE OR REPLACE FUNCTION friend_ids(uid VARCHAR)
+RETURNS TABLE (friend_id VARCHAR) AS $$
+	SELECT CASE WHEN f.user_id1 = uid THEN f.user_id2 ELSE f.user_id1 END
+	FROM friends f
+	WHERE uid IN (f.user_id1, f.user_id2) AND f.status = 'accepted';
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION mutual_friend_count(uid VARCHAR, other_id VARCHAR)
+RETURNS INT AS $$
+	SELECT COUNT(*)::INT
+	FROM friend_ids(uid) mine
+	JOIN friend_ids(other_id) theirs ON theirs.friend_id = mine.friend_id;
+$$ LANGUAGE sql STABLE;
+
+-- Users uid has no relation with, ranked by the friends they have in common
+-- and then by the group chatrooms they share
+CREATE OR REPLACE FUNCTION friend_suggestions(uid VARCHAR, max_results INT)
+RETURNS TABLE (
+	user_id VARCHAR(255),
+	mutual_friends INT,
+	shared_chatrooms INT
+) AS $$
+	WITH mutual AS (
+		SELECT fof.friend_id AS user_id, COUNT(*)::INT AS n
+		FROM friend_ids(uid) f
+		CROSS JOIN LATERAL friend_ids(f.friend_id) fof
+		GROUP BY fof.friend_id
+	), shared AS (
+		SELECT other.user_id, COUNT(*)::INT AS n
+		FROM user_chatrooms mine
+		JOIN chatrooms c ON c.id = mine.chatroom_id AND NOT c.direct_message
+		JOIN user_chatrooms other ON other.chatroom_id = mine.chatroom_id
+		WHERE mine.user_id = uid
+		GROUP BY other.user_id
+	), candidates AS (
+		SELECT mutual.user_id FROM mutual
+		UNION
+		SELECT shared.user_id FROM shared
+	)
+	SELECT cand.user_id, COALESCE(m.n, 0), COALESCE(s.n, 0)
+	FROM candidates cand
+	LEFT JOIN mutual m ON m.user_id = cand.user_id
+	LEFT JOIN shared s ON s.user_id = cand.user_id
+	WHERE cand.user_id <> uid
+	AND NOT EXISTS (
+		SELECT 1 FROM friends f
+		WHERE (f.user_id1 = uid AND f.user_id2 = cand.user_id) OR (f.user_id1 = cand.user_id AND f.user_id2 = uid)
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM blocks b
+		WHERE (b.blocker_id = uid AND b.blocked_id = cand.user_id) OR (b.blocker_id = cand.user_id AND b.blocked_id = uid)
+	)
+	ORDER BY COALESCE(m.n, 0) DESC, COALESCE(s.n, 0) DESC, cand.user_id
+	LIMIT max_results;
+$$ LANGUAGE sql STABLE;
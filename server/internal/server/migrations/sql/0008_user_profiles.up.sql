@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio TEXT NOT NULL DEFAULT '';
+
+-- Usernames become unique regardless of case, the later of duplicate
+-- usernames get their id appended
+UPDATE users u SET username = u.username || '_' || u.id
+WHERE u.username <> '' AND EXISTS (
+	SELECT 1 FROM users o WHERE lower(o.username) = lower(u.username) AND o.id < u.id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_idx ON users (lower(username)) WHERE username <> '';
@@ -0,0 +1,8 @@
+ALTER TABLE user_chatrooms DROP COLUMN IF EXISTS role;
+
+DROP TRIGGER IF EXISTS chatrooms_set_updated_at ON chatrooms;
+ALTER TABLE chatrooms DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE chatrooms DROP COLUMN IF EXISTS stream_quality;
+ALTER TABLE chatrooms DROP COLUMN IF EXISTS default_start_url;
+ALTER TABLE chatrooms DROP COLUMN IF EXISTS topic;
+ALTER TABLE chatrooms DROP COLUMN IF EXISTS description;
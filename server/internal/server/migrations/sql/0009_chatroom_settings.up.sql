@@ -0,0 +1,27 @@
+ALTER TABLE chatrooms ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE chatrooms ADD COLUMN IF NOT EXISTS topic VARCHAR(255) NOT NULL DEFAULT '';
+-- Preferences of the shared browser, stream_quality names a vbrowser preset
+ALTER TABLE chatrooms ADD COLUMN IF NOT EXISTS default_start_url VARCHAR(2048) NOT NULL DEFAULT '';
+ALTER TABLE chatrooms ADD COLUMN IF NOT EXISTS stream_quality VARCHAR(20) NOT NULL DEFAULT 'high';
+ALTER TABLE chatrooms ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE chatrooms ADD CONSTRAINT chatrooms_stream_quality_check CHECK (stream_quality IN ('low', 'medium', 'high'));
+
+DROP TRIGGER IF EXISTS chatrooms_set_updated_at ON chatrooms;
+CREATE TRIGGER chatrooms_set_updated_at BEFORE UPDATE ON chatrooms
+	FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+-- Owners manage the settings of a room. Nobody knows who created the
+-- existing rooms, the member holding the remote becomes the owner, the
+-- lowest user_id when nobody does. Both members own a direct message.
+ALTER TABLE user_chatrooms ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'member';
+UPDATE user_chatrooms SET role = 'owner'
+FROM (
+	SELECT DISTINCT ON (uc.chatroom_id) uc.chatroom_id, uc.user_id
+	FROM user_chatrooms uc
+	LEFT JOIN remote r ON r.chatroom_id = uc.chatroom_id AND r.user_id = uc.user_id
+	ORDER BY uc.chatroom_id, r.user_id IS NULL, uc.user_id
+) picked
+WHERE user_chatrooms.chatroom_id = picked.chatroom_id AND user_chatrooms.user_id = picked.user_id;
+UPDATE user_chatrooms SET role = 'owner'
+WHERE chatroom_id IN (SELECT chatroom_id FROM direct_messages);
+ALTER TABLE user_chatrooms ADD CONSTRAINT user_chatrooms_role_check CHECK (role IN ('owner', 'member'));
@@ -0,0 +1,9 @@
+DELETE FROM messages WHERE sender IS NULL;
+
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_sender_fkey;
+ALTER TABLE messages ADD CONSTRAINT messages_sender_fkey
+	FOREIGN KEY (sender) REFERENCES users(user_id) ON DELETE CASCADE;
+ALTER TABLE messages ALTER COLUMN sender SET NOT NULL;
+
+DROP INDEX IF EXISTS users_deletion_requested_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_requested_at;
@@ -0,0 +1,13 @@
+-- Set when the user asked for their account to be deleted, the account is
+-- purged once the grace period is over unless they log in again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at TIMESTAMP NULL;
+
+CREATE INDEX IF NOT EXISTS users_deletion_requested_idx ON users (deletion_requested_at)
+	WHERE deletion_requested_at IS NOT NULL;
+
+-- Messages of deleted users stay in the history of their chatrooms without
+-- an author instead of disappearing with them
+ALTER TABLE messages ALTER COLUMN sender DROP NOT NULL;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_sender_fkey;
+ALTER TABLE messages ADD CONSTRAINT messages_sender_fkey
+	FOREIGN KEY (sender) REFERENCES users(user_id) ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS messages_sender_client_id_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS client_id;
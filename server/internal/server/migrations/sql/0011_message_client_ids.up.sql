@@ -0,0 +1,6 @@
+-- The id the sending client gave a message. Chat events are persisted by a
+-- JetStream consumer that may see an event more than once, this keeps them
+-- stored once.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_id VARCHAR(255) NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_sender_client_id_idx ON messages (sender, client_id);
@@ -0,0 +1,10 @@
+-- Which server node streams a chatroom. Commands for the stream are routed
+-- to that node, a node that stops refreshing heartbeat_at loses its rooms.
+CREATE TABLE IF NOT EXISTS stream_rooms (
+	chatroom_id UUID PRIMARY KEY REFERENCES chatrooms(id) ON DELETE CASCADE,
+	node_id VARCHAR(255) NOT NULL,
+	claimed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	heartbeat_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS stream_rooms_node_id_idx ON stream_rooms (node_id);
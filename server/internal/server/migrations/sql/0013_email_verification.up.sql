@@ -0,0 +1,4 @@
+-- Set once the user showed they own the email: it came verified from an
+-- OAuth provider or they used a reset link sent to it. Only a verified email
+-- is linked to a provider identity with the same address.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
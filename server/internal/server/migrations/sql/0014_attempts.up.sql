@@ -0,0 +1,11 @@
+-- Rate limited actions, like failed logins and password reset requests,
+-- counted per key by every API node. Rows older than the longest window are
+-- swept.
+CREATE TABLE IF NOT EXISTS attempts (
+	id BIGSERIAL PRIMARY KEY,
+	attempt_key VARCHAR(320) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS attempts_attempt_key_idx ON attempts (attempt_key, created_at);
+CREATE INDEX IF NOT EXISTS attempts_created_at_idx ON attempts (created_at);
@@ -0,0 +1,28 @@
+DROP FUNCTION IF EXISTS search_users(VARCHAR, TEXT, INT);
+
+-- Users whose name or username contains the query, best matches first. An
+-- email only matches in full, so a search can't enumerate the addresses of
+-- a domain.
+CREATE OR REPLACE FUNCTION search_users(query TEXT, max_results INT)
+RETURNS TABLE (
+	name VARCHAR(255),
+	user_id VARCHAR(255),
+	username VARCHAR(255),
+	email VARCHAR(255),
+	status VARCHAR(50),
+	profile_picture VARCHAR(255)
+) AS $$
+	SELECT u.name, u.user_id, COALESCE(u.username, ''), u.email, u.status, u.profile_picture
+	FROM users u
+	WHERE query <> '' AND (
+		strpos(lower(u.name), lower(query)) > 0
+		OR strpos(lower(COALESCE(u.username, '')), lower(query)) > 0
+		OR lower(u.email) = lower(trim(query))
+	)
+	ORDER BY
+		lower(COALESCE(u.username, '')) = lower(query) DESC,
+		strpos(lower(COALESCE(u.username, '')), lower(query)) = 1 DESC,
+		strpos(lower(u.name), lower(query)) = 1 DESC,
+		u.name
+	LIMIT max_results;
+$$ LANGUAGE sql STABLE;
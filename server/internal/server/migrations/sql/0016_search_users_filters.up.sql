@@ -0,0 +1,32 @@
+-- The searcher and the users blocked either way are left out before the
+-- limit, a page of results is never short because of them.
+DROP FUNCTION IF EXISTS search_users(TEXT, INT);
+
+CREATE OR REPLACE FUNCTION search_users(searcher VARCHAR(255), query TEXT, max_results INT)
+RETURNS TABLE (
+	name VARCHAR(255),
+	user_id VARCHAR(255),
+	username VARCHAR(255),
+	email VARCHAR(255),
+	status VARCHAR(50),
+	profile_picture VARCHAR(255)
+) AS $$
+	SELECT u.name, u.user_id, COALESCE(u.username, ''), u.email, u.status, u.profile_picture
+	FROM users u
+	WHERE query <> '' AND (
+		strpos(lower(u.name), lower(query)) > 0
+		OR strpos(lower(COALESCE(u.username, '')), lower(query)) > 0
+		OR lower(u.email) = lower(trim(query))
+	)
+	AND u.user_id != searcher
+	AND NOT EXISTS (
+		SELECT 1 FROM blocks b
+		WHERE (b.blocker_id = searcher AND b.blocked_id = u.user_id) OR (b.blocker_id = u.user_id AND b.blocked_id = searcher)
+	)
+	ORDER BY
+		lower(COALESCE(u.username, '')) = lower(query) DESC,
+		strpos(lower(COALESCE(u.username, '')), lower(query)) = 1 DESC,
+		strpos(lower(u.name), lower(query)) = 1 DESC,
+		u.name
+	LIMIT max_results;
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,3 @@
+-- The rooms stay, they can't be told apart from the ones opened since and
+-- may hold messages by now
+SELECT 1;
@@ -0,0 +1,22 @@
+-- Accepting a friend request opens the direct message room of the pair.
+-- Friends from before that get theirs here, listing friends never creates
+-- one.
+DO $$
+DECLARE
+	pair RECORD;
+	room UUID;
+BEGIN
+	FOR pair IN
+		SELECT DISTINCT LEAST(f.user_id1, f.user_id2) AS user_low, GREATEST(f.user_id1, f.user_id2) AS user_high
+		FROM friends f
+		WHERE f.status = 'accepted'
+		AND NOT EXISTS (
+			SELECT 1 FROM direct_messages dm
+			WHERE dm.user_low = LEAST(f.user_id1, f.user_id2) AND dm.user_high = GREATEST(f.user_id1, f.user_id2)
+		)
+	LOOP
+		INSERT INTO chatrooms (name, direct_message) VALUES ('', TRUE) RETURNING id INTO room;
+		INSERT INTO direct_messages (chatroom_id, user_low, user_high) VALUES (room, pair.user_low, pair.user_high);
+		INSERT INTO user_chatrooms (user_id, chatroom_id, role) VALUES (pair.user_low, room, 'owner'), (pair.user_high, room, 'owner');
+	END LOOP;
+END $$;
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+const (
+	// Logging in again during the grace period keeps the account
+	AccountDeletionGrace = 14 * 24 * time.Hour
+
+	accountPurgeInterval = time.Hour
+	accountPurgeBatch    = 100
+)
+
+// ScheduleAccountDeletion marks the account of userId for deletion once the
+// grace period is over and logs it out everywhere. confirm must repeat the
+// username, or the email of an account without one, so an open tab alone
+// can't delete an account by accident.
+func (s *Service) ScheduleAccountDeletion(userId string, confirm string) (*dto.DeleteAccountResponse, error) {
+	user, err := s.Store.GetUserById(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in ScheduleAccountDeletion[GetUserById]:", err)
+		return nil, err
+	}
+	expected, field := user.Username, "username"
+	if expected == "" {
+		expected, field = user.Email, "email"
+	}
+	confirm = strings.TrimSpace(confirm)
+	if confirm == "" || !strings.EqualFold(confirm, expected) {
+		return nil, lib.BadRequest("Type your " + field + " to confirm the deletion")
+	}
+
+	requestedAt, err := s.Store.ScheduleUserDeletion(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in ScheduleAccountDeletion[ScheduleUserDeletion]:", err)
+		return nil, err
+	}
+	if err := s.LogoutEverywhere(userId); err != nil {
+		return nil, err
+	}
+
+	deleteAt := requestedAt.Add(AccountDeletionGrace)
+	body := fmt.Sprintf("Hi %s,\n\nYour Shiba account will be deleted on %s.\n"+
+		"Your messages will stay in your chatrooms without your name, everything else will be removed.\n\n"+
+		"Changed your mind? Log in before then and the deletion is cancelled:\n\n%s\n",
+		user.Name, deleteAt.Format("January 2, 2006"), lib.Client("/login"))
+	if err := s.mailer.Send(s.Ctx, user.Email, "Your Shiba account will be deleted", body); err != nil {
+		log.Println("Error in ScheduleAccountDeletion[Send]:", err)
+	}
+
+	return &dto.DeleteAccountResponse{DeletionScheduledFor: deleteAt}, nil
+}
+
+// cancelAccountDeletion keeps the account of a user who logged in during the
+// grace period
+func (s *Service) cancelAccountDeletion(userId string) {
+	cancelled, err := s.Store.CancelUserDeletion(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in cancelAccountDeletion:", err)
+		return
+	}
+	if cancelled {
+		log.Println("Deletion of account", userId, "cancelled by logging in")
+	}
+}
+
+// RunAccountPurger deletes the accounts whose grace period is over, every
+// accountPurgeInterval until ctx is done
+func (s *Service) RunAccountPurger(ctx context.Context) {
+	ticker := time.NewTicker(accountPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := s.PurgeDeletedAccounts(AccountDeletionGrace); err != nil {
+			log.Println("Error in RunAccountPurger:", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted accounts", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeDeletedAccounts deletes the accounts whose deletion was asked more
+// than grace ago and returns how many were deleted
+func (s *Service) PurgeDeletedAccounts(grace time.Duration) (int, error) {
+	userIds, err := s.Store.GetUsersDueForDeletion(s.Ctx, grace, accountPurgeBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, userId := range userIds {
+		if err := s.deleteAccount(userId); err != nil {
+			log.Println("Error in PurgeDeletedAccounts[deleteAccount]:", userId, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// deleteAccount hands off what other members rely on, removes the user and
+// then the files only they had
+func (s *Service) deleteAccount(userId string) error {
+	user, err := s.Store.GetUserById(s.Ctx, userId)
+	if err != nil {
+		return err
+	}
+	attachments, err := s.Store.GetAttachmentsByUploader(s.Ctx, userId)
+	if err != nil {
+		return err
+	}
+	friends, err := s.Store.GetFriendsByUserId(s.Ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	if err := s.handOffChatrooms(userId); err != nil {
+		return err
+	}
+	if err := s.Store.DeleteUser(s.Ctx, userId); err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		if err := s.Blob.Delete(s.Ctx, a.StorageKey); err != nil {
+			log.Println("Error in deleteAccount[Blob.Delete]:", err)
+		}
+		if a.ThumbnailKey.Valid {
+			s.Blob.Delete(s.Ctx, a.ThumbnailKey.String)
+		}
+	}
+	s.deleteAvatar(user)
+
+	for _, friend := range friends {
+		s.publishToUser(friend.UserId, "user.deleted", map[string]string{"user_id": userId})
+	}
+	return nil
+}
+
+// handOffChatrooms makes sure the group chatrooms of userId keep an owner and
+// gives the remotes they hold to another member
+func (s *Service) handOffChatrooms(userId string) error {
+	chatrooms, err := s.Store.GetChatRoomsByUserId(s.Ctx, userId)
+	if err != nil {
+		return err
+	}
+	for _, chatroom := range chatrooms {
+		if chatroom.DirectMessage {
+			continue
+		}
+		next, err := s.nextChatroomMember(userId, chatroom.Id)
+		if err != nil {
+			return err
+		}
+		if next != nil && next.Role != lib.ChatroomOwner {
+			if err := s.Store.SetChatroomRole(s.Ctx, chatroom.Id, next.UserId, lib.ChatroomOwner); err != nil {
+				return err
+			}
+		}
+	}
+
+	remotes, err := s.Store.GetRemotesByUserId(s.Ctx, userId)
+	if err != nil {
+		return err
+	}
+	for _, chatroomId := range remotes {
+		next, err := s.nextChatroomMember(userId, chatroomId)
+		if err != nil {
+			return err
+		}
+		// Nobody left to take it, the remote goes with the user
+		if next == nil {
+			continue
+		}
+		if err := s.Store.UpdateRemote(s.Ctx, chatroomId, next.UserId); err != nil {
+			return err
+		}
+		s.Notify(next.UserId, lib.NotificationRemoteGranted, "", chatroomId, nil)
+	}
+	return nil
+}
+
+// nextChatroomMember returns the member taking over from userId, owners come
+// first. It is nil when userId is alone in the chatroom.
+func (s *Service) nextChatroomMember(userId string, chatroomId string) (*lib.UserChatroom, error) {
+	members, err := s.Store.GetChatroomMembers(s.Ctx, chatroomId)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if member.UserId != userId {
+			return &member, nil
+		}
+	}
+	return nil, nil
+}
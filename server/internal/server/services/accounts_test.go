@@ -0,0 +1,204 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"sideDesert/shiba/internal/server/blob"
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/store"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	s := newTestService(t)
+	publisher := &recordingPublisher{}
+	s.SetPublisher(publisher)
+	ada, bob, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "bob"), newTestUser(t, s, "eve")
+
+	sent, _ := s.SendFriendRequest(ada, bob)
+	if _, err := s.HandleFriendRequest(bob, sent.RequestId, FriendAccepted); err != nil {
+		t.Fatal(err)
+	}
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room", Participants: []string{bob, eve}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StoreChatMessage(ada, chatroomId, dto.ChatMessagePayload{Content: "bye"}); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := s.StartSession(ada, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ScheduleAccountDeletion(ada, "bob"); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("confirming with another username gave %v, want a bad request", err)
+	}
+	if _, err := s.ScheduleAccountDeletion(ada, " "); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("a blank confirmation gave %v, want a bad request", err)
+	}
+	scheduled, err := s.ScheduleAccountDeletion(ada, " ADA ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(scheduled.DeletionScheduledFor); until < AccountDeletionGrace-time.Minute || until > AccountDeletionGrace {
+		t.Errorf("deletion scheduled for %v", scheduled.DeletionScheduledFor)
+	}
+	if s.SessionActive(tokens.SessionId) {
+		t.Error("the session is still active after asking for deletion")
+	}
+
+	// Nothing is purged before the grace period is over
+	if purged, err := s.PurgeDeletedAccounts(AccountDeletionGrace); err != nil || purged != 0 {
+		t.Fatalf("PurgeDeletedAccounts during the grace period = %d, %v", purged, err)
+	}
+	if purged, err := s.PurgeDeletedAccounts(-time.Minute); err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedAccounts = %d, %v", purged, err)
+	}
+	if _, err := s.Store.GetUserById(s.Ctx, ada); errStatus(err) != http.StatusNotFound {
+		t.Errorf("the deleted user gave %v, want not found", err)
+	}
+
+	// The room keeps an owner and the remote goes to them
+	members, _ := s.Store.GetChatroomMembers(s.Ctx, chatroomId)
+	if len(members) != 2 || members[0].Role != lib.ChatroomOwner {
+		t.Fatalf("members after the deletion = %+v", members)
+	}
+	heir := members[0].UserId
+	if remote, _ := s.Store.GetRemoteByChatroomId(s.Ctx, chatroomId); remote.UserId != heir {
+		t.Errorf("remote is held by %q, want %q", remote.UserId, heir)
+	}
+	if subjects := publisher.subjects(heir); !slices.Contains(subjects, "notification") {
+		t.Errorf("the new remote holder got %v, want a notification", subjects)
+	}
+	if subjects := publisher.subjects(bob); !slices.Contains(subjects, "user.deleted") {
+		t.Errorf("a friend got %v, want a user.deleted event", subjects)
+	}
+
+	history, _ := s.GetChatroomHistory(heir, chatroomId, 0, false)
+	if len(history) != 1 || history[0].Content != "bye" || history[0].SenderName != lib.DeletedUserName {
+		t.Errorf("history after the deletion = %+v", history)
+	}
+}
+
+func TestDeleteAccountCancelledByLogin(t *testing.T) {
+	s := newTestService(t)
+	eve := newTestUser(t, s, "eve")
+
+	if _, err := s.ScheduleAccountDeletion(eve, "eve"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StartSession(eve, "test", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if purged, err := s.PurgeDeletedAccounts(-time.Minute); err != nil || purged != 0 {
+		t.Errorf("PurgeDeletedAccounts after logging in = %d, %v", purged, err)
+	}
+	if _, err := s.Store.GetUserById(s.Ctx, eve); err != nil {
+		t.Errorf("the account is gone after logging in: %v", err)
+	}
+}
+
+func TestExportUserData(t *testing.T) {
+	s := newTestService(t)
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blob = blobs
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+
+	sent, _ := s.SendFriendRequest(ada, bob)
+	if _, err := s.HandleFriendRequest(bob, sent.RequestId, FriendAccepted); err != nil {
+		t.Fatal(err)
+	}
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room", Participants: []string{bob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := s.StoreChatMessage(ada, chatroomId, dto.ChatMessagePayload{Content: "message " + strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.StoreChatMessage(bob, chatroomId, dto.ChatMessagePayload{Content: "not ada's"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UploadAvatar(ada, bytes.NewReader(newTestPNG(t, 64, 64))); err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := s.ExportUserData(ada)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Buffer{}
+	if err := export.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+
+	for _, name := range []string{"profile.json", "identities.json", "friends.json", "blocked.json", "chatrooms.json", "attachments.json", "messages.json", "avatar.jpg"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("the archive has no %s", name)
+		}
+	}
+
+	profile := dto.UserResponse{}
+	if err := json.Unmarshal(files["profile.json"], &profile); err != nil || profile.UserId != ada {
+		t.Errorf("profile.json = %s, %v", files["profile.json"], err)
+	}
+	friends := []store.UserFriend{}
+	if err := json.Unmarshal(files["friends.json"], &friends); err != nil || len(friends) != 1 || friends[0].UserId != bob {
+		t.Errorf("friends.json = %s, %v", files["friends.json"], err)
+	}
+	messages := []lib.Message{}
+	if err := json.Unmarshal(files["messages.json"], &messages); err != nil {
+		t.Fatalf("messages.json = %s, %v", files["messages.json"], err)
+	}
+	if len(messages) != 3 || messages[0].Content != "message 0" || messages[2].Content != "message 2" {
+		t.Errorf("messages.json has %+v", messages)
+	}
+}
+
+func TestDeleteAccountWithoutUsername(t *testing.T) {
+	s := newTestService(t)
+	userId, err := s.Store.CreateUser(s.Ctx, &dto.SignupUserRequest{
+		Name:     "Grace",
+		Email:    "grace@example.com",
+		Password: "correct horse battery",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty username doesn't make an empty confirmation enough
+	if _, err := s.ScheduleAccountDeletion(*userId, ""); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("an empty confirmation gave %v, want a bad request", err)
+	}
+	if _, err := s.ScheduleAccountDeletion(*userId, "Grace@Example.com"); err != nil {
+		t.Errorf("confirming with the email gave %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"sideDesert/shiba/internal/server/lib"
+)
+
+const (
+	thumbnailSize = 320
+	// Images declaring more pixels than this get no thumbnail, decoding them
+	// would allocate far more memory than the upload is worth
+	thumbnailMaxPixels = 40_000_000
+)
+
+var defaultAllowedAttachmentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"video/mp4",
+	"video/webm",
+	"audio/mpeg",
+	"application/pdf",
+	"text/plain; charset=utf-8",
+}
+
+// Types we know how to decode for thumbnails
+var thumbnailTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+type AttachmentLimits struct {
+	MaxBytes     int64
+	AllowedTypes []string
+}
+
+func attachmentLimitsFromEnv() AttachmentLimits {
+	limits := AttachmentLimits{
+		MaxBytes:     10 << 20,
+		AllowedTypes: defaultAllowedAttachmentTypes,
+	}
+
+	if v := os.Getenv("ATTACHMENT_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Println("Invalid ATTACHMENT_MAX_BYTES, using default:", err)
+		} else {
+			limits.MaxBytes = n
+		}
+	}
+
+	if v := os.Getenv("ATTACHMENT_ALLOWED_TYPES"); v != "" {
+		limits.AllowedTypes = strings.Split(v, ",")
+	}
+
+	return limits
+}
+
+func (s *Service) AttachmentLimits() AttachmentLimits {
+	return s.attachmentLimits
+}
+
+func (s *Service) checkChatroomMember(userId string, chatroomId string) error {
+	isMember, err := s.Store.IsUserInChatroom(s.Ctx, userId, chatroomId)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return lib.Forbidden("User is not a member of chatroom")
+	}
+	return nil
+}
+
+// thumbnail scales an uploaded image down to thumbnailSize. The size it
+// declares is checked before decoding, a small file can claim a huge image.
+func thumbnail(data []byte) ([]byte, int, int, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if config.Width*config.Height > thumbnailMaxPixels {
+		return nil, 0, 0, fmt.Errorf("image is %dx%d, larger than %d pixels", config.Width, config.Height, thumbnailMaxPixels)
+	}
+	return lib.Thumbnail(bytes.NewReader(data), thumbnailSize)
+}
+
+// UploadAttachment validates and stores a file for a chatroom. The returned
+// attachment is not linked to a message until a chat message referencing its
+// id is stored.
+func (s *Service) UploadAttachment(userId string, chatroomId string, fileName string, r io.Reader) (*lib.Attachment, error) {
+	if err := s.checkChatroomMember(userId, chatroomId); err != nil {
+		log.Println("Error in UploadAttachment[checkChatroomMember]:", err)
+		return nil, err
+	}
+
+	limits := s.attachmentLimits
+
+	// Read one byte past the limit so that oversized files can be detected
+	data, err := io.ReadAll(io.LimitReader(r, limits.MaxBytes+1))
+	if err != nil {
+		log.Println("Error in UploadAttachment[ReadAll]:", err)
+		return nil, err
+	}
+	if int64(len(data)) > limits.MaxBytes {
+		return nil, lib.NewApiError(http.StatusRequestEntityTooLarge, lib.CodePayloadTooLarge, fmt.Sprintf("File is larger than %d bytes", limits.MaxBytes))
+	}
+	if len(data) == 0 {
+		return nil, lib.BadRequest("File is empty")
+	}
+
+	// Never trust the client supplied content type
+	contentType := http.DetectContentType(data)
+	if !lib.Contains(limits.AllowedTypes, contentType) {
+		return nil, lib.NewApiError(http.StatusUnsupportedMediaType, lib.CodeUnsupportedType, fmt.Sprintf("File type %s is not allowed", contentType))
+	}
+
+	id, err := lib.GenerateSecureRandomID(16)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := &lib.Attachment{
+		ChatroomId:  chatroomId,
+		Uploader:    userId,
+		FileName:    path.Base(fileName),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  "attachments/" + chatroomId + "/" + id,
+	}
+
+	err = s.Blob.Put(s.Ctx, attachment.StorageKey, bytes.NewReader(data), attachment.Size, contentType)
+	if err != nil {
+		log.Println("Error in UploadAttachment[Blob.Put]:", err)
+		return nil, err
+	}
+
+	if lib.Contains(thumbnailTypes, contentType) {
+		thumb, w, h, err := thumbnail(data)
+		if err != nil {
+			// A broken image is still a valid attachment, just without preview
+			log.Println("Error in UploadAttachment[Thumbnail]:", err)
+		} else {
+			thumbKey := attachment.StorageKey + "-thumb.jpg"
+			err = s.Blob.Put(s.Ctx, thumbKey, bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg")
+			if err != nil {
+				log.Println("Error in UploadAttachment[Blob.Put(thumbnail)]:", err)
+			} else {
+				attachment.ThumbnailKey.String, attachment.ThumbnailKey.Valid = thumbKey, true
+				attachment.Width.Int32, attachment.Width.Valid = int32(w), true
+				attachment.Height.Int32, attachment.Height.Valid = int32(h), true
+			}
+		}
+	}
+
+	if _, err := s.Store.CreateAttachment(s.Ctx, attachment); err != nil {
+		log.Println("Error in UploadAttachment[CreateAttachment]:", err)
+		s.Blob.Delete(s.Ctx, attachment.StorageKey)
+		if attachment.ThumbnailKey.Valid {
+			s.Blob.Delete(s.Ctx, attachment.ThumbnailKey.String)
+		}
+		return nil, err
+	}
+
+	withAttachmentUrls(attachment)
+	return attachment, nil
+}
+
+// OpenAttachment returns the file (or its thumbnail) if userId is a member
+// of the chatroom the attachment was uploaded to.
+func (s *Service) OpenAttachment(userId string, attachmentId string, thumbnail bool) (io.ReadCloser, *lib.Attachment, error) {
+	attachment, err := s.Store.GetAttachmentById(s.Ctx, attachmentId)
+	if err != nil {
+		log.Println("Error in OpenAttachment[GetAttachmentById]:", err)
+		if lib.IsNoRows(err) {
+			return nil, nil, lib.NotFound("Attachment not found")
+		}
+		return nil, nil, err
+	}
+
+	if err := s.checkChatroomMember(userId, attachment.ChatroomId); err != nil {
+		log.Println("Error in OpenAttachment[checkChatroomMember]:", err)
+		return nil, nil, err
+	}
+
+	key := attachment.StorageKey
+	if thumbnail {
+		if !attachment.ThumbnailKey.Valid {
+			return nil, nil, lib.NotFound("Attachment has no thumbnail")
+		}
+		key = attachment.ThumbnailKey.String
+	}
+
+	file, err := s.Blob.Get(s.Ctx, key)
+	if err != nil {
+		log.Println("Error in OpenAttachment[Blob.Get]:", err)
+		return nil, nil, err
+	}
+
+	return file, attachment, nil
+}
+
+func withAttachmentUrls(a *lib.Attachment) {
+	a.Url = lib.ApiPrefix + "/attachments/" + a.Id
+	if a.ThumbnailKey.Valid {
+		a.ThumbnailUrl = a.Url + "?thumb=1"
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sideDesert/shiba/internal/server/blob"
+	"sideDesert/shiba/internal/server/dto"
+)
+
+// newBombPNG is a small PNG whose header claims w x h pixels
+func newBombPNG(t *testing.T, w uint32, h uint32) []byte {
+	t.Helper()
+	data := newTestPNG(t, 1, 1)
+	// The IHDR chunk follows the 8 byte signature: length, type, data, crc
+	ihdr := data[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:4], w)
+	binary.BigEndian.PutUint32(ihdr[4:8], h)
+	binary.BigEndian.PutUint32(data[8+8+13:], crc32.ChecksumIEEE(data[8+4:8+8+13]))
+	return data
+}
+
+func TestUploadAttachment(t *testing.T) {
+	s := newTestService(t)
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blob = blobs
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachment, err := s.UploadAttachment(ada, chatroomId, "../photo.png", bytes.NewReader(newTestPNG(t, 800, 400)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName != "photo.png" || attachment.ContentType != "image/png" {
+		t.Errorf("UploadAttachment = %+v", attachment)
+	}
+	if attachment.Width.Int32 != thumbnailSize || attachment.Height.Int32 != thumbnailSize/2 {
+		t.Errorf("thumbnail is %dx%d", attachment.Width.Int32, attachment.Height.Int32)
+	}
+	// Both match the GET /attachments/{id} route
+	if want := "/api/v1/attachments/" + attachment.Id; attachment.Url != want || attachment.ThumbnailUrl != want+"?thumb=1" {
+		t.Errorf("urls = %q and %q", attachment.Url, attachment.ThumbnailUrl)
+	}
+	file, _, err := s.OpenAttachment(ada, attachment.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(file)
+	file.Close()
+	if err != nil || img.Bounds().Dx() != thumbnailSize {
+		t.Errorf("the thumbnail decodes to %v, %v", img, err)
+	}
+
+	// A few bytes claiming 50000x50000 pixels are stored without decoding
+	bomb := newBombPNG(t, 50_000, 50_000)
+	attachment, err = s.UploadAttachment(ada, chatroomId, "bomb.png", bytes.NewReader(bomb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.ThumbnailKey.Valid || attachment.Width.Valid {
+		t.Errorf("the bomb got a thumbnail: %+v", attachment)
+	}
+	file, _, err = s.OpenAttachment(ada, attachment.Id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := io.ReadAll(file)
+	file.Close()
+	if !bytes.Equal(stored, bomb) {
+		t.Error("the stored file differs from the upload")
+	}
+
+	if _, err := s.UploadAttachment(bob, chatroomId, "a.png", bytes.NewReader(newTestPNG(t, 4, 4))); errStatus(err) != http.StatusForbidden {
+		t.Errorf("uploading to a foreign room gave %v, want forbidden", err)
+	}
+	if _, err := s.UploadAttachment(ada, chatroomId, "a.html", strings.NewReader("<html><script>alert(1)</script></html>")); errStatus(err) != http.StatusUnsupportedMediaType {
+		t.Errorf("an html page gave %v, want unsupported media type", err)
+	}
+}
+
+func TestThumbnailRefusesLargeImages(t *testing.T) {
+	if _, _, _, err := thumbnail(newBombPNG(t, 50_000, 50_000)); err == nil {
+		t.Error("a 50000x50000 image was decoded")
+	}
+	if _, w, h, err := thumbnail(newTestPNG(t, 40, 30)); err != nil || w != 40 || h != 30 {
+		t.Errorf("thumbnail of a small image = %dx%d, %v", w, h, err)
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/unfurl"
+)
+
+// How long Check waits for the addresses of a host
+const browserUrlLookupTimeout = 5 * time.Second
+
+// BrowserUrlPolicy decides which urls can be opened in the shared browser.
+// Patterns are hostnames, "*.example.com" also matches example.com itself.
+// An empty allow list allows every host that is not denied.
+//
+// Hosts on loopback, private and link-local addresses are refused unless
+// AllowPrivate is set: the browser runs next to its own DevTools port, the
+// cloud metadata service and whatever else the worker can reach, and the
+// whole room watches the page. The check here only sees the url typed in,
+// the egress proxy of the worker refuses the private addresses a page
+// reaches through redirects or DNS rebinding.
+type BrowserUrlPolicy struct {
+	Allow        []string
+	Deny         []string
+	AllowPrivate bool
+
+	// Resolves hostnames, net.DefaultResolver when nil
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func browserUrlPolicyFromEnv() BrowserUrlPolicy {
+	return BrowserUrlPolicy{
+		Allow:        splitList(os.Getenv("BROWSER_URL_ALLOW")),
+		Deny:         splitList(os.Getenv("BROWSER_URL_DENY")),
+		AllowPrivate: os.Getenv("BROWSER_URL_ALLOW_PRIVATE") == "true",
+	}
+}
+
+func splitList(v string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func matchHost(pattern string, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// Check returns the normalized url if it may be opened.
+func (p BrowserUrlPolicy) Check(rawUrl string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return "", lib.BadRequest("Invalid url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", lib.BadRequest("Only http and https urls can be opened")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return "", lib.BadRequest("Invalid url")
+	}
+
+	for _, pattern := range p.Deny {
+		if matchHost(pattern, host) {
+			return "", lib.Forbidden(fmt.Sprintf("Opening %s is not allowed", host))
+		}
+	}
+
+	allowed := len(p.Allow) == 0
+	for _, pattern := range p.Allow {
+		allowed = allowed || matchHost(pattern, host)
+	}
+	if !allowed {
+		return "", lib.Forbidden(fmt.Sprintf("Opening %s is not allowed", host))
+	}
+
+	if !p.AllowPrivate {
+		if err := p.checkAddresses(host); err != nil {
+			return "", err
+		}
+	}
+	return u.String(), nil
+}
+
+// checkAddresses refuses hosts that are or resolve to a private address. A
+// host that doesn't resolve is refused too, browsers read forms like
+// "127.1" or "2130706433" as addresses that net.ParseIP doesn't.
+func (p BrowserUrlPolicy) checkAddresses(host string) error {
+	blocked := lib.Forbidden(fmt.Sprintf("Opening %s is not allowed", host))
+	if ip := net.ParseIP(host); ip != nil {
+		if unfurl.IsPrivateIP(ip) {
+			return blocked
+		}
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return blocked
+	}
+
+	lookup := p.lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), browserUrlLookupTimeout)
+	defer cancel()
+	addrs, err := lookup(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		log.Println("Error in BrowserUrlPolicy.Check[lookup]:", host, err)
+		return lib.BadRequest(fmt.Sprintf("Could not resolve %s", host))
+	}
+	for _, addr := range addrs {
+		if unfurl.IsPrivateIP(addr.IP) {
+			return blocked
+		}
+	}
+	return nil
+}
+
+// CheckBrowserUrl makes sure userId holds the remote of the chatroom and that
+// the url passes the deployment's allow/deny lists.
+func (s *Service) CheckBrowserUrl(userId string, chatroomId string, rawUrl string) (string, error) {
+	if !s.CheckUserIsRemoteForChatroom(userId, chatroomId) {
+		return "", lib.Forbidden("User is not remote for chatroom")
+	}
+	return s.browserUrlPolicy.Check(rawUrl)
+}
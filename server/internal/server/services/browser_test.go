@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// testLookup resolves the hosts of the table tests without DNS
+func testLookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	hosts := map[string]string{
+		"example.com":       "93.184.216.34",
+		"www.example.com":   "93.184.216.34",
+		"other.org":         "203.0.113.10",
+		"internal.corp":     "10.0.0.7",
+		"metadata.internal": "169.254.169.254",
+		"rebind.example":    "127.0.0.1",
+	}
+	ip, ok := hosts[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+func TestBrowserUrlPolicy(t *testing.T) {
+	open := BrowserUrlPolicy{lookup: testLookup}
+	allowList := BrowserUrlPolicy{Allow: []string{"*.example.com", "127.0.0.1"}, lookup: testLookup}
+	denyList := BrowserUrlPolicy{Deny: []string{"other.org"}, lookup: testLookup}
+	private := BrowserUrlPolicy{AllowPrivate: true, lookup: testLookup}
+
+	tests := []struct {
+		name   string
+		policy BrowserUrlPolicy
+		url    string
+		want   string
+		status int
+	}{
+		{"public host", open, "https://example.com/a b", "https://example.com/a%20b", http.StatusOK},
+		{"public ip", open, "http://93.184.216.34/", "http://93.184.216.34/", http.StatusOK},
+		{"scheme", open, "file:///etc/passwd", "", http.StatusBadRequest},
+		{"javascript", open, "javascript:alert(1)", "", http.StatusBadRequest},
+		{"no host", open, "https:///path", "", http.StatusBadRequest},
+		{"devtools port", open, "http://127.0.0.1:9222/json", "", http.StatusForbidden},
+		{"loopback v6", open, "http://[::1]:9222/", "", http.StatusForbidden},
+		{"metadata", open, "http://169.254.169.254/latest/meta-data/", "", http.StatusForbidden},
+		{"private network", open, "http://192.168.1.1/", "", http.StatusForbidden},
+		{"unspecified", open, "http://0.0.0.0:9222/", "", http.StatusForbidden},
+		{"mapped v4", open, "http://[::ffff:127.0.0.1]/", "", http.StatusForbidden},
+		{"localhost", open, "http://localhost:9000/", "", http.StatusForbidden},
+		{"localhost subdomain", open, "http://api.localhost/", "", http.StatusForbidden},
+		{"resolves private", open, "http://internal.corp/", "", http.StatusForbidden},
+		{"resolves link-local", open, "http://metadata.internal/", "", http.StatusForbidden},
+		{"resolves loopback", open, "http://rebind.example/", "", http.StatusForbidden},
+		{"short ip form", open, "http://127.1/", "", http.StatusBadRequest},
+		{"decimal ip form", open, "http://2130706433/", "", http.StatusBadRequest},
+		{"unresolved", open, "https://nowhere.invalid/", "", http.StatusBadRequest},
+		{"allow list", allowList, "https://www.example.com/", "https://www.example.com/", http.StatusOK},
+		{"allow list apex", allowList, "https://example.com/", "https://example.com/", http.StatusOK},
+		{"not allow listed", allowList, "https://other.org/", "", http.StatusForbidden},
+		{"allow list keeps private out", allowList, "http://127.0.0.1/", "", http.StatusForbidden},
+		{"deny list", denyList, "https://other.org/", "", http.StatusForbidden},
+		{"not denied", denyList, "https://example.com/", "https://example.com/", http.StatusOK},
+		{"private allowed", private, "http://127.0.0.1:3000/", "http://127.0.0.1:3000/", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Check(tt.url)
+			if errStatus(err) != tt.status || got != tt.want {
+				t.Errorf("Check(%q) = %q, %v, want %q with status %d", tt.url, got, err, tt.want, tt.status)
+			}
+		})
+	}
+}
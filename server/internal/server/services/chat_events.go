@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// Every room event is published to the stream as "chatrooms.<chatroomId>"
+	ChatroomStream = "CHATROOMS"
+
+	// How long reconnecting clients can resume, older events are only in the
+	// history
+	chatroomStreamMaxAge = 7 * 24 * time.Hour
+	// A chat message published again within the window with the same
+	// Nats-Msg-Id is dropped by the stream
+	chatroomStreamDuplicates = 2 * time.Minute
+
+	chatPersisterDurable    = "chat-persister"
+	chatPersisterMaxDeliver = 10
+)
+
+func ChatroomSubject(chatroomId string) string {
+	return "chatrooms." + chatroomId
+}
+
+// ChatMessageId is the Nats-Msg-Id of a chat message, a client resending a
+// message after a reconnect is deduplicated by the stream
+func ChatMessageId(senderId string, clientId string) string {
+	return senderId + ":" + clientId
+}
+
+// SetupChatroomStream creates the chatroom stream or updates its config
+func SetupChatroomStream(ctx context.Context, js jetstream.JetStream) (jetstream.Stream, error) {
+	return js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       ChatroomStream,
+		Subjects:   []string{ChatroomSubject("*")},
+		Retention:  jetstream.LimitsPolicy,
+		Storage:    jetstream.FileStorage,
+		MaxAge:     chatroomStreamMaxAge,
+		Duplicates: chatroomStreamDuplicates,
+	})
+}
+
+type jetStreamPublisher struct {
+	ctx context.Context
+	js  jetstream.JetStream
+}
+
+// NewJetStreamPublisher publishes to a stream and waits for the ack
+func NewJetStreamPublisher(ctx context.Context, js jetstream.JetStream) Publisher {
+	return &jetStreamPublisher{ctx: ctx, js: js}
+}
+
+func (p *jetStreamPublisher) Publish(subject string, data []byte) error {
+	_, err := p.js.Publish(p.ctx, subject, data)
+	return err
+}
+
+// SetRoomPublisher sets where events for everyone in a chatroom go, the
+// chatroom stream outside of tests
+func (s *Service) SetRoomPublisher(p Publisher) {
+	s.roomPublisher = p
+}
+
+func (s *Service) publishToRoom(chatroomId string, data []byte) {
+	if s.roomPublisher == nil {
+		return
+	}
+	if err := s.roomPublisher.Publish(ChatroomSubject(chatroomId), data); err != nil {
+		log.Println("Error in publishToRoom[Publish]:", err)
+	}
+}
+
+// RunChatPersister stores the chat messages of the chatroom stream with a
+// durable consumer until ctx is done. Messages published while no server
+// was running are stored once one starts.
+func (s *Service) RunChatPersister(ctx context.Context, js jetstream.JetStream) error {
+	consumer, err := js.CreateOrUpdateConsumer(ctx, ChatroomStream, jetstream.ConsumerConfig{
+		Durable:       chatPersisterDurable,
+		FilterSubject: ChatroomSubject("*"),
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxDeliver:    chatPersisterMaxDeliver,
+	})
+	if err != nil {
+		log.Println("Error in RunChatPersister[CreateOrUpdateConsumer]:", err)
+		return err
+	}
+
+	cc, err := consumer.Consume(func(msg jetstream.Msg) {
+		err := s.persistChatEvent(msg.Data())
+		if err == nil {
+			msg.Ack()
+			return
+		}
+
+		// Retrying won't fix a malformed message or a deleted chatroom
+		if apiErr := lib.AsApiError(err); apiErr.Status < http.StatusInternalServerError {
+			log.Println("Error in RunChatPersister[persistChatEvent], dropping message:", err)
+			msg.Term()
+			return
+		}
+
+		log.Println("Error in RunChatPersister[persistChatEvent]:", err)
+		delay := time.Second
+		if meta, err := msg.Metadata(); err == nil {
+			delay *= time.Duration(meta.NumDelivered)
+		}
+		msg.NakWithDelay(delay)
+	})
+	if err != nil {
+		log.Println("Error in RunChatPersister[Consume]:", err)
+		return err
+	}
+
+	<-ctx.Done()
+	cc.Stop()
+	return nil
+}
+
+// persistChatEvent stores a "chat.<chatroomId>" event of the chatroom stream.
+// Other room events are left alone. A redelivered message is stored once,
+// it is found by the id the sender's client gave it and only its mentions,
+// which may have failed the earlier delivery, are stored again.
+func (s *Service) persistChatEvent(data []byte) error {
+	event := dto.Message[dto.ChatMessagePayload]{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return lib.BadRequest("Malformed chat event").Wrap(err)
+	}
+
+	// Previews are "chat.preview" and are stored before they are published
+	subject := strings.Split(event.Subject, ".")
+	if len(subject) != 2 || subject[0] != "chat" || subject[1] == "preview" {
+		return nil
+	}
+	chatroomId := subject[1]
+
+	if event.Payload.Id != "" {
+		messageId, err := s.Store.GetMessageIdByClientId(s.Ctx, event.Sender, event.Payload.Id)
+		if err == nil {
+			return s.storeMentions(event.Sender, chatroomId, messageId, event.Payload.Content)
+		}
+		if !lib.IsNoRows(err) {
+			return err
+		}
+	}
+
+	messageId, err := s.StoreChatMessage(event.Sender, chatroomId, event.Payload)
+	if lib.IsUniqueViolation(err, "messages_sender_client_id_idx") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	go s.publishLinkPreviews(chatroomId, messageId, event.Payload)
+	return nil
+}
+
+// publishLinkPreviews unfurls the links of a stored message and pushes the
+// result to the room as a "chat.preview" message.
+func (s *Service) publishLinkPreviews(chatroomId string, messageId string, payload dto.ChatMessagePayload) {
+	previews, err := s.UnfurlMessage(messageId, payload.Content)
+	if err != nil {
+		log.Println("❌ Error in publishLinkPreviews[UnfurlMessage]:", err)
+		return
+	}
+	if len(previews) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(dto.Message[dto.ChatPreviewPayload]{
+		Sender:  "server",
+		Subject: "chat.preview",
+		Payload: dto.ChatPreviewPayload{
+			MessageId:       messageId,
+			ClientMessageId: payload.Id,
+			ChatroomId:      chatroomId,
+			Previews:        previews,
+		},
+	})
+	if err != nil {
+		log.Println("❌ Error in publishLinkPreviews[Marshal]:", err)
+		return
+	}
+
+	s.publishToRoom(chatroomId, data)
+}
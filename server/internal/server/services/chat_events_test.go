@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/store"
+)
+
+func TestPersistChatEvent(t *testing.T) {
+	s := newTestService(t)
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room", Participants: []string{bob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(subject string, clientId string) []byte {
+		data, err := json.Marshal(dto.Message[dto.ChatMessagePayload]{
+			Sender:  ada,
+			Subject: subject,
+			Payload: dto.ChatMessagePayload{Id: clientId, Content: "hello"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	// A redelivered message is stored once
+	for i := 0; i < 2; i++ {
+		if err := s.persistChatEvent(event("chat."+chatroomId, "c1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.persistChatEvent(event("chat."+chatroomId, "c2")); err != nil {
+		t.Fatal(err)
+	}
+	// Other room events are only delivered
+	for _, subject := range []string{"chat.preview", "stream.navigated." + chatroomId} {
+		if err := s.persistChatEvent(event(subject, "c3")); err != nil {
+			t.Errorf("persisting %s gave %v", subject, err)
+		}
+	}
+
+	history, err := s.GetChatroomHistory(ada, chatroomId, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].ClientId != "c2" || history[1].ClientId != "c1" || history[1].Sender != ada {
+		t.Errorf("history = %+v", history)
+	}
+
+	if err := s.persistChatEvent([]byte("{")); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("a malformed event gave %v, want a bad request", err)
+	}
+	if err := s.persistChatEvent(event("chat.00000000-0000-4000-8000-000000000000", "c4")); errStatus(err) != http.StatusNotFound {
+		t.Errorf("a message to a missing chatroom gave %v, want not found", err)
+	}
+}
+
+// failingMentionsStore fails StoreMessageMentions while fail is set
+type failingMentionsStore struct {
+	store.Store
+	fail bool
+}
+
+func (f *failingMentionsStore) StoreMessageMentions(ctx context.Context, messageId string, userIds []string) ([]string, error) {
+	if f.fail {
+		return nil, errors.New("connection reset")
+	}
+	return f.Store.StoreMessageMentions(ctx, messageId, userIds)
+}
+
+func TestPersistChatEventFinishesMentions(t *testing.T) {
+	s := newTestService(t)
+	failing := &failingMentionsStore{Store: s.Store, fail: true}
+	s.Store = failing
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room", Participants: []string{bob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(dto.Message[dto.ChatMessagePayload]{
+		Sender:  ada,
+		Subject: "chat." + chatroomId,
+		Payload: dto.ChatMessagePayload{Id: "c1", Content: "hi @bob"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The message is stored but the mentions fail, the persister retries
+	if err := s.persistChatEvent(data); errStatus(err) < http.StatusInternalServerError {
+		t.Fatalf("failing mentions gave %v, want a retry", err)
+	}
+
+	failing.fail = false
+	for i := 0; i < 2; i++ {
+		if err := s.persistChatEvent(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := s.GetChatroomHistory(bob, chatroomId, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || !slices.Equal(history[0].Mentions, []string{bob}) {
+		t.Errorf("history = %+v, want the message mentioning bob once", history)
+	}
+	mentions := 0
+	notifications, _ := s.Store.GetNotificationsByUserId(s.Ctx, bob, false, 10, 0)
+	for _, n := range notifications {
+		if n.Type == lib.NotificationMention {
+			mentions++
+		}
+	}
+	if mentions != 1 {
+		t.Errorf("bob got %d mention notifications, want 1", mentions)
+	}
+}
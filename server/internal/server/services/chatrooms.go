@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+func chatroomAvatarKey(chatroomId string, avatarId string) string {
+	return "chatrooms/" + chatroomId + "/avatars/" + avatarId + ".jpg"
+}
+
+func chatroomAvatarUrl(chatroomId string, avatarId string) string {
+	return lib.ApiPrefix + "/chatrooms/" + chatroomId + "/avatar/" + avatarId
+}
+
+// chatroomRole returns the role of userId in the chatroom, non members get a
+// forbidden error rather than a not found so room ids can't be probed
+func (s *Service) chatroomRole(userId string, chatroomId string) (string, error) {
+	role, err := s.Store.GetChatroomRole(s.Ctx, userId, chatroomId)
+	if lib.IsNoRows(err) {
+		return "", lib.Forbidden("User is not a member of chatroom")
+	}
+	return role, err
+}
+
+// requireChatroomOwner checks that userId may change the chatroom. Both
+// members own a direct message, neither changes it for the other.
+func (s *Service) requireChatroomOwner(userId string, chatroomId string) error {
+	role, err := s.chatroomRole(userId, chatroomId)
+	if err != nil {
+		return err
+	}
+	if role != lib.ChatroomOwner {
+		return lib.Forbidden("Only owners can change the chatroom")
+	}
+
+	chatroom, err := s.Store.GetChatRoomById(s.Ctx, chatroomId)
+	if err != nil {
+		log.Println("Error in requireChatroomOwner[GetChatRoomById]:", err)
+		return err
+	}
+	if chatroom.DirectMessage {
+		return lib.Forbidden("Direct messages can't be changed")
+	}
+	return nil
+}
+
+// GetChatroom returns a chatroom userId is a member of along with their role
+func (s *Service) GetChatroom(userId string, chatroomId string) (*dto.ChatroomResponse, error) {
+	role, err := s.chatroomRole(userId, chatroomId)
+	if err != nil {
+		return nil, err
+	}
+
+	chatroom, err := s.Store.GetChatRoomById(s.Ctx, chatroomId)
+	if err != nil {
+		log.Println("Error in GetChatroom:", err)
+		return nil, err
+	}
+	return &dto.ChatroomResponse{Chatroom: *chatroom, Role: role}, nil
+}
+
+// UpdateChatroom changes the settings of a chatroom owned by userId and sends
+// the result to every member as a chatroom.updated event
+func (s *Service) UpdateChatroom(userId string, chatroomId string, req dto.UpdateChatroomRequest) (*dto.ChatroomResponse, error) {
+	if err := s.requireChatroomOwner(userId, chatroomId); err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return nil, lib.BadRequest("Name can't be empty")
+		}
+		req.Name = &name
+	}
+	if req.Description != nil {
+		description := strings.TrimSpace(*req.Description)
+		req.Description = &description
+	}
+	if req.Topic != nil {
+		topic := strings.TrimSpace(*req.Topic)
+		req.Topic = &topic
+	}
+	// An empty start url falls back to the browser's home page
+	if req.DefaultStartUrl != nil {
+		startUrl := strings.TrimSpace(*req.DefaultStartUrl)
+		if startUrl != "" {
+			checked, err := s.browserUrlPolicy.Check(startUrl)
+			if err != nil {
+				return nil, err
+			}
+			startUrl = checked
+		}
+		req.DefaultStartUrl = &startUrl
+	}
+
+	if err := s.Store.UpdateChatroom(s.Ctx, chatroomId, req); err != nil {
+		log.Println("Error in UpdateChatroom:", err)
+		return nil, err
+	}
+
+	s.publishChatroom(chatroomId)
+	return s.GetChatroom(userId, chatroomId)
+}
+
+// UploadChatroomAvatar makes an uploaded image the picture of a chatroom owned
+// by userId. The previous avatar is removed.
+func (s *Service) UploadChatroomAvatar(userId string, chatroomId string, r io.Reader) (*dto.ChatroomResponse, error) {
+	if err := s.requireChatroomOwner(userId, chatroomId); err != nil {
+		return nil, err
+	}
+
+	avatar, err := readAvatar(r)
+	if err != nil {
+		return nil, err
+	}
+
+	chatroom, err := s.Store.GetChatRoomById(s.Ctx, chatroomId)
+	if err != nil {
+		return nil, err
+	}
+
+	avatarId, err := lib.GenerateSecureRandomID(16)
+	if err != nil {
+		return nil, err
+	}
+
+	key := chatroomAvatarKey(chatroomId, avatarId)
+	if err := s.Blob.Put(s.Ctx, key, bytes.NewReader(avatar), int64(len(avatar)), "image/jpeg"); err != nil {
+		log.Println("Error in UploadChatroomAvatar[Blob.Put]:", err)
+		return nil, err
+	}
+
+	if err := s.Store.UpdateChatroomPicture(s.Ctx, chatroomId, chatroomAvatarUrl(chatroomId, avatarId)); err != nil {
+		log.Println("Error in UploadChatroomAvatar[UpdateChatroomPicture]:", err)
+		s.Blob.Delete(s.Ctx, key)
+		return nil, err
+	}
+
+	s.deleteChatroomAvatar(chatroom)
+	s.publishChatroom(chatroomId)
+	return s.GetChatroom(userId, chatroomId)
+}
+
+// RemoveChatroomAvatar clears the picture of a chatroom owned by userId
+func (s *Service) RemoveChatroomAvatar(userId string, chatroomId string) (*dto.ChatroomResponse, error) {
+	if err := s.requireChatroomOwner(userId, chatroomId); err != nil {
+		return nil, err
+	}
+
+	chatroom, err := s.Store.GetChatRoomById(s.Ctx, chatroomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Store.UpdateChatroomPicture(s.Ctx, chatroomId, ""); err != nil {
+		log.Println("Error in RemoveChatroomAvatar:", err)
+		return nil, err
+	}
+
+	s.deleteChatroomAvatar(chatroom)
+	s.publishChatroom(chatroomId)
+	return s.GetChatroom(userId, chatroomId)
+}
+
+// OpenChatroomAvatar returns the avatar of a chatroom to one of its members
+func (s *Service) OpenChatroomAvatar(userId string, chatroomId string, avatarId string) (io.ReadCloser, error) {
+	if _, err := s.chatroomRole(userId, chatroomId); err != nil {
+		return nil, err
+	}
+	if !avatarIdRe.MatchString(avatarId) {
+		return nil, lib.NotFound("Avatar not found")
+	}
+
+	file, err := s.Blob.Get(s.Ctx, chatroomAvatarKey(chatroomId, avatarId))
+	if err != nil {
+		log.Println("Error in OpenChatroomAvatar[Blob.Get]:", err)
+		return nil, lib.NotFound("Avatar not found")
+	}
+	return file, nil
+}
+
+func (s *Service) deleteChatroomAvatar(chatroom *lib.Chatroom) {
+	prefix := chatroomAvatarUrl(chatroom.Id, "")
+	if !chatroom.ProfilePicture.Valid || !strings.HasPrefix(chatroom.ProfilePicture.String, prefix) {
+		return
+	}
+
+	avatarId := strings.TrimPrefix(chatroom.ProfilePicture.String, prefix)
+	if err := s.Blob.Delete(s.Ctx, chatroomAvatarKey(chatroom.Id, avatarId)); err != nil {
+		log.Println("Error in deleteChatroomAvatar[Blob.Delete]:", err)
+	}
+}
+
+// publishChatroom sends the settings of a chatroom to all of its members as a
+// chatroom.updated event
+func (s *Service) publishChatroom(chatroomId string) {
+	chatroom, err := s.Store.GetChatRoomById(s.Ctx, chatroomId)
+	if err != nil {
+		log.Println("Error in publishChatroom[GetChatRoomById]:", err)
+		return
+	}
+	members, err := s.Store.GetUsersByChatroomId(s.Ctx, chatroomId)
+	if err != nil {
+		log.Println("Error in publishChatroom[GetUsersByChatroomId]:", err)
+		return
+	}
+
+	for _, memberId := range members {
+		s.publishToUser(memberId, "chatroom.updated", chatroom)
+	}
+}
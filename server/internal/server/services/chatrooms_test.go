@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"sideDesert/shiba/internal/server/blob"
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+func TestUpdateChatroom(t *testing.T) {
+	s := newTestService(t)
+	publisher := &recordingPublisher{}
+	s.SetPublisher(publisher)
+	ada, bob, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "bob"), newTestUser(t, s, "eve")
+
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room", Participants: []string{bob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := s.GetChatroom(bob, chatroomId)
+	if err != nil || room.Role != lib.ChatroomMember || room.StreamQuality != "high" {
+		t.Fatalf("GetChatroom of a member = %+v, %v", room, err)
+	}
+	if remote, err := s.Store.GetRemoteByChatroomId(s.Ctx, chatroomId); err != nil || remote.UserId != ada {
+		t.Errorf("the remote of a new room = %+v, %v, want the creator", remote, err)
+	}
+	if _, err := s.GetChatroom(eve, chatroomId); errStatus(err) != http.StatusForbidden {
+		t.Errorf("GetChatroom of a stranger gave %v, want forbidden", err)
+	}
+
+	topic := "Movie night"
+	if _, err := s.UpdateChatroom(bob, chatroomId, dto.UpdateChatroomRequest{Topic: &topic}); errStatus(err) != http.StatusForbidden {
+		t.Errorf("a member changing the room gave %v, want forbidden", err)
+	}
+
+	startUrl, quality := "  https://example.com/a b ", "low"
+	room, err = s.UpdateChatroom(ada, chatroomId, dto.UpdateChatroomRequest{Topic: &topic, DefaultStartUrl: &startUrl, StreamQuality: &quality})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Role != lib.ChatroomOwner || room.Topic != topic || room.DefaultStartUrl != "https://example.com/a%20b" || room.StreamQuality != quality {
+		t.Errorf("UpdateChatroom = %+v", room)
+	}
+
+	for _, userId := range []string{ada, bob} {
+		if subjects := publisher.subjects(userId); !slices.Contains(subjects, "chatroom.updated") {
+			t.Errorf("%s got %v, want a chatroom.updated event", userId, subjects)
+		}
+	}
+	if subjects := publisher.subjects(eve); len(subjects) != 0 {
+		t.Errorf("a stranger got %v", subjects)
+	}
+
+	badUrl := "javascript:alert(1)"
+	if _, err := s.UpdateChatroom(ada, chatroomId, dto.UpdateChatroomRequest{DefaultStartUrl: &badUrl}); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("a javascript url gave %v, want a bad request", err)
+	}
+	blank := " "
+	if _, err := s.UpdateChatroom(ada, chatroomId, dto.UpdateChatroomRequest{Name: &blank}); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("a blank name gave %v, want a bad request", err)
+	}
+	room, err = s.UpdateChatroom(ada, chatroomId, dto.UpdateChatroomRequest{DefaultStartUrl: &blank})
+	if err != nil || room.DefaultStartUrl != "" {
+		t.Errorf("clearing the start url = %+v, %v", room, err)
+	}
+
+	// Both members own a direct message, neither can rename it
+	sent, _ := s.SendFriendRequest(ada, bob)
+	accepted, err := s.HandleFriendRequest(bob, sent.RequestId, FriendAccepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room, err := s.GetChatroom(bob, accepted.ChatroomId); err != nil || room.Role != lib.ChatroomOwner {
+		t.Fatalf("GetChatroom of a direct message = %+v, %v", room, err)
+	}
+	name := "ours"
+	if _, err := s.UpdateChatroom(bob, accepted.ChatroomId, dto.UpdateChatroomRequest{Name: &name}); errStatus(err) != http.StatusForbidden {
+		t.Errorf("renaming a direct message gave %v, want forbidden", err)
+	}
+}
+
+func TestUploadChatroomAvatar(t *testing.T) {
+	s := newTestService(t)
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blob = blobs
+	ada, bob, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "bob"), newTestUser(t, s, "eve")
+
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room", Participants: []string{ada, bob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.UploadChatroomAvatar(bob, chatroomId, bytes.NewReader(newTestPNG(t, 40, 40))); errStatus(err) != http.StatusForbidden {
+		t.Errorf("a member uploading an avatar gave %v, want forbidden", err)
+	}
+
+	room, err := s.UploadChatroomAvatar(ada, chatroomId, bytes.NewReader(newTestPNG(t, 300, 200)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	avatarId, ok := strings.CutPrefix(room.ProfilePicture.String, chatroomAvatarUrl(chatroomId, ""))
+	if !ok {
+		t.Fatalf("profile picture = %q, want a chatroom avatar url", room.ProfilePicture.String)
+	}
+
+	file, err := s.OpenChatroomAvatar(bob, chatroomId, avatarId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if _, err := s.OpenChatroomAvatar(eve, chatroomId, avatarId); errStatus(err) != http.StatusForbidden {
+		t.Errorf("a stranger opening the avatar gave %v, want forbidden", err)
+	}
+
+	room, err = s.RemoveChatroomAvatar(ada, chatroomId)
+	if err != nil || room.ProfilePicture.Valid {
+		t.Errorf("RemoveChatroomAvatar = %+v, %v", room, err)
+	}
+	if _, err := s.OpenChatroomAvatar(bob, chatroomId, avatarId); errStatus(err) != http.StatusNotFound {
+		t.Errorf("a removed avatar gave %v, want not found", err)
+	}
+}
+
+func TestChatroomMembersOnly(t *testing.T) {
+	s := newTestService(t)
+	publisher := &recordingPublisher{}
+	s.SetPublisher(publisher)
+	ada, bob, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "bob"), newTestUser(t, s, "eve")
+
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room", Participants: []string{bob}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StoreChatMessage(ada, chatroomId, dto.ChatMessagePayload{Content: "members only"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if history, err := s.GetChatroomHistory(bob, chatroomId, 0, false); err != nil || len(history) != 1 {
+		t.Errorf("history of a member = %+v, %v", history, err)
+	}
+	if _, err := s.GetChatroomHistory(eve, chatroomId, 0, false); errStatus(err) != http.StatusForbidden {
+		t.Errorf("history of a non member gave %v, want forbidden", err)
+	}
+	if _, err := s.GetChatroomHistory(eve, chatroomId, 0, true); errStatus(err) != http.StatusForbidden {
+		t.Errorf("mentions of a non member gave %v, want forbidden", err)
+	}
+
+	if remote, err := s.GetChatroomRemote(bob, chatroomId); err != nil || remote.UserId != ada {
+		t.Errorf("remote seen by a member = %+v, %v", remote, err)
+	}
+	if _, err := s.GetChatroomRemote(eve, chatroomId); errStatus(err) != http.StatusForbidden {
+		t.Errorf("remote seen by a non member gave %v, want forbidden", err)
+	}
+
+	// The remote stays in the room and nobody outside it hears of it
+	if err := s.ChangeChatroomRemote(ada, chatroomId, eve); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("handing the remote to a non member gave %v, want a bad request", err)
+	}
+	if err := s.ChangeChatroomRemote(eve, chatroomId, eve); errStatus(err) != http.StatusForbidden {
+		t.Errorf("a non member handing out the remote gave %v, want forbidden", err)
+	}
+	if remote, _ := s.Store.GetRemoteByChatroomId(s.Ctx, chatroomId); remote.UserId != ada {
+		t.Errorf("the remote went to %q", remote.UserId)
+	}
+	if subjects := publisher.subjects(eve); len(subjects) != 0 {
+		t.Errorf("eve got %v", subjects)
+	}
+
+	if err := s.ChangeChatroomRemote(ada, chatroomId, bob); err != nil {
+		t.Fatal(err)
+	}
+	if remote, _ := s.GetChatroomRemote(ada, chatroomId); remote.UserId != bob {
+		t.Errorf("the remote is held by %q, want bob", remote.UserId)
+	}
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/store"
+)
+
+const exportMessagesPage = 500
+
+// UserExport holds what is exported about a user. Messages are paged from the
+// store while the archive is written, everything else is loaded up front so
+// that failures happen before anything is sent.
+type UserExport struct {
+	s      *Service
+	userId string
+
+	Profile     *dto.UserResponse
+	Identities  []lib.UserIdentity
+	Friends     []store.UserFriend
+	Blocked     []dto.BlockedUserResponse
+	Chatrooms   []lib.Chatroom
+	Attachments []lib.Attachment
+	CreatedAt   time.Time
+}
+
+// ExportUserData gathers the data of userId for GET /user/export
+func (s *Service) ExportUserData(userId string) (*UserExport, error) {
+	export := &UserExport{s: s, userId: userId, CreatedAt: time.Now().UTC()}
+
+	var err error
+	if export.Profile, err = s.GetUserResponse(userId); err != nil {
+		return nil, err
+	}
+	if export.Identities, err = s.Store.GetIdentitiesByUserId(s.Ctx, userId); err != nil {
+		return nil, err
+	}
+	if export.Friends, err = s.Store.GetFriendsByUserId(s.Ctx, userId); err != nil {
+		return nil, err
+	}
+	if export.Blocked, err = s.Store.GetBlockedUsers(s.Ctx, userId); err != nil {
+		return nil, err
+	}
+	if export.Chatrooms, err = s.Store.GetChatRoomsByUserId(s.Ctx, userId); err != nil {
+		return nil, err
+	}
+	if export.Attachments, err = s.Store.GetAttachmentsByUploader(s.Ctx, userId); err != nil {
+		return nil, err
+	}
+	for i := range export.Attachments {
+		withAttachmentUrls(&export.Attachments[i])
+	}
+	return export, nil
+}
+
+// FileName is the name the archive is downloaded as
+func (e *UserExport) FileName() string {
+	return "shiba-export-" + e.CreatedAt.Format("2006-01-02") + ".zip"
+}
+
+// WriteZip writes the export as a zip archive of JSON files, plus the
+// uploaded avatar when there is one
+func (e *UserExport) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", e.Profile},
+		{"identities.json", e.Identities},
+		{"friends.json", e.Friends},
+		{"blocked.json", e.Blocked},
+		{"chatrooms.json", e.Chatrooms},
+		{"attachments.json", e.Attachments},
+	}
+	for _, file := range files {
+		if err := e.writeJSON(archive, file.name, file.v); err != nil {
+			return err
+		}
+	}
+
+	if err := e.writeMessages(archive); err != nil {
+		return err
+	}
+	if err := e.writeAvatar(archive); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func (e *UserExport) create(archive *zip.Writer, name string) (io.Writer, error) {
+	return archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: e.CreatedAt,
+	})
+}
+
+func (e *UserExport) writeJSON(archive *zip.Writer, name string, v any) error {
+	f, err := e.create(archive, name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeMessages writes every message the user sent as one JSON array without
+// holding all of them in memory
+func (e *UserExport) writeMessages(archive *zip.Writer) error {
+	f, err := e.create(archive, "messages.json")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(f, "["); err != nil {
+		return err
+	}
+	written := 0
+	for offset := 0; ; offset += exportMessagesPage {
+		messages, err := e.s.Store.GetMessagesBySender(e.s.Ctx, e.userId, offset, exportMessagesPage)
+		if err != nil {
+			log.Println("Error in UserExport.writeMessages[GetMessagesBySender]:", err)
+			return err
+		}
+
+		for _, message := range messages {
+			data, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			separator := ",\n  "
+			if written == 0 {
+				separator = "\n  "
+			}
+			if _, err := io.WriteString(f, separator); err != nil {
+				return err
+			}
+			if _, err := f.Write(data); err != nil {
+				return err
+			}
+			written++
+		}
+
+		if len(messages) < exportMessagesPage {
+			break
+		}
+	}
+	_, err = io.WriteString(f, "\n]\n")
+	return err
+}
+
+func (e *UserExport) writeAvatar(archive *zip.Writer) error {
+	prefix := avatarUrl(e.userId, "")
+	avatarId, ok := strings.CutPrefix(e.Profile.ProfilePicture, prefix)
+	if !ok {
+		return nil
+	}
+
+	avatar, err := e.s.OpenAvatar(e.userId, avatarId)
+	if err != nil {
+		// The picture is in profile.json either way
+		return nil
+	}
+	defer avatar.Close()
+
+	f, err := e.create(archive, "avatar.jpg")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, avatar)
+	return err
+}
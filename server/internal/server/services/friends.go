@@ -0,0 +1,294 @@
+package services
+
+import (
+	"log"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/store"
+)
+
+const (
+	FriendPending  = "pending"
+	FriendAccepted = "accepted"
+	FriendRejected = "rejected"
+	FriendBlocked  = "blocked"
+)
+
+var (
+	ErrFriendRequestNotFound = lib.NotFound("Friend request not found")
+	ErrFriendRequestAnswered = lib.Conflict("This friend request was already answered")
+	ErrBlocked               = lib.Forbidden("You can't interact with this user")
+)
+
+// GetFriends lists the friends of userId with their direct message room,
+// opened when the request was accepted.
+func (s *Service) GetFriends(userId string) ([]store.UserFriend, error) {
+	users, err := s.Store.GetFriendsByUserId(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in GetFriends:", err)
+		return users, err
+	}
+	return users, nil
+}
+
+// GetFriendSuggestions lists people userId may know, the ones with the most
+// friends in common first
+func (s *Service) GetFriendSuggestions(userId string, limit int) ([]dto.FriendSuggestionResponse, error) {
+	suggestions, err := s.Store.GetFriendSuggestions(s.Ctx, userId, limit)
+	if err != nil {
+		log.Println("Error in GetFriendSuggestions:", err)
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// GetFriendRequests lists the pending requests sent to userId, or the ones
+// userId sent when sent is true
+func (s *Service) GetFriendRequests(userId string, sent bool) ([]dto.FriendRequestResponse, error) {
+	var requests []dto.FriendRequestResponse
+	var err error
+	if sent {
+		requests, err = s.Store.GetSentFriendRequestsByUserId(s.Ctx, userId)
+	} else {
+		requests, err = s.Store.GetFriendRequestsByUserId(s.Ctx, userId)
+	}
+
+	if err != nil {
+		log.Println("Error in GetFriendRequests:", err)
+		return nil, err
+	}
+	return requests, nil
+}
+
+// SendFriendRequest is idempotent: asking again while a request is pending
+// or once friends returns the existing relation, and asking someone whose
+// request is pending accepts it.
+func (s *Service) SendFriendRequest(userId string, friendId string) (*dto.FriendResponse, error) {
+	if friendId == userId {
+		return nil, lib.BadRequest("You can't send a friend request to yourself")
+	}
+
+	if _, err := s.Store.GetUserById(s.Ctx, friendId); err != nil {
+		if lib.IsNoRows(err) {
+			return nil, lib.NotFound("User not found")
+		}
+		return nil, err
+	}
+
+	blocked, err := s.Store.IsBlocked(s.Ctx, userId, friendId)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrBlocked
+	}
+
+	relation, err := s.Store.GetFriendRelationBetween(s.Ctx, userId, friendId)
+	if err != nil && !lib.IsNoRows(err) {
+		log.Println("Error in SendFriendRequest[GetFriendRelationBetween]:", err)
+		return nil, err
+	}
+
+	if relation != nil {
+		switch {
+		case relation.Status.String == FriendAccepted:
+			chatroomId, err := s.Store.GetOrCreateDirectMessage(s.Ctx, userId, friendId)
+			if err != nil {
+				return nil, err
+			}
+			return &dto.FriendResponse{Status: FriendAccepted, RequestId: relation.Id, ChatroomId: chatroomId}, nil
+
+		case relation.Status.String == FriendPending && relation.UserId1 == userId:
+			return &dto.FriendResponse{Status: FriendPending, RequestId: relation.Id}, nil
+
+		case relation.Status.String == FriendPending:
+			return s.answerFriendRequest(userId, relation, FriendAccepted)
+		}
+
+		// A rejected request can be sent again by either user
+		if err := s.Store.DeleteFriendRelation(s.Ctx, relation.Id); err != nil {
+			log.Println("Error in SendFriendRequest[DeleteFriendRelation]:", err)
+			return nil, err
+		}
+	}
+
+	requestId, err := s.Store.InsertFriendStatus(s.Ctx, userId, friendId, FriendPending)
+	if err != nil {
+		log.Println("Error in SendFriendRequest:", err)
+		return nil, err
+	}
+
+	s.Notify(friendId, lib.NotificationFriendRequest, userId, "", map[string]any{
+		"request_id": requestId,
+	})
+	return &dto.FriendResponse{Status: FriendPending, RequestId: requestId}, nil
+}
+
+// friendRequestFor loads a request userId is part of, requests between
+// other users are reported as missing
+func (s *Service) friendRequestFor(userId string, reqId string) (*lib.FriendRelations, error) {
+	relation, err := s.Store.GetFriendRelationById(s.Ctx, reqId)
+	if err != nil {
+		if lib.IsNoRows(err) {
+			return nil, ErrFriendRequestNotFound
+		}
+		return nil, err
+	}
+
+	if relation.UserId1 != userId && relation.UserId2 != userId {
+		return nil, ErrFriendRequestNotFound
+	}
+	return relation, nil
+}
+
+// HandleFriendRequest answers a request sent to userId. Answering again with
+// the same status does nothing, blocking also removes the request. Accepting
+// opens the direct message room of the new friends.
+func (s *Service) HandleFriendRequest(userId string, reqId string, status string) (*dto.FriendResponse, error) {
+	relation, err := s.friendRequestFor(userId, reqId)
+	if err != nil {
+		return nil, err
+	}
+
+	if relation.UserId2 != userId {
+		return nil, lib.Forbidden("Only the recipient can answer a friend request")
+	}
+
+	if status == FriendBlocked {
+		if err := s.BlockUser(userId, relation.UserId1); err != nil {
+			return nil, err
+		}
+		return &dto.FriendResponse{Status: FriendBlocked, RequestId: relation.Id}, nil
+	}
+	if relation.Status.String == status {
+		response := &dto.FriendResponse{Status: status, RequestId: relation.Id}
+		if status == FriendAccepted {
+			response.ChatroomId, err = s.Store.GetOrCreateDirectMessage(s.Ctx, userId, relation.UserId1)
+		}
+		return response, err
+	}
+	if relation.Status.String != FriendPending {
+		return nil, ErrFriendRequestAnswered
+	}
+
+	return s.answerFriendRequest(userId, relation, status)
+}
+
+func (s *Service) answerFriendRequest(userId string, relation *lib.FriendRelations, status string) (*dto.FriendResponse, error) {
+	err := s.Store.ChangeFriendStatus(s.Ctx, relation.Id, status)
+	if err != nil {
+		log.Println("Error in HandleFriendRequest:", err)
+		return nil, err
+	}
+
+	response := &dto.FriendResponse{Status: status, RequestId: relation.Id}
+	if status != FriendAccepted {
+		return response, nil
+	}
+
+	response.ChatroomId, err = s.Store.GetOrCreateDirectMessage(s.Ctx, userId, relation.UserId1)
+	if err != nil {
+		log.Println("Error in HandleFriendRequest[GetOrCreateDirectMessage]:", err)
+		return nil, err
+	}
+
+	s.Notify(relation.UserId1, lib.NotificationFriendAccepted, userId, response.ChatroomId, map[string]any{
+		"request_id": relation.Id,
+	})
+	return response, nil
+}
+
+// OpenDirectMessage returns the direct message room userId shares with
+// friendId, creating it if needed. Only friends get one.
+func (s *Service) OpenDirectMessage(userId string, friendId string) (string, error) {
+	relation, err := s.Store.GetFriendRelationBetween(s.Ctx, userId, friendId)
+	if err != nil && !lib.IsNoRows(err) {
+		return "", err
+	}
+	if relation == nil || relation.Status.String != FriendAccepted {
+		return "", lib.Forbidden("You can only message your friends directly")
+	}
+
+	blocked, err := s.Store.IsBlocked(s.Ctx, userId, friendId)
+	if err != nil {
+		return "", err
+	}
+	if blocked {
+		return "", ErrBlocked
+	}
+
+	chatroomId, err := s.Store.GetOrCreateDirectMessage(s.Ctx, userId, friendId)
+	if err != nil {
+		log.Println("Error in OpenDirectMessage:", err)
+		return "", err
+	}
+	return chatroomId, nil
+}
+
+// CancelFriendRequest withdraws a pending request userId sent
+func (s *Service) CancelFriendRequest(userId string, reqId string) error {
+	relation, err := s.friendRequestFor(userId, reqId)
+	if err != nil {
+		return err
+	}
+
+	if relation.UserId1 != userId {
+		return lib.Forbidden("Only the sender can cancel a friend request")
+	}
+	if relation.Status.String != FriendPending {
+		return ErrFriendRequestAnswered
+	}
+
+	return s.Store.DeleteFriendRelation(s.Ctx, relation.Id)
+}
+
+func (s *Service) Unfriend(userId string, friendId string) error {
+	relation, err := s.Store.GetFriendRelationBetween(s.Ctx, userId, friendId)
+	if err != nil && !lib.IsNoRows(err) {
+		return err
+	}
+	if relation == nil || relation.Status.String != FriendAccepted {
+		return lib.NotFound("You are not friends with this user")
+	}
+
+	return s.Store.DeleteFriendRelation(s.Ctx, relation.Id)
+}
+
+// BlockUser blocks targetId for userId and ends any friendship between them
+func (s *Service) BlockUser(userId string, targetId string) error {
+	if targetId == userId {
+		return lib.BadRequest("You can't block yourself")
+	}
+
+	if _, err := s.Store.GetUserById(s.Ctx, targetId); err != nil {
+		if lib.IsNoRows(err) {
+			return lib.NotFound("User not found")
+		}
+		return err
+	}
+
+	if err := s.Store.BlockUser(s.Ctx, userId, targetId); err != nil {
+		log.Println("Error in BlockUser:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Service) UnblockUser(userId string, targetId string) error {
+	return s.Store.UnblockUser(s.Ctx, userId, targetId)
+}
+
+func (s *Service) GetBlockedUsers(userId string) ([]dto.BlockedUserResponse, error) {
+	return s.Store.GetBlockedUsers(s.Ctx, userId)
+}
+
+// CanMessage tells whether userId may post to chatroomId, direct messages
+// stop working once either member blocks the other
+func (s *Service) CanMessage(userId string, chatroomId string) (bool, error) {
+	blocked, err := s.Store.IsDirectMessageBlocked(s.Ctx, userId, chatroomId)
+	if err != nil {
+		return false, err
+	}
+	return !blocked, nil
+}
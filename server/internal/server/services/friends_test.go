@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/store"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	s := NewServiceWithStore(context.Background(), &ServerConfig{}, store.NewMemoryStore(), nil)
+	// Tests don't reach DNS
+	s.browserUrlPolicy.lookup = testLookup
+	return s
+}
+
+func newTestUser(t *testing.T, s *Service, username string) string {
+	t.Helper()
+	userId, err := s.Store.CreateUser(s.Ctx, &dto.SignupUserRequest{
+		Name:     username,
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "correct horse battery",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *userId
+}
+
+func errStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return lib.AsApiError(err).Status
+}
+
+func TestFriendRequests(t *testing.T) {
+	s := newTestService(t)
+	ada, bob, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "bob"), newTestUser(t, s, "eve")
+
+	sent, err := s.SendFriendRequest(ada, bob)
+	if err != nil || sent.Status != FriendPending || sent.RequestId == "" {
+		t.Fatalf("SendFriendRequest = %+v, %v", sent, err)
+	}
+
+	again, err := s.SendFriendRequest(ada, bob)
+	if err != nil || again.RequestId != sent.RequestId {
+		t.Errorf("sending again = %+v, %v, want the same pending request", again, err)
+	}
+
+	if _, err := s.HandleFriendRequest(eve, sent.RequestId, FriendAccepted); errStatus(err) != http.StatusNotFound {
+		t.Errorf("a stranger answering gave %v, want not found", err)
+	}
+	if _, err := s.HandleFriendRequest(ada, sent.RequestId, FriendAccepted); errStatus(err) != http.StatusForbidden {
+		t.Errorf("the sender answering gave %v, want forbidden", err)
+	}
+
+	if _, err := s.HandleFriendRequest(bob, sent.RequestId, FriendAccepted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.HandleFriendRequest(bob, sent.RequestId, FriendAccepted); err != nil {
+		t.Errorf("accepting twice gave %v", err)
+	}
+	if _, err := s.HandleFriendRequest(bob, sent.RequestId, FriendRejected); errStatus(err) != http.StatusConflict {
+		t.Errorf("rejecting an accepted request gave %v, want a conflict", err)
+	}
+
+	friends, _ := s.GetFriends(ada)
+	if len(friends) != 1 || friends[0].UserId != bob || friends[0].ChatroomId == "" {
+		t.Errorf("ada's friends = %+v, want bob with a direct message room", friends)
+	}
+	if response, _ := s.SendFriendRequest(bob, ada); response.Status != FriendAccepted || response.ChatroomId != friends[0].ChatroomId {
+		t.Errorf("asking a friend again = %+v, want accepted", response)
+	}
+
+	if err := s.Unfriend(bob, ada); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unfriend(bob, ada); errStatus(err) != http.StatusNotFound {
+		t.Errorf("unfriending twice gave %v, want not found", err)
+	}
+
+	if _, err := s.SendFriendRequest(ada, ada); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("befriending yourself gave %v, want a bad request", err)
+	}
+}
+
+func TestFriendRequestCrossing(t *testing.T) {
+	s := newTestService(t)
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+
+	sent, _ := s.SendFriendRequest(ada, bob)
+	crossed, err := s.SendFriendRequest(bob, ada)
+	if err != nil || crossed.Status != FriendAccepted || crossed.RequestId != sent.RequestId || crossed.ChatroomId == "" {
+		t.Errorf("asking someone who already asked = %+v, %v, want their request accepted", crossed, err)
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+	s := newTestService(t)
+	ada, bob, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "bob"), newTestUser(t, s, "eve")
+
+	if _, err := s.OpenDirectMessage(ada, bob); errStatus(err) != http.StatusForbidden {
+		t.Errorf("messaging a stranger gave %v, want forbidden", err)
+	}
+	_, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{DirectMessage: true, Participants: []string{ada, bob}})
+	if errStatus(err) != http.StatusForbidden {
+		t.Errorf("a direct message room with a stranger gave %v, want forbidden", err)
+	}
+
+	sent, _ := s.SendFriendRequest(ada, bob)
+	accepted, err := s.HandleFriendRequest(bob, sent.RequestId, FriendAccepted)
+	if err != nil || accepted.ChatroomId == "" {
+		t.Fatalf("accepting = %+v, %v, want a direct message room", accepted, err)
+	}
+
+	chatroomId, err := s.OpenDirectMessage(ada, bob)
+	if err != nil || chatroomId != accepted.ChatroomId {
+		t.Errorf("OpenDirectMessage = %q, %v, want %q", chatroomId, err, accepted.ChatroomId)
+	}
+	chatroomId, err = s.CreateChatRoom(bob, dto.CreateChatRoomRequest{Name: "dm", DirectMessage: true, Participants: []string{bob, ada}})
+	if err != nil || chatroomId != accepted.ChatroomId {
+		t.Errorf("creating a direct message room again = %q, %v, want %q", chatroomId, err, accepted.ChatroomId)
+	}
+	_, err = s.CreateChatRoom(ada, dto.CreateChatRoomRequest{DirectMessage: true, Participants: []string{ada, bob, eve}})
+	if errStatus(err) != http.StatusBadRequest {
+		t.Errorf("a direct message room with two others gave %v, want a bad request", err)
+	}
+
+	rooms, _ := s.Store.GetChatRoomsByUserId(s.Ctx, ada)
+	if len(rooms) != 1 || !rooms[0].DirectMessage {
+		t.Errorf("ada's chatrooms = %+v, want the direct message room only", rooms)
+	}
+
+	// Unfriending keeps the history, making friends again finds the same room
+	if err := s.Unfriend(ada, bob); err != nil {
+		t.Fatal(err)
+	}
+	sent, _ = s.SendFriendRequest(bob, ada)
+	again, err := s.HandleFriendRequest(ada, sent.RequestId, FriendAccepted)
+	if err != nil || again.ChatroomId != accepted.ChatroomId {
+		t.Errorf("befriending again = %+v, %v, want room %q", again, err, accepted.ChatroomId)
+	}
+}
+
+func TestCancelFriendRequest(t *testing.T) {
+	s := newTestService(t)
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+
+	sent, _ := s.SendFriendRequest(ada, bob)
+	if err := s.CancelFriendRequest(bob, sent.RequestId); errStatus(err) != http.StatusForbidden {
+		t.Errorf("the recipient cancelling gave %v, want forbidden", err)
+	}
+	if err := s.CancelFriendRequest(ada, sent.RequestId); err != nil {
+		t.Fatal(err)
+	}
+	if requests, _ := s.GetFriendRequests(bob, false); len(requests) != 0 {
+		t.Errorf("bob still has %d requests", len(requests))
+	}
+
+	// A rejected request can be sent again
+	sent, _ = s.SendFriendRequest(ada, bob)
+	if _, err := s.HandleFriendRequest(bob, sent.RequestId, FriendRejected); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CancelFriendRequest(ada, sent.RequestId); errStatus(err) != http.StatusConflict {
+		t.Errorf("cancelling a rejected request gave %v, want a conflict", err)
+	}
+	if again, err := s.SendFriendRequest(ada, bob); err != nil || again.Status != FriendPending {
+		t.Errorf("asking again after a rejection = %+v, %v", again, err)
+	}
+}
+
+func TestBlockUser(t *testing.T) {
+	s := newTestService(t)
+	ada, bob, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "bob"), newTestUser(t, s, "eve")
+
+	sent, _ := s.SendFriendRequest(bob, ada)
+	if _, err := s.HandleFriendRequest(ada, sent.RequestId, FriendBlocked); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.SendFriendRequest(bob, ada); errStatus(err) != http.StatusForbidden {
+		t.Errorf("a request to someone who blocked you gave %v, want forbidden", err)
+	}
+	if _, err := s.SendFriendRequest(ada, bob); errStatus(err) != http.StatusForbidden {
+		t.Errorf("a request to someone you blocked gave %v, want forbidden", err)
+	}
+
+	_, err := s.CreateChatRoom(bob, dto.CreateChatRoomRequest{Name: "dm", DirectMessage: true, Participants: []string{bob, ada}})
+	if errStatus(err) != http.StatusForbidden {
+		t.Errorf("a direct message to someone who blocked you gave %v, want forbidden", err)
+	}
+	_, err = s.CreateChatRoom(eve, dto.CreateChatRoomRequest{Name: "group", Participants: []string{eve, ada, bob}})
+	if err != nil {
+		t.Errorf("a third user inviting both gave %v", err)
+	}
+
+	if results, _ := s.SearchUsers(bob, "ada", 10); len(results) != 0 {
+		t.Errorf("search shows %+v to the blocked user", results)
+	}
+
+	if err := s.UnblockUser(ada, bob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SendFriendRequest(bob, ada); err != nil {
+		t.Errorf("a request after unblocking gave %v", err)
+	}
+}
+
+func TestGetFriendsReadOnly(t *testing.T) {
+	s := newTestService(t)
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+
+	// Accepted in the store, without the room accepting opens
+	requestId, err := s.Store.InsertFriendStatus(s.Ctx, ada, bob, FriendPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store.ChangeFriendStatus(s.Ctx, requestId, FriendAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	friends, err := s.GetFriends(ada)
+	if err != nil || len(friends) != 1 || friends[0].ChatroomId != "" {
+		t.Errorf("GetFriends = %+v, %v, want bob without a room", friends, err)
+	}
+	if chatroomId, err := s.Store.GetDirectMessage(s.Ctx, ada, bob); !lib.IsNoRows(err) {
+		t.Errorf("listing friends opened room %q, %v", chatroomId, err)
+	}
+}
@@ -2,50 +2,82 @@ package services
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"strings"
-	"time"
+	"slices"
 
 	"log"
-	"os"
+	"sideDesert/shiba/internal/server/blob"
 	"sideDesert/shiba/internal/server/dto"
 	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/mailer"
 	"sideDesert/shiba/internal/server/store"
-
-	"golang.org/x/oauth2"
+	"sideDesert/shiba/internal/server/unfurl"
 )
 
 type ServerConfig struct {
-	DbUrl string
+	DbUrl   string
+	NatsUrl string
+	// Apply pending schema migrations before serving
+	AutoMigrate bool
 }
 
 type Service struct {
-	Store       *store.Store
-	Ctx         context.Context
-	oauthConfig *oauth2.Config
-	config      *ServerConfig
+	Store  store.Store
+	Blob   blob.Store
+	Ctx    context.Context
+	config *ServerConfig
+
+	// Keyed by the provider name used in the login and callback routes
+	oauthProviders map[string]OAuthProvider
+
+	attachmentLimits AttachmentLimits
+	unfurler         *unfurl.Unfurler
+	browserUrlPolicy BrowserUrlPolicy
+	publisher        Publisher
+	roomPublisher    Publisher
+	mailer           mailer.Mailer
+	sessions         *sessionCache
+	workers          *workerRegistry
 }
 
 func NewService(ctx context.Context, config *ServerConfig) (*Service, error) {
-	store, err := store.NewStore(ctx, config.DbUrl)
+	pgStore, err := store.NewPostgresStore(ctx, config.DbUrl)
+	if err != nil {
+		log.Println("Error in NewService[NewPostgresStore()]:", err)
+		return nil, err
+	}
+
+	if config.AutoMigrate {
+		if err := migrate(ctx, pgStore); err != nil {
+			log.Println("Error in NewService[migrate()]:", err)
+			return nil, err
+		}
+	}
+
+	blobStore, err := blob.NewStoreFromEnv()
 	if err != nil {
-		log.Println("Error in NewService[NewStore()]:", err)
+		log.Println("Error in NewService[NewStoreFromEnv()]:", err)
 		return nil, err
 	}
 
+	return NewServiceWithStore(ctx, config, pgStore, blobStore), nil
+}
+
+// NewServiceWithStore builds a Service on top of the given stores, tests use
+// it with store.NewMemoryStore and blob.NewLocalStore
+func NewServiceWithStore(ctx context.Context, config *ServerConfig, st store.Store, blobStore blob.Store) *Service {
 	return &Service{
-		Ctx:    ctx,
-		Store:  store,
-		config: config,
-		oauthConfig: createGoogleOAuthConfig(
-			os.Getenv("CLIENT_ID"),
-			os.Getenv("CLIENT_SECRET"),
-			// Note: Redirect URL is the same is CALLBACK_URL
-			os.Getenv("CALLBACK_URL"),
-		),
-	}, nil
+		Ctx:              ctx,
+		Store:            st,
+		Blob:             blobStore,
+		config:           config,
+		oauthProviders:   oauthProvidersFromEnv(ctx),
+		attachmentLimits: attachmentLimitsFromEnv(),
+		unfurler:         unfurl.New(unfurl.DefaultConfig()),
+		browserUrlPolicy: browserUrlPolicyFromEnv(),
+		mailer:           mailer.NewMailerFromEnv(),
+		sessions:         newSessionCache(),
+		workers:          newWorkerRegistry(),
+	}
 }
 
 func (s *Service) RegisterUser(user *dto.SignupUserRequest) (*dto.SignupUserResponse, error) {
@@ -53,6 +85,9 @@ func (s *Service) RegisterUser(user *dto.SignupUserRequest) (*dto.SignupUserResp
 
 	if err != nil {
 		log.Print("Error in Registering User:", err)
+		if lib.IsUniqueViolation(err, "users_username_idx") {
+			return nil, ErrUsernameTaken
+		}
 		return nil, err
 	}
 
@@ -63,87 +98,15 @@ func (s *Service) RegisterUser(user *dto.SignupUserRequest) (*dto.SignupUserResp
 	}, nil
 }
 
-func (s *Service) GenerateOAuthLoginURL(provider string) string {
-	config := s.oauthConfig
-	var oauthStateString = "state"
-
-	url := config.AuthCodeURL(oauthStateString, oauth2.AccessTypeOffline)
-	return url
-}
-
-func (s *Service) Logout() error {
-	return nil
-}
-
 func (s *Service) Health() (string, error) {
 	return "Healthy!", nil
 }
 
-func (s *Service) ExchangeCodeForToken(code string) (*oauth2.Token, error) {
-	return s.oauthConfig.Exchange(s.Ctx, code)
-}
-
-func (s *Service) GetOAuthUserData(token *oauth2.Token) (*dto.OAuthGoogleUserDataResponse, error) {
-	client := s.oauthConfig.Client(s.Ctx, token)
-
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
-	defer resp.Body.Close()
-
-	userDataResponse := dto.OAuthGoogleUserDataResponse{}
-
-	err = json.NewDecoder(resp.Body).Decode(&userDataResponse)
-	if err != nil {
-		return nil, err
-	}
-	return &userDataResponse, nil
-}
-
-func (s *Service) GetUserResponse(w http.ResponseWriter, r *http.Request) (*dto.UserResponse, error) {
-	if r.Method != http.MethodGet {
-		return nil, fmt.Errorf("Method %s not allowed", r.Method)
-	}
-
-	tokenCookie, err := r.Cookie("shiba-auth-token")
-
-	if err != nil {
-		log.Println("Error in handleUser: No token in Cookies named shiba-auth-token")
-		return nil, fmt.Errorf("No token in Cookies named shiba-auth-token")
-	}
-
-	cookieString := tokenCookie.String()
-	tokenString := strings.Split(cookieString, "=")[1]
-	token, err := lib.VerifyToken(tokenString)
-	if err != nil {
-		log.Println("Error in handleUser:", err)
-		return nil, fmt.Errorf("Token Validation Error")
-	}
-
-	exp_time, err := token.Claims.GetExpirationTime()
-	if err != nil {
-		log.Println("Error in handleUser: No Expiration Time for token")
-		return nil, fmt.Errorf("No Expiration Time for token")
-	}
-
-	if exp_time.Before(time.Now()) {
-		log.Println("Error in handleUser: Token has expired")
-		return nil, fmt.Errorf("Token Expired")
-	}
-
-	userID, err := token.Claims.GetSubject()
-	if err != nil {
-		log.Println("Error in handleUser: No Subject in Token")
-		return nil, err
-	}
-
-	if userID == "" {
-		log.Println("Error in handleUser: UserID is empty string")
-		return nil, fmt.Errorf("UserID is empty")
-	}
-
+func (s *Service) GetUserResponse(userID string) (*dto.UserResponse, error) {
 	userDetails, err := s.Store.GetUserById(s.Ctx, userID)
 	if err != nil {
 		log.Println("Error in handleUser[GetUserById]", err)
-		return nil, fmt.Errorf("User could not be fetched from DB")
+		return nil, err
 	}
 
 	return &dto.UserResponse{
@@ -152,6 +115,7 @@ func (s *Service) GetUserResponse(w http.ResponseWriter, r *http.Request) (*dto.
 		Username:       userDetails.Username,
 		Name:           userDetails.Name,
 		ProfilePicture: userDetails.ProfilePicture.String,
+		Bio:            userDetails.Bio,
 		CreatedAt:      userDetails.CreatedAt,
 	}, nil
 }
@@ -160,40 +124,125 @@ func (s *Service) GetUserChatRooms(userId string) ([]lib.Chatroom, error) {
 	chatrooms, err := s.Store.GetChatRoomsByUserId(s.Ctx, userId)
 	if err != nil {
 		log.Println("Error in GetUserChatRooms[userId]", err)
-		return nil, fmt.Errorf("Chatrooms could not be fetched from DB")
+		return nil, err
 	}
 	return chatrooms, nil
 }
 
-func (s *Service) CreateChatRoom(crr dto.CreateChatRoomRequest) (string, error) {
-	return s.Store.CreateChatRoom(s.Ctx, crr)
+// CreateChatRoom creates a group chatroom. Asking for a direct message returns
+// the room userId shares with the other participant instead, those are never
+// created from the client's say so.
+func (s *Service) CreateChatRoom(userId string, crr dto.CreateChatRoomRequest) (string, error) {
+	if crr.DirectMessage {
+		others := make([]string, 0, 1)
+		for _, participant := range crr.Participants {
+			if participant != userId && !slices.Contains(others, participant) {
+				others = append(others, participant)
+			}
+		}
+		if len(others) != 1 {
+			return "", lib.BadRequest("A direct message needs exactly one other participant")
+		}
+		return s.OpenDirectMessage(userId, others[0])
+	}
+
+	// Blocked users can't be invited, which also covers direct messages
+	for _, participant := range crr.Participants {
+		if participant == userId {
+			continue
+		}
+		blocked, err := s.Store.IsBlocked(s.Ctx, userId, participant)
+		if err != nil {
+			return "", err
+		}
+		if blocked {
+			return "", ErrBlocked
+		}
+	}
+
+	// The creator is always a member and owns the room
+	if !slices.Contains(crr.Participants, userId) {
+		crr.Participants = append(crr.Participants, userId)
+	}
+
+	chatroomId, err := s.Store.CreateChatRoom(s.Ctx, crr)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Store.SetChatroomRole(s.Ctx, chatroomId, userId, lib.ChatroomOwner); err != nil {
+		log.Println("Error in CreateChatRoom[SetChatroomRole]:", err)
+		return "", err
+	}
+	// The creator holds the remote, so they can start the room's stream
+	if err := s.Store.CreateRemote(s.Ctx, chatroomId, userId); err != nil {
+		log.Println("Error in CreateChatRoom[CreateRemote]:", err)
+		return "", err
+	}
+
+	for _, participant := range crr.Participants {
+		if participant == userId {
+			continue
+		}
+		s.Notify(participant, lib.NotificationRoomInvite, userId, chatroomId, map[string]any{
+			"chatroom_name": crr.Name,
+		})
+	}
+	return chatroomId, nil
 }
 
-func (s *Service) StoreChatMessage(senderId string, chatroomId string, msg dto.ChatMessagePayload) error {
+func (s *Service) StoreChatMessage(senderId string, chatroomId string, msg dto.ChatMessagePayload) (string, error) {
+
+	attachmentIds := make([]string, 0, len(msg.Attachments))
+	for _, a := range msg.Attachments {
+		attachmentIds = append(attachmentIds, a.Id)
+	}
 
 	temp := store.StoreChatMessageDto{
-		Sender:     senderId,
-		ChatroomId: chatroomId,
-		Id:         msg.Id,
-		SenderName: msg.SenderName,
-		Content:    msg.Content,
-		CreatedAt:  msg.CreatedAt,
-	}
-	err := s.Store.StoreChatRoomMessage(s.Ctx, temp)
+		Sender:      senderId,
+		ChatroomId:  chatroomId,
+		Id:          msg.Id,
+		SenderName:  msg.SenderName,
+		Content:     msg.Content,
+		CreatedAt:   msg.CreatedAt,
+		Attachments: attachmentIds,
+	}
+	messageId, err := s.Store.StoreChatRoomMessage(s.Ctx, temp)
 	if err != nil {
 		log.Println("❌ Error in StoreChatMessage:", err)
-		return err
+		return "", err
 	}
-	return nil
+
+	// The message is stored either way, a redelivery finishes the mentions
+	if err := s.storeMentions(senderId, chatroomId, messageId, msg.Content); err != nil {
+		log.Println("❌ Error in StoreChatMessage[storeMentions]:", err)
+		return messageId, err
+	}
+	return messageId, nil
 }
 
-func (s *Service) GetChatroomHistory(chatroomId string, offset int) ([]lib.Message, error) {
-	messages, err := s.Store.GetLast50ChatRoomMessages(s.Ctx, chatroomId, offset)
+// GetChatroomHistory pages through the messages of a chatroom userId is a
+// member of, only those mentioning userId when onlyMentions is set
+func (s *Service) GetChatroomHistory(userId string, chatroomId string, offset int, onlyMentions bool) ([]lib.Message, error) {
+	if _, err := s.chatroomRole(userId, chatroomId); err != nil {
+		return nil, err
+	}
+
+	mentionedUserId := ""
+	if onlyMentions {
+		mentionedUserId = userId
+	}
+	messages, err := s.Store.GetLast50ChatRoomMessages(s.Ctx, chatroomId, offset, mentionedUserId)
 	if err != nil {
 		log.Println("❌ Error in GetChatroomHistory:", err)
 		return nil, err
 	}
 
+	for i := range messages {
+		for j := range messages[i].Attachments {
+			withAttachmentUrls(&messages[i].Attachments[j])
+		}
+	}
+
 	return messages, nil
 }
 
@@ -207,72 +256,73 @@ func (s *Service) SearchUsers(userId string, query string, limit int) ([]dto.Sea
 	return users, nil
 }
 
-func (s *Service) GetFriends(userId string) ([]store.UserFriend, error) {
-	users, err := s.Store.GetFriendsByUserId(s.Ctx, userId)
+// GetChatroomRemote returns who holds the remote of a chatroom userId is a
+// member of
+func (s *Service) GetChatroomRemote(userId string, chatroomId string) (*dto.RemoteResponse, error) {
+	if _, err := s.chatroomRole(userId, chatroomId); err != nil {
+		return nil, err
+	}
+
+	remote, err := s.Store.GetRemoteByChatroomId(s.Ctx, chatroomId)
+
 	if err != nil {
-		log.Println("Error in SearchUsers:", err)
-		return users, err
+		log.Println("Error in SendFriendRequest:", err)
+		return nil, err
 	}
-	return users, nil
+	return remote, nil
 }
 
-func (s *Service) HandleFriendRequest(userId string, reqId string, status string) error {
-	// TODO: Add Check for - Can this userId even accept this friend request?
-	err := s.Store.ChangeFriendStatus(s.Ctx, reqId, status)
-	if err != nil {
-		log.Println("Error in SearchUsers:", err)
+// ChangeChatroomRemote hands the remote from actorId to userId, both have to
+// be members of the chatroom
+func (s *Service) ChangeChatroomRemote(actorId string, chatroomId string, userId string) error {
+	if _, err := s.chatroomRole(actorId, chatroomId); err != nil {
+		return err
+	}
+	if _, err := s.Store.GetChatroomRole(s.Ctx, userId, chatroomId); lib.IsNoRows(err) {
+		return lib.BadRequest("The remote can only go to a member of the chatroom")
+	} else if err != nil {
 		return err
 	}
-	return nil
-}
 
-func (s *Service) SendFriendRequest(userId string, friendId string) error {
-	err := s.Store.InsertFriendStatus(s.Ctx, userId, friendId, "pending")
+	err := s.Store.UpdateRemote(s.Ctx, chatroomId, userId)
 
 	if err != nil {
 		log.Println("Error in SendFriendRequest:", err)
 		return err
 	}
+
+	s.Notify(userId, lib.NotificationRemoteGranted, actorId, chatroomId, nil)
 	return nil
 }
 
-func (s *Service) GetFriendRequests(userId string) ([]dto.FriendRequestResponse, error) {
-	friends, err := s.Store.GetFriendRequestsByUserId(s.Ctx, userId)
-
-	if err != nil {
-		log.Println("Error in SendFriendRequest:", err)
-		return nil, err
+// NotifyStreamStarted tells every other member of the chatroom that userId
+// started streaming.
+func (s *Service) NotifyStreamStarted(userId string, chatroomId string, memberIds []string) {
+	for _, memberId := range memberIds {
+		s.Notify(memberId, lib.NotificationStreamStarted, userId, chatroomId, nil)
 	}
-
-	return friends, nil
 }
-
-func (s *Service) GetChatroomRemote(chatroomId string) (*dto.RemoteResponse, error) {
+func (s *Service) CheckUserIsRemoteForChatroom(userId string, chatroomId string) bool {
 	remote, err := s.Store.GetRemoteByChatroomId(s.Ctx, chatroomId)
 
 	if err != nil {
 		log.Println("Error in SendFriendRequest:", err)
-		return nil, err
+		return false
 	}
-	return remote, nil
-}
 
-func (s *Service) ChangeChatroomRemote(chatroomId string, userId string) error {
-	err := s.Store.UpdateRemote(s.Ctx, chatroomId, userId)
+	return remote.UserId == userId
+}
 
+func migrate(ctx context.Context, store *store.PostgresStore) error {
+	migrator, err := store.Migrator()
 	if err != nil {
-		log.Println("Error in SendFriendRequest:", err)
 		return err
 	}
-	return nil
-}
-func (s *Service) CheckUserIsRemoteForChatroom(userId string, chatroomId string) bool {
-	remote, err := s.Store.GetRemoteByChatroomId(s.Ctx, chatroomId)
 
+	applied, err := migrator.Up(ctx)
 	if err != nil {
-		log.Println("Error in SendFriendRequest:", err)
-		return false
+		return err
 	}
-
-	return remote.UserId == userId
+	log.Printf("Database schema is up to date, %d migrations applied", len(applied))
+	return nil
 }
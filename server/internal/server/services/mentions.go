@@ -0,0 +1,50 @@
+package services
+
+import (
+	"log"
+
+	"sideDesert/shiba/internal/server/lib"
+)
+
+// Previews of the message in mention notifications are cut to this length
+const mentionPreviewLength = 140
+
+// storeMentions resolves the @usernames in content against the members of
+// the chatroom, stores them for the message and notifies the users it didn't
+// mention yet, so running it again for a redelivered message is harmless.
+func (s *Service) storeMentions(senderId string, chatroomId string, messageId string, content string) error {
+	usernames := lib.ParseMentions(content)
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	members, err := s.Store.GetChatroomMembersByUsernames(s.Ctx, chatroomId, usernames)
+	if err != nil {
+		log.Println("Error in storeMentions[GetChatroomMembersByUsernames]:", err)
+		return err
+	}
+
+	userIds := make([]string, 0, len(members))
+	for _, m := range members {
+		userIds = append(userIds, m.UserId)
+	}
+
+	added, err := s.Store.StoreMessageMentions(s.Ctx, messageId, userIds)
+	if err != nil {
+		log.Println("Error in storeMentions[StoreMessageMentions]:", err)
+		return err
+	}
+
+	preview := []rune(content)
+	if len(preview) > mentionPreviewLength {
+		preview = append(preview[:mentionPreviewLength], '…')
+	}
+
+	for _, userId := range added {
+		s.Notify(userId, lib.NotificationMention, senderId, chatroomId, map[string]any{
+			"message_id": messageId,
+			"content":    string(preview),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+func TestStoreMentions(t *testing.T) {
+	s := newTestService(t)
+	ada, bob, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "bob"), newTestUser(t, s, "eve")
+	mallory := newTestUser(t, s, "mallory")
+
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room", Participants: []string{bob, eve}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mallory isn't a member and nobody is called nobody
+	content := "@Bob @bob and @mallory, @nobody or ada@example.com"
+	mentioning, err := s.StoreChatMessage(ada, chatroomId, dto.ChatMessagePayload{Content: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StoreChatMessage(bob, chatroomId, dto.ChatMessagePayload{Content: "no mentions here"}); err != nil {
+		t.Fatal(err)
+	}
+	long := "@eve " + strings.Repeat("ö", mentionPreviewLength)
+	if _, err := s.StoreChatMessage(ada, chatroomId, dto.ChatMessagePayload{Content: long}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := s.GetChatroomHistory(ada, chatroomId, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mentions := map[string][]string{}
+	for _, msg := range history {
+		mentions[msg.Content] = msg.Mentions
+	}
+	if got := mentions[content]; !slices.Equal(got, []string{bob}) {
+		t.Errorf("mentions of the first message = %v, want only bob", got)
+	}
+	if got := mentions["no mentions here"]; len(got) != 0 {
+		t.Errorf("a message without mentions mentions %v", got)
+	}
+
+	// The mentions filter only keeps the messages mentioning the user
+	for userId, want := range map[string]int{bob: 1, eve: 1, ada: 0} {
+		filtered, err := s.GetChatroomHistory(userId, chatroomId, 0, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(filtered) != want {
+			t.Errorf("%d messages mention %s, want %d", len(filtered), userId, want)
+		}
+		for _, msg := range filtered {
+			if !slices.Contains(msg.Mentions, userId) {
+				t.Errorf("the filter for %s kept %q", userId, msg.Content)
+			}
+		}
+	}
+
+	notifications, err := s.Store.GetNotificationsByUserId(s.Ctx, bob, false, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mentioned []lib.Notification
+	for _, n := range notifications {
+		if n.Type == lib.NotificationMention {
+			mentioned = append(mentioned, n)
+		}
+	}
+	if len(mentioned) != 1 || mentioned[0].Data["message_id"] != mentioning || mentioned[0].ActorId.String != ada || mentioned[0].ChatroomId.String != chatroomId {
+		t.Fatalf("mention notifications of bob = %+v", mentioned)
+	}
+
+	// The preview of a long message is cut
+	notifications, err = s.Store.GetNotificationsByUserId(s.Ctx, eve, false, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previews := []string{}
+	for _, n := range notifications {
+		if n.Type == lib.NotificationMention {
+			preview, _ := n.Data["content"].(string)
+			previews = append(previews, preview)
+		}
+	}
+	if len(previews) != 1 || len([]rune(previews[0])) != mentionPreviewLength+1 ||
+		!strings.HasSuffix(previews[0], "…") || !strings.HasPrefix(long, strings.TrimSuffix(previews[0], "…")) {
+		t.Errorf("previews of the mentions of eve = %q", previews)
+	}
+
+	// Nothing mentions mallory or the sender
+	for _, userId := range []string{ada, mallory} {
+		if n, _ := s.Store.GetNotificationsByUserId(s.Ctx, userId, false, 10, 0); slices.ContainsFunc(n, func(n lib.Notification) bool { return n.Type == lib.NotificationMention }) {
+			t.Errorf("%s was notified of a mention", userId)
+		}
+	}
+}
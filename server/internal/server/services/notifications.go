@@ -0,0 +1,118 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+const notificationsPageSize = 30
+
+// Publisher is the part of the NATS connection the service needs to push
+// real-time events. *nats.Conn satisfies it.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+func (s *Service) SetPublisher(p Publisher) {
+	s.publisher = p
+}
+
+// Every open websocket of a user subscribes to this subject
+func UserNotificationsSubject(userId string) string {
+	return "users." + userId + ".notifications"
+}
+
+// Notify stores a notification for userId and delivers it to all of the
+// user's open websockets. Failing to notify never fails the action that
+// triggered it, so errors are only logged.
+func (s *Service) Notify(userId string, notificationType string, actorId string, chatroomId string, data map[string]any) {
+	if userId == "" || userId == actorId {
+		return
+	}
+
+	n := &lib.Notification{
+		UserId: userId,
+		Type:   notificationType,
+		Data:   data,
+	}
+	n.ActorId.String, n.ActorId.Valid = actorId, actorId != ""
+	n.ChatroomId.String, n.ChatroomId.Valid = chatroomId, chatroomId != ""
+
+	if n.Data == nil {
+		n.Data = map[string]any{}
+	}
+	if actorId != "" {
+		if actor, err := s.Store.GetUserById(s.Ctx, actorId); err == nil {
+			n.Data["actor_name"] = actor.Name
+			n.Data["actor_username"] = actor.Username
+		}
+	}
+
+	if err := s.Store.CreateNotification(s.Ctx, n); err != nil {
+		log.Println("Error in Notify[CreateNotification]:", err)
+		return
+	}
+
+	s.publishToUser(userId, "notification", n)
+}
+
+func (s *Service) publishToUser(userId string, subject string, payload any) {
+	if s.publisher == nil {
+		return
+	}
+
+	data, err := json.Marshal(dto.Message[any]{
+		Sender:  "server",
+		Subject: subject,
+		Payload: payload,
+	})
+	if err != nil {
+		log.Println("Error in publishToUser[Marshal]:", err)
+		return
+	}
+
+	if err := s.publisher.Publish(UserNotificationsSubject(userId), data); err != nil {
+		log.Println("Error in publishToUser[Publish]:", err)
+	}
+}
+
+func (s *Service) GetNotifications(userId string, unreadOnly bool, page int) (*dto.NotificationsResponse, error) {
+	notifications, err := s.Store.GetNotificationsByUserId(s.Ctx, userId, unreadOnly, notificationsPageSize, page*notificationsPageSize)
+	if err != nil {
+		log.Println("Error in GetNotifications:", err)
+		return nil, err
+	}
+
+	unread, err := s.Store.CountUnreadNotifications(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in GetNotifications[CountUnreadNotifications]:", err)
+		return nil, err
+	}
+
+	return &dto.NotificationsResponse{
+		Notifications: notifications,
+		UnreadCount:   unread,
+	}, nil
+}
+
+func (s *Service) MarkNotificationsRead(userId string, req dto.MarkNotificationsReadRequest) (int64, error) {
+	ids := req.Ids
+	if req.All {
+		ids = nil
+	} else if len(ids) == 0 {
+		return 0, nil
+	}
+
+	updated, err := s.Store.MarkNotificationsRead(s.Ctx, userId, ids)
+	if err != nil {
+		log.Println("Error in MarkNotificationsRead:", err)
+		return 0, err
+	}
+
+	// Let the user's other tabs/devices update their badge
+	s.publishToUser(userId, "notification.read", req)
+	return updated, nil
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+func TestNotify(t *testing.T) {
+	s := newTestService(t)
+	publisher := &recordingPublisher{}
+	s.SetPublisher(publisher)
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+
+	chatroomId, err := s.CreateChatRoom(ada, dto.CreateChatRoomRequest{Name: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Notify(bob, lib.NotificationRemoteGranted, ada, chatroomId, map[string]any{"note": "yours"})
+
+	page, err := s.GetNotifications(bob, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Notifications) != 1 || page.UnreadCount != 1 {
+		t.Fatalf("GetNotifications = %+v, want the one notification", page)
+	}
+	n := page.Notifications[0]
+	if n.Type != lib.NotificationRemoteGranted || n.ActorId.String != ada || n.ChatroomId.String != chatroomId {
+		t.Errorf("notification = %+v", n)
+	}
+	// The actor is named so the client doesn't have to look them up
+	if n.Data["note"] != "yours" || n.Data["actor_name"] != "ada" || n.Data["actor_username"] != "ada" {
+		t.Errorf("data = %v", n.Data)
+	}
+
+	// Pushed to the open websockets of bob as it is stored
+	if subjects := publisher.subjects(bob); !slices.Equal(subjects, []string{"notification"}) {
+		t.Fatalf("bob got %v", subjects)
+	}
+	pushed := lib.Notification{}
+	if err := json.Unmarshal(publisher.messages[UserNotificationsSubject(bob)][0].Payload, &pushed); err != nil || pushed.Id != n.Id {
+		t.Errorf("pushed notification = %+v, %v, want %s", pushed, err, n.Id)
+	}
+
+	// Nobody is notified of their own actions, or when there is nobody
+	s.Notify(ada, lib.NotificationRemoteGranted, ada, chatroomId, nil)
+	s.Notify("", lib.NotificationRemoteGranted, ada, chatroomId, nil)
+	if page, _ := s.GetNotifications(ada, false, 0); len(page.Notifications) != 0 {
+		t.Errorf("the actor was notified of their own action: %+v", page.Notifications)
+	}
+	if subjects := publisher.subjects(ada); len(subjects) != 0 {
+		t.Errorf("ada got %v", subjects)
+	}
+
+	// A notification that can't be stored isn't pushed either
+	s.Notify("missing", lib.NotificationMention, ada, "", nil)
+	if subjects := publisher.subjects("missing"); len(subjects) != 0 {
+		t.Errorf("a missing user got %v", subjects)
+	}
+}
+
+func TestGetNotificationsPages(t *testing.T) {
+	s := newTestService(t)
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+
+	for i := 0; i < notificationsPageSize+5; i++ {
+		s.Notify(ada, lib.NotificationFriendRequest, bob, "", nil)
+	}
+
+	first, err := s.GetNotifications(ada, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.GetNotifications(ada, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Notifications) != notificationsPageSize || len(second.Notifications) != 5 {
+		t.Errorf("pages of %d and %d notifications", len(first.Notifications), len(second.Notifications))
+	}
+	// The unread count covers every page
+	if first.UnreadCount != notificationsPageSize+5 || second.UnreadCount != first.UnreadCount {
+		t.Errorf("unread counts = %d and %d", first.UnreadCount, second.UnreadCount)
+	}
+}
+
+func TestMarkNotificationsRead(t *testing.T) {
+	s := newTestService(t)
+	publisher := &recordingPublisher{}
+	s.SetPublisher(publisher)
+	ada, bob := newTestUser(t, s, "ada"), newTestUser(t, s, "bob")
+
+	for i := 0; i < 3; i++ {
+		s.Notify(ada, lib.NotificationFriendRequest, bob, "", nil)
+	}
+	s.Notify(bob, lib.NotificationFriendRequest, ada, "", nil)
+	page, err := s.GetNotifications(ada, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobs, err := s.GetNotifications(bob, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No ids and not all of them is nothing
+	if updated, err := s.MarkNotificationsRead(ada, dto.MarkNotificationsReadRequest{}); err != nil || updated != 0 {
+		t.Errorf("marking nothing = %d, %v", updated, err)
+	}
+	if subjects := publisher.subjects(ada); slices.Contains(subjects, "notification.read") {
+		t.Errorf("marking nothing was pushed: %v", subjects)
+	}
+
+	// The notification of another user is left alone
+	ids := []string{page.Notifications[0].Id, bobs.Notifications[0].Id}
+	if updated, err := s.MarkNotificationsRead(ada, dto.MarkNotificationsReadRequest{Ids: ids}); err != nil || updated != 1 {
+		t.Errorf("MarkNotificationsRead = %d, %v, want 1", updated, err)
+	}
+	if unread, _ := s.GetNotifications(bob, true, 0); len(unread.Notifications) != 1 {
+		t.Error("ada marked a notification of bob as read")
+	}
+
+	// All wins over the ids
+	req := dto.MarkNotificationsReadRequest{Ids: []string{page.Notifications[1].Id}, All: true}
+	if updated, err := s.MarkNotificationsRead(ada, req); err != nil || updated != 2 {
+		t.Errorf("marking all read = %d, %v, want 2", updated, err)
+	}
+	if unread, _ := s.GetNotifications(ada, true, 0); len(unread.Notifications) != 0 || unread.UnreadCount != 0 {
+		t.Errorf("unread after marking all = %+v", unread)
+	}
+
+	// The other tabs of ada update their badge
+	read := 0
+	for _, subject := range publisher.subjects(ada) {
+		if subject == "notification.read" {
+			read++
+		}
+	}
+	if read != 2 {
+		t.Errorf("%d notification.read pushed, want 2", read)
+	}
+}
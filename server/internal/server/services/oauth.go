@@ -1,22 +1,294 @@
 package services
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
-var OauthConfig = make(map[string]*oauth2.Config)
+const (
+	OAuthStateCookie   = "shiba-state-token"
+	OAuthStateLifetime = 10 * time.Minute
+)
+
+var (
+	ErrUnknownOAuthProvider = lib.NewApiError(http.StatusNotFound, "unknown_oauth_provider", "Unknown OAuth provider")
+	ErrInvalidOAuthState    = lib.NewApiError(http.StatusBadRequest, "invalid_oauth_state", "Invalid OAuth state, please try again")
+	ErrOAuthProvider        = lib.NewApiError(http.StatusBadGateway, "oauth_provider_error", "Could not sign in with the provider")
+)
+
+// OAuthState is kept in a signed cookie between the redirect to the provider
+// and the callback. The PKCE verifier never leaves our domain otherwise.
+type OAuthState struct {
+	State     string `json:"s"`
+	Verifier  string `json:"v"`
+	Provider  string `json:"p"`
+	ExpiresAt int64  `json:"e"`
+	// Set when a signed in user adds another provider to their account
+	LinkUserId string `json:"l,omitempty"`
+}
+
+func (s *Service) oauthProvider(name string) (OAuthProvider, error) {
+	p, ok := s.oauthProviders[name]
+	if !ok {
+		return nil, ErrUnknownOAuthProvider
+	}
+	return p, nil
+}
+
+// OAuthProviders lists the names of the configured providers
+func (s *Service) OAuthProviders() []string {
+	names := make([]string, 0, len(s.oauthProviders))
+	for name := range s.oauthProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BeginOAuthLogin returns the provider url to redirect to and the signed
+// value for the OAuthStateCookie cookie. linkUserId is the signed in user
+// when the login should link the provider to their account, "" otherwise.
+func (s *Service) BeginOAuthLogin(provider string, linkUserId string) (string, string, error) {
+	p, err := s.oauthProvider(provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := lib.CreateCSRFToken()
+	if err != nil {
+		log.Println("Error in BeginOAuthLogin[CreateCSRFToken]:", err)
+		return "", "", err
+	}
+
+	st := OAuthState{
+		State:      state,
+		Verifier:   oauth2.GenerateVerifier(),
+		Provider:   provider,
+		ExpiresAt:  time.Now().Add(OAuthStateLifetime).Unix(),
+		LinkUserId: linkUserId,
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		return "", "", err
+	}
+
+	url := p.Config().AuthCodeURL(st.State, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(st.Verifier))
+	return url, lib.SignValue(data), nil
+}
+
+func verifyOAuthState(provider string, state string, cookieValue string) (*OAuthState, error) {
+	data, err := lib.VerifySignedValue(cookieValue)
+	if err != nil {
+		return nil, ErrInvalidOAuthState.Wrap(err)
+	}
+
+	st := OAuthState{}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, ErrInvalidOAuthState.Wrap(err)
+	}
+
+	if time.Now().Unix() > st.ExpiresAt {
+		return nil, ErrInvalidOAuthState.Wrap(fmt.Errorf("OAuth login took too long"))
+	}
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(st.State)) != 1 {
+		return nil, ErrInvalidOAuthState.Wrap(fmt.Errorf("OAuth state mismatch"))
+	}
+
+	// A state issued for one provider can't complete a login with another
+	if st.Provider != provider {
+		return nil, ErrInvalidOAuthState.Wrap(fmt.Errorf("OAuth provider mismatch"))
+	}
+
+	return &st, nil
+}
 
-func createGoogleOAuthConfig(clientId string, clientSecret string, redirectUrl string) *oauth2.Config {
-	return &oauth2.Config{
-		ClientID:     clientId,
-		ClientSecret: clientSecret,
-		RedirectURL:  redirectUrl,
-		Scopes: []string{
-			"openid",
-			"email",
-			"profile",
-		},
-		Endpoint: google.Endpoint,
+// CompleteOAuthLogin validates the callback against the state cookie, then
+// exchanges the code (with the PKCE verifier) and fetches the user profile.
+func (s *Service) CompleteOAuthLogin(provider string, state string, code string, cookieValue string) (*dto.OAuthUser, *OAuthState, error) {
+	p, err := s.oauthProvider(provider)
+	if err != nil {
+		return nil, nil, err
 	}
+
+	st, err := verifyOAuthState(provider, state, cookieValue)
+	if err != nil {
+		log.Println("Error in CompleteOAuthLogin[verifyOAuthState]:", err)
+		return nil, nil, err
+	}
+
+	if code == "" {
+		return nil, nil, lib.BadRequest("No code in URL, code recieved is \"\"")
+	}
+
+	token, err := p.Config().Exchange(s.Ctx, code, oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		log.Println("Error in CompleteOAuthLogin[Exchange]:", err)
+		return nil, nil, ErrOAuthProvider.Wrap(err)
+	}
+
+	user, err := p.UserInfo(s.Ctx, token)
+	if err != nil {
+		log.Println("Error in CompleteOAuthLogin[UserInfo]:", err)
+		return nil, nil, ErrOAuthProvider.Wrap(err)
+	}
+	if user.Subject == "" {
+		return nil, nil, ErrOAuthProvider.Wrap(fmt.Errorf("%s did not return a user id", provider))
+	}
+
+	return user, st, nil
+}
+
+// LoginWithOAuth maps a provider identity to a user. In order it tries the
+// identity itself, the account being linked, and an existing account with
+// the same email when both sides verified it. Otherwise a new user is
+// created. The bool is true for a new user.
+func (s *Service) LoginWithOAuth(oauthUser *dto.OAuthUser, linkUserId string) (*lib.User, bool, error) {
+	user, err := s.Store.GetUserByIdentity(s.Ctx, oauthUser.Provider, oauthUser.Subject)
+	if err == nil {
+		if linkUserId != "" && linkUserId != user.UserId {
+			return nil, false, lib.Conflict(fmt.Sprintf("This %s account is already linked to another user", oauthUser.Provider))
+		}
+		return user, false, nil
+	}
+
+	if linkUserId != "" {
+		user, err = s.Store.GetUserById(s.Ctx, linkUserId)
+		if err != nil {
+			log.Println("Error in LoginWithOAuth[GetUserById]:", err)
+			return nil, false, err
+		}
+		err = s.Store.CreateIdentity(s.Ctx, user.UserId, oauthUser)
+		if lib.IsUniqueViolation(err, "user_identities_user_id_provider_key") {
+			return nil, false, lib.Conflict(fmt.Sprintf("Another %s account is already linked, unlink it first", oauthUser.Provider))
+		}
+		return user, false, err
+	}
+
+	if oauthUser.Email == "" {
+		return nil, false, lib.BadRequest(fmt.Sprintf("%s did not share an email address", oauthUser.Provider))
+	}
+
+	// An unverified email could belong to anyone, so never link on it. That
+	// goes for the local account too: anyone can sign up with an address
+	// they don't own and wait for its owner to come through the provider.
+	user, err = s.Store.GetUserByEmail(s.Ctx, oauthUser.Email)
+	if err == nil {
+		if !oauthUser.EmailVerified || !user.EmailVerified {
+			return nil, false, lib.Conflict(fmt.Sprintf("An account with this email exists, sign in and link %s from your settings", oauthUser.Provider))
+		}
+		return user, false, s.Store.CreateIdentity(s.Ctx, user.UserId, oauthUser)
+	}
+
+	user, err = s.createOAuthUser(oauthUser)
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, s.Store.CreateIdentity(s.Ctx, user.UserId, oauthUser)
+}
+
+func (s *Service) createOAuthUser(oauthUser *dto.OAuthUser) (*lib.User, error) {
+	// Users created through OAuth sign in with the provider, the password
+	// is random until they set one with a reset link
+	password, err := lib.GenerateSecureRandomID(24)
+	if err != nil {
+		log.Println("Error in createOAuthUser[GenerateSecureRandomID]:", err)
+		return nil, err
+	}
+
+	username := oauthUser.Username
+	if username == "" {
+		username = strings.Split(oauthUser.Email, "@")[0]
+	}
+	name := oauthUser.Name
+	if name == "" {
+		name = username
+	}
+
+	// The provider's username may be taken here, try a few suffixed ones
+	var userId *string
+	for attempt := 0; ; attempt++ {
+		candidate := username
+		if attempt > 0 {
+			suffix, err := lib.GenerateSecureRandomID(2)
+			if err != nil {
+				return nil, err
+			}
+			candidate = username + "_" + suffix
+		}
+
+		userId, err = s.Store.CreateUser(s.Ctx, &dto.SignupUserRequest{
+			Name:     name,
+			Username: candidate,
+			Email:    oauthUser.Email,
+			Password: password,
+		})
+		if err == nil || attempt == 3 || !lib.IsUniqueViolation(err, "users_username_idx") {
+			break
+		}
+	}
+	if err != nil {
+		log.Println("Error in createOAuthUser[CreateUser]:", err)
+		return nil, err
+	}
+	if oauthUser.EmailVerified {
+		if err := s.Store.MarkEmailVerified(s.Ctx, *userId); err != nil {
+			log.Println("Error in createOAuthUser[MarkEmailVerified]:", err)
+			return nil, err
+		}
+	}
+
+	return s.Store.GetUserById(s.Ctx, *userId)
+}
+
+func (s *Service) GetIdentities(userId string) ([]lib.UserIdentity, error) {
+	identities, err := s.Store.GetIdentitiesByUserId(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in GetIdentities:", err)
+		return nil, err
+	}
+	return identities, nil
+}
+
+// UnlinkIdentity removes a provider from the account. The last identity
+// can only go when the account has a password to sign in with, one made
+// through OAuth gets it from a reset link.
+func (s *Service) UnlinkIdentity(userId string, provider string) error {
+	identities, err := s.Store.GetIdentitiesByUserId(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in UnlinkIdentity[GetIdentitiesByUserId]:", err)
+		return err
+	}
+
+	found := false
+	for _, i := range identities {
+		found = found || i.Provider == provider
+	}
+	if !found {
+		return lib.NotFound(fmt.Sprintf("%s is not linked to your account", provider))
+	}
+	if len(identities) == 1 {
+		user, err := s.Store.GetUserById(s.Ctx, userId)
+		if err != nil {
+			log.Println("Error in UnlinkIdentity[GetUserById]:", err)
+			return err
+		}
+		if user.PasswordHash == "" {
+			return lib.Conflict("Can't unlink the only sign in method of your account")
+		}
+	}
+
+	return s.Store.DeleteIdentity(s.Ctx, userId, provider)
 }
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+	"golang.org/x/oauth2/google"
+)
+
+// OAuthProvider is an identity provider users can sign in with
+type OAuthProvider interface {
+	Name() string
+	Config() *oauth2.Config
+	// UserInfo fetches the profile of the user the token belongs to
+	UserInfo(ctx context.Context, token *oauth2.Token) (*dto.OAuthUser, error)
+}
+
+// oauthCallbackURL is where a provider redirects back to. CALLBACK_URL is
+// still honoured for Google so existing deployments keep working.
+func oauthCallbackURL(provider string) string {
+	if provider == "google" && os.Getenv("CALLBACK_URL") != "" {
+		return os.Getenv("CALLBACK_URL")
+	}
+	base := os.Getenv("OAUTH_CALLBACK_BASE")
+	if base == "" {
+		base = "http://localhost:9000"
+	}
+	return strings.TrimRight(base, "/") + lib.ApiPrefix + "/oauth/callback/" + provider
+}
+
+// oauthProvidersFromEnv registers every provider that has credentials set
+func oauthProvidersFromEnv(ctx context.Context) map[string]OAuthProvider {
+	providers := make(map[string]OAuthProvider)
+
+	googleId := os.Getenv("GOOGLE_CLIENT_ID")
+	googleSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
+	if googleId == "" {
+		googleId, googleSecret = os.Getenv("CLIENT_ID"), os.Getenv("CLIENT_SECRET")
+	}
+	if googleId != "" {
+		providers["google"] = NewGoogleProvider(googleId, googleSecret, oauthCallbackURL("google"))
+	}
+
+	if id := os.Getenv("GITHUB_CLIENT_ID"); id != "" {
+		providers["github"] = NewGitHubProvider(id, os.Getenv("GITHUB_CLIENT_SECRET"), oauthCallbackURL("github"))
+	}
+
+	if id := os.Getenv("DISCORD_CLIENT_ID"); id != "" {
+		providers["discord"] = NewDiscordProvider(id, os.Getenv("DISCORD_CLIENT_SECRET"), oauthCallbackURL("discord"))
+	}
+
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		name := os.Getenv("OIDC_NAME")
+		if name == "" {
+			name = "oidc"
+		}
+		p, err := NewOIDCProvider(ctx, name, issuer, os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), oauthCallbackURL(name))
+		if err != nil {
+			log.Println("Error in oauthProvidersFromEnv[NewOIDCProvider]:", err)
+		} else {
+			providers[name] = p
+		}
+	}
+
+	return providers
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Google
+
+type googleProvider struct {
+	config      *oauth2.Config
+	userInfoURL string
+}
+
+func NewGoogleProvider(clientId string, clientSecret string, redirectUrl string) *googleProvider {
+	return &googleProvider{
+		config: &oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectUrl,
+			Scopes:       []string{"openid", "email", "profile"},
+			Endpoint:     google.Endpoint,
+		},
+		userInfoURL: "https://www.googleapis.com/oauth2/v2/userinfo",
+	}
+}
+
+func (p *googleProvider) Name() string           { return "google" }
+func (p *googleProvider) Config() *oauth2.Config { return p.config }
+
+func (p *googleProvider) UserInfo(ctx context.Context, token *oauth2.Token) (*dto.OAuthUser, error) {
+	data := dto.OAuthGoogleUserDataResponse{}
+	if err := getJSON(ctx, p.config.Client(ctx, token), p.userInfoURL, &data); err != nil {
+		return nil, err
+	}
+
+	return &dto.OAuthUser{
+		Provider:      p.Name(),
+		Subject:       data.Id,
+		Email:         data.Email,
+		EmailVerified: data.VerifiedEmail,
+		Name:          data.Name,
+		Picture:       data.Picture,
+	}, nil
+}
+
+// GitHub
+
+type githubProvider struct {
+	config  *oauth2.Config
+	apiBase string
+}
+
+func NewGitHubProvider(clientId string, clientSecret string, redirectUrl string) *githubProvider {
+	return &githubProvider{
+		config: &oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectUrl,
+			Scopes:       []string{"read:user", "user:email"},
+			Endpoint:     github.Endpoint,
+		},
+		apiBase: "https://api.github.com",
+	}
+}
+
+func (p *githubProvider) Name() string           { return "github" }
+func (p *githubProvider) Config() *oauth2.Config { return p.config }
+
+func (p *githubProvider) UserInfo(ctx context.Context, token *oauth2.Token) (*dto.OAuthUser, error) {
+	client := p.config.Client(ctx, token)
+
+	data := struct {
+		Id        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarUrl string `json:"avatar_url"`
+	}{}
+	if err := getJSON(ctx, client, p.apiBase+"/user", &data); err != nil {
+		return nil, err
+	}
+
+	// The profile email can be hidden, the emails endpoint has the primary one
+	emails := []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}{}
+	if err := getJSON(ctx, client, p.apiBase+"/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	user := &dto.OAuthUser{
+		Provider: p.Name(),
+		Subject:  strconv.FormatInt(data.Id, 10),
+		Name:     data.Name,
+		Username: data.Login,
+		Picture:  data.AvatarUrl,
+	}
+	if user.Name == "" {
+		user.Name = data.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			user.Email, user.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return user, nil
+}
+
+// Discord
+
+var discordEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://discord.com/oauth2/authorize",
+	TokenURL: "https://discord.com/api/oauth2/token",
+}
+
+type discordProvider struct {
+	config  *oauth2.Config
+	apiBase string
+}
+
+func NewDiscordProvider(clientId string, clientSecret string, redirectUrl string) *discordProvider {
+	return &discordProvider{
+		config: &oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectUrl,
+			Scopes:       []string{"identify", "email"},
+			Endpoint:     discordEndpoint,
+		},
+		apiBase: "https://discord.com/api",
+	}
+}
+
+func (p *discordProvider) Name() string           { return "discord" }
+func (p *discordProvider) Config() *oauth2.Config { return p.config }
+
+func (p *discordProvider) UserInfo(ctx context.Context, token *oauth2.Token) (*dto.OAuthUser, error) {
+	data := struct {
+		Id         string `json:"id"`
+		Username   string `json:"username"`
+		GlobalName string `json:"global_name"`
+		Email      string `json:"email"`
+		Verified   bool   `json:"verified"`
+		Avatar     string `json:"avatar"`
+	}{}
+	if err := getJSON(ctx, p.config.Client(ctx, token), p.apiBase+"/users/@me", &data); err != nil {
+		return nil, err
+	}
+
+	user := &dto.OAuthUser{
+		Provider:      p.Name(),
+		Subject:       data.Id,
+		Email:         data.Email,
+		EmailVerified: data.Verified,
+		Name:          data.GlobalName,
+		Username:      data.Username,
+	}
+	if user.Name == "" {
+		user.Name = data.Username
+	}
+	if data.Avatar != "" {
+		user.Picture = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", data.Id, data.Avatar)
+	}
+	return user, nil
+}
+
+// Generic OpenID Connect, configured through the issuer's discovery document
+
+type oidcProvider struct {
+	name        string
+	config      *oauth2.Config
+	userInfoURL string
+}
+
+func NewOIDCProvider(ctx context.Context, name string, issuer string, clientId string, clientSecret string, redirectUrl string) (*oidcProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	discovery := struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	}{}
+	wellKnown := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, http.DefaultClient, wellKnown, &discovery); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimRight(discovery.Issuer, "/") != strings.TrimRight(issuer, "/") {
+		return nil, fmt.Errorf("issuer mismatch: discovery document is for %s", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("discovery document of %s is missing endpoints", issuer)
+	}
+
+	return &oidcProvider{
+		name: name,
+		config: &oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectUrl,
+			Scopes:       []string{"openid", "email", "profile"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+		},
+		userInfoURL: discovery.UserinfoEndpoint,
+	}, nil
+}
+
+func (p *oidcProvider) Name() string           { return p.name }
+func (p *oidcProvider) Config() *oauth2.Config { return p.config }
+
+func (p *oidcProvider) UserInfo(ctx context.Context, token *oauth2.Token) (*dto.OAuthUser, error) {
+	claims := struct {
+		Sub               string `json:"sub"`
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+		Picture           string `json:"picture"`
+	}{}
+	if err := getJSON(ctx, p.config.Client(ctx, token), p.userInfoURL, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Sub == "" {
+		return nil, fmt.Errorf("userinfo response has no sub claim")
+	}
+
+	return &dto.OAuthUser{
+		Provider:      p.name,
+		Subject:       claims.Sub,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Username:      claims.PreferredUsername,
+		Picture:       claims.Picture,
+	}, nil
+}
@@ -0,0 +1,364 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeProvider is a minimal OAuth2 authorization server that enforces PKCE
+type fakeProvider struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	challenges map[string]string // code -> code_challenge
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	p := &fakeProvider{challenges: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		challenge, ok := p.challenges[r.Form.Get("code")]
+		delete(p.challenges, r.Form.Get("code"))
+		p.mu.Unlock()
+
+		if !ok || oauth2.S256ChallengeFromVerifier(r.Form.Get("code_verifier")) != challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"fake-access","token_type":"Bearer","expires_in":3600}`))
+	})
+	userinfo := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer fake-access" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		}
+	}
+	mux.HandleFunc("/userinfo", userinfo(`{"id":"42","email":"shiba@example.com","verified_email":true,"name":"Shiba Inu"}`))
+	mux.HandleFunc("/oidc/userinfo", userinfo(`{"sub":"oidc-7","email":"shiba@example.com","email_verified":true,"preferred_username":"shiba"}`))
+	mux.HandleFunc("/user", userinfo(`{"id":1001,"login":"shiba-gh","name":""}`))
+	mux.HandleFunc("/user/emails", userinfo(`[{"email":"old@example.com","primary":false,"verified":true},{"email":"shiba@example.com","primary":true,"verified":true}]`))
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"userinfo_endpoint":      p.URL + "/oidc/userinfo",
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// authorize plays the user approving the login: it records the challenge
+// sent on the authorize url and returns the code and state of the redirect.
+func (p *fakeProvider) authorize(t *testing.T, authUrl string) (string, string) {
+	u, err := url.Parse(authUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorize url is missing PKCE params: %s", authUrl)
+	}
+
+	code := "code-" + q.Get("state")[:8]
+	p.mu.Lock()
+	p.challenges[code] = q.Get("code_challenge")
+	p.mu.Unlock()
+
+	return code, q.Get("state")
+}
+
+func newOAuthTestService(t *testing.T) (*Service, *fakeProvider) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	p := newFakeProvider(t)
+
+	endpoint := oauth2.Endpoint{
+		AuthURL:  p.URL + "/authorize",
+		TokenURL: p.URL + "/token",
+	}
+
+	google := NewGoogleProvider("client", "secret", "http://localhost:9000/oauth/callback/google")
+	google.config.Endpoint = endpoint
+	google.userInfoURL = p.URL + "/userinfo"
+
+	github := NewGitHubProvider("client", "secret", "http://localhost:9000/oauth/callback/github")
+	github.config.Endpoint = endpoint
+	github.apiBase = p.URL
+
+	oidc, err := NewOIDCProvider(context.Background(), "oidc", p.URL, "client", "secret", "http://localhost:9000/oauth/callback/oidc")
+	if err != nil {
+		t.Fatalf("NewOIDCProvider: %v", err)
+	}
+
+	return &Service{
+		Ctx: context.Background(),
+		oauthProviders: map[string]OAuthProvider{
+			"google": google,
+			"github": github,
+			"oidc":   oidc,
+		},
+	}, p
+}
+
+func TestOAuthLoginFlow(t *testing.T) {
+	s, p := newOAuthTestService(t)
+
+	authUrl, cookie, err := s.BeginOAuthLogin("google", "")
+	if err != nil {
+		t.Fatalf("BeginOAuthLogin: %v", err)
+	}
+	if !strings.HasPrefix(authUrl, p.URL+"/authorize") {
+		t.Fatalf("unexpected auth url %s", authUrl)
+	}
+
+	code, state := p.authorize(t, authUrl)
+
+	user, _, err := s.CompleteOAuthLogin("google", state, code, cookie)
+	if err != nil {
+		t.Fatalf("CompleteOAuthLogin: %v", err)
+	}
+	if user.Provider != "google" || user.Subject != "42" || user.Email != "shiba@example.com" || user.Name != "Shiba Inu" {
+		t.Errorf("unexpected user %+v", user)
+	}
+}
+
+func TestOAuthGitHubUsesPrimaryEmail(t *testing.T) {
+	s, p := newOAuthTestService(t)
+
+	authUrl, cookie, err := s.BeginOAuthLogin("github", "")
+	if err != nil {
+		t.Fatalf("BeginOAuthLogin: %v", err)
+	}
+	code, state := p.authorize(t, authUrl)
+
+	user, _, err := s.CompleteOAuthLogin("github", state, code, cookie)
+	if err != nil {
+		t.Fatalf("CompleteOAuthLogin: %v", err)
+	}
+	if user.Subject != "1001" || user.Email != "shiba@example.com" || !user.EmailVerified || user.Name != "shiba-gh" {
+		t.Errorf("unexpected user %+v", user)
+	}
+}
+
+func TestOAuthOIDCDiscovery(t *testing.T) {
+	s, p := newOAuthTestService(t)
+
+	authUrl, cookie, err := s.BeginOAuthLogin("oidc", "user-1")
+	if err != nil {
+		t.Fatalf("BeginOAuthLogin: %v", err)
+	}
+	if !strings.HasPrefix(authUrl, p.URL+"/authorize") {
+		t.Fatalf("discovered auth url not used: %s", authUrl)
+	}
+	code, state := p.authorize(t, authUrl)
+
+	user, st, err := s.CompleteOAuthLogin("oidc", state, code, cookie)
+	if err != nil {
+		t.Fatalf("CompleteOAuthLogin: %v", err)
+	}
+	if user.Subject != "oidc-7" || user.Username != "shiba" {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if st.LinkUserId != "user-1" {
+		t.Errorf("link user lost in state: %+v", st)
+	}
+}
+
+func TestOAuthUnknownProvider(t *testing.T) {
+	s, _ := newOAuthTestService(t)
+
+	if _, _, err := s.BeginOAuthLogin("myspace", ""); err != ErrUnknownOAuthProvider {
+		t.Fatalf("expected ErrUnknownOAuthProvider, got %v", err)
+	}
+}
+
+func TestOAuthCallbackRejectsOtherProvider(t *testing.T) {
+	s, p := newOAuthTestService(t)
+
+	// A state issued for google can't be replayed on the github callback
+	authUrl, cookie, _ := s.BeginOAuthLogin("google", "")
+	code, state := p.authorize(t, authUrl)
+
+	if _, _, err := s.CompleteOAuthLogin("github", state, code, cookie); err == nil {
+		t.Fatal("expected provider mismatch error")
+	}
+}
+
+func TestOAuthStateIsRandom(t *testing.T) {
+	s, p := newOAuthTestService(t)
+
+	url1, _, _ := s.BeginOAuthLogin("google", "")
+	url2, _, _ := s.BeginOAuthLogin("google", "")
+	_, state1 := p.authorize(t, url1)
+	_, state2 := p.authorize(t, url2)
+
+	if state1 == state2 || state1 == "state" {
+		t.Fatalf("state must be random per login, got %q and %q", state1, state2)
+	}
+}
+
+func TestOAuthCallbackRejectsWrongState(t *testing.T) {
+	s, p := newOAuthTestService(t)
+
+	authUrl, cookie, _ := s.BeginOAuthLogin("google", "")
+	code, _ := p.authorize(t, authUrl)
+
+	if _, _, err := s.CompleteOAuthLogin("google", "attacker-state", code, cookie); err == nil {
+		t.Fatal("expected state mismatch error")
+	}
+}
+
+func TestOAuthCallbackRejectsTamperedCookie(t *testing.T) {
+	s, p := newOAuthTestService(t)
+
+	authUrl, _, _ := s.BeginOAuthLogin("google", "")
+	code, state := p.authorize(t, authUrl)
+
+	// Forge a cookie for the observed state without knowing the secret
+	forged, _ := json.Marshal(OAuthState{State: state, Verifier: "guess", Provider: "google", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	t.Setenv("JWT_SECRET", "other-secret")
+	cookie := lib.SignValue(forged)
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	if _, _, err := s.CompleteOAuthLogin("google", state, code, cookie); err == nil {
+		t.Fatal("expected invalid signature error")
+	}
+}
+
+func TestOAuthCallbackRejectsExpiredState(t *testing.T) {
+	s, p := newOAuthTestService(t)
+
+	authUrl, _, _ := s.BeginOAuthLogin("google", "")
+	code, state := p.authorize(t, authUrl)
+
+	expired, _ := json.Marshal(OAuthState{State: state, Verifier: "v", Provider: "google", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if _, _, err := s.CompleteOAuthLogin("google", state, code, lib.SignValue(expired)); err == nil {
+		t.Fatal("expected expired state error")
+	}
+}
+
+func TestOAuthCallbackRequiresMatchingVerifier(t *testing.T) {
+	s, p := newOAuthTestService(t)
+
+	// A valid cookie from one login can't be used to redeem another login's code
+	_, otherCookie, _ := s.BeginOAuthLogin("google", "")
+	authUrl, _, _ := s.BeginOAuthLogin("google", "")
+	code, _ := p.authorize(t, authUrl)
+
+	otherData, _ := lib.VerifySignedValue(otherCookie)
+	other := OAuthState{}
+	json.Unmarshal(otherData, &other)
+
+	if _, _, err := s.CompleteOAuthLogin("google", other.State, code, otherCookie); err == nil {
+		t.Fatal("expected token exchange to fail with the wrong verifier")
+	}
+}
+
+// Someone signed up with an address they don't own: logging in with a
+// provider that verified it must not land in their account
+func TestOAuthLoginLinksOnlyVerifiedEmails(t *testing.T) {
+	s := newTestService(t)
+	squatter := newTestUser(t, s, "victim")
+	google := &dto.OAuthUser{Provider: "google", Subject: "g-1", Email: "victim@example.com", EmailVerified: true}
+
+	if _, _, err := s.LoginWithOAuth(google, ""); errStatus(err) != http.StatusConflict {
+		t.Errorf("logging in over an unverified local email gave %v, want a conflict", err)
+	}
+	if _, err := s.Store.GetUserByIdentity(s.Ctx, "google", "g-1"); !lib.IsNoRows(err) {
+		t.Errorf("the identity was linked anyway: %v", err)
+	}
+
+	// Once the owner proves the address the accounts are linked
+	if err := s.Store.MarkEmailVerified(s.Ctx, squatter); err != nil {
+		t.Fatal(err)
+	}
+	user, created, err := s.LoginWithOAuth(google, "")
+	if err != nil || created || user.UserId != squatter {
+		t.Errorf("logging in over a verified email = %+v, %v, %v", user, created, err)
+	}
+
+	unverified := &dto.OAuthUser{Provider: "github", Subject: "gh-1", Email: "victim@example.com"}
+	if _, _, err := s.LoginWithOAuth(unverified, ""); errStatus(err) != http.StatusConflict {
+		t.Errorf("logging in with an unverified provider email gave %v, want a conflict", err)
+	}
+
+	// A new account made from a verified provider email is verified itself
+	fresh := &dto.OAuthUser{Provider: "google", Subject: "g-2", Email: "fresh@example.com", EmailVerified: true, Username: "fresh"}
+	user, created, err = s.LoginWithOAuth(fresh, "")
+	if err != nil || !created || !user.EmailVerified {
+		t.Errorf("signing up through google = %+v, %v, %v", user, created, err)
+	}
+}
+
+func TestOAuthLinkSecondAccountOfProvider(t *testing.T) {
+	s := newTestService(t)
+	ada := newTestUser(t, s, "ada")
+
+	first := &dto.OAuthUser{Provider: "google", Subject: "g-1", Email: "ada@example.com"}
+	if _, _, err := s.LoginWithOAuth(first, ada); err != nil {
+		t.Fatal(err)
+	}
+	second := &dto.OAuthUser{Provider: "google", Subject: "g-2", Email: "ada@example.com"}
+	if _, _, err := s.LoginWithOAuth(second, ada); errStatus(err) != http.StatusConflict {
+		t.Errorf("linking a second google account gave %v, want a conflict", err)
+	}
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	s := newTestService(t)
+	ada := newTestUser(t, s, "ada")
+
+	if err := s.UnlinkIdentity(ada, "google"); errStatus(err) != http.StatusNotFound {
+		t.Errorf("unlinking a provider that isn't linked gave %v, want not found", err)
+	}
+
+	// The password still signs in, so the only identity can go
+	google := &dto.OAuthUser{Provider: "google", Subject: "g-1", Email: "ada@example.com"}
+	if _, _, err := s.LoginWithOAuth(google, ada); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnlinkIdentity(ada, "google"); err != nil {
+		t.Fatalf("unlinking the only identity of an account with a password gave %v", err)
+	}
+	if identities, _ := s.GetIdentities(ada); len(identities) != 0 {
+		t.Errorf("identities after the unlink = %+v", identities)
+	}
+
+	// Without a password it is the only way in
+	if _, _, err := s.LoginWithOAuth(google, ada); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store.UpdatePasswordHash(s.Ctx, ada, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnlinkIdentity(ada, "google"); errStatus(err) != http.StatusConflict {
+		t.Errorf("unlinking the only sign in method gave %v, want a conflict", err)
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sideDesert/shiba/internal/server/lib"
+)
+
+const (
+	minPasswordLength     = 8
+	maxPasswordBytes      = 72 // bcrypt refuses longer passwords
+	passwordResetLifetime = time.Hour
+)
+
+var (
+	failedLoginsPerAccount = attemptLimiter{name: "login-account", max: 5, window: 15 * time.Minute}
+	failedLoginsPerIP      = attemptLimiter{name: "login-ip", max: 20, window: 15 * time.Minute}
+	// Each request mails the account, the limits keep it from being flooded
+	resetRequestsPerEmail = attemptLimiter{name: "reset-email", max: 3, window: time.Hour}
+	resetRequestsPerIP    = attemptLimiter{name: "reset-ip", max: 10, window: time.Hour}
+)
+
+var (
+	ErrInvalidCredentials = lib.NewApiError(http.StatusUnauthorized, "invalid_credentials", "Invalid email or password")
+	ErrTooManyAttempts    = lib.TooManyRequests("Too many failed login attempts, try again later")
+	ErrTooManyResets      = lib.TooManyRequests("Too many password reset requests, try again later")
+)
+
+// Compared against when the email does not exist so that a login for an
+// unknown account takes as long as one with a wrong password.
+var dummyPasswordHash, _ = lib.HashPassword("shiba-dummy-password")
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return lib.NewApiError(http.StatusBadRequest, lib.CodeValidation, fmt.Sprintf("Password must be at least %d characters", minPasswordLength)).
+			WithDetails(map[string]any{"field": "password", "min_length": minPasswordLength})
+	}
+	if len(password) > maxPasswordBytes {
+		return lib.NewApiError(http.StatusBadRequest, lib.CodeValidation, fmt.Sprintf("Password must be at most %d bytes", maxPasswordBytes)).
+			WithDetails(map[string]any{"field": "password", "max_bytes": maxPasswordBytes})
+	}
+	return nil
+}
+
+// LoginWithPassword checks email/password and returns the user. Failed
+// attempts are counted per account and per ip.
+func (s *Service) LoginWithPassword(email string, password string, ip string) (*lib.User, error) {
+	accountKey := strings.ToLower(strings.TrimSpace(email))
+
+	if s.attemptsBlocked(failedLoginsPerAccount, accountKey) || s.attemptsBlocked(failedLoginsPerIP, ip) {
+		log.Println("Error in LoginWithPassword: too many attempts for", accountKey, ip)
+		return nil, ErrTooManyAttempts
+	}
+
+	user, err := s.Store.GetUserByEmail(s.Ctx, strings.TrimSpace(email))
+	if err != nil {
+		lib.CheckPassword(dummyPasswordHash, password)
+		s.recordAttempt(failedLoginsPerAccount, accountKey)
+		s.recordAttempt(failedLoginsPerIP, ip)
+		return nil, ErrInvalidCredentials
+	}
+
+	if !lib.CheckPassword(user.PasswordHash, password) {
+		s.recordAttempt(failedLoginsPerAccount, accountKey)
+		s.recordAttempt(failedLoginsPerIP, ip)
+		return nil, ErrInvalidCredentials
+	}
+
+	s.clearAttempts(failedLoginsPerAccount, accountKey)
+	return user, nil
+}
+
+// ChangePassword replaces the password of userId and logs out every other
+// session, whoever knew the old password may still be logged in there
+func (s *Service) ChangePassword(userId string, sessionId string, currentPassword string, newPassword string) error {
+	user, err := s.Store.GetUserById(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in ChangePassword[GetUserById]:", err)
+		return err
+	}
+
+	if !lib.CheckPassword(user.PasswordHash, currentPassword) {
+		return lib.NewApiError(http.StatusForbidden, "invalid_credentials", "Current password is incorrect")
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	hash, err := lib.HashPassword(newPassword)
+	if err != nil {
+		log.Println("Error in ChangePassword[HashPassword]:", err)
+		return err
+	}
+	if err := s.Store.UpdatePasswordHash(s.Ctx, userId, hash); err != nil {
+		return err
+	}
+	return s.LogoutOtherSessions(userId, sessionId)
+}
+
+// RequestPasswordReset emails a reset link if the account exists. It never
+// tells the caller whether the email is registered, requests are limited
+// per email and per ip either way.
+func (s *Service) RequestPasswordReset(email string, ip string) error {
+	emailKey := strings.ToLower(strings.TrimSpace(email))
+	if s.attemptsBlocked(resetRequestsPerEmail, emailKey) || s.attemptsBlocked(resetRequestsPerIP, ip) {
+		log.Println("Error in RequestPasswordReset: too many requests for", emailKey, ip)
+		return ErrTooManyResets
+	}
+	s.recordAttempt(resetRequestsPerEmail, emailKey)
+	s.recordAttempt(resetRequestsPerIP, ip)
+
+	user, err := s.Store.GetUserByEmail(s.Ctx, strings.TrimSpace(email))
+	if err != nil {
+		log.Println("RequestPasswordReset: no user for", email)
+		return nil
+	}
+
+	token, err := lib.GenerateSecureRandomID(32)
+	if err != nil {
+		return err
+	}
+
+	err = s.Store.CreatePasswordReset(s.Ctx, user.UserId, lib.HashToken(token), passwordResetLifetime)
+	if err != nil {
+		log.Println("Error in RequestPasswordReset[CreatePasswordReset]:", err)
+		return err
+	}
+
+	link := lib.Client("/reset-password?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your Shiba account.\n"+
+		"Open the link below within the next hour to choose a new one:\n\n%s\n\n"+
+		"If it wasn't you, you can ignore this email.\n", user.Name, link)
+
+	if err := s.mailer.Send(s.Ctx, user.Email, "Reset your Shiba password", body); err != nil {
+		log.Println("Error in RequestPasswordReset[Send]:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Service) ResetPassword(token string, newPassword string) error {
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	userId, err := s.Store.ConsumePasswordReset(s.Ctx, lib.HashToken(token))
+	if err != nil {
+		return lib.NewApiError(http.StatusBadRequest, "invalid_token", "Reset link is invalid or has expired")
+	}
+
+	hash, err := lib.HashPassword(newPassword)
+	if err != nil {
+		log.Println("Error in ResetPassword[HashPassword]:", err)
+		return err
+	}
+	if err := s.Store.UpdatePasswordHash(s.Ctx, userId, hash); err != nil {
+		return err
+	}
+	// The link was mailed to the account's address, so the user owns it
+	if err := s.Store.MarkEmailVerified(s.Ctx, userId); err != nil {
+		log.Println("Error in ResetPassword[MarkEmailVerified]:", err)
+		return err
+	}
+
+	// Whoever knew the old password may still be logged in somewhere
+	return s.LogoutEverywhere(userId)
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sideDesert/shiba/internal/server/lib"
+)
+
+func TestChangePassword(t *testing.T) {
+	s := newTestService(t)
+	ada := newTestUser(t, s, "ada")
+
+	current, err := s.StartSession(ada, "laptop", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.StartSession(ada, "phone", "127.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ChangePassword(ada, current.SessionId, "wrong password", "new password"); errStatus(err) != http.StatusForbidden {
+		t.Errorf("a wrong current password gave %v, want forbidden", err)
+	}
+	if err := s.ChangePassword(ada, current.SessionId, "correct horse battery", "short"); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("a short password gave %v, want a bad request", err)
+	}
+	// 40 characters but 80 bytes, more than bcrypt takes
+	if err := s.ChangePassword(ada, current.SessionId, "correct horse battery", strings.Repeat("é", 40)); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("a password over 72 bytes gave %v, want a bad request", err)
+	}
+	if !s.SessionActive(other.SessionId) {
+		t.Fatal("a refused change logged out the other session")
+	}
+
+	if err := s.ChangePassword(ada, current.SessionId, "correct horse battery", strings.Repeat("é", 36)); err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.Store.GetUserById(s.Ctx, ada)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lib.CheckPassword(user.PasswordHash, strings.Repeat("é", 36)) {
+		t.Error("the new password doesn't match")
+	}
+
+	// Whoever knew the old password is logged out, not the one changing it
+	if !s.SessionActive(current.SessionId) {
+		t.Error("the session that changed the password was logged out")
+	}
+	if s.SessionActive(other.SessionId) {
+		t.Error("another session is still active after the change")
+	}
+}
+
+func TestLoginAttemptsLimited(t *testing.T) {
+	s := newTestService(t)
+	newTestUser(t, s, "ada")
+
+	for i := 0; i < failedLoginsPerAccount.max; i++ {
+		if _, err := s.LoginWithPassword("ada@example.com", "wrong password", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d gave %v, want invalid credentials", i, err)
+		}
+	}
+	// Even the right password is refused now, from any ip
+	if _, err := s.LoginWithPassword(" ADA@example.com", "correct horse battery", "10.0.0.2"); errStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("a login after %d failures gave %v, want too many requests", failedLoginsPerAccount.max, err)
+	}
+
+	// A successful login forgets the failures of the account
+	newTestUser(t, s, "eve")
+	for i := 0; i < failedLoginsPerAccount.max-1; i++ {
+		s.LoginWithPassword("eve@example.com", "wrong password", "10.0.0.3")
+	}
+	if _, err := s.LoginWithPassword("eve@example.com", "correct horse battery", "10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoginWithPassword("eve@example.com", "wrong password", "10.0.0.3"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("a failure after a successful login gave %v, want invalid credentials", err)
+	}
+}
+
+func TestRequestPasswordResetLimited(t *testing.T) {
+	s := newTestService(t)
+	newTestUser(t, s, "ada")
+
+	for i := 0; i < resetRequestsPerEmail.max; i++ {
+		if err := s.RequestPasswordReset("ada@example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.RequestPasswordReset("Ada@example.com ", "10.0.0.2"); !errors.Is(err, ErrTooManyResets) {
+		t.Errorf("request %d for the email gave %v, want too many resets", resetRequestsPerEmail.max+1, err)
+	}
+
+	// Unknown emails are counted too, or the limit would tell them apart
+	for i := 0; i < resetRequestsPerEmail.max; i++ {
+		s.RequestPasswordReset("nobody@example.com", "10.0.0.3")
+	}
+	if err := s.RequestPasswordReset("nobody@example.com", "10.0.0.4"); !errors.Is(err, ErrTooManyResets) {
+		t.Errorf("an unknown email wasn't limited, got %v", err)
+	}
+
+	// One ip asking for many emails
+	for i := 0; i < resetRequestsPerIP.max; i++ {
+		s.RequestPasswordReset(fmt.Sprintf("user%d@example.com", i), "10.0.0.5")
+	}
+	if err := s.RequestPasswordReset("someone@example.com", "10.0.0.5"); !errors.Is(err, ErrTooManyResets) {
+		t.Errorf("request %d from the ip gave %v, want too many resets", resetRequestsPerIP.max+1, err)
+	}
+}
+
+func TestSweepAttempts(t *testing.T) {
+	s := newTestService(t)
+	s.recordAttempt(failedLoginsPerAccount, "ada@example.com")
+
+	if swept, err := s.SweepAttempts(); err != nil || swept != 0 {
+		t.Errorf("SweepAttempts = %d, %v, want a recent attempt to be kept", swept, err)
+	}
+	if !s.attemptsBlocked(attemptLimiter{name: failedLoginsPerAccount.name, max: 1, window: failedLoginsPerAccount.window}, "ada@example.com") {
+		t.Error("the attempt is gone after the sweep")
+	}
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/unfurl"
+)
+
+// Links beyond this in a single message are not unfurled
+const maxPreviewsPerMessage = 3
+
+// UnfurlMessage fetches previews for the links in content and attaches them
+// to the stored message. It does network IO so callers run it off the
+// websocket read loop.
+func (s *Service) UnfurlMessage(messageId string, content string) ([]lib.LinkPreview, error) {
+	urls := unfurl.ExtractURLs(content, maxPreviewsPerMessage)
+	previews := make([]lib.LinkPreview, 0, len(urls))
+
+	for _, u := range urls {
+		p, err := s.unfurler.Unfurl(context.Background(), u)
+		if err != nil {
+			log.Println("Error in UnfurlMessage[Unfurl]:", u, err)
+			continue
+		}
+		previews = append(previews, lib.LinkPreview{
+			MessageId:   messageId,
+			Url:         u,
+			Title:       p.Title,
+			Description: p.Description,
+			Image:       p.Image,
+			SiteName:    p.SiteName,
+			Type:        p.Type,
+		})
+	}
+
+	if len(previews) == 0 {
+		return previews, nil
+	}
+
+	if err := s.Store.StoreLinkPreviews(s.Ctx, previews); err != nil {
+		log.Println("Error in UnfurlMessage[StoreLinkPreviews]:", err)
+		return nil, err
+	}
+	return previews, nil
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+const (
+	AvatarMaxBytes = 5 << 20
+
+	avatarSize = 256
+	// Decoding is refused above this many pixels, whatever the file size
+	avatarMaxPixels = 40_000_000
+)
+
+var ErrUsernameTaken = lib.Conflict("This username is taken")
+
+var avatarIdRe = regexp.MustCompile(`^[0-9a-f]+$`)
+
+func avatarKey(userId string, avatarId string) string {
+	return "avatars/" + userId + "/" + avatarId + ".jpg"
+}
+
+func avatarUrl(userId string, avatarId string) string {
+	return lib.ApiPrefix + "/users/" + userId + "/avatar/" + avatarId
+}
+
+func profileResponse(user *lib.User) *dto.UserProfileResponse {
+	return &dto.UserProfileResponse{
+		UserId:         user.UserId,
+		Name:           user.Name,
+		Username:       user.Username,
+		ProfilePicture: user.ProfilePicture.String,
+		Bio:            user.Bio,
+	}
+}
+
+// UpdateProfile changes the name, username or bio of userId and tells their
+// friends about it
+func (s *Service) UpdateProfile(userId string, req dto.UpdateUserRequest) (*dto.UserResponse, error) {
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return nil, lib.BadRequest("Name can't be empty")
+		}
+		req.Name = &name
+	}
+	if req.Username != nil && *req.Username == "" {
+		return nil, lib.BadRequest("Username can't be empty")
+	}
+	if req.Bio != nil {
+		bio := strings.TrimSpace(*req.Bio)
+		req.Bio = &bio
+	}
+
+	if err := s.Store.UpdateUserProfile(s.Ctx, userId, req); err != nil {
+		log.Println("Error in UpdateProfile:", err)
+		if lib.IsUniqueViolation(err, "users_username_idx") {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+
+	s.publishProfile(userId)
+	return s.GetUserResponse(userId)
+}
+
+// readAvatar reads an uploaded image and returns it cropped and scaled to a
+// square JPEG, user and chatroom avatars both go through it
+func readAvatar(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, AvatarMaxBytes+1))
+	if err != nil {
+		log.Println("Error in readAvatar[ReadAll]:", err)
+		return nil, err
+	}
+	if len(data) > AvatarMaxBytes {
+		return nil, lib.NewApiError(http.StatusRequestEntityTooLarge, lib.CodePayloadTooLarge, fmt.Sprintf("Avatar is larger than %d bytes", AvatarMaxBytes))
+	}
+	if len(data) == 0 {
+		return nil, lib.BadRequest("File is empty")
+	}
+
+	contentType := http.DetectContentType(data)
+	if !lib.Contains(thumbnailTypes, contentType) {
+		return nil, lib.NewApiError(http.StatusUnsupportedMediaType, lib.CodeUnsupportedType, fmt.Sprintf("File type %s can't be used as an avatar", contentType))
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > avatarMaxPixels {
+		return nil, lib.BadRequest("Image can't be used as an avatar")
+	}
+
+	avatar, err := lib.Avatar(bytes.NewReader(data), avatarSize)
+	if err != nil {
+		log.Println("Error in readAvatar[Avatar]:", err)
+		return nil, lib.BadRequest("Image can't be used as an avatar")
+	}
+	return avatar, nil
+}
+
+// UploadAvatar crops and scales an image to a square avatar and makes it the
+// profile picture of userId. The previous avatar is removed.
+func (s *Service) UploadAvatar(userId string, r io.Reader) (*dto.UserResponse, error) {
+	avatar, err := readAvatar(r)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.Store.GetUserById(s.Ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	avatarId, err := lib.GenerateSecureRandomID(16)
+	if err != nil {
+		return nil, err
+	}
+
+	key := avatarKey(userId, avatarId)
+	if err := s.Blob.Put(s.Ctx, key, bytes.NewReader(avatar), int64(len(avatar)), "image/jpeg"); err != nil {
+		log.Println("Error in UploadAvatar[Blob.Put]:", err)
+		return nil, err
+	}
+
+	if err := s.Store.UpdateProfilePicture(s.Ctx, userId, avatarUrl(userId, avatarId)); err != nil {
+		log.Println("Error in UploadAvatar[UpdateProfilePicture]:", err)
+		s.Blob.Delete(s.Ctx, key)
+		return nil, err
+	}
+
+	s.deleteAvatar(user)
+	s.publishProfile(userId)
+	return s.GetUserResponse(userId)
+}
+
+// RemoveAvatar clears the profile picture of userId
+func (s *Service) RemoveAvatar(userId string) (*dto.UserResponse, error) {
+	user, err := s.Store.GetUserById(s.Ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Store.UpdateProfilePicture(s.Ctx, userId, ""); err != nil {
+		log.Println("Error in RemoveAvatar:", err)
+		return nil, err
+	}
+
+	s.deleteAvatar(user)
+	s.publishProfile(userId)
+	return s.GetUserResponse(userId)
+}
+
+// OpenAvatar returns an avatar uploaded by userId. Avatars are public to
+// signed in users, their ids are random so they can be cached forever.
+func (s *Service) OpenAvatar(userId string, avatarId string) (io.ReadCloser, error) {
+	if !avatarIdRe.MatchString(avatarId) {
+		return nil, lib.NotFound("Avatar not found")
+	}
+
+	file, err := s.Blob.Get(s.Ctx, avatarKey(userId, avatarId))
+	if err != nil {
+		log.Println("Error in OpenAvatar[Blob.Get]:", err)
+		return nil, lib.NotFound("Avatar not found")
+	}
+	return file, nil
+}
+
+// deleteAvatar removes the blob behind the current profile picture of user
+// when it is an uploaded avatar, pictures from OAuth providers are only urls
+func (s *Service) deleteAvatar(user *lib.User) {
+	prefix := avatarUrl(user.UserId, "")
+	if !user.ProfilePicture.Valid || !strings.HasPrefix(user.ProfilePicture.String, prefix) {
+		return
+	}
+
+	avatarId := strings.TrimPrefix(user.ProfilePicture.String, prefix)
+	if err := s.Blob.Delete(s.Ctx, avatarKey(user.UserId, avatarId)); err != nil {
+		log.Println("Error in deleteAvatar[Blob.Delete]:", err)
+	}
+}
+
+// publishProfile sends the profile of userId to their friends and their own
+// other devices as a user.updated event
+func (s *Service) publishProfile(userId string) {
+	user, err := s.Store.GetUserById(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in publishProfile[GetUserById]:", err)
+		return
+	}
+	friends, err := s.Store.GetFriendsByUserId(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in publishProfile[GetFriendsByUserId]:", err)
+		return
+	}
+
+	profile := profileResponse(user)
+	s.publishToUser(userId, "user.updated", profile)
+	for _, friend := range friends {
+		s.publishToUser(friend.UserId, "user.updated", profile)
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"sideDesert/shiba/internal/server/blob"
+	"sideDesert/shiba/internal/server/dto"
+)
+
+type recordingPublisher struct {
+	mu       sync.Mutex
+	messages map[string][]dto.Message[json.RawMessage]
+}
+
+func (p *recordingPublisher) Publish(subject string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	msg := dto.Message[json.RawMessage]{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	if p.messages == nil {
+		p.messages = make(map[string][]dto.Message[json.RawMessage])
+	}
+	p.messages[subject] = append(p.messages[subject], msg)
+	return nil
+}
+
+// subjects lists the subjects of the messages sent to userId
+func (p *recordingPublisher) subjects(userId string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subjects := []string{}
+	for _, msg := range p.messages[UserNotificationsSubject(userId)] {
+		subjects = append(subjects, msg.Subject)
+	}
+	return subjects
+}
+
+func newTestPNG(t *testing.T, w int, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUpdateProfile(t *testing.T) {
+	s := newTestService(t)
+	publisher := &recordingPublisher{}
+	s.SetPublisher(publisher)
+	ada, bob, eve := newTestUser(t, s, "ada"), newTestUser(t, s, "bob"), newTestUser(t, s, "eve")
+
+	sent, _ := s.SendFriendRequest(ada, bob)
+	if _, err := s.HandleFriendRequest(bob, sent.RequestId, FriendAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	name, username, bio := "  Ada Lovelace ", "countess", "Engines"
+	user, err := s.UpdateProfile(ada, dto.UpdateUserRequest{Name: &name, Username: &username, Bio: &bio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Ada Lovelace" || user.Username != "countess" || user.Bio != "Engines" {
+		t.Errorf("UpdateProfile = %+v", user)
+	}
+
+	for _, userId := range []string{ada, bob} {
+		if subjects := publisher.subjects(userId); !strings.Contains(strings.Join(subjects, " "), "user.updated") {
+			t.Errorf("%s got %v, want a user.updated event", userId, subjects)
+		}
+	}
+	if subjects := publisher.subjects(eve); len(subjects) != 0 {
+		t.Errorf("a stranger got %v", subjects)
+	}
+
+	taken := "BOB"
+	if _, err := s.UpdateProfile(eve, dto.UpdateUserRequest{Username: &taken}); errStatus(err) != http.StatusConflict {
+		t.Errorf("taking a username gave %v, want a conflict", err)
+	}
+	blank := "  "
+	if _, err := s.UpdateProfile(eve, dto.UpdateUserRequest{Name: &blank}); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("a blank name gave %v, want a bad request", err)
+	}
+}
+
+func TestUploadAvatar(t *testing.T) {
+	s := newTestService(t)
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blob = blobs
+	ada := newTestUser(t, s, "ada")
+
+	user, err := s.UploadAvatar(ada, bytes.NewReader(newTestPNG(t, 600, 400)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := user.ProfilePicture
+	if !strings.HasPrefix(first, avatarUrl(ada, "")) {
+		t.Fatalf("profile picture = %q, want an avatar url", first)
+	}
+
+	avatarId := strings.TrimPrefix(first, avatarUrl(ada, ""))
+	file, err := s.OpenAvatar(ada, avatarId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != avatarSize || b.Dy() != avatarSize {
+		t.Errorf("avatar is %dx%d, want %dx%d", b.Dx(), b.Dy(), avatarSize, avatarSize)
+	}
+
+	// A new avatar replaces the old file
+	user, err = s.UploadAvatar(ada, bytes.NewReader(newTestPNG(t, 50, 80)))
+	if err != nil || user.ProfilePicture == first {
+		t.Fatalf("second UploadAvatar = %+v, %v", user, err)
+	}
+	if _, err := s.OpenAvatar(ada, avatarId); errStatus(err) != http.StatusNotFound {
+		t.Errorf("the previous avatar gave %v, want not found", err)
+	}
+
+	if _, err := s.UploadAvatar(ada, strings.NewReader("not an image")); errStatus(err) != http.StatusUnsupportedMediaType {
+		t.Errorf("a text file gave %v, want unsupported media type", err)
+	}
+	if _, err := s.OpenAvatar(ada, "../../etc"); errStatus(err) != http.StatusNotFound {
+		t.Errorf("a malformed avatar id gave %v, want not found", err)
+	}
+
+	user, err = s.RemoveAvatar(ada)
+	if err != nil || user.ProfilePicture != "" {
+		t.Errorf("RemoveAvatar = %+v, %v", user, err)
+	}
+}
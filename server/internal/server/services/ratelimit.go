@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Attempts older than the longest window are swept this often
+const attemptSweepInterval = 10 * time.Minute
+
+// attemptLimiters are all the limiters, SweepAttempts keeps what the longest
+// window needs
+var attemptLimiters = []attemptLimiter{
+	failedLoginsPerAccount,
+	failedLoginsPerIP,
+	resetRequestsPerEmail,
+	resetRequestsPerIP,
+}
+
+// attemptLimiter counts attempts (e.g. wrong passwords) per key inside a
+// sliding window and blocks the key once max attempts are reached. The
+// attempts are kept in the store so every API node counts the same ones.
+type attemptLimiter struct {
+	// Keeps the keys of the limiters apart in the store
+	name   string
+	max    int
+	window time.Duration
+}
+
+func (s *Service) attemptsBlocked(l attemptLimiter, key string) bool {
+	count, err := s.Store.CountAttempts(s.Ctx, l.name+":"+key, l.window)
+	if err != nil {
+		// Nothing is counted while the store is down, nothing gets blocked
+		log.Println("Error in attemptsBlocked[CountAttempts]:", l.name, err)
+		return false
+	}
+	return count >= l.max
+}
+
+func (s *Service) recordAttempt(l attemptLimiter, key string) {
+	if err := s.Store.RecordAttempt(s.Ctx, l.name+":"+key); err != nil {
+		log.Println("Error in recordAttempt[RecordAttempt]:", l.name, err)
+	}
+}
+
+func (s *Service) clearAttempts(l attemptLimiter, key string) {
+	if err := s.Store.ClearAttempts(s.Ctx, l.name+":"+key); err != nil {
+		log.Println("Error in clearAttempts[ClearAttempts]:", l.name, err)
+	}
+}
+
+// SweepAttempts deletes the attempts no limiter looks at anymore
+func (s *Service) SweepAttempts() (int64, error) {
+	longest := time.Duration(0)
+	for _, l := range attemptLimiters {
+		longest = max(longest, l.window)
+	}
+	return s.Store.DeleteAttemptsOlderThan(s.Ctx, longest)
+}
+
+// RunAttemptSweeper calls SweepAttempts every attemptSweepInterval until ctx
+// is done
+func (s *Service) RunAttemptSweeper(ctx context.Context) {
+	ticker := time.NewTicker(attemptSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if swept, err := s.SweepAttempts(); err != nil {
+			log.Println("Error in RunAttemptSweeper:", err)
+		} else if swept > 0 {
+			log.Printf("Swept %d attempts", swept)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sideDesert/shiba/internal/server/lib"
+)
+
+const (
+	// How long SessionActive trusts its cache before asking the db again
+	sessionCacheTTL = 30 * time.Second
+
+	// Two requests racing to refresh the same cookie both present the old
+	// token, the loser is not treated as a replayed token within this window
+	refreshReuseGrace = 30 * time.Second
+)
+
+var ErrSessionExpired = lib.NewApiError(http.StatusUnauthorized, "session_expired", "Session expired, please log in again")
+
+type sessionCacheEntry struct {
+	active    bool
+	checkedAt time.Time
+}
+
+type sessionCache struct {
+	mu      sync.Mutex
+	entries map[int32]sessionCacheEntry
+}
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{entries: make(map[int32]sessionCacheEntry)}
+}
+
+func (c *sessionCache) get(id int32) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Since(entry.checkedAt) > sessionCacheTTL {
+		delete(c.entries, id)
+		return false, false
+	}
+	return entry.active, true
+}
+
+func (c *sessionCache) set(id int32, active bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[id] = sessionCacheEntry{active: active, checkedAt: time.Now()}
+}
+
+func parseSessionId(sessionId string) (int32, error) {
+	id, err := strconv.ParseInt(sessionId, 10, 32)
+	if err != nil {
+		return 0, lib.Unauthorized(fmt.Sprintf("Invalid session id %q", sessionId))
+	}
+	return int32(id), nil
+}
+
+func (s *Service) issueAccessToken(userId string, sessionId int32, refreshToken string) (*lib.SessionTokens, error) {
+	sid := strconv.Itoa(int(sessionId))
+	accessToken, err := lib.CreateToken(userId, sid)
+	if err != nil {
+		log.Println("Error in issueAccessToken[CreateToken]:", err)
+		return nil, err
+	}
+
+	return &lib.SessionTokens{
+		UserId:       userId,
+		SessionId:    sid,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    time.Now().Add(lib.AccessTokenLifetime),
+	}, nil
+}
+
+// StartSession creates a session for a user who just logged in. Logging in
+// cancels a pending account deletion.
+func (s *Service) StartSession(userId string, userAgent string, ip string) (*lib.SessionTokens, error) {
+	refreshToken, err := lib.GenerateSecureRandomID(32)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &lib.Session{
+		UserId:    userId,
+		Token:     lib.HashToken(refreshToken),
+		UserAgent: sql.NullString{String: userAgent, Valid: userAgent != ""},
+		Ip:        sql.NullString{String: ip, Valid: ip != ""},
+		ExpiresAt: time.Now().Add(lib.RefreshTokenLifetime),
+	}
+	if err := s.Store.CreateSession(s.Ctx, session); err != nil {
+		log.Println("Error in StartSession[CreateSession]:", err)
+		return nil, err
+	}
+	s.sessions.set(session.Id, true)
+	s.cancelAccountDeletion(userId)
+
+	return s.issueAccessToken(userId, session.Id, refreshToken)
+}
+
+// RefreshSession rotates a refresh token and issues a new access token.
+// Presenting an already rotated token outside of refreshReuseGrace means it
+// was copied, the whole session is revoked then.
+func (s *Service) RefreshSession(refreshToken string) (*lib.SessionTokens, error) {
+	tokenHash := lib.HashToken(refreshToken)
+
+	session, err := s.Store.GetSessionByToken(s.Ctx, tokenHash)
+	if err != nil {
+		return nil, ErrSessionExpired
+	}
+	if session.RevokedAt.Valid || time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+
+	if session.Token != tokenHash {
+		if session.RotatedAt.Valid && time.Since(session.RotatedAt.Time) < refreshReuseGrace {
+			return s.issueAccessToken(session.UserId, session.Id, "")
+		}
+
+		log.Println("RefreshSession: refresh token reused, revoking session", session.Id)
+		if err := s.Store.RevokeSession(s.Ctx, session.UserId, session.Id); err != nil {
+			log.Println("Error in RefreshSession[RevokeSession]:", err)
+		}
+		s.sessions.set(session.Id, false)
+		return nil, ErrSessionExpired
+	}
+
+	newToken, err := lib.GenerateSecureRandomID(32)
+	if err != nil {
+		return nil, err
+	}
+
+	rotated, err := s.Store.RotateSession(s.Ctx, session.Id, tokenHash, lib.HashToken(newToken), time.Now().Add(lib.RefreshTokenLifetime))
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// Lost the race against a concurrent refresh, its response carries
+		// the new refresh cookie
+		return s.issueAccessToken(session.UserId, session.Id, "")
+	}
+
+	return s.issueAccessToken(session.UserId, session.Id, newToken)
+}
+
+// SessionActive is checked on every authenticated request
+func (s *Service) SessionActive(sessionId string) bool {
+	id, err := parseSessionId(sessionId)
+	if err != nil {
+		return false
+	}
+
+	if active, ok := s.sessions.get(id); ok {
+		return active
+	}
+
+	active, err := s.Store.IsSessionActive(s.Ctx, id)
+	if err != nil {
+		// Not cached, so the next request asks the db again
+		return false
+	}
+	s.sessions.set(id, active)
+	return active
+}
+
+// Logout revokes the session the request was made with
+func (s *Service) Logout(userId string, sessionId string) error {
+	id, err := parseSessionId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Store.RevokeSession(s.Ctx, userId, id); err != nil {
+		log.Println("Error in Logout[RevokeSession]:", err)
+		return err
+	}
+	s.sessions.set(id, false)
+	return nil
+}
+
+// LogoutEverywhere revokes every session of the user, on all devices
+func (s *Service) LogoutEverywhere(userId string) error {
+	return s.revokeUserSessions(userId, 0)
+}
+
+// LogoutOtherSessions revokes every session of the user but sessionId
+func (s *Service) LogoutOtherSessions(userId string, sessionId string) error {
+	id, err := parseSessionId(sessionId)
+	if err != nil {
+		return err
+	}
+	return s.revokeUserSessions(userId, id)
+}
+
+func (s *Service) revokeUserSessions(userId string, exceptId int32) error {
+	ids, err := s.Store.RevokeUserSessions(s.Ctx, userId, exceptId)
+	if err != nil {
+		log.Println("Error in revokeUserSessions[RevokeUserSessions]:", err)
+		return err
+	}
+
+	for _, id := range ids {
+		s.sessions.set(id, false)
+	}
+	return nil
+}
+
+func (s *Service) GetSessions(userId string) ([]lib.Session, error) {
+	sessions, err := s.Store.GetActiveSessionsByUserId(s.Ctx, userId)
+	if err != nil {
+		log.Println("Error in GetSessions:", err)
+		return nil, err
+	}
+	return sessions, nil
+}
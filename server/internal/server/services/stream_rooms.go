@@ -0,0 +1,52 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"sideDesert/shiba/internal/server/lib"
+)
+
+// Rooms whose worker hasn't reported them for StreamRoomTTL can be claimed
+// by another worker
+const StreamRoomTTL = 30 * time.Second
+
+// ClaimStreamRoom records that workerId streams chatroomId. It is a conflict
+// when another worker streams it already.
+func (s *Service) ClaimStreamRoom(chatroomId string, workerId string) error {
+	owner, err := s.Store.ClaimStreamRoom(s.Ctx, chatroomId, workerId, StreamRoomTTL)
+	if err != nil {
+		log.Println("Error in ClaimStreamRoom:", err)
+		return err
+	}
+	if owner != workerId {
+		return lib.Conflict("Streaming already taking place for this chatroom")
+	}
+	return nil
+}
+
+func (s *Service) ReleaseStreamRoom(chatroomId string, workerId string) {
+	if err := s.Store.ReleaseStreamRoom(s.Ctx, chatroomId, workerId); err != nil {
+		log.Println("Error in ReleaseStreamRoom:", err)
+	}
+}
+
+// StreamRoomWorker returns the worker streaming chatroomId, empty when nobody
+// is
+func (s *Service) StreamRoomWorker(chatroomId string) (string, error) {
+	workerId, err := s.Store.GetStreamRoomNode(s.Ctx, chatroomId, StreamRoomTTL)
+	if lib.IsNoRows(err) {
+		return "", nil
+	}
+	return workerId, err
+}
+
+// RefreshStreamRooms keeps the claims of the rooms a worker reports alive
+func (s *Service) RefreshStreamRooms(workerId string, chatroomIds []string) {
+	if len(chatroomIds) == 0 {
+		return
+	}
+	if _, err := s.Store.RefreshStreamRooms(s.Ctx, workerId, chatroomIds); err != nil {
+		log.Println("Error in RefreshStreamRooms:", err)
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+// A worker that missed this many status updates is considered gone
+const workerTTL = 3 * lib.WorkerStatusInterval
+
+type workerEntry struct {
+	status dto.WorkerStatus
+	seenAt time.Time
+}
+
+// workerRegistry is what this API node knows of the streaming workers, from
+// the status they publish
+type workerRegistry struct {
+	mu      sync.Mutex
+	workers map[string]workerEntry
+}
+
+func newWorkerRegistry() *workerRegistry {
+	return &workerRegistry{workers: make(map[string]workerEntry)}
+}
+
+// live returns the workers heard from within workerTTL and forgets the others
+func (r *workerRegistry) live() []dto.WorkerStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	live := make([]dto.WorkerStatus, 0, len(r.workers))
+	for id, entry := range r.workers {
+		if time.Since(entry.seenAt) > workerTTL {
+			delete(r.workers, id)
+			continue
+		}
+		live = append(live, entry.status)
+	}
+	return live
+}
+
+// UpdateWorker records the status a worker published
+func (s *Service) UpdateWorker(status dto.WorkerStatus) {
+	if status.WorkerId == "" {
+		return
+	}
+	s.workers.mu.Lock()
+	s.workers.workers[status.WorkerId] = workerEntry{status: status, seenAt: time.Now()}
+	s.workers.mu.Unlock()
+}
+
+// StreamWorkers returns the healthy workers with room for another stream,
+// least loaded first
+func (s *Service) StreamWorkers() []string {
+	free := make([]dto.WorkerStatus, 0)
+	for _, status := range s.workers.live() {
+		if status.Healthy && len(status.Rooms) < status.Capacity {
+			free = append(free, status)
+		}
+	}
+
+	slices.SortFunc(free, func(a, b dto.WorkerStatus) int {
+		if n := len(a.Rooms) - len(b.Rooms); n != 0 {
+			return n
+		}
+		return strings.Compare(a.WorkerId, b.WorkerId)
+	})
+
+	ids := make([]string, 0, len(free))
+	for _, status := range free {
+		ids = append(ids, status.WorkerId)
+	}
+	return ids
+}
+
+// StreamCapacity counts the healthy workers and how many more chatrooms they
+// can stream
+func (s *Service) StreamCapacity() (workers int, free int) {
+	for _, status := range s.workers.live() {
+		if !status.Healthy {
+			continue
+		}
+		workers++
+		free += max(status.Capacity-len(status.Rooms), 0)
+	}
+	return workers, free
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) CreateAttachment(ctx context.Context, a *lib.Attachment) (string, error) {
+	q := `INSERT INTO attachments (chatroom_id, uploader, file_name, content_type, size, storage_key, thumbnail_key, width, height)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id, created_at`
+
+	err := s.pool.QueryRow(ctx, q,
+		a.ChatroomId,
+		a.Uploader,
+		a.FileName,
+		a.ContentType,
+		a.Size,
+		a.StorageKey,
+		a.ThumbnailKey,
+		a.Width,
+		a.Height,
+	).Scan(&a.Id, &a.CreatedAt)
+
+	if err != nil {
+		log.Println("Error in Store.CreateAttachment[QueryRow.Scan]:", err)
+		return "", err
+	}
+	return a.Id, nil
+}
+
+func (s *PostgresStore) GetAttachmentById(ctx context.Context, id string) (*lib.Attachment, error) {
+	q := `SELECT id, message_id, chatroom_id, uploader, file_name, content_type, size, storage_key, thumbnail_key, width, height, created_at
+	FROM attachments WHERE id = $1`
+
+	a := lib.Attachment{}
+	err := s.pool.QueryRow(ctx, q, id).Scan(
+		&a.Id,
+		&a.MessageId,
+		&a.ChatroomId,
+		&a.Uploader,
+		&a.FileName,
+		&a.ContentType,
+		&a.Size,
+		&a.StorageKey,
+		&a.ThumbnailKey,
+		&a.Width,
+		&a.Height,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		log.Println("Error in Store.GetAttachmentById[Scan]:", err)
+		return nil, err
+	}
+	return &a, nil
+}
+
+// linkAttachmentsQuery attaches previously uploaded files to a message.
+// Only files uploaded by the sender to the same chatroom that are not yet
+// linked to another message are touched.
+const linkAttachmentsQuery = `UPDATE attachments SET message_id = $1
+	WHERE id = ANY($2) AND chatroom_id = $3 AND uploader = $4 AND message_id IS NULL`
+
+func (s *PostgresStore) GetAttachmentsByMessageIds(ctx context.Context, messageIds []string) (map[string][]lib.Attachment, error) {
+	response := make(map[string][]lib.Attachment)
+	if len(messageIds) == 0 {
+		return response, nil
+	}
+
+	q := `SELECT id, message_id, chatroom_id, uploader, file_name, content_type, size, storage_key, thumbnail_key, width, height, created_at
+	FROM attachments WHERE message_id = ANY($1)
+	ORDER BY created_at`
+
+	rows, err := s.pool.Query(ctx, q, messageIds)
+	if err == pgx.ErrNoRows {
+		return response, nil
+	}
+	if err != nil {
+		log.Println("Error in Store.GetAttachmentsByMessageIds[Query]:", err)
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := lib.Attachment{}
+		err := rows.Scan(
+			&a.Id,
+			&a.MessageId,
+			&a.ChatroomId,
+			&a.Uploader,
+			&a.FileName,
+			&a.ContentType,
+			&a.Size,
+			&a.StorageKey,
+			&a.ThumbnailKey,
+			&a.Width,
+			&a.Height,
+			&a.CreatedAt,
+		)
+		if err != nil {
+			log.Println("Error in Store.GetAttachmentsByMessageIds[Scan]:", err)
+			continue
+		}
+		response[a.MessageId.String] = append(response[a.MessageId.String], a)
+	}
+
+	return response, rows.Err()
+}
+
+func (s *PostgresStore) IsUserInChatroom(ctx context.Context, userId string, chatroomId string) (bool, error) {
+	q := "SELECT EXISTS (SELECT 1 FROM user_chatrooms WHERE user_id = $1 AND chatroom_id = $2)"
+
+	var exists bool
+	err := s.pool.QueryRow(ctx, q, userId, chatroomId).Scan(&exists)
+	if err != nil {
+		log.Println("Error in Store.IsUserInChatroom[Scan]:", err)
+		return false, err
+	}
+	return exists, nil
+}
+
+// GetAttachmentsByUploader returns every file userId uploaded, linked to a
+// message or not
+func (s *PostgresStore) GetAttachmentsByUploader(ctx context.Context, userId string) ([]lib.Attachment, error) {
+	q := `SELECT id, message_id, chatroom_id, uploader, file_name, content_type, size, storage_key, thumbnail_key, width, height, created_at
+	FROM attachments WHERE uploader = $1
+	ORDER BY created_at`
+
+	rows, err := s.pool.Query(ctx, q, userId)
+	if err != nil {
+		log.Println("Error in Store.GetAttachmentsByUploader[Query]:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]lib.Attachment, 0)
+	for rows.Next() {
+		a := lib.Attachment{}
+		err := rows.Scan(
+			&a.Id,
+			&a.MessageId,
+			&a.ChatroomId,
+			&a.Uploader,
+			&a.FileName,
+			&a.ContentType,
+			&a.Size,
+			&a.StorageKey,
+			&a.ThumbnailKey,
+			&a.Width,
+			&a.Height,
+			&a.CreatedAt,
+		)
+		if err != nil {
+			log.Println("Error in Store.GetAttachmentsByUploader[Scan]:", err)
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
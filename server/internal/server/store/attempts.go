@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (s *PostgresStore) RecordAttempt(ctx context.Context, key string) error {
+	_, err := s.pool.Exec(ctx, "INSERT INTO attempts (attempt_key) VALUES ($1)", key)
+	if err != nil {
+		log.Println("Error in Store.RecordAttempt[Exec]:", err)
+	}
+	return err
+}
+
+// CountAttempts returns how many attempts were recorded for key within
+// window, measured with the database clock that wrote them
+func (s *PostgresStore) CountAttempts(ctx context.Context, key string, window time.Duration) (int, error) {
+	q := `SELECT COUNT(*) FROM attempts
+	WHERE attempt_key = $1 AND created_at > CURRENT_TIMESTAMP - make_interval(secs => $2)`
+
+	var count int
+	if err := s.pool.QueryRow(ctx, q, key, window.Seconds()).Scan(&count); err != nil {
+		log.Println("Error in Store.CountAttempts[Scan]:", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *PostgresStore) ClearAttempts(ctx context.Context, key string) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM attempts WHERE attempt_key = $1", key)
+	if err != nil {
+		log.Println("Error in Store.ClearAttempts[Exec]:", err)
+	}
+	return err
+}
+
+// DeleteAttemptsOlderThan sweeps the attempts no window looks at anymore
+func (s *PostgresStore) DeleteAttemptsOlderThan(ctx context.Context, age time.Duration) (int64, error) {
+	q := "DELETE FROM attempts WHERE created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)"
+
+	tag, err := s.pool.Exec(ctx, q, age.Seconds())
+	if err != nil {
+		log.Println("Error in Store.DeleteAttemptsOlderThan[Exec]:", err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GetChatroomRole returns the role of a member, pgx.ErrNoRows when userId is
+// not in the chatroom
+func (s *PostgresStore) GetChatroomRole(ctx context.Context, userId string, chatroomId string) (string, error) {
+	q := "SELECT role FROM user_chatrooms WHERE user_id = $1 AND chatroom_id = $2"
+
+	var role string
+	if err := s.pool.QueryRow(ctx, q, userId, chatroomId).Scan(&role); err != nil {
+		if err != pgx.ErrNoRows {
+			log.Println("Error in Store.GetChatroomRole[Scan]:", err)
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+// GetChatroomMembers returns the members of a chatroom with their roles,
+// owners first
+func (s *PostgresStore) GetChatroomMembers(ctx context.Context, chatroomId string) ([]lib.UserChatroom, error) {
+	q := `SELECT user_id, chatroom_id, role FROM user_chatrooms
+	WHERE chatroom_id = $1
+	ORDER BY role = 'owner' DESC, user_id`
+
+	rows, err := s.pool.Query(ctx, q, chatroomId)
+	if err != nil {
+		log.Println("Error in Store.GetChatroomMembers[Query]:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]lib.UserChatroom, 0)
+	for rows.Next() {
+		uc := lib.UserChatroom{}
+		if err := rows.Scan(&uc.UserId, &uc.ChatroomId, &uc.Role); err != nil {
+			log.Println("Error in Store.GetChatroomMembers[Scan]:", err)
+			return nil, err
+		}
+		members = append(members, uc)
+	}
+	return members, rows.Err()
+}
+
+func (s *PostgresStore) SetChatroomRole(ctx context.Context, chatroomId string, userId string, role string) error {
+	q := "UPDATE user_chatrooms SET role = $3 WHERE chatroom_id = $1 AND user_id = $2"
+
+	tag, err := s.pool.Exec(ctx, q, chatroomId, userId, role)
+	if err != nil {
+		log.Println("Error in Store.SetChatroomRole[Exec]:", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateChatroom changes the settings that are not nil
+func (s *PostgresStore) UpdateChatroom(ctx context.Context, chatroomId string, settings dto.UpdateChatroomRequest) error {
+	q := `UPDATE chatrooms SET
+	name = COALESCE($2, name),
+	description = COALESCE($3, description),
+	topic = COALESCE($4, topic),
+	default_start_url = COALESCE($5, default_start_url),
+	stream_quality = COALESCE($6, stream_quality)
+	WHERE id = $1`
+
+	tag, err := s.pool.Exec(ctx, q, chatroomId, settings.Name, settings.Description, settings.Topic, settings.DefaultStartUrl, settings.StreamQuality)
+	if err != nil {
+		log.Println("Error in Store.UpdateChatroom[Exec]:", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (s *PostgresStore) UpdateChatroomPicture(ctx context.Context, chatroomId string, profilePicture string) error {
+	q := "UPDATE chatrooms SET profile_picture = NULLIF($2, '') WHERE id = $1"
+
+	tag, err := s.pool.Exec(ctx, q, chatroomId, profilePicture)
+	if err != nil {
+		log.Println("Error in Store.UpdateChatroomPicture[Exec]:", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ScheduleUserDeletion marks the account of userId for deletion and returns
+// when that was asked. Asking again keeps the first request's time.
+func (s *PostgresStore) ScheduleUserDeletion(ctx context.Context, userId string) (time.Time, error) {
+	q := `UPDATE users SET deletion_requested_at = COALESCE(deletion_requested_at, CURRENT_TIMESTAMP)
+	WHERE user_id = $1
+	RETURNING deletion_requested_at::timestamptz`
+
+	var requestedAt time.Time
+	if err := s.pool.QueryRow(ctx, q, userId).Scan(&requestedAt); err != nil {
+		if err != pgx.ErrNoRows {
+			log.Println("Error in Store.ScheduleUserDeletion[Scan]:", err)
+		}
+		return time.Time{}, err
+	}
+	return requestedAt, nil
+}
+
+// CancelUserDeletion clears a pending deletion, it reports whether there was one
+func (s *PostgresStore) CancelUserDeletion(ctx context.Context, userId string) (bool, error) {
+	q := "UPDATE users SET deletion_requested_at = NULL WHERE user_id = $1 AND deletion_requested_at IS NOT NULL"
+
+	tag, err := s.pool.Exec(ctx, q, userId)
+	if err != nil {
+		log.Println("Error in Store.CancelUserDeletion[Exec]:", err)
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetUsersDueForDeletion returns the users who asked for deletion more than
+// grace ago, oldest request first. The request time is written with the
+// database clock, so it is compared with it too.
+func (s *PostgresStore) GetUsersDueForDeletion(ctx context.Context, grace time.Duration, limit int) ([]string, error) {
+	q := `SELECT user_id FROM users
+	WHERE deletion_requested_at IS NOT NULL
+	AND deletion_requested_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+	ORDER BY deletion_requested_at
+	LIMIT $2`
+
+	rows, err := s.pool.Query(ctx, q, grace.Seconds(), limit)
+	if err != nil {
+		log.Println("Error in Store.GetUsersDueForDeletion[Query]:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIds := make([]string, 0)
+	for rows.Next() {
+		var userId string
+		if err := rows.Scan(&userId); err != nil {
+			log.Println("Error in Store.GetUsersDueForDeletion[Scan]:", err)
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+	return userIds, rows.Err()
+}
+
+// DeleteUser removes a user for good. Their messages lose their sender,
+// everything else they own goes with them.
+func (s *PostgresStore) DeleteUser(ctx context.Context, userId string) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM users WHERE user_id = $1", userId)
+	if err != nil {
+		log.Println("Error in Store.DeleteUser[Exec]:", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// directMessagePair orders two user ids the way direct_messages stores them
+func directMessagePair(userId string, otherId string) (string, string) {
+	if otherId < userId {
+		return otherId, userId
+	}
+	return userId, otherId
+}
+
+func (s *PostgresStore) GetDirectMessage(ctx context.Context, userId string, otherId string) (string, error) {
+	q := "SELECT chatroom_id FROM direct_messages WHERE user_low = $1 AND user_high = $2"
+
+	low, high := directMessagePair(userId, otherId)
+	var chatroomId string
+	if err := s.pool.QueryRow(ctx, q, low, high).Scan(&chatroomId); err != nil {
+		if err != pgx.ErrNoRows {
+			log.Println("Error in Store.GetDirectMessage[Scan]:", err)
+		}
+		return "", err
+	}
+	return chatroomId, nil
+}
+
+// GetOrCreateDirectMessage returns the direct message chatroom of the two
+// users, creating it with both of them as members the first time. Concurrent
+// calls for the same pair agree on a single room.
+func (s *PostgresStore) GetOrCreateDirectMessage(ctx context.Context, userId string, otherId string) (string, error) {
+	chatroomId, err := s.GetDirectMessage(ctx, userId, otherId)
+	if err != pgx.ErrNoRows {
+		return chatroomId, err
+	}
+
+	low, high := directMessagePair(userId, otherId)
+	err = pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		q := "INSERT INTO chatrooms (name, direct_message) VALUES ('', TRUE) RETURNING id"
+		if err := tx.QueryRow(ctx, q).Scan(&chatroomId); err != nil {
+			log.Println("Error in Store.GetOrCreateDirectMessage[Scan]:", err)
+			return err
+		}
+
+		q = "INSERT INTO direct_messages (chatroom_id, user_low, user_high) VALUES ($1, $2, $3)"
+		if _, err := tx.Exec(ctx, q, chatroomId, low, high); err != nil {
+			return err
+		}
+
+		// Both members own the room
+		q = "INSERT INTO user_chatrooms (user_id, chatroom_id, role) VALUES ($1, $3, 'owner'), ($2, $3, 'owner')"
+		if _, err := tx.Exec(ctx, q, low, high, chatroomId); err != nil {
+			log.Println("Error in Store.GetOrCreateDirectMessage[Exec]:", err)
+			return err
+		}
+		return nil
+	})
+
+	// Someone else created the room in the meantime, the transaction rolled
+	// back ours
+	if lib.IsUniqueViolation(err, "direct_messages_user_low_user_high_key") {
+		return s.GetDirectMessage(ctx, userId, otherId)
+	}
+	if err != nil {
+		return "", err
+	}
+	return chatroomId, nil
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GetFriendRelationBetween returns the relation of two users whichever of
+// them sent the request
+func (s *PostgresStore) GetFriendRelationBetween(ctx context.Context, userId string, otherId string) (*lib.FriendRelations, error) {
+	q := `SELECT id, user_id1, user_id2, created_at, updated_at, status FROM friends
+	WHERE (user_id1 = $1 AND user_id2 = $2) OR (user_id1 = $2 AND user_id2 = $1)`
+
+	f := lib.FriendRelations{}
+	err := s.pool.QueryRow(ctx, q, userId, otherId).Scan(&f.Id, &f.UserId1, &f.UserId2, &f.CreatedAt, &f.UpdatedAt, &f.Status)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Println("Error in Store.GetFriendRelationBetween[Scan]:", err)
+		}
+		return nil, err
+	}
+	return &f, nil
+}
+
+// GetSentFriendRequestsByUserId lists the pending requests userId sent, with
+// the details of their recipients
+func (s *PostgresStore) GetSentFriendRequestsByUserId(ctx context.Context, userId string) ([]dto.FriendRequestResponse, error) {
+	q := `SELECT u.name, u.username, u.profile_picture, f.id, f.user_id2, f.created_at, f.status
+	FROM friends f
+	JOIN users u
+	ON f.user_id2 = u.user_id
+	WHERE f.user_id1 = $1 AND f.status = $2`
+
+	response := make([]dto.FriendRequestResponse, 0)
+
+	rows, err := s.pool.Query(ctx, q, userId, "pending")
+	if err != nil {
+		log.Println("Error in Store.GetSentFriendRequestsByUserId[Query]:", err)
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f := dto.FriendRequestResponse{}
+		err := rows.Scan(&f.Name, &f.Username, &f.ProfilePicture, &f.RequestId, &f.UserId, &f.CreatedAt, &f.Status)
+		if err != nil {
+			log.Println("Error in Store.GetSentFriendRequestsByUserId[Scan]:", err)
+			continue
+		}
+		response = append(response, f)
+	}
+
+	return response, rows.Err()
+}
+
+func (s *PostgresStore) DeleteFriendRelation(ctx context.Context, id string) error {
+	q := "DELETE FROM friends WHERE id = $1"
+
+	_, err := s.pool.Exec(ctx, q, id)
+	if err != nil {
+		log.Println("Error in Store.DeleteFriendRelation[Exec]:", err)
+		return err
+	}
+	return nil
+}
+
+// BlockUser records the block and drops any friendship or pending request
+// between the two users. Blocking twice is not an error.
+func (s *PostgresStore) BlockUser(ctx context.Context, blockerId string, blockedId string) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		q := `INSERT INTO blocks (blocker_id, blocked_id) VALUES ($1, $2)
+		ON CONFLICT (blocker_id, blocked_id) DO NOTHING`
+		if _, err := tx.Exec(ctx, q, blockerId, blockedId); err != nil {
+			log.Println("Error in Store.BlockUser[Exec]:", err)
+			return err
+		}
+
+		q = `DELETE FROM friends
+		WHERE (user_id1 = $1 AND user_id2 = $2) OR (user_id1 = $2 AND user_id2 = $1)`
+		if _, err := tx.Exec(ctx, q, blockerId, blockedId); err != nil {
+			log.Println("Error in Store.BlockUser[Exec]:", err)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) UnblockUser(ctx context.Context, blockerId string, blockedId string) error {
+	q := "DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2"
+
+	_, err := s.pool.Exec(ctx, q, blockerId, blockedId)
+	if err != nil {
+		log.Println("Error in Store.UnblockUser[Exec]:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetBlockedUsers(ctx context.Context, userId string) ([]dto.BlockedUserResponse, error) {
+	q := `SELECT u.user_id, u.name, u.username, u.profile_picture, b.created_at
+	FROM blocks b
+	JOIN users u ON u.user_id = b.blocked_id
+	WHERE b.blocker_id = $1
+	ORDER BY b.created_at DESC`
+
+	response := make([]dto.BlockedUserResponse, 0)
+
+	rows, err := s.pool.Query(ctx, q, userId)
+	if err != nil {
+		log.Println("Error in Store.GetBlockedUsers[Query]:", err)
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b := dto.BlockedUserResponse{}
+		if err := rows.Scan(&b.UserId, &b.Name, &b.Username, &b.ProfilePicture, &b.BlockedAt); err != nil {
+			log.Println("Error in Store.GetBlockedUsers[Scan]:", err)
+			continue
+		}
+		response = append(response, b)
+	}
+
+	return response, rows.Err()
+}
+
+// IsBlocked tells whether either user blocked the other
+func (s *PostgresStore) IsBlocked(ctx context.Context, userId string, otherId string) (bool, error) {
+	q := `SELECT EXISTS (
+		SELECT 1 FROM blocks
+		WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+	)`
+
+	var blocked bool
+	if err := s.pool.QueryRow(ctx, q, userId, otherId).Scan(&blocked); err != nil {
+		log.Println("Error in Store.IsBlocked[Scan]:", err)
+		return false, err
+	}
+	return blocked, nil
+}
+
+// IsDirectMessageBlocked tells whether chatroomId is a direct message room
+// where userId and the other member blocked one another
+func (s *PostgresStore) IsDirectMessageBlocked(ctx context.Context, userId string, chatroomId string) (bool, error) {
+	q := `SELECT EXISTS (
+		SELECT 1 FROM chatrooms c
+		JOIN user_chatrooms uc ON uc.chatroom_id = c.id
+		JOIN blocks b ON (b.blocker_id = $1 AND b.blocked_id = uc.user_id) OR (b.blocker_id = uc.user_id AND b.blocked_id = $1)
+		WHERE c.id = $2 AND c.direct_message
+	)`
+
+	var blocked bool
+	if err := s.pool.QueryRow(ctx, q, userId, chatroomId).Scan(&blocked); err != nil {
+		log.Println("Error in Store.IsDirectMessageBlocked[Scan]:", err)
+		return false, err
+	}
+	return blocked, nil
+}
+
+// GetFriendSuggestions ranks the users userId has no relation with by mutual
+// friends, then by shared group chatrooms
+func (s *PostgresStore) GetFriendSuggestions(ctx context.Context, userId string, limit int) ([]dto.FriendSuggestionResponse, error) {
+	q := `SELECT u.user_id, u.name, COALESCE(u.username, ''), u.status, u.profile_picture, fs.mutual_friends, fs.shared_chatrooms
+	FROM friend_suggestions($1, $2) fs
+	JOIN users u ON u.user_id = fs.user_id
+	ORDER BY fs.mutual_friends DESC, fs.shared_chatrooms DESC, u.user_id`
+
+	response := make([]dto.FriendSuggestionResponse, 0)
+
+	rows, err := s.pool.Query(ctx, q, userId, limit)
+	if err != nil {
+		log.Println("Error in Store.GetFriendSuggestions[Query]:", err)
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f := dto.FriendSuggestionResponse{}
+		err := rows.Scan(&f.UserId, &f.Name, &f.Username, &f.Status, &f.ProfilePicture, &f.MutualFriends, &f.SharedChatrooms)
+		if err != nil {
+			log.Println("Error in Store.GetFriendSuggestions[Scan]:", err)
+			continue
+		}
+		response = append(response, f)
+	}
+
+	return response, rows.Err()
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) GetUserByIdentity(ctx context.Context, provider string, subject string) (*lib.User, error) {
+	q := `SELECT u.id, u.user_id, u.name, u.email, u.email_verified, u.password_hash, u.username, u.created_at, u.status, u.profile_picture, u.bio
+	FROM user_identities i
+	JOIN users u ON u.user_id = i.user_id
+	WHERE i.provider = $1 AND i.subject = $2`
+
+	user := lib.User{}
+	err := s.pool.QueryRow(ctx, q, provider, subject).Scan(
+		&user.Id,
+		&user.UserId,
+		&user.Name,
+		&user.Email,
+		&user.EmailVerified,
+		&user.PasswordHash,
+		&user.Username,
+		&user.CreatedAt,
+		&user.Status,
+		&user.ProfilePicture,
+		&user.Bio,
+	)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Println("Error in Store.GetUserByIdentity[Scan]:", err)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *PostgresStore) CreateIdentity(ctx context.Context, userId string, identity *dto.OAuthUser) error {
+	q := `INSERT INTO user_identities (user_id, provider, subject, email)
+	VALUES ($1, $2, $3, NULLIF($4, ''))`
+
+	_, err := s.pool.Exec(ctx, q, userId, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		log.Println("Error in Store.CreateIdentity[Exec]:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetIdentitiesByUserId(ctx context.Context, userId string) ([]lib.UserIdentity, error) {
+	q := `SELECT id, user_id, provider, subject, email, created_at
+	FROM user_identities
+	WHERE user_id = $1
+	ORDER BY created_at`
+
+	response := make([]lib.UserIdentity, 0)
+
+	rows, err := s.pool.Query(ctx, q, userId)
+	if err != nil {
+		log.Println("Error in Store.GetIdentitiesByUserId[Query]:", err)
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		i := lib.UserIdentity{}
+		if err := rows.Scan(&i.Id, &i.UserId, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			log.Println("Error in Store.GetIdentitiesByUserId[Scan]:", err)
+			continue
+		}
+		response = append(response, i)
+	}
+
+	return response, rows.Err()
+}
+
+func (s *PostgresStore) DeleteIdentity(ctx context.Context, userId string, provider string) error {
+	q := "DELETE FROM user_identities WHERE user_id = $1 AND provider = $2"
+
+	_, err := s.pool.Exec(ctx, q, userId, provider)
+	if err != nil {
+		log.Println("Error in Store.DeleteIdentity[Exec]:", err)
+		return err
+	}
+	return nil
+}
@@ -5,23 +5,25 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"strings"
 
 	"sideDesert/shiba/internal/server/dto"
 	"sideDesert/shiba/internal/server/lib"
+	"sideDesert/shiba/internal/server/migrations"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type Store struct {
+// PostgresStore is the Store used in production
+type PostgresStore struct {
 	pool *pgxpool.Pool
 }
 
-func NewStore(ctx context.Context, dbUrl string) (*Store, error) {
+func NewPostgresStore(ctx context.Context, dbUrl string) (*PostgresStore, error) {
 	config, err := pgxpool.ParseConfig(dbUrl)
 	if err != nil {
 		log.Printf("Unable to create config using dbUrl: %v", err)
+		return nil, err
 	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, config)
@@ -30,18 +32,23 @@ func NewStore(ctx context.Context, dbUrl string) (*Store, error) {
 		return nil, err
 	}
 
-	return &Store{
+	return &PostgresStore{
 		pool: pool,
 	}, nil
 }
 
-func (s *Store) Close(ctx context.Context) error {
+// Migrator manages the schema of the store database
+func (s *PostgresStore) Migrator() (*migrations.Migrator, error) {
+	return migrations.NewMigrator(s.pool)
+}
+
+func (s *PostgresStore) Close(ctx context.Context) error {
 	s.pool.Close()
 
 	return nil
 }
 
-func (s *Store) CreateUser(ctx context.Context, user *dto.SignupUserRequest) (*string, error) {
+func (s *PostgresStore) CreateUser(ctx context.Context, user *dto.SignupUserRequest) (*string, error) {
 	query := `INSERT INTO users (name, email, username, password_hash)
 			  VALUES ($1, $2, $3, $4)
 			  RETURNING user_id;`
@@ -62,8 +69,8 @@ func (s *Store) CreateUser(ctx context.Context, user *dto.SignupUserRequest) (*s
 	return &userID, nil
 }
 
-func (s *Store) GetUserByEmail(ctx context.Context, email string) (*lib.User, error) {
-	query := `SELECT id, user_id, name, email, password_hash, username, created_at, status, profile_picture FROM users WHERE email = $1`
+func (s *PostgresStore) GetUserByEmail(ctx context.Context, email string) (*lib.User, error) {
+	query := `SELECT id, user_id, name, email, email_verified, password_hash, username, created_at, status, profile_picture, bio FROM users WHERE email = $1`
 	row := s.pool.QueryRow(ctx, query, email)
 	user := lib.User{}
 	err := row.Scan(
@@ -71,11 +78,13 @@ func (s *Store) GetUserByEmail(ctx context.Context, email string) (*lib.User, er
 		&user.UserId,
 		&user.Name,
 		&user.Email,
+		&user.EmailVerified,
 		&user.PasswordHash,
 		&user.Username,
 		&user.CreatedAt,
 		&user.Status,
 		&user.ProfilePicture,
+		&user.Bio,
 	)
 
 	if err != nil {
@@ -85,8 +94,8 @@ func (s *Store) GetUserByEmail(ctx context.Context, email string) (*lib.User, er
 	return &user, nil
 }
 
-func (s *Store) GetUserById(ctx context.Context, user_id string) (*lib.User, error) {
-	query := `SELECT id, user_id, name, email, password_hash, username, created_at, status, profile_picture FROM users WHERE user_id = $1`
+func (s *PostgresStore) GetUserById(ctx context.Context, user_id string) (*lib.User, error) {
+	query := `SELECT id, user_id, name, email, email_verified, password_hash, username, created_at, status, profile_picture, bio FROM users WHERE user_id = $1`
 	row := s.pool.QueryRow(ctx, query, user_id)
 	user := lib.User{}
 	err := row.Scan(
@@ -94,11 +103,13 @@ func (s *Store) GetUserById(ctx context.Context, user_id string) (*lib.User, err
 		&user.UserId,
 		&user.Name,
 		&user.Email,
+		&user.EmailVerified,
 		&user.PasswordHash,
 		&user.Username,
 		&user.CreatedAt,
 		&user.Status,
 		&user.ProfilePicture,
+		&user.Bio,
 	)
 
 	if err != nil {
@@ -108,63 +119,24 @@ func (s *Store) GetUserById(ctx context.Context, user_id string) (*lib.User, err
 	return &user, nil
 }
 
-// TODO: Complete this
-func (s *Store) GetOrCreateUser(ctx context.Context, oauthUser *dto.OAuthGoogleUserDataResponse) (*lib.User, bool, error) {
-	email := oauthUser.Email
-	log.Println("Email", email)
-	// Check if user exists
-	user, err := s.GetUserByEmail(ctx, email)
-	newUser := false
-
-	if err != nil {
-		log.Println("GetOrCreateUser: Creating New User")
-		newUser = true
-		dummyPass, err := lib.GenerateSecureRandomID(12)
-		if err != nil {
-			log.Println("Error in GetOrCreateUser[CreateUser]", err)
-			return nil, newUser, err
-		}
-
-		hashedPassword, err := lib.HashPassword(dummyPass)
-		if err != nil {
-			log.Println("Error in GetOrCreateUser[CreateUser]", err)
-			return nil, newUser, err
-		}
-
-		_, err = s.CreateUser(ctx, &dto.SignupUserRequest{
-			Name:     oauthUser.Name,
-			Username: strings.Split(oauthUser.Email, "@")[0],
-			Email:    oauthUser.Email,
-			Password: hashedPassword,
-		})
-
-		if err != nil {
-			log.Println("Error in GetOrCreateUser[CreateUser]", err)
-			return nil, newUser, err
-		}
-
-		newUser = true
-
-	}
-	return user, newUser, nil
-}
-
-func (s *Store) GetChatRoomById(ctx context.Context, chatroomId int32) (*lib.Chatroom, error) {
-	q := "SELECT * FROM chatrooms WHERE id = $1"
+func (s *PostgresStore) GetChatRoomById(ctx context.Context, chatroomId string) (*lib.Chatroom, error) {
+	q := `SELECT id, name, profile_picture, created_at, direct_message, description, topic, default_start_url, stream_quality, updated_at
+	FROM chatrooms WHERE id = $1`
 	row := s.pool.QueryRow(ctx, q, chatroomId)
 	chatRoom := lib.Chatroom{}
-	err := row.Scan(&chatRoom.Id, &chatRoom.Name, &chatRoom.ProfilePicture, &chatRoom.CreatedAt)
+	err := row.Scan(&chatRoom.Id, &chatRoom.Name, &chatRoom.ProfilePicture, &chatRoom.CreatedAt, &chatRoom.DirectMessage,
+		&chatRoom.Description, &chatRoom.Topic, &chatRoom.DefaultStartUrl, &chatRoom.StreamQuality, &chatRoom.UpdatedAt)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return &chatRoom, fmt.Errorf("GetChatRoomById: No chatroom with id %d", chatroomId)
+			return &chatRoom, lib.NotFound(fmt.Sprintf("No chatroom with id %s", chatroomId))
 		}
-		return &chatRoom, fmt.Errorf("GetChatRoomById: %d: %s", chatroomId, err)
+		return &chatRoom, fmt.Errorf("GetChatRoomById: %s: %w", chatroomId, err)
 	}
 
 	return &chatRoom, nil
 }
 
-func (s *Store) GetUsersByChatroomId(ctx context.Context, chatroomId string) ([]lib.UserId, error) {
+func (s *PostgresStore) GetUsersByChatroomId(ctx context.Context, chatroomId string) ([]lib.UserId, error) {
 	q := "SELECT user_id FROM user_chatrooms WHERE chatroom_id = $1"
 	row, err := s.pool.Query(ctx, q, chatroomId)
 	userIds := make([]lib.UserId, 0)
@@ -186,7 +158,7 @@ func (s *Store) GetUsersByChatroomId(ctx context.Context, chatroomId string) ([]
 	return userIds, nil
 }
 
-func (s *Store) CreateChatRoom(ctx context.Context, chatroom dto.CreateChatRoomRequest) (string, error) {
+func (s *PostgresStore) CreateChatRoom(ctx context.Context, chatroom dto.CreateChatRoomRequest) (string, error) {
 	q := "INSERT INTO chatrooms (name, profile_picture, direct_message) VALUES ($1, $2, $3) RETURNING id"
 	row := s.pool.QueryRow(ctx, q, chatroom.Name, chatroom.ProfilePicture, chatroom.DirectMessage)
 	var chatRoomId string
@@ -194,21 +166,21 @@ func (s *Store) CreateChatRoom(ctx context.Context, chatroom dto.CreateChatRoomR
 		return "", err
 	}
 
-	s.AddParticipantsToChatRoom(ctx, chatRoomId, chatroom.Participants)
-	return chatRoomId, nil
+	err := s.AddParticipantsToChatRoom(ctx, chatRoomId, chatroom.Participants)
+	return chatRoomId, err
 }
 
-func (s *Store) CreateChatRoomByName(ctx context.Context, name string) (string, error) {
-	q := "INSERT INTO chatooms (name) VALUES ($1)"
+func (s *PostgresStore) CreateChatRoomByName(ctx context.Context, name string) (string, error) {
+	q := "INSERT INTO chatrooms (name) VALUES ($1) RETURNING id"
 	row := s.pool.QueryRow(ctx, q, name)
 	var chatRoomId string
 	if err := row.Scan(&chatRoomId); err != nil {
-		return "", nil
+		return "", err
 	}
 	return chatRoomId, nil
 }
 
-func (s *Store) AddParticipantsToChatRoom(ctx context.Context, chatroom_id string, participants []string) error {
+func (s *PostgresStore) AddParticipantsToChatRoom(ctx context.Context, chatroom_id string, participants []string) error {
 	if len(participants) == 0 {
 		log.Println("No participants provided")
 		return nil
@@ -219,7 +191,7 @@ func (s *Store) AddParticipantsToChatRoom(ctx context.Context, chatroom_id strin
 	for _, userId := range participants {
 		commandTag, err := s.pool.Exec(ctx, q, userId, chatroom_id)
 		if err != nil {
-			return fmt.Errorf("Error in Adding participants: %s", err.Error())
+			return fmt.Errorf("Error in Adding participants: %w", err)
 		}
 		rowsAffected := commandTag.RowsAffected()
 		log.Println("Rows Affected:", rowsAffected)
@@ -227,16 +199,21 @@ func (s *Store) AddParticipantsToChatRoom(ctx context.Context, chatroom_id strin
 	return nil
 }
 
-func (s *Store) GetLast50ChatRoomMessages(ctx context.Context, chatroomId string, offset int) ([]lib.Message, error) {
-	q := `SELECT m.id, u.name, m.sender, m.recipient, m.content, m.created_at
+// GetLast50ChatRoomMessages returns a page of the chatroom history. When
+// mentionedUserId is not empty only messages mentioning that user are returned.
+func (s *PostgresStore) GetLast50ChatRoomMessages(ctx context.Context, chatroomId string, offset int, mentionedUserId string) ([]lib.Message, error) {
+	q := `SELECT m.id, COALESCE(m.client_id, ''), COALESCE(u.name, '` + lib.DeletedUserName + `'), COALESCE(m.sender, ''), m.recipient, m.content, m.created_at
 FROM messages m
 LEFT JOIN users u ON u.user_id = m.sender
 WHERE m.recipient = $1
+AND ($3 = '' OR EXISTS (
+	SELECT 1 FROM message_mentions mm WHERE mm.message_id = m.id AND mm.user_id = $3
+))
 ORDER BY m.created_at DESC
 LIMIT 50 OFFSET $2;
 `
 
-	rows, err := s.pool.Query(ctx, q, chatroomId, 50*offset)
+	rows, err := s.pool.Query(ctx, q, chatroomId, 50*offset, mentionedUserId)
 	messages := make([]lib.Message, 0)
 
 	if err == pgx.ErrNoRows {
@@ -251,7 +228,7 @@ LIMIT 50 OFFSET $2;
 	for rows.Next() {
 		tempMsg := lib.Message{}
 
-		err := rows.Scan(&tempMsg.Id, &tempMsg.SenderName, &tempMsg.Sender, &tempMsg.ChatroomId, &tempMsg.Content, &tempMsg.CreatedAt)
+		err := rows.Scan(&tempMsg.Id, &tempMsg.ClientId, &tempMsg.SenderName, &tempMsg.Sender, &tempMsg.ChatroomId, &tempMsg.Content, &tempMsg.CreatedAt)
 		if err != nil {
 			log.Println("Error in GetChatRoomHistory[Scan]:", err.Error())
 			continue
@@ -259,38 +236,121 @@ LIMIT 50 OFFSET $2;
 
 		messages = append(messages, tempMsg)
 	}
+	rows.Close()
+
+	messageIds := make([]string, 0, len(messages))
+	for _, m := range messages {
+		messageIds = append(messageIds, m.Id)
+	}
+
+	attachments, err := s.GetAttachmentsByMessageIds(ctx, messageIds)
+	if err != nil {
+		log.Println("Error in GetChatRoomHistory[GetAttachmentsByMessageIds]:", err.Error())
+		return messages, err
+	}
+
+	previews, err := s.GetLinkPreviewsByMessageIds(ctx, messageIds)
+	if err != nil {
+		log.Println("Error in GetChatRoomHistory[GetLinkPreviewsByMessageIds]:", err.Error())
+		return messages, err
+	}
+
+	mentions, err := s.GetMentionsByMessageIds(ctx, messageIds)
+	if err != nil {
+		log.Println("Error in GetChatRoomHistory[GetMentionsByMessageIds]:", err.Error())
+		return messages, err
+	}
+
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].Id]
+		messages[i].Previews = previews[messages[i].Id]
+		messages[i].Mentions = mentions[messages[i].Id]
+	}
 
 	return messages, nil
 }
 
-type StoreChatMessageDto struct {
-	Id         string `json:"id"`
-	Sender     string `json:"string"`
-	ChatroomId string `json:"chatroomId"`
-	SenderName string `json:"sender_name"`
-	Content    string `json:"content"`
-	CreatedAt  string `json:"created_at"`
-}
+// GetMessagesBySender returns a page of the messages userId sent to any
+// chatroom, oldest first
+func (s *PostgresStore) GetMessagesBySender(ctx context.Context, userId string, offset int, limit int) ([]lib.Message, error) {
+	q := `SELECT m.id, COALESCE(m.client_id, ''), u.name, m.sender, m.recipient, m.content, m.created_at
+FROM messages m
+JOIN users u ON u.user_id = m.sender
+WHERE m.sender = $1
+ORDER BY m.created_at, m.id
+LIMIT $3 OFFSET $2`
 
-func (s *Store) StoreChatRoomMessage(ctx context.Context, msg StoreChatMessageDto) error {
-	q := "INSERT INTO messages (sender, content, recipient) VALUES ($1, $2, $3)"
+	rows, err := s.pool.Query(ctx, q, userId, offset, limit)
+	if err != nil {
+		log.Println("Error in Store.GetMessagesBySender[Query]:", err)
+		return nil, err
+	}
+	defer rows.Close()
 
-	log.Println("sender", msg.Sender)
-	log.Println("content", msg.Content)
-	log.Println("ChatroomId", msg.ChatroomId)
+	messages := make([]lib.Message, 0)
+	for rows.Next() {
+		m := lib.Message{}
+		if err := rows.Scan(&m.Id, &m.ClientId, &m.SenderName, &m.Sender, &m.ChatroomId, &m.Content, &m.CreatedAt); err != nil {
+			log.Println("Error in Store.GetMessagesBySender[Scan]:", err)
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+type StoreChatMessageDto struct {
+	Id          string   `json:"id"`
+	Sender      string   `json:"string"`
+	ChatroomId  string   `json:"chatroomId"`
+	SenderName  string   `json:"sender_name"`
+	Content     string   `json:"content"`
+	CreatedAt   string   `json:"created_at"`
+	Attachments []string `json:"attachments"`
+}
 
-	_, err := s.pool.Exec(ctx, q, msg.Sender, msg.Content, msg.ChatroomId)
+// StoreChatRoomMessage inserts the message and links its attachments in one
+// transaction, a message found by its client id has its attachments
+func (s *PostgresStore) StoreChatRoomMessage(ctx context.Context, msg StoreChatMessageDto) (string, error) {
+	var messageId string
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		q := "INSERT INTO messages (sender, content, recipient, client_id) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id"
+		if err := tx.QueryRow(ctx, q, msg.Sender, msg.Content, msg.ChatroomId, msg.Id).Scan(&messageId); err != nil {
+			log.Println("⁉️ Error in StoreChatRoomMessage:", err)
+			return err
+		}
 
+		if len(msg.Attachments) == 0 {
+			return nil
+		}
+		if _, err := tx.Exec(ctx, linkAttachmentsQuery, messageId, msg.Attachments, msg.ChatroomId, msg.Sender); err != nil {
+			log.Println("⁉️ Error in StoreChatRoomMessage[LinkAttachments]:", err)
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		log.Println("⁉️ Error in StoreChatRoomMessage:", err)
-		return err
+		return "", err
 	}
+	return messageId, nil
+}
 
-	return nil
+// GetMessageIdByClientId finds a message by the id its sender's client gave it
+func (s *PostgresStore) GetMessageIdByClientId(ctx context.Context, sender string, clientId string) (string, error) {
+	q := "SELECT id FROM messages WHERE sender = $1 AND client_id = $2"
+
+	var messageId string
+	if err := s.pool.QueryRow(ctx, q, sender, clientId).Scan(&messageId); err != nil {
+		if err != pgx.ErrNoRows {
+			log.Println("Error in Store.GetMessageIdByClientId[Scan]:", err)
+		}
+		return "", err
+	}
+	return messageId, nil
 }
 
-func (s *Store) GetChatRoomsByUserId(ctx context.Context, userId string) ([]lib.Chatroom, error) {
-	q := `SELECT c.id, c.name, c.profile_picture, c.created_at, c.direct_message
+func (s *PostgresStore) GetChatRoomsByUserId(ctx context.Context, userId string) ([]lib.Chatroom, error) {
+	q := `SELECT c.id, c.name, c.profile_picture, c.created_at, c.direct_message, c.description, c.topic, c.default_start_url, c.stream_quality, c.updated_at
 	FROM chatrooms c
 	JOIN user_chatrooms uc ON c.id = uc.chatroom_id
 	WHERE uc.user_id = $1`
@@ -309,31 +369,36 @@ func (s *Store) GetChatRoomsByUserId(ctx context.Context, userId string) ([]lib.
 
 	for rows.Next() {
 		c := lib.Chatroom{}
-		rows.Scan(&c.Id, &c.Name, &c.ProfilePicture, &c.CreatedAt, &c.DirectMessage)
+		err := rows.Scan(&c.Id, &c.Name, &c.ProfilePicture, &c.CreatedAt, &c.DirectMessage,
+			&c.Description, &c.Topic, &c.DefaultStartUrl, &c.StreamQuality, &c.UpdatedAt)
+		if err != nil {
+			log.Println("Error in GetChatRoomsByUserId[Scan]:", err)
+			continue
+		}
 		chatroomList = append(chatroomList, c)
 	}
 
 	return chatroomList, rows.Err()
 }
 
-func (s *Store) SearchUsers(ctx context.Context, userId string, searchString string, limit int) ([]dto.SearchUserResponse, error) {
-	q := `SELECT name, user_id, username, email, status, profile_picture FROM search_users($2, $3) WHERE user_id != $1`
+func (s *PostgresStore) SearchUsers(ctx context.Context, userId string, searchString string, limit int) ([]dto.SearchUserResponse, error) {
+	// Only friends get to see the email
+	q := `SELECT name, user_id, username,
+		CASE WHEN su.user_id IN (SELECT friend_id FROM friend_ids($1)) THEN su.email ELSE '' END,
+		status, profile_picture, mutual_friend_count($1, su.user_id)
+	FROM search_users($1, $2, $3) su`
 
 	response := make([]dto.SearchUserResponse, 0)
 	rows, err := s.pool.Query(ctx, q, userId, searchString, limit)
-	if err == pgx.ErrNoRows {
-		log.Println("NO Rows in Store.SearchUsers[Query]:")
-		return response, nil
-	}
-
 	if err != nil {
 		log.Println("Error in Store.SearchUsers[Query]:", err)
 		return response, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		temp := dto.SearchUserResponse{}
-		err := rows.Scan(&temp.Name, &temp.UserId, &temp.Username, &temp.Email, &temp.Status, &temp.ProfilePicture)
+		err := rows.Scan(&temp.Name, &temp.UserId, &temp.Username, &temp.Email, &temp.Status, &temp.ProfilePicture, &temp.MutualFriends)
 		if err != nil {
 			log.Println("Error in Store.SearchUsers[Scan]:", err)
 			continue
@@ -341,7 +406,7 @@ func (s *Store) SearchUsers(ctx context.Context, userId string, searchString str
 		response = append(response, temp)
 	}
 
-	return response, nil
+	return response, rows.Err()
 }
 
 type UserFriend struct {
@@ -355,7 +420,7 @@ type UserFriend struct {
 	ChatroomId     string         `json:"chatroom_id"`
 }
 
-func (s *Store) GetFriendsByUserId(ctx context.Context, userId string) ([]UserFriend, error) {
+func (s *PostgresStore) GetFriendsByUserId(ctx context.Context, userId string) ([]UserFriend, error) {
 	q := `SELECT
 u.user_id,
 u.name,
@@ -364,7 +429,7 @@ u.profile_picture,
 u.status,
 f.status AS friendship_status,
 f.id AS friendship_id,
-uc.chatroom_id AS chatroom_id
+COALESCE(dm.chatroom_id::text, '') AS chatroom_id
 FROM friends f
 JOIN
     users u ON u.user_id = CASE
@@ -372,11 +437,7 @@ JOIN
         ELSE f.user_id1
     END
 LEFT JOIN
-    user_chatrooms uc ON (uc.user_id = u.user_id AND uc.chatroom_id IN (
-        SELECT chatroom_id
-        FROM user_chatrooms
-        WHERE user_id = $1
-    ))
+    direct_messages dm ON dm.user_low = LEAST(f.user_id1, f.user_id2) AND dm.user_high = GREATEST(f.user_id1, f.user_id2)
 WHERE $1 IN (f.user_id1, f.user_id2)
 AND f.status = 'accepted'`
 
@@ -406,7 +467,7 @@ AND f.status = 'accepted'`
 	return response, nil
 }
 
-func (s *Store) GetFriendRelationsByUserId(ctx context.Context, userId string) ([]lib.FriendRelations, error) {
+func (s *PostgresStore) GetFriendRelationsByUserId(ctx context.Context, userId string) ([]lib.FriendRelations, error) {
 	q := "SELECT id, user_id1, user_id2, created_at, updated_at, status FROM friends WHERE user_id1 = $1 OR user_id2 = $1"
 	response := make([]lib.FriendRelations, 0)
 
@@ -434,11 +495,11 @@ func (s *Store) GetFriendRelationsByUserId(ctx context.Context, userId string) (
 	return response, nil
 }
 
-func (s *Store) GetFriendRequestsByUserId(ctx context.Context, userId string) ([]dto.FriendRequestResponse, error) {
+func (s *PostgresStore) GetFriendRequestsByUserId(ctx context.Context, userId string) ([]dto.FriendRequestResponse, error) {
 	q := `SELECT u.name, u.username, u.profile_picture, f.id, f.user_id1, f.created_at, f.status
 	FROM friends f
 	JOIN users u
-	ON f.user_id2 = u.user_id
+	ON f.user_id1 = u.user_id
 	WHERE f.user_id2 = $1 AND f.status = $2`
 
 	response := make([]dto.FriendRequestResponse, 0)
@@ -467,7 +528,7 @@ func (s *Store) GetFriendRequestsByUserId(ctx context.Context, userId string) ([
 	return response, nil
 }
 
-func (s *Store) ChangeFriendStatus(ctx context.Context, id string, status string) error {
+func (s *PostgresStore) ChangeFriendStatus(ctx context.Context, id string, status string) error {
 	q := "UPDATE friends SET status = $1 WHERE id = $2"
 	log.Println("Chaging Friend Request Status for ID", id, "to", status)
 	_, err := s.pool.Exec(ctx, q, status, id)
@@ -478,19 +539,21 @@ func (s *Store) ChangeFriendStatus(ctx context.Context, id string, status string
 	return nil
 }
 
-func (s *Store) InsertFriendStatus(ctx context.Context, userId string, friendId string, status string) error {
-	q := "INSERT INTO friends (user_id1, user_id2, status) VALUES ($1, $2, $3)"
-	fmt.Println("userId", userId, "friendId", friendId, status, "status")
-	_, err := s.pool.Exec(ctx, q, userId, friendId, status)
+// InsertFriendStatus creates the relation between userId and friendId and
+// returns its id. There is at most one relation per pair of users.
+func (s *PostgresStore) InsertFriendStatus(ctx context.Context, userId string, friendId string, status string) (string, error) {
+	q := "INSERT INTO friends (user_id1, user_id2, status) VALUES ($1, $2, $3) RETURNING id"
 
+	var id string
+	err := s.pool.QueryRow(ctx, q, userId, friendId, status).Scan(&id)
 	if err != nil {
 		log.Println("Error in Store.InsertFriendStatus[Scan]:", err)
-		return err
+		return "", err
 	}
-	return nil
+	return id, nil
 }
 
-func (s *Store) GetRemoteByChatroomId(ctx context.Context, chatroomId string) (*dto.RemoteResponse, error) {
+func (s *PostgresStore) GetRemoteByChatroomId(ctx context.Context, chatroomId string) (*dto.RemoteResponse, error) {
 	q := `SELECT u.name, u.username, r.user_id, u.status
 	FROM remote r
 	JOIN users u
@@ -520,7 +583,7 @@ func (s *Store) GetRemoteByChatroomId(ctx context.Context, chatroomId string) (*
 	return response, nil
 }
 
-func (s *Store) UpdateRemote(ctx context.Context, chatroomId string, userId string) error {
+func (s *PostgresStore) UpdateRemote(ctx context.Context, chatroomId string, userId string) error {
 	q := "UPDATE remote SET user_id = $1 WHERE chatroom_id = $2"
 	_, err := s.pool.Exec(ctx, q, userId, chatroomId)
 	if err != nil {
@@ -530,7 +593,29 @@ func (s *Store) UpdateRemote(ctx context.Context, chatroomId string, userId stri
 
 	return nil
 }
-func (s *Store) CreateRemote(ctx context.Context, chatroomId string, userId string) error {
+
+// GetRemotesByUserId returns the chatrooms in which userId holds the remote
+func (s *PostgresStore) GetRemotesByUserId(ctx context.Context, userId string) ([]string, error) {
+	rows, err := s.pool.Query(ctx, "SELECT chatroom_id FROM remote WHERE user_id = $1", userId)
+	if err != nil {
+		log.Println("Error in Store.GetRemotesByUserId[Query]:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	chatroomIds := make([]string, 0)
+	for rows.Next() {
+		var chatroomId string
+		if err := rows.Scan(&chatroomId); err != nil {
+			log.Println("Error in Store.GetRemotesByUserId[Scan]:", err)
+			return nil, err
+		}
+		chatroomIds = append(chatroomIds, chatroomId)
+	}
+	return chatroomIds, rows.Err()
+}
+
+func (s *PostgresStore) CreateRemote(ctx context.Context, chatroomId string, userId string) error {
 	q := "INSERT INTO remote (user_id, chatroom_id) VALUES ($1, $2)"
 	_, err := s.pool.Exec(ctx, q, userId, chatroomId)
 	if err != nil {
@@ -540,3 +625,15 @@ func (s *Store) CreateRemote(ctx context.Context, chatroomId string, userId stri
 
 	return nil
 }
+
+func (s *PostgresStore) GetFriendRelationById(ctx context.Context, id string) (*lib.FriendRelations, error) {
+	q := "SELECT id, user_id1, user_id2, created_at, updated_at, status FROM friends WHERE id = $1"
+
+	f := lib.FriendRelations{}
+	err := s.pool.QueryRow(ctx, q, id).Scan(&f.Id, &f.UserId1, &f.UserId2, &f.CreatedAt, &f.UpdatedAt, &f.Status)
+	if err != nil {
+		log.Println("Error in Store.GetFriendRelationById[Scan]:", err)
+		return nil, err
+	}
+	return &f, nil
+}
@@ -0,0 +1,1778 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// MemoryStore keeps everything in maps and slices behind a single mutex.
+// It mirrors the constraints of the schema in internal/server/migrations
+// (unique keys, foreign keys, defaults) so tests against it behave like
+// they would against Postgres.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	users         []*lib.User
+	chatrooms     []*lib.Chatroom
+	members       []lib.UserChatroom
+	messages      []*lib.Message
+	mentions      []lib.MessageMention
+	previews      []lib.LinkPreview
+	attachments   []*lib.Attachment
+	friends       []*lib.FriendRelations
+	blocks        []block
+	dms           []directMessage
+	remotes       map[string]string
+	sessions      []*lib.Session
+	identities    []*lib.UserIdentity
+	resets        []*lib.PasswordReset
+	notifications []*lib.Notification
+	// users.deletion_requested_at, keyed by user id
+	deletions map[string]time.Time
+	// stream_rooms, keyed by chatroom id
+	streamRooms map[string]streamRoom
+	attempts    []attempt
+
+	nextUserId     int32
+	nextSessionId  int32
+	nextIdentityId int32
+	nextResetId    int32
+}
+
+type block struct {
+	blockerId string
+	blockedId string
+	createdAt time.Time
+}
+
+type streamRoom struct {
+	nodeId      string
+	claimedAt   time.Time
+	heartbeatAt time.Time
+}
+
+type attempt struct {
+	key       string
+	createdAt time.Time
+}
+
+type directMessage struct {
+	chatroomId string
+	userLow    string
+	userHigh   string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		remotes:     make(map[string]string),
+		deletions:   make(map[string]time.Time),
+		streamRooms: make(map[string]streamRoom),
+	}
+}
+
+func (m *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// Postgres TIMESTAMP columns keep microseconds and come back as UTC
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func uniqueViolation(constraint string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23505",
+		Message:        fmt.Sprintf("duplicate key value violates unique constraint %q", constraint),
+		ConstraintName: constraint,
+	}
+}
+
+func foreignKeyViolation(constraint string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23503",
+		Message:        fmt.Sprintf("violates foreign key constraint %q", constraint),
+		ConstraintName: constraint,
+	}
+}
+
+func checkViolation(constraint string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23514",
+		Message:        fmt.Sprintf("violates check constraint %q", constraint),
+		ConstraintName: constraint,
+	}
+}
+
+// The helpers below expect m.mu to be held
+
+func (m *MemoryStore) user(userId string) *lib.User {
+	for _, u := range m.users {
+		if u.UserId == userId {
+			return u
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) chatroom(chatroomId string) *lib.Chatroom {
+	for _, c := range m.chatrooms {
+		if c.Id == chatroomId {
+			return c
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) message(messageId string) *lib.Message {
+	for _, msg := range m.messages {
+		if msg.Id == messageId {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) isBlocked(userId string, otherId string) bool {
+	for _, b := range m.blocks {
+		if (b.blockerId == userId && b.blockedId == otherId) || (b.blockerId == otherId && b.blockedId == userId) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) relationBetween(userId string, otherId string) *lib.FriendRelations {
+	for _, f := range m.friends {
+		if (f.UserId1 == userId && f.UserId2 == otherId) || (f.UserId1 == otherId && f.UserId2 == userId) {
+			return f
+		}
+	}
+	return nil
+}
+
+// friendIds mirrors the friend_ids SQL function
+func (m *MemoryStore) friendIds(userId string) []string {
+	ids := make([]string, 0)
+	for _, f := range m.friends {
+		if f.Status.String != "accepted" {
+			continue
+		}
+		if f.UserId1 == userId {
+			ids = append(ids, f.UserId2)
+		} else if f.UserId2 == userId {
+			ids = append(ids, f.UserId1)
+		}
+	}
+	return ids
+}
+
+func (m *MemoryStore) mutualFriendCount(userId string, otherId string) int {
+	theirs := m.friendIds(otherId)
+	count := 0
+	for _, id := range m.friendIds(userId) {
+		if slices.Contains(theirs, id) {
+			count++
+		}
+	}
+	return count
+}
+
+func (m *MemoryStore) isMember(userId string, chatroomId string) bool {
+	for _, uc := range m.members {
+		if uc.UserId == userId && uc.ChatroomId == chatroomId {
+			return true
+		}
+	}
+	return false
+}
+
+// Users
+
+func (m *MemoryStore) CreateUser(ctx context.Context, user *dto.SignupUserRequest) (*string, error) {
+	hashedPassword, err := lib.HashPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == user.Email {
+			return nil, uniqueViolation("users_email_key")
+		}
+	}
+	if m.usernameTaken(user.Username, "") {
+		return nil, uniqueViolation("users_username_idx")
+	}
+
+	m.nextUserId++
+	u := &lib.User{
+		Id:           m.nextUserId,
+		UserId:       newUUID(),
+		Name:         user.Name,
+		Email:        user.Email,
+		Username:     user.Username,
+		PasswordHash: hashedPassword,
+		CreatedAt:    now(),
+	}
+	m.users = append(m.users, u)
+
+	userId := u.UserId
+	return &userId, nil
+}
+
+func (m *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*lib.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == email {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (m *MemoryStore) GetUserById(ctx context.Context, userId string) (*lib.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.user(userId)
+	if u == nil {
+		return nil, pgx.ErrNoRows
+	}
+	user := *u
+	return &user, nil
+}
+
+// SearchUsers follows the search_users function: the caller and blocked
+// users are filtered out before the limit and only friends get their email
+// returned.
+func (m *MemoryStore) SearchUsers(ctx context.Context, userId string, searchString string, limit int) ([]dto.SearchUserResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := make([]dto.SearchUserResponse, 0)
+	if searchString == "" {
+		return response, nil
+	}
+	query := strings.ToLower(searchString)
+
+	matches := make([]*lib.User, 0)
+	for _, u := range m.users {
+		name, username, email := strings.ToLower(u.Name), strings.ToLower(u.Username), strings.ToLower(u.Email)
+		if u.UserId == userId || m.isBlocked(userId, u.UserId) {
+			continue
+		}
+		if strings.Contains(name, query) || strings.Contains(username, query) || email == strings.TrimSpace(query) {
+			matches = append(matches, u)
+		}
+	}
+
+	rank := func(u *lib.User) [3]bool {
+		username := strings.ToLower(u.Username)
+		return [3]bool{
+			username == query,
+			strings.HasPrefix(username, query),
+			strings.HasPrefix(strings.ToLower(u.Name), query),
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		ri, rj := rank(matches[i]), rank(matches[j])
+		for k := range ri {
+			if ri[k] != rj[k] {
+				return ri[k]
+			}
+		}
+		return matches[i].Name < matches[j].Name
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	for _, u := range matches {
+		email := ""
+		if slices.Contains(m.friendIds(userId), u.UserId) {
+			email = u.Email
+		}
+		response = append(response, dto.SearchUserResponse{
+			Name:           u.Name,
+			UserId:         u.UserId,
+			Username:       u.Username,
+			Email:          email,
+			Status:         u.Status,
+			ProfilePicture: u.ProfilePicture,
+			MutualFriends:  m.mutualFriendCount(userId, u.UserId),
+		})
+	}
+	return response, nil
+}
+
+// usernameTaken mirrors users_username_idx, usernames are unique regardless
+// of case and empty ones are not indexed
+func (m *MemoryStore) usernameTaken(username string, exceptUserId string) bool {
+	if username == "" {
+		return false
+	}
+	for _, u := range m.users {
+		if u.UserId != exceptUserId && strings.EqualFold(u.Username, username) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) UpdateUserProfile(ctx context.Context, userId string, profile dto.UpdateUserRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.user(userId)
+	if u == nil {
+		return pgx.ErrNoRows
+	}
+	if profile.Username != nil && m.usernameTaken(*profile.Username, userId) {
+		return uniqueViolation("users_username_idx")
+	}
+
+	if profile.Name != nil {
+		u.Name = *profile.Name
+	}
+	if profile.Username != nil {
+		u.Username = *profile.Username
+	}
+	if profile.Bio != nil {
+		u.Bio = *profile.Bio
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdateProfilePicture(ctx context.Context, userId string, profilePicture string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.user(userId)
+	if u == nil {
+		return pgx.ErrNoRows
+	}
+	u.ProfilePicture = sql.NullString{String: profilePicture, Valid: profilePicture != ""}
+	return nil
+}
+
+func (m *MemoryStore) ScheduleUserDeletion(ctx context.Context, userId string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.user(userId) == nil {
+		return time.Time{}, pgx.ErrNoRows
+	}
+	requestedAt, ok := m.deletions[userId]
+	if !ok {
+		requestedAt = now()
+		m.deletions[userId] = requestedAt
+	}
+	return requestedAt, nil
+}
+
+func (m *MemoryStore) CancelUserDeletion(ctx context.Context, userId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.deletions[userId]
+	delete(m.deletions, userId)
+	return ok, nil
+}
+
+func (m *MemoryStore) GetUsersDueForDeletion(ctx context.Context, grace time.Duration, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := now().Add(-grace)
+	userIds := make([]string, 0)
+	for userId, requestedAt := range m.deletions {
+		if requestedAt.Before(before) {
+			userIds = append(userIds, userId)
+		}
+	}
+	sort.Slice(userIds, func(i, j int) bool {
+		return m.deletions[userIds[i]].Before(m.deletions[userIds[j]])
+	})
+	return userIds[:min(limit, len(userIds))], nil
+}
+
+// DeleteUser applies the ON DELETE rules of every table referencing users
+func (m *MemoryStore) DeleteUser(ctx context.Context, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.user(userId) == nil {
+		return pgx.ErrNoRows
+	}
+
+	m.users = slices.DeleteFunc(m.users, func(u *lib.User) bool { return u.UserId == userId })
+	m.members = slices.DeleteFunc(m.members, func(uc lib.UserChatroom) bool { return uc.UserId == userId })
+	m.mentions = slices.DeleteFunc(m.mentions, func(mm lib.MessageMention) bool { return mm.UserId == userId })
+	m.attachments = slices.DeleteFunc(m.attachments, func(a *lib.Attachment) bool { return a.Uploader == userId })
+	m.friends = slices.DeleteFunc(m.friends, func(f *lib.FriendRelations) bool {
+		return f.UserId1 == userId || f.UserId2 == userId
+	})
+	m.blocks = slices.DeleteFunc(m.blocks, func(b block) bool { return b.blockerId == userId || b.blockedId == userId })
+	m.dms = slices.DeleteFunc(m.dms, func(dm directMessage) bool { return dm.userLow == userId || dm.userHigh == userId })
+	m.sessions = slices.DeleteFunc(m.sessions, func(s *lib.Session) bool { return s.UserId == userId })
+	m.identities = slices.DeleteFunc(m.identities, func(i *lib.UserIdentity) bool { return i.UserId == userId })
+	m.resets = slices.DeleteFunc(m.resets, func(r *lib.PasswordReset) bool { return r.UserId == userId })
+	m.notifications = slices.DeleteFunc(m.notifications, func(n *lib.Notification) bool { return n.UserId == userId })
+	for chatroomId, holder := range m.remotes {
+		if holder == userId {
+			delete(m.remotes, chatroomId)
+		}
+	}
+	delete(m.deletions, userId)
+
+	// ON DELETE SET NULL
+	for _, msg := range m.messages {
+		if msg.Sender == userId {
+			msg.Sender = ""
+		}
+	}
+	for _, n := range m.notifications {
+		if n.ActorId.String == userId {
+			n.ActorId = sql.NullString{}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdatePasswordHash(ctx context.Context, userId string, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u := m.user(userId); u != nil {
+		u.PasswordHash = passwordHash
+	}
+	return nil
+}
+
+func (m *MemoryStore) MarkEmailVerified(ctx context.Context, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u := m.user(userId); u != nil {
+		u.EmailVerified = true
+	}
+	return nil
+}
+
+// Chatrooms
+
+func (m *MemoryStore) GetChatRoomById(ctx context.Context, chatroomId string) (*lib.Chatroom, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.chatroom(chatroomId)
+	if c == nil {
+		return &lib.Chatroom{}, lib.NotFound(fmt.Sprintf("No chatroom with id %s", chatroomId))
+	}
+	chatroom := *c
+	return &chatroom, nil
+}
+
+func (m *MemoryStore) GetChatRoomsByUserId(ctx context.Context, userId string) ([]lib.Chatroom, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chatroomList := make([]lib.Chatroom, 0)
+	for _, uc := range m.members {
+		if uc.UserId != userId {
+			continue
+		}
+		if c := m.chatroom(uc.ChatroomId); c != nil {
+			chatroomList = append(chatroomList, *c)
+		}
+	}
+	return chatroomList, nil
+}
+
+func (m *MemoryStore) GetUsersByChatroomId(ctx context.Context, chatroomId string) ([]lib.UserId, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userIds := make([]lib.UserId, 0)
+	for _, uc := range m.members {
+		if uc.ChatroomId == chatroomId {
+			userIds = append(userIds, uc.UserId)
+		}
+	}
+	return userIds, nil
+}
+
+func (m *MemoryStore) IsUserInChatroom(ctx context.Context, userId string, chatroomId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.isMember(userId, chatroomId), nil
+}
+
+// newChatroomRow fills in the column defaults of a chatroom row
+func newChatroomRow(name string, profilePicture sql.NullString, directMessage bool) *lib.Chatroom {
+	createdAt := now()
+	return &lib.Chatroom{
+		Id:             newUUID(),
+		Name:           name,
+		ProfilePicture: profilePicture,
+		DirectMessage:  directMessage,
+		StreamQuality:  "high",
+		CreatedAt:      createdAt,
+		UpdatedAt:      createdAt,
+	}
+}
+
+func (m *MemoryStore) createChatroom(name string, profilePicture sql.NullString, directMessage bool) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := newChatroomRow(name, profilePicture, directMessage)
+	m.chatrooms = append(m.chatrooms, c)
+	return c.Id
+}
+
+func (m *MemoryStore) CreateChatRoom(ctx context.Context, chatroom dto.CreateChatRoomRequest) (string, error) {
+	profilePicture := sql.NullString{String: chatroom.ProfilePicture, Valid: true}
+	chatroomId := m.createChatroom(chatroom.Name, profilePicture, chatroom.DirectMessage)
+
+	err := m.AddParticipantsToChatRoom(ctx, chatroomId, chatroom.Participants)
+	return chatroomId, err
+}
+
+// directMessage returns the id of the pair's direct message room, empty when
+// there is none
+func (m *MemoryStore) directMessage(userId string, otherId string) string {
+	low, high := directMessagePair(userId, otherId)
+	for _, dm := range m.dms {
+		if dm.userLow == low && dm.userHigh == high {
+			return dm.chatroomId
+		}
+	}
+	return ""
+}
+
+func (m *MemoryStore) GetDirectMessage(ctx context.Context, userId string, otherId string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if chatroomId := m.directMessage(userId, otherId); chatroomId != "" {
+		return chatroomId, nil
+	}
+	return "", pgx.ErrNoRows
+}
+
+func (m *MemoryStore) GetOrCreateDirectMessage(ctx context.Context, userId string, otherId string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if chatroomId := m.directMessage(userId, otherId); chatroomId != "" {
+		return chatroomId, nil
+	}
+
+	low, high := directMessagePair(userId, otherId)
+	switch {
+	case m.user(low) == nil:
+		return "", foreignKeyViolation("direct_messages_user_low_fkey")
+	case m.user(high) == nil:
+		return "", foreignKeyViolation("direct_messages_user_high_fkey")
+	case low == high:
+		return "", checkViolation("direct_messages_check")
+	}
+
+	c := newChatroomRow("", sql.NullString{}, true)
+	m.chatrooms = append(m.chatrooms, c)
+	m.dms = append(m.dms, directMessage{chatroomId: c.Id, userLow: low, userHigh: high})
+	m.members = append(m.members,
+		lib.UserChatroom{UserId: low, ChatroomId: c.Id, Role: lib.ChatroomOwner},
+		lib.UserChatroom{UserId: high, ChatroomId: c.Id, Role: lib.ChatroomOwner},
+	)
+	return c.Id, nil
+}
+
+func (m *MemoryStore) CreateChatRoomByName(ctx context.Context, name string) (string, error) {
+	return m.createChatroom(name, sql.NullString{}, false), nil
+}
+
+// AddParticipantsToChatRoom inserts one row at a time like the Postgres
+// version, participants before a failing one stay added
+func (m *MemoryStore) AddParticipantsToChatRoom(ctx context.Context, chatroomId string, participants []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, userId := range participants {
+		var err error
+		switch {
+		case m.user(userId) == nil:
+			err = foreignKeyViolation("user_chatrooms_user_id_fkey")
+		case m.chatroom(chatroomId) == nil:
+			err = foreignKeyViolation("user_chatrooms_chatroom_id_fkey")
+		case m.isMember(userId, chatroomId):
+			err = uniqueViolation("user_chatrooms_pkey")
+		}
+		if err != nil {
+			return fmt.Errorf("Error in Adding participants: %w", err)
+		}
+		m.members = append(m.members, lib.UserChatroom{UserId: userId, ChatroomId: chatroomId, Role: lib.ChatroomMember})
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetChatroomRole(ctx context.Context, userId string, chatroomId string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, uc := range m.members {
+		if uc.UserId == userId && uc.ChatroomId == chatroomId {
+			return uc.Role, nil
+		}
+	}
+	return "", pgx.ErrNoRows
+}
+
+func (m *MemoryStore) GetChatroomMembers(ctx context.Context, chatroomId string) ([]lib.UserChatroom, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := make([]lib.UserChatroom, 0)
+	for _, uc := range m.members {
+		if uc.ChatroomId == chatroomId {
+			members = append(members, uc)
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		if (members[i].Role == lib.ChatroomOwner) != (members[j].Role == lib.ChatroomOwner) {
+			return members[i].Role == lib.ChatroomOwner
+		}
+		return members[i].UserId < members[j].UserId
+	})
+	return members, nil
+}
+
+func (m *MemoryStore) SetChatroomRole(ctx context.Context, chatroomId string, userId string, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if role != lib.ChatroomOwner && role != lib.ChatroomMember {
+		return checkViolation("user_chatrooms_role_check")
+	}
+	for i, uc := range m.members {
+		if uc.UserId == userId && uc.ChatroomId == chatroomId {
+			m.members[i].Role = role
+			return nil
+		}
+	}
+	return pgx.ErrNoRows
+}
+
+func (m *MemoryStore) UpdateChatroom(ctx context.Context, chatroomId string, settings dto.UpdateChatroomRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.chatroom(chatroomId)
+	if c == nil {
+		return pgx.ErrNoRows
+	}
+	if settings.StreamQuality != nil && !slices.Contains([]string{"low", "medium", "high"}, *settings.StreamQuality) {
+		return checkViolation("chatrooms_stream_quality_check")
+	}
+
+	if settings.Name != nil {
+		c.Name = *settings.Name
+	}
+	if settings.Description != nil {
+		c.Description = *settings.Description
+	}
+	if settings.Topic != nil {
+		c.Topic = *settings.Topic
+	}
+	if settings.DefaultStartUrl != nil {
+		c.DefaultStartUrl = *settings.DefaultStartUrl
+	}
+	if settings.StreamQuality != nil {
+		c.StreamQuality = *settings.StreamQuality
+	}
+	c.UpdatedAt = now()
+	return nil
+}
+
+func (m *MemoryStore) UpdateChatroomPicture(ctx context.Context, chatroomId string, profilePicture string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.chatroom(chatroomId)
+	if c == nil {
+		return pgx.ErrNoRows
+	}
+	c.ProfilePicture = sql.NullString{String: profilePicture, Valid: profilePicture != ""}
+	c.UpdatedAt = now()
+	return nil
+}
+
+func (m *MemoryStore) GetChatroomMembersByUsernames(ctx context.Context, chatroomId string, usernames []string) ([]lib.MessageMention, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := make([]lib.MessageMention, 0)
+	for _, uc := range m.members {
+		if uc.ChatroomId != chatroomId {
+			continue
+		}
+		u := m.user(uc.UserId)
+		if u != nil && lib.Contains(usernames, strings.ToLower(u.Username)) {
+			response = append(response, lib.MessageMention{UserId: u.UserId, Username: u.Username})
+		}
+	}
+	return response, nil
+}
+
+// Messages
+
+func (m *MemoryStore) StoreChatRoomMessage(ctx context.Context, msg StoreChatMessageDto) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.user(msg.Sender) == nil {
+		return "", foreignKeyViolation("messages_sender_fkey")
+	}
+	if m.chatroom(msg.ChatroomId) == nil {
+		return "", foreignKeyViolation("messages_recipient_fkey")
+	}
+	if msg.Id != "" {
+		for _, existing := range m.messages {
+			if existing.Sender == msg.Sender && existing.ClientId == msg.Id {
+				return "", uniqueViolation("messages_sender_client_id_idx")
+			}
+		}
+	}
+
+	stored := &lib.Message{
+		Id:         newUUID(),
+		ClientId:   msg.Id,
+		Sender:     msg.Sender,
+		ChatroomId: msg.ChatroomId,
+		Content:    msg.Content,
+		CreatedAt:  now(),
+	}
+	m.messages = append(m.messages, stored)
+
+	for _, a := range m.attachments {
+		if lib.Contains(msg.Attachments, a.Id) && a.ChatroomId == msg.ChatroomId && a.Uploader == msg.Sender && !a.MessageId.Valid {
+			a.MessageId = sql.NullString{String: stored.Id, Valid: true}
+		}
+	}
+
+	return stored.Id, nil
+}
+
+func (m *MemoryStore) GetMessageIdByClientId(ctx context.Context, sender string, clientId string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, msg := range m.messages {
+		if msg.Sender == sender && msg.ClientId == clientId {
+			return msg.Id, nil
+		}
+	}
+	return "", pgx.ErrNoRows
+}
+
+func (m *MemoryStore) GetLast50ChatRoomMessages(ctx context.Context, chatroomId string, offset int, mentionedUserId string) ([]lib.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]lib.Message, 0)
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		if msg.ChatroomId != chatroomId {
+			continue
+		}
+
+		var mentions []string
+		mentioned := false
+		for _, mm := range m.mentions {
+			if mm.MessageId == msg.Id {
+				mentions = append(mentions, mm.UserId)
+				mentioned = mentioned || mm.UserId == mentionedUserId
+			}
+		}
+		if mentionedUserId != "" && !mentioned {
+			continue
+		}
+
+		tempMsg := *msg
+		if u := m.user(msg.Sender); u != nil {
+			tempMsg.SenderName = u.Name
+		} else {
+			tempMsg.SenderName = lib.DeletedUserName
+		}
+		tempMsg.Mentions = mentions
+		for _, a := range m.attachments {
+			if a.MessageId.String == msg.Id {
+				tempMsg.Attachments = append(tempMsg.Attachments, *a)
+			}
+		}
+		for _, p := range m.previews {
+			if p.MessageId == msg.Id {
+				tempMsg.Previews = append(tempMsg.Previews, p)
+			}
+		}
+		messages = append(messages, tempMsg)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.After(messages[j].CreatedAt)
+	})
+
+	start := min(50*offset, len(messages))
+	end := min(start+50, len(messages))
+	return messages[start:end], nil
+}
+
+// StoreMessageMentions stores all of the mentions or none, like the single
+// INSERT does
+func (m *MemoryStore) StoreMessageMentions(ctx context.Context, messageId string, userIds []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := make([]string, 0)
+	if len(userIds) == 0 {
+		return added, nil
+	}
+	if m.message(messageId) == nil {
+		return nil, foreignKeyViolation("message_mentions_message_id_fkey")
+	}
+	for _, userId := range userIds {
+		if m.user(userId) == nil {
+			return nil, foreignKeyViolation("message_mentions_user_id_fkey")
+		}
+	}
+
+	for _, userId := range userIds {
+		mention := lib.MessageMention{MessageId: messageId, UserId: userId}
+		if !lib.Contains(m.mentions, mention) {
+			m.mentions = append(m.mentions, mention)
+			added = append(added, userId)
+		}
+	}
+	return added, nil
+}
+
+func (m *MemoryStore) StoreLinkPreviews(ctx context.Context, previews []lib.LinkPreview) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+next:
+	for _, p := range previews {
+		if m.message(p.MessageId) == nil {
+			return foreignKeyViolation("link_previews_message_id_fkey")
+		}
+		for _, existing := range m.previews {
+			if existing.MessageId == p.MessageId && existing.Url == p.Url {
+				continue next
+			}
+		}
+		m.previews = append(m.previews, p)
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateAttachment(ctx context.Context, a *lib.Attachment) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.chatroom(a.ChatroomId) == nil {
+		return "", foreignKeyViolation("attachments_chatroom_id_fkey")
+	}
+	if m.user(a.Uploader) == nil {
+		return "", foreignKeyViolation("attachments_uploader_fkey")
+	}
+
+	a.Id = newUUID()
+	a.CreatedAt = now()
+
+	stored := *a
+	stored.MessageId = sql.NullString{}
+	stored.Url, stored.ThumbnailUrl = "", ""
+	m.attachments = append(m.attachments, &stored)
+	return a.Id, nil
+}
+
+func (m *MemoryStore) GetAttachmentById(ctx context.Context, id string) (*lib.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, a := range m.attachments {
+		if a.Id == id {
+			attachment := *a
+			return &attachment, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (m *MemoryStore) GetAttachmentsByUploader(ctx context.Context, userId string) ([]lib.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attachments := make([]lib.Attachment, 0)
+	for _, a := range m.attachments {
+		if a.Uploader == userId {
+			attachments = append(attachments, *a)
+		}
+	}
+	return attachments, nil
+}
+
+func (m *MemoryStore) GetMessagesBySender(ctx context.Context, userId string, offset int, limit int) ([]lib.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.user(userId)
+	messages := make([]lib.Message, 0)
+	for _, msg := range m.messages {
+		if u != nil && msg.Sender == userId {
+			message := *msg
+			message.SenderName = u.Name
+			messages = append(messages, message)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+
+	start := min(offset, len(messages))
+	end := min(start+limit, len(messages))
+	return messages[start:end], nil
+}
+
+// Friends
+
+func (m *MemoryStore) GetFriendsByUserId(ctx context.Context, userId string) ([]UserFriend, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := make([]UserFriend, 0)
+	for _, f := range m.friends {
+		if (f.UserId1 != userId && f.UserId2 != userId) || f.Status.String != "accepted" {
+			continue
+		}
+		friendId := f.UserId2
+		if f.UserId1 != userId {
+			friendId = f.UserId1
+		}
+		u := m.user(friendId)
+		if u == nil {
+			continue
+		}
+
+		friend := UserFriend{
+			UserId:         u.UserId,
+			Name:           u.Name,
+			Username:       u.Username,
+			ProfilePicture: u.ProfilePicture,
+			UserStatus:     u.Status,
+			FriendStatus:   f.Status.String,
+			FriendshipId:   f.Id,
+		}
+
+		friend.ChatroomId = m.directMessage(userId, friendId)
+		response = append(response, friend)
+	}
+	return response, nil
+}
+
+// GetFriendSuggestions mirrors the friend_suggestions SQL function
+func (m *MemoryStore) GetFriendSuggestions(ctx context.Context, userId string, limit int) ([]dto.FriendSuggestionResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mutual := make(map[string]int)
+	for _, friendId := range m.friendIds(userId) {
+		for _, id := range m.friendIds(friendId) {
+			mutual[id]++
+		}
+	}
+
+	shared := make(map[string]int)
+	for _, mine := range m.members {
+		if mine.UserId != userId {
+			continue
+		}
+		if c := m.chatroom(mine.ChatroomId); c == nil || c.DirectMessage {
+			continue
+		}
+		for _, other := range m.members {
+			if other.ChatroomId == mine.ChatroomId {
+				shared[other.UserId]++
+			}
+		}
+	}
+
+	response := make([]dto.FriendSuggestionResponse, 0)
+	for _, u := range m.users {
+		if mutual[u.UserId] == 0 && shared[u.UserId] == 0 {
+			continue
+		}
+		if u.UserId == userId || m.relationBetween(userId, u.UserId) != nil || m.isBlocked(userId, u.UserId) {
+			continue
+		}
+		response = append(response, dto.FriendSuggestionResponse{
+			UserId:          u.UserId,
+			Name:            u.Name,
+			Username:        u.Username,
+			Status:          u.Status,
+			ProfilePicture:  u.ProfilePicture,
+			MutualFriends:   mutual[u.UserId],
+			SharedChatrooms: shared[u.UserId],
+		})
+	}
+
+	sort.Slice(response, func(i, j int) bool {
+		a, b := response[i], response[j]
+		if a.MutualFriends != b.MutualFriends {
+			return a.MutualFriends > b.MutualFriends
+		}
+		if a.SharedChatrooms != b.SharedChatrooms {
+			return a.SharedChatrooms > b.SharedChatrooms
+		}
+		return a.UserId < b.UserId
+	})
+	if len(response) > limit {
+		response = response[:limit]
+	}
+	return response, nil
+}
+
+func (m *MemoryStore) GetFriendRelationsByUserId(ctx context.Context, userId string) ([]lib.FriendRelations, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := make([]lib.FriendRelations, 0)
+	for _, f := range m.friends {
+		if f.UserId1 == userId || f.UserId2 == userId {
+			response = append(response, *f)
+		}
+	}
+	return response, nil
+}
+
+func (m *MemoryStore) GetFriendRequestsByUserId(ctx context.Context, userId string) ([]dto.FriendRequestResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := make([]dto.FriendRequestResponse, 0)
+	for _, f := range m.friends {
+		if f.UserId2 != userId || f.Status.String != "pending" {
+			continue
+		}
+		u := m.user(f.UserId1)
+		if u == nil {
+			continue
+		}
+		response = append(response, dto.FriendRequestResponse{
+			Name:           u.Name,
+			Username:       u.Username,
+			ProfilePicture: u.ProfilePicture,
+			RequestId:      f.Id,
+			UserId:         f.UserId1,
+			CreatedAt:      f.CreatedAt,
+			Status:         f.Status.String,
+		})
+	}
+	return response, nil
+}
+
+func (m *MemoryStore) GetFriendRelationById(ctx context.Context, id string) (*lib.FriendRelations, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range m.friends {
+		if f.Id == id {
+			relation := *f
+			return &relation, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (m *MemoryStore) InsertFriendStatus(ctx context.Context, userId string, friendId string, status string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.user(userId) == nil {
+		return "", foreignKeyViolation("friends_user_id1_fkey")
+	}
+	if m.user(friendId) == nil {
+		return "", foreignKeyViolation("friends_user_id2_fkey")
+	}
+	if userId == friendId {
+		return "", checkViolation("friends_not_self")
+	}
+	if m.relationBetween(userId, friendId) != nil {
+		return "", uniqueViolation("friends_pair_idx")
+	}
+
+	createdAt := now()
+	relation := &lib.FriendRelations{
+		Id:        newUUID(),
+		UserId1:   userId,
+		UserId2:   friendId,
+		Status:    sql.NullString{String: status, Valid: true},
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+	m.friends = append(m.friends, relation)
+	return relation.Id, nil
+}
+
+func (m *MemoryStore) GetFriendRelationBetween(ctx context.Context, userId string, otherId string) (*lib.FriendRelations, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.relationBetween(userId, otherId)
+	if f == nil {
+		return nil, pgx.ErrNoRows
+	}
+	relation := *f
+	return &relation, nil
+}
+
+func (m *MemoryStore) GetSentFriendRequestsByUserId(ctx context.Context, userId string) ([]dto.FriendRequestResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := make([]dto.FriendRequestResponse, 0)
+	for _, f := range m.friends {
+		if f.UserId1 != userId || f.Status.String != "pending" {
+			continue
+		}
+		u := m.user(f.UserId2)
+		if u == nil {
+			continue
+		}
+		response = append(response, dto.FriendRequestResponse{
+			Name:           u.Name,
+			Username:       u.Username,
+			ProfilePicture: u.ProfilePicture,
+			RequestId:      f.Id,
+			UserId:         f.UserId2,
+			CreatedAt:      f.CreatedAt,
+			Status:         f.Status.String,
+		})
+	}
+	return response, nil
+}
+
+func (m *MemoryStore) DeleteFriendRelation(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.friends = slices.DeleteFunc(m.friends, func(f *lib.FriendRelations) bool {
+		return f.Id == id
+	})
+	return nil
+}
+
+func (m *MemoryStore) BlockUser(ctx context.Context, blockerId string, blockedId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.user(blockerId) == nil {
+		return foreignKeyViolation("blocks_blocker_id_fkey")
+	}
+	if m.user(blockedId) == nil {
+		return foreignKeyViolation("blocks_blocked_id_fkey")
+	}
+	if blockerId == blockedId {
+		return checkViolation("blocks_check")
+	}
+
+	exists := slices.ContainsFunc(m.blocks, func(b block) bool {
+		return b.blockerId == blockerId && b.blockedId == blockedId
+	})
+	if !exists {
+		m.blocks = append(m.blocks, block{blockerId: blockerId, blockedId: blockedId, createdAt: now()})
+	}
+
+	m.friends = slices.DeleteFunc(m.friends, func(f *lib.FriendRelations) bool {
+		return (f.UserId1 == blockerId && f.UserId2 == blockedId) || (f.UserId1 == blockedId && f.UserId2 == blockerId)
+	})
+	return nil
+}
+
+func (m *MemoryStore) UnblockUser(ctx context.Context, blockerId string, blockedId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blocks = slices.DeleteFunc(m.blocks, func(b block) bool {
+		return b.blockerId == blockerId && b.blockedId == blockedId
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetBlockedUsers(ctx context.Context, userId string) ([]dto.BlockedUserResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := make([]dto.BlockedUserResponse, 0)
+	for i := len(m.blocks) - 1; i >= 0; i-- {
+		b := m.blocks[i]
+		if b.blockerId != userId {
+			continue
+		}
+		if u := m.user(b.blockedId); u != nil {
+			response = append(response, dto.BlockedUserResponse{
+				UserId:         u.UserId,
+				Name:           u.Name,
+				Username:       u.Username,
+				ProfilePicture: u.ProfilePicture,
+				BlockedAt:      b.createdAt,
+			})
+		}
+	}
+	return response, nil
+}
+
+func (m *MemoryStore) IsBlocked(ctx context.Context, userId string, otherId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.isBlocked(userId, otherId), nil
+}
+
+func (m *MemoryStore) IsDirectMessageBlocked(ctx context.Context, userId string, chatroomId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.chatroom(chatroomId)
+	if c == nil || !c.DirectMessage {
+		return false, nil
+	}
+	for _, uc := range m.members {
+		if uc.ChatroomId == chatroomId && m.isBlocked(userId, uc.UserId) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) ChangeFriendStatus(ctx context.Context, id string, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range m.friends {
+		if f.Id == id {
+			f.Status = sql.NullString{String: status, Valid: true}
+			f.UpdatedAt = now()
+		}
+	}
+	return nil
+}
+
+// Remotes
+
+func (m *MemoryStore) GetRemoteByChatroomId(ctx context.Context, chatroomId string) (*dto.RemoteResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := &dto.RemoteResponse{}
+	if u := m.user(m.remotes[chatroomId]); u != nil {
+		response.Name = u.Name
+		response.Username = u.Username
+		response.UserId = u.UserId
+		response.Status = u.Status
+	}
+	return response, nil
+}
+
+func (m *MemoryStore) CreateRemote(ctx context.Context, chatroomId string, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.chatroom(chatroomId) == nil {
+		return foreignKeyViolation("remote_chatroom_id_fkey")
+	}
+	if m.user(userId) == nil {
+		return foreignKeyViolation("remote_user_id_fkey")
+	}
+	if _, ok := m.remotes[chatroomId]; ok {
+		return uniqueViolation("remote_pkey")
+	}
+	m.remotes[chatroomId] = userId
+	return nil
+}
+
+func (m *MemoryStore) GetRemotesByUserId(ctx context.Context, userId string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chatroomIds := make([]string, 0)
+	for chatroomId, holder := range m.remotes {
+		if holder == userId {
+			chatroomIds = append(chatroomIds, chatroomId)
+		}
+	}
+	sort.Strings(chatroomIds)
+	return chatroomIds, nil
+}
+
+func (m *MemoryStore) UpdateRemote(ctx context.Context, chatroomId string, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.remotes[chatroomId]; !ok {
+		return nil
+	}
+	if m.user(userId) == nil {
+		return foreignKeyViolation("remote_user_id_fkey")
+	}
+	m.remotes[chatroomId] = userId
+	return nil
+}
+
+// Sessions
+
+func (m *MemoryStore) CreateSession(ctx context.Context, session *lib.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.user(session.UserId) == nil {
+		return foreignKeyViolation("session_user_id_fkey")
+	}
+	for _, s := range m.sessions {
+		if s.Token == session.Token {
+			return uniqueViolation("session_token_key")
+		}
+	}
+
+	m.nextSessionId++
+	session.Id = m.nextSessionId
+	session.CreatedAt = now()
+
+	stored := *session
+	stored.ExpiresAt = stored.ExpiresAt.UTC().Truncate(time.Microsecond)
+	m.sessions = append(m.sessions, &stored)
+	return nil
+}
+
+func (m *MemoryStore) GetSessionByToken(ctx context.Context, tokenHash string) (*lib.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.Token == tokenHash || (s.PreviousToken.Valid && s.PreviousToken.String == tokenHash) {
+			session := *s
+			return &session, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func sessionActive(session *lib.Session) bool {
+	return !session.RevokedAt.Valid && session.ExpiresAt.After(now())
+}
+
+func (m *MemoryStore) RotateSession(ctx context.Context, sessionId int32, oldTokenHash string, newTokenHash string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.Id == sessionId && s.Token == oldTokenHash && sessionActive(s) {
+			s.PreviousToken = sql.NullString{String: s.Token, Valid: true}
+			s.Token = newTokenHash
+			s.RotatedAt = sql.NullTime{Time: now(), Valid: true}
+			s.ExpiresAt = expiresAt.UTC().Truncate(time.Microsecond)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) IsSessionActive(ctx context.Context, sessionId int32) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.Id == sessionId {
+			return sessionActive(s), nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) RevokeSession(ctx context.Context, userId string, sessionId int32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.Id == sessionId && s.UserId == userId && !s.RevokedAt.Valid {
+			s.RevokedAt = sql.NullTime{Time: now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) RevokeUserSessions(ctx context.Context, userId string, exceptId int32) ([]int32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]int32, 0)
+	for _, s := range m.sessions {
+		if s.UserId == userId && s.Id != exceptId && !s.RevokedAt.Valid {
+			s.RevokedAt = sql.NullTime{Time: now(), Valid: true}
+			ids = append(ids, s.Id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *MemoryStore) GetActiveSessionsByUserId(ctx context.Context, userId string) ([]lib.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := make([]lib.Session, 0)
+	for _, s := range m.sessions {
+		if s.UserId == userId && sessionActive(s) {
+			sessions = append(sessions, *s)
+		}
+	}
+
+	lastUsed := func(s lib.Session) time.Time {
+		if s.RotatedAt.Valid {
+			return s.RotatedAt.Time
+		}
+		return s.CreatedAt
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return lastUsed(sessions[i]).After(lastUsed(sessions[j]))
+	})
+	return sessions, nil
+}
+
+// Accounts
+
+func (m *MemoryStore) GetUserByIdentity(ctx context.Context, provider string, subject string) (*lib.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.identities {
+		if i.Provider == provider && i.Subject == subject {
+			if u := m.user(i.UserId); u != nil {
+				user := *u
+				return &user, nil
+			}
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (m *MemoryStore) CreateIdentity(ctx context.Context, userId string, identity *dto.OAuthUser) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.user(userId) == nil {
+		return foreignKeyViolation("user_identities_user_id_fkey")
+	}
+	for _, i := range m.identities {
+		if i.Provider == identity.Provider && i.Subject == identity.Subject {
+			return uniqueViolation("user_identities_provider_subject_key")
+		}
+		if i.UserId == userId && i.Provider == identity.Provider {
+			return uniqueViolation("user_identities_user_id_provider_key")
+		}
+	}
+
+	m.nextIdentityId++
+	m.identities = append(m.identities, &lib.UserIdentity{
+		Id:        m.nextIdentityId,
+		UserId:    userId,
+		Provider:  identity.Provider,
+		Subject:   identity.Subject,
+		Email:     sql.NullString{String: identity.Email, Valid: identity.Email != ""},
+		CreatedAt: now(),
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetIdentitiesByUserId(ctx context.Context, userId string) ([]lib.UserIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := make([]lib.UserIdentity, 0)
+	for _, i := range m.identities {
+		if i.UserId == userId {
+			response = append(response, *i)
+		}
+	}
+	return response, nil
+}
+
+func (m *MemoryStore) DeleteIdentity(ctx context.Context, userId string, provider string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.identities[:0]
+	for _, i := range m.identities {
+		if i.UserId != userId || i.Provider != provider {
+			kept = append(kept, i)
+		}
+	}
+	m.identities = kept
+	return nil
+}
+
+func (m *MemoryStore) CreatePasswordReset(ctx context.Context, userId string, tokenHash string, lifetime time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.user(userId) == nil {
+		return foreignKeyViolation("password_resets_user_id_fkey")
+	}
+	for _, r := range m.resets {
+		if r.TokenHash == tokenHash {
+			return uniqueViolation("password_resets_token_hash_key")
+		}
+	}
+
+	m.nextResetId++
+	m.resets = append(m.resets, &lib.PasswordReset{
+		Id:        m.nextResetId,
+		UserId:    userId,
+		TokenHash: tokenHash,
+		ExpiresAt: now().Add(lifetime),
+		CreatedAt: now(),
+	})
+	return nil
+}
+
+func (m *MemoryStore) ConsumePasswordReset(ctx context.Context, tokenHash string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.resets {
+		if r.TokenHash == tokenHash && !r.UsedAt.Valid && r.ExpiresAt.After(now()) {
+			r.UsedAt = sql.NullTime{Time: now(), Valid: true}
+			return r.UserId, nil
+		}
+	}
+	return "", pgx.ErrNoRows
+}
+
+// Notifications
+
+func (m *MemoryStore) CreateNotification(ctx context.Context, n *lib.Notification) error {
+	if n.Data == nil {
+		n.Data = map[string]any{}
+	}
+
+	// Data goes through JSON like it does through the JSONB column
+	data, err := json.Marshal(n.Data)
+	if err != nil {
+		return err
+	}
+	stored := *n
+	stored.Data = nil
+	if err := json.Unmarshal(data, &stored.Data); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.user(n.UserId) == nil {
+		return foreignKeyViolation("notifications_user_id_fkey")
+	}
+	if n.ActorId.Valid && m.user(n.ActorId.String) == nil {
+		return foreignKeyViolation("notifications_actor_id_fkey")
+	}
+	if n.ChatroomId.Valid && m.chatroom(n.ChatroomId.String) == nil {
+		return foreignKeyViolation("notifications_chatroom_id_fkey")
+	}
+
+	n.Id = newUUID()
+	n.CreatedAt = now()
+	stored.Id, stored.CreatedAt, stored.ReadAt = n.Id, n.CreatedAt, sql.NullTime{}
+	m.notifications = append(m.notifications, &stored)
+	return nil
+}
+
+func (m *MemoryStore) GetNotificationsByUserId(ctx context.Context, userId string, unreadOnly bool, limit int, offset int) ([]lib.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := make([]lib.Notification, 0)
+	for i := len(m.notifications) - 1; i >= 0; i-- {
+		n := m.notifications[i]
+		if n.UserId == userId && (!unreadOnly || !n.ReadAt.Valid) {
+			response = append(response, *n)
+		}
+	}
+	sort.SliceStable(response, func(i, j int) bool {
+		return response[i].CreatedAt.After(response[j].CreatedAt)
+	})
+
+	start := min(offset, len(response))
+	end := min(start+limit, len(response))
+	return response[start:end], nil
+}
+
+func (m *MemoryStore) CountUnreadNotifications(ctx context.Context, userId string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, n := range m.notifications {
+		if n.UserId == userId && !n.ReadAt.Valid {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) MarkNotificationsRead(ctx context.Context, userId string, ids []string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var updated int64
+	for _, n := range m.notifications {
+		if n.UserId != userId || n.ReadAt.Valid {
+			continue
+		}
+		if len(ids) == 0 || lib.Contains(ids, n.Id) {
+			n.ReadAt = sql.NullTime{Time: now(), Valid: true}
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (m *MemoryStore) ClaimStreamRoom(ctx context.Context, chatroomId string, nodeId string, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	staleBefore := now().Add(-ttl)
+
+	if m.chatroom(chatroomId) == nil {
+		return "", foreignKeyViolation("stream_rooms_chatroom_id_fkey")
+	}
+
+	room, ok := m.streamRooms[chatroomId]
+	if ok && room.nodeId != nodeId && !room.heartbeatAt.Before(staleBefore) {
+		return room.nodeId, nil
+	}
+	if !ok || room.nodeId != nodeId {
+		room = streamRoom{nodeId: nodeId, claimedAt: now()}
+	}
+	room.heartbeatAt = now()
+	m.streamRooms[chatroomId] = room
+	return nodeId, nil
+}
+
+func (m *MemoryStore) RefreshStreamRooms(ctx context.Context, nodeId string, chatroomIds []string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var refreshed int64
+	for _, chatroomId := range chatroomIds {
+		room, ok := m.streamRooms[chatroomId]
+		if ok && room.nodeId == nodeId {
+			room.heartbeatAt = now()
+			m.streamRooms[chatroomId] = room
+			refreshed++
+		}
+	}
+	return refreshed, nil
+}
+
+func (m *MemoryStore) ReleaseStreamRoom(ctx context.Context, chatroomId string, nodeId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if room, ok := m.streamRooms[chatroomId]; ok && room.nodeId == nodeId {
+		delete(m.streamRooms, chatroomId)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetStreamRoomNode(ctx context.Context, chatroomId string, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.streamRooms[chatroomId]
+	if !ok || room.heartbeatAt.Before(now().Add(-ttl)) {
+		return "", pgx.ErrNoRows
+	}
+	return room.nodeId, nil
+}
+
+// Attempts
+
+func (m *MemoryStore) RecordAttempt(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.attempts = append(m.attempts, attempt{key: key, createdAt: now()})
+	return nil
+}
+
+func (m *MemoryStore) CountAttempts(ctx context.Context, key string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	after := now().Add(-window)
+	count := 0
+	for _, a := range m.attempts {
+		if a.key == key && a.createdAt.After(after) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) ClearAttempts(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.attempts = slices.DeleteFunc(m.attempts, func(a attempt) bool { return a.key == key })
+	return nil
+}
+
+func (m *MemoryStore) DeleteAttemptsOlderThan(ctx context.Context, age time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := now().Add(-age)
+	kept := len(m.attempts)
+	m.attempts = slices.DeleteFunc(m.attempts, func(a attempt) bool { return a.createdAt.Before(before) })
+	return int64(kept - len(m.attempts)), nil
+}
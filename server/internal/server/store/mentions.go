@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GetChatroomMembersByUsernames resolves usernames (case insensitive) to the
+// members of chatroomId. Usernames of users outside the room are dropped.
+func (s *PostgresStore) GetChatroomMembersByUsernames(ctx context.Context, chatroomId string, usernames []string) ([]lib.MessageMention, error) {
+	response := make([]lib.MessageMention, 0)
+	if len(usernames) == 0 {
+		return response, nil
+	}
+
+	q := `SELECT u.user_id, u.username
+	FROM users u
+	JOIN user_chatrooms uc ON uc.user_id = u.user_id
+	WHERE uc.chatroom_id = $1 AND lower(u.username) = ANY($2)`
+
+	rows, err := s.pool.Query(ctx, q, chatroomId, usernames)
+	if err == pgx.ErrNoRows {
+		return response, nil
+	}
+	if err != nil {
+		log.Println("Error in Store.GetChatroomMembersByUsernames[Query]:", err)
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := lib.MessageMention{}
+		if err := rows.Scan(&m.UserId, &m.Username); err != nil {
+			log.Println("Error in Store.GetChatroomMembersByUsernames[Scan]:", err)
+			continue
+		}
+		response = append(response, m)
+	}
+
+	return response, rows.Err()
+}
+
+// StoreMessageMentions stores the mentions of a message in one statement and
+// returns the users that were not mentioned by it yet
+func (s *PostgresStore) StoreMessageMentions(ctx context.Context, messageId string, userIds []string) ([]string, error) {
+	q := `INSERT INTO message_mentions (message_id, user_id)
+	SELECT DISTINCT $1::uuid, unnest($2::varchar[])
+	ON CONFLICT (message_id, user_id) DO NOTHING
+	RETURNING user_id`
+
+	added := make([]string, 0)
+	if len(userIds) == 0 {
+		return added, nil
+	}
+
+	rows, err := s.pool.Query(ctx, q, messageId, userIds)
+	if err != nil {
+		log.Println("Error in Store.StoreMessageMentions[Query]:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId string
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		added = append(added, userId)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error in Store.StoreMessageMentions[Rows]:", err)
+		return nil, err
+	}
+	return added, nil
+}
+
+func (s *PostgresStore) GetMentionsByMessageIds(ctx context.Context, messageIds []string) (map[string][]string, error) {
+	response := make(map[string][]string)
+	if len(messageIds) == 0 {
+		return response, nil
+	}
+
+	q := "SELECT message_id, user_id FROM message_mentions WHERE message_id = ANY($1)"
+
+	rows, err := s.pool.Query(ctx, q, messageIds)
+	if err == pgx.ErrNoRows {
+		return response, nil
+	}
+	if err != nil {
+		log.Println("Error in Store.GetMentionsByMessageIds[Query]:", err)
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageId, userId string
+		if err := rows.Scan(&messageId, &userId); err != nil {
+			log.Println("Error in Store.GetMentionsByMessageIds[Scan]:", err)
+			continue
+		}
+		response[messageId] = append(response[messageId], userId)
+	}
+
+	return response, rows.Err()
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) CreateNotification(ctx context.Context, n *lib.Notification) error {
+	q := `INSERT INTO notifications (user_id, type, actor_id, chatroom_id, data)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	if n.Data == nil {
+		n.Data = map[string]any{}
+	}
+
+	err := s.pool.QueryRow(ctx, q, n.UserId, n.Type, n.ActorId, n.ChatroomId, n.Data).Scan(&n.Id, &n.CreatedAt)
+	if err != nil {
+		log.Println("Error in Store.CreateNotification[Scan]:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetNotificationsByUserId(ctx context.Context, userId string, unreadOnly bool, limit int, offset int) ([]lib.Notification, error) {
+	q := `SELECT id, user_id, type, actor_id, chatroom_id, data, read_at, created_at
+	FROM notifications
+	WHERE user_id = $1 AND ($2 = false OR read_at IS NULL)
+	ORDER BY created_at DESC
+	LIMIT $3 OFFSET $4`
+
+	response := make([]lib.Notification, 0)
+
+	rows, err := s.pool.Query(ctx, q, userId, unreadOnly, limit, offset)
+	if err == pgx.ErrNoRows {
+		return response, nil
+	}
+	if err != nil {
+		log.Println("Error in Store.GetNotificationsByUserId[Query]:", err)
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		n := lib.Notification{}
+		err := rows.Scan(&n.Id, &n.UserId, &n.Type, &n.ActorId, &n.ChatroomId, &n.Data, &n.ReadAt, &n.CreatedAt)
+		if err != nil {
+			log.Println("Error in Store.GetNotificationsByUserId[Scan]:", err)
+			continue
+		}
+		response = append(response, n)
+	}
+
+	return response, rows.Err()
+}
+
+func (s *PostgresStore) CountUnreadNotifications(ctx context.Context, userId string) (int, error) {
+	q := "SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL"
+
+	var count int
+	if err := s.pool.QueryRow(ctx, q, userId).Scan(&count); err != nil {
+		log.Println("Error in Store.CountUnreadNotifications[Scan]:", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// MarkNotificationsRead marks the given notifications of userId as read, or
+// all of them when ids is empty. Ids belonging to other users are ignored.
+func (s *PostgresStore) MarkNotificationsRead(ctx context.Context, userId string, ids []string) (int64, error) {
+	q := `UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND read_at IS NULL AND (cardinality($2::uuid[]) = 0 OR id = ANY($2::uuid[]))`
+
+	if ids == nil {
+		ids = []string{}
+	}
+
+	tag, err := s.pool.Exec(ctx, q, userId, ids)
+	if err != nil {
+		log.Println("Error in Store.MarkNotificationsRead[Exec]:", err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
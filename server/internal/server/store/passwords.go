@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (s *PostgresStore) UpdatePasswordHash(ctx context.Context, userId string, passwordHash string) error {
+	q := "UPDATE users SET password_hash = $1 WHERE user_id = $2"
+
+	_, err := s.pool.Exec(ctx, q, passwordHash, userId)
+	if err != nil {
+		log.Println("Error in Store.UpdatePasswordHash[Exec]:", err)
+		return err
+	}
+	return nil
+}
+
+// MarkEmailVerified records that the user showed they own their email
+func (s *PostgresStore) MarkEmailVerified(ctx context.Context, userId string) error {
+	q := "UPDATE users SET email_verified = TRUE WHERE user_id = $1"
+
+	_, err := s.pool.Exec(ctx, q, userId)
+	if err != nil {
+		log.Println("Error in Store.MarkEmailVerified[Exec]:", err)
+		return err
+	}
+	return nil
+}
+
+// CreatePasswordReset stores a reset token that expires after lifetime,
+// measured with the database clock ConsumePasswordReset checks it with
+func (s *PostgresStore) CreatePasswordReset(ctx context.Context, userId string, tokenHash string, lifetime time.Duration) error {
+	q := `INSERT INTO password_resets (user_id, token_hash, expires_at)
+	VALUES ($1, $2, CURRENT_TIMESTAMP + make_interval(secs => $3))`
+
+	_, err := s.pool.Exec(ctx, q, userId, tokenHash, lifetime.Seconds())
+	if err != nil {
+		log.Println("Error in Store.CreatePasswordReset[Exec]:", err)
+		return err
+	}
+	return nil
+}
+
+// ConsumePasswordReset marks an unused, unexpired reset token as used and
+// returns the user it belongs to. A token can only ever be consumed once.
+func (s *PostgresStore) ConsumePasswordReset(ctx context.Context, tokenHash string) (string, error) {
+	q := `UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	RETURNING user_id`
+
+	var userId string
+	err := s.pool.QueryRow(ctx, q, tokenHash).Scan(&userId)
+	if err != nil {
+		log.Println("Error in Store.ConsumePasswordReset[Scan]:", err)
+		return "", err
+	}
+	return userId, nil
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) StoreLinkPreviews(ctx context.Context, previews []lib.LinkPreview) error {
+	q := `INSERT INTO link_previews (message_id, url, title, description, image, site_name, type)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (message_id, url) DO NOTHING`
+
+	for _, p := range previews {
+		_, err := s.pool.Exec(ctx, q, p.MessageId, p.Url, p.Title, p.Description, p.Image, p.SiteName, p.Type)
+		if err != nil {
+			log.Println("Error in Store.StoreLinkPreviews[Exec]:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetLinkPreviewsByMessageIds(ctx context.Context, messageIds []string) (map[string][]lib.LinkPreview, error) {
+	response := make(map[string][]lib.LinkPreview)
+	if len(messageIds) == 0 {
+		return response, nil
+	}
+
+	q := `SELECT message_id, url, title, COALESCE(description, ''), COALESCE(image, ''), COALESCE(site_name, ''), COALESCE(type, '')
+	FROM link_previews WHERE message_id = ANY($1)
+	ORDER BY created_at`
+
+	rows, err := s.pool.Query(ctx, q, messageIds)
+	if err == pgx.ErrNoRows {
+		return response, nil
+	}
+	if err != nil {
+		log.Println("Error in Store.GetLinkPreviewsByMessageIds[Query]:", err)
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := lib.LinkPreview{}
+		err := rows.Scan(&p.MessageId, &p.Url, &p.Title, &p.Description, &p.Image, &p.SiteName, &p.Type)
+		if err != nil {
+			log.Println("Error in Store.GetLinkPreviewsByMessageIds[Scan]:", err)
+			continue
+		}
+		response[p.MessageId] = append(response[p.MessageId], p)
+	}
+
+	return response, rows.Err()
+}
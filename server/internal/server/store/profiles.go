@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"sideDesert/shiba/internal/server/dto"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UpdateUserProfile changes the fields of profile that are not nil. A taken
+// username is a unique violation of users_username_idx.
+func (s *PostgresStore) UpdateUserProfile(ctx context.Context, userId string, profile dto.UpdateUserRequest) error {
+	q := `UPDATE users SET
+	name = COALESCE($2, name),
+	username = COALESCE($3, username),
+	bio = COALESCE($4, bio)
+	WHERE user_id = $1
+	RETURNING user_id`
+
+	err := s.pool.QueryRow(ctx, q, userId, profile.Name, profile.Username, profile.Bio).Scan(&userId)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Println("Error in Store.UpdateUserProfile[Scan]:", err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *PostgresStore) UpdateProfilePicture(ctx context.Context, userId string, profilePicture string) error {
+	q := "UPDATE users SET profile_picture = NULLIF($2, '') WHERE user_id = $1"
+
+	tag, err := s.pool.Exec(ctx, q, userId, profilePicture)
+	if err != nil {
+		log.Println("Error in Store.UpdateProfilePicture[Exec]:", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sideDesert/shiba/internal/server/lib"
+)
+
+func (s *PostgresStore) CreateSession(ctx context.Context, session *lib.Session) error {
+	q := `INSERT INTO session (user_id, token, user_agent, ip, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	err := s.pool.QueryRow(ctx, q, session.UserId, session.Token, session.UserAgent, session.Ip, session.ExpiresAt).
+		Scan(&session.Id, &session.CreatedAt)
+	if err != nil {
+		log.Println("Error in Store.CreateSession[Scan]:", err)
+		return err
+	}
+	return nil
+}
+
+// GetSessionByToken finds the session whose current or previous refresh
+// token hash is tokenHash
+func (s *PostgresStore) GetSessionByToken(ctx context.Context, tokenHash string) (*lib.Session, error) {
+	q := `SELECT id, user_id, token, previous_token, user_agent, ip, created_at, rotated_at, expires_at, revoked_at
+	FROM session
+	WHERE token = $1 OR previous_token = $1`
+
+	session := lib.Session{}
+	err := s.pool.QueryRow(ctx, q, tokenHash).Scan(
+		&session.Id,
+		&session.UserId,
+		&session.Token,
+		&session.PreviousToken,
+		&session.UserAgent,
+		&session.Ip,
+		&session.CreatedAt,
+		&session.RotatedAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSession replaces the refresh token of an active session. It only
+// succeeds for the caller that still holds the current token, so two
+// concurrent refreshes can't both rotate.
+func (s *PostgresStore) RotateSession(ctx context.Context, sessionId int32, oldTokenHash string, newTokenHash string, expiresAt time.Time) (bool, error) {
+	q := `UPDATE session
+	SET previous_token = token, token = $3, rotated_at = CURRENT_TIMESTAMP, expires_at = $4
+	WHERE id = $1 AND token = $2 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP`
+
+	tag, err := s.pool.Exec(ctx, q, sessionId, oldTokenHash, newTokenHash, expiresAt)
+	if err != nil {
+		log.Println("Error in Store.RotateSession[Exec]:", err)
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *PostgresStore) IsSessionActive(ctx context.Context, sessionId int32) (bool, error) {
+	q := `SELECT EXISTS (
+		SELECT 1 FROM session WHERE id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	)`
+
+	var active bool
+	if err := s.pool.QueryRow(ctx, q, sessionId).Scan(&active); err != nil {
+		log.Println("Error in Store.IsSessionActive[Scan]:", err)
+		return false, err
+	}
+	return active, nil
+}
+
+// RevokeSession revokes a session of userId, it does nothing for sessions
+// of other users
+func (s *PostgresStore) RevokeSession(ctx context.Context, userId string, sessionId int32) error {
+	q := "UPDATE session SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL"
+
+	_, err := s.pool.Exec(ctx, q, sessionId, userId)
+	if err != nil {
+		log.Println("Error in Store.RevokeSession[Exec]:", err)
+		return err
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of userId but exceptId and
+// returns their ids. Session ids start at 1, 0 revokes them all.
+func (s *PostgresStore) RevokeUserSessions(ctx context.Context, userId string, exceptId int32) ([]int32, error) {
+	q := `UPDATE session SET revoked_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	RETURNING id`
+
+	ids := make([]int32, 0)
+
+	rows, err := s.pool.Query(ctx, q, userId, exceptId)
+	if err != nil {
+		log.Println("Error in Store.RevokeUserSessions[Query]:", err)
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *PostgresStore) GetActiveSessionsByUserId(ctx context.Context, userId string) ([]lib.Session, error) {
+	q := `SELECT id, user_id, token, previous_token, user_agent, ip, created_at, rotated_at, expires_at, revoked_at
+	FROM session
+	WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	ORDER BY COALESCE(rotated_at, created_at) DESC`
+
+	sessions := make([]lib.Session, 0)
+
+	rows, err := s.pool.Query(ctx, q, userId)
+	if err != nil {
+		log.Println("Error in Store.GetActiveSessionsByUserId[Query]:", err)
+		return sessions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session := lib.Session{}
+		err := rows.Scan(
+			&session.Id,
+			&session.UserId,
+			&session.Token,
+			&session.PreviousToken,
+			&session.UserAgent,
+			&session.Ip,
+			&session.CreatedAt,
+			&session.RotatedAt,
+			&session.ExpiresAt,
+			&session.RevokedAt,
+		)
+		if err != nil {
+			log.Println("Error in Store.GetActiveSessionsByUserId[Scan]:", err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
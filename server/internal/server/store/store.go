@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+// Store is everything the services persist. PostgresStore is used in
+// production and MemoryStore in tests, both follow the same semantics:
+// missing rows are pgx.ErrNoRows and constraint violations are
+// *pgconn.PgError so lib.AsApiError maps them the same way.
+type Store interface {
+	Users
+	Chatrooms
+	Messages
+	Friends
+	Remotes
+	Sessions
+	Accounts
+	Notifications
+	Streams
+	Attempts
+
+	Close(ctx context.Context) error
+}
+
+type Users interface {
+	CreateUser(ctx context.Context, user *dto.SignupUserRequest) (*string, error)
+	GetUserByEmail(ctx context.Context, email string) (*lib.User, error)
+	GetUserById(ctx context.Context, userId string) (*lib.User, error)
+	SearchUsers(ctx context.Context, userId string, searchString string, limit int) ([]dto.SearchUserResponse, error)
+	UpdatePasswordHash(ctx context.Context, userId string, passwordHash string) error
+	MarkEmailVerified(ctx context.Context, userId string) error
+	UpdateUserProfile(ctx context.Context, userId string, profile dto.UpdateUserRequest) error
+	UpdateProfilePicture(ctx context.Context, userId string, profilePicture string) error
+
+	ScheduleUserDeletion(ctx context.Context, userId string) (time.Time, error)
+	CancelUserDeletion(ctx context.Context, userId string) (bool, error)
+	GetUsersDueForDeletion(ctx context.Context, grace time.Duration, limit int) ([]string, error)
+	DeleteUser(ctx context.Context, userId string) error
+}
+
+type Chatrooms interface {
+	GetChatRoomById(ctx context.Context, chatroomId string) (*lib.Chatroom, error)
+	GetChatRoomsByUserId(ctx context.Context, userId string) ([]lib.Chatroom, error)
+	GetUsersByChatroomId(ctx context.Context, chatroomId string) ([]lib.UserId, error)
+	IsUserInChatroom(ctx context.Context, userId string, chatroomId string) (bool, error)
+	CreateChatRoom(ctx context.Context, chatroom dto.CreateChatRoomRequest) (string, error)
+	CreateChatRoomByName(ctx context.Context, name string) (string, error)
+	AddParticipantsToChatRoom(ctx context.Context, chatroomId string, participants []string) error
+	GetChatroomMembersByUsernames(ctx context.Context, chatroomId string, usernames []string) ([]lib.MessageMention, error)
+
+	GetChatroomMembers(ctx context.Context, chatroomId string) ([]lib.UserChatroom, error)
+	GetChatroomRole(ctx context.Context, userId string, chatroomId string) (string, error)
+	SetChatroomRole(ctx context.Context, chatroomId string, userId string, role string) error
+	UpdateChatroom(ctx context.Context, chatroomId string, settings dto.UpdateChatroomRequest) error
+	UpdateChatroomPicture(ctx context.Context, chatroomId string, profilePicture string) error
+
+	GetDirectMessage(ctx context.Context, userId string, otherId string) (string, error)
+	GetOrCreateDirectMessage(ctx context.Context, userId string, otherId string) (string, error)
+}
+
+type Messages interface {
+	StoreChatRoomMessage(ctx context.Context, msg StoreChatMessageDto) (string, error)
+	GetMessageIdByClientId(ctx context.Context, sender string, clientId string) (string, error)
+	GetLast50ChatRoomMessages(ctx context.Context, chatroomId string, offset int, mentionedUserId string) ([]lib.Message, error)
+	StoreMessageMentions(ctx context.Context, messageId string, userIds []string) ([]string, error)
+	StoreLinkPreviews(ctx context.Context, previews []lib.LinkPreview) error
+	CreateAttachment(ctx context.Context, a *lib.Attachment) (string, error)
+	GetAttachmentById(ctx context.Context, id string) (*lib.Attachment, error)
+	GetAttachmentsByUploader(ctx context.Context, userId string) ([]lib.Attachment, error)
+	GetMessagesBySender(ctx context.Context, userId string, offset int, limit int) ([]lib.Message, error)
+}
+
+type Friends interface {
+	GetFriendsByUserId(ctx context.Context, userId string) ([]UserFriend, error)
+	GetFriendRelationsByUserId(ctx context.Context, userId string) ([]lib.FriendRelations, error)
+	GetFriendRequestsByUserId(ctx context.Context, userId string) ([]dto.FriendRequestResponse, error)
+	GetFriendRelationById(ctx context.Context, id string) (*lib.FriendRelations, error)
+	GetFriendRelationBetween(ctx context.Context, userId string, otherId string) (*lib.FriendRelations, error)
+	GetSentFriendRequestsByUserId(ctx context.Context, userId string) ([]dto.FriendRequestResponse, error)
+	InsertFriendStatus(ctx context.Context, userId string, friendId string, status string) (string, error)
+	ChangeFriendStatus(ctx context.Context, id string, status string) error
+	DeleteFriendRelation(ctx context.Context, id string) error
+	GetFriendSuggestions(ctx context.Context, userId string, limit int) ([]dto.FriendSuggestionResponse, error)
+
+	BlockUser(ctx context.Context, blockerId string, blockedId string) error
+	UnblockUser(ctx context.Context, blockerId string, blockedId string) error
+	GetBlockedUsers(ctx context.Context, userId string) ([]dto.BlockedUserResponse, error)
+	IsBlocked(ctx context.Context, userId string, otherId string) (bool, error)
+	IsDirectMessageBlocked(ctx context.Context, userId string, chatroomId string) (bool, error)
+}
+
+type Remotes interface {
+	GetRemoteByChatroomId(ctx context.Context, chatroomId string) (*dto.RemoteResponse, error)
+	CreateRemote(ctx context.Context, chatroomId string, userId string) error
+	UpdateRemote(ctx context.Context, chatroomId string, userId string) error
+	GetRemotesByUserId(ctx context.Context, userId string) ([]string, error)
+}
+
+type Sessions interface {
+	CreateSession(ctx context.Context, session *lib.Session) error
+	GetSessionByToken(ctx context.Context, tokenHash string) (*lib.Session, error)
+	RotateSession(ctx context.Context, sessionId int32, oldTokenHash string, newTokenHash string, expiresAt time.Time) (bool, error)
+	IsSessionActive(ctx context.Context, sessionId int32) (bool, error)
+	RevokeSession(ctx context.Context, userId string, sessionId int32) error
+	RevokeUserSessions(ctx context.Context, userId string, exceptId int32) ([]int32, error)
+	GetActiveSessionsByUserId(ctx context.Context, userId string) ([]lib.Session, error)
+}
+
+// Accounts covers the ways to sign in besides a password: linked OAuth
+// identities and password reset tokens
+type Accounts interface {
+	GetUserByIdentity(ctx context.Context, provider string, subject string) (*lib.User, error)
+	CreateIdentity(ctx context.Context, userId string, identity *dto.OAuthUser) error
+	GetIdentitiesByUserId(ctx context.Context, userId string) ([]lib.UserIdentity, error)
+	DeleteIdentity(ctx context.Context, userId string, provider string) error
+	CreatePasswordReset(ctx context.Context, userId string, tokenHash string, lifetime time.Duration) error
+	ConsumePasswordReset(ctx context.Context, tokenHash string) (string, error)
+}
+
+type Notifications interface {
+	CreateNotification(ctx context.Context, n *lib.Notification) error
+	GetNotificationsByUserId(ctx context.Context, userId string, unreadOnly bool, limit int, offset int) ([]lib.Notification, error)
+	CountUnreadNotifications(ctx context.Context, userId string) (int, error)
+	MarkNotificationsRead(ctx context.Context, userId string, ids []string) (int64, error)
+}
+
+// Streams is the registry of the server node streaming each chatroom. Claims
+// are kept alive with heartbeats, the rooms of a node that stopped sending
+// them can be claimed by another.
+type Streams interface {
+	ClaimStreamRoom(ctx context.Context, chatroomId string, nodeId string, ttl time.Duration) (string, error)
+	RefreshStreamRooms(ctx context.Context, nodeId string, chatroomIds []string) (int64, error)
+	ReleaseStreamRoom(ctx context.Context, chatroomId string, nodeId string) error
+	GetStreamRoomNode(ctx context.Context, chatroomId string, ttl time.Duration) (string, error)
+}
+
+// Attempts counts rate limited actions per key, like failed logins, for every
+// API node at once
+type Attempts interface {
+	RecordAttempt(ctx context.Context, key string) error
+	CountAttempts(ctx context.Context, key string, window time.Duration) (int, error)
+	ClearAttempts(ctx context.Context, key string) error
+	DeleteAttemptsOlderThan(ctx context.Context, age time.Duration) (int64, error)
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
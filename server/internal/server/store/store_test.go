@@ -0,0 +1,1362 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+)
+
+// The same suite runs against every Store implementation. Postgres only runs
+// when SHIBA_TEST_DB_URL points to a database the tests may write to, the
+// tests never delete anything so they use fresh users every time.
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestPostgresStore(t *testing.T) {
+	testStore(t, newTestPostgresStore(t, ""))
+}
+
+// Timestamps written by the database and compared with a duration have to
+// agree whatever the TimeZone of the session, here 14 hours ahead of UTC
+func TestPostgresStoreTimeZone(t *testing.T) {
+	s := newTestPostgresStore(t, "Pacific/Kiritimati")
+	t.Run("StreamRooms", func(t *testing.T) { testStreamRooms(t, s) })
+	t.Run("AccountDeletion", func(t *testing.T) { testAccountDeletion(t, s) })
+	t.Run("Accounts", func(t *testing.T) { testAccounts(t, s) })
+	t.Run("Attempts", func(t *testing.T) { testAttempts(t, s) })
+}
+
+func newTestPostgresStore(t *testing.T, timeZone string) *PostgresStore {
+	t.Helper()
+	dbUrl := os.Getenv("SHIBA_TEST_DB_URL")
+	if dbUrl == "" {
+		t.Skip("SHIBA_TEST_DB_URL is not set")
+	}
+	if timeZone != "" {
+		sep := "?"
+		if strings.Contains(dbUrl, "?") {
+			sep = "&"
+		}
+		dbUrl += sep + "timezone=" + timeZone
+	}
+
+	ctx := context.Background()
+	s, err := NewPostgresStore(ctx, dbUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close(ctx) })
+
+	migrator, err := s.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testStore(t *testing.T, s Store) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, s) })
+	t.Run("Profiles", func(t *testing.T) { testProfiles(t, s) })
+	t.Run("SearchUsers", func(t *testing.T) { testSearchUsers(t, s) })
+	t.Run("Chatrooms", func(t *testing.T) { testChatrooms(t, s) })
+	t.Run("ChatroomSettings", func(t *testing.T) { testChatroomSettings(t, s) })
+	t.Run("DirectMessages", func(t *testing.T) { testDirectMessages(t, s) })
+	t.Run("Messages", func(t *testing.T) { testMessages(t, s) })
+	t.Run("MessageClientIds", func(t *testing.T) { testMessageClientIds(t, s) })
+	t.Run("Friends", func(t *testing.T) { testFriends(t, s) })
+	t.Run("FriendSuggestions", func(t *testing.T) { testFriendSuggestions(t, s) })
+	t.Run("Blocks", func(t *testing.T) { testBlocks(t, s) })
+	t.Run("Remotes", func(t *testing.T) { testRemotes(t, s) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, s) })
+	t.Run("Accounts", func(t *testing.T) { testAccounts(t, s) })
+	t.Run("AccountDeletion", func(t *testing.T) { testAccountDeletion(t, s) })
+	t.Run("Notifications", func(t *testing.T) { testNotifications(t, s) })
+	t.Run("StreamRooms", func(t *testing.T) { testStreamRooms(t, s) })
+	t.Run("Attempts", func(t *testing.T) { testAttempts(t, s) })
+}
+
+var ctx = context.Background()
+
+// unique keeps names apart between runs against the same database
+func unique(prefix string) string {
+	return prefix + newUUID()[:8]
+}
+
+func newUser(t *testing.T, s Store, name string) *lib.User {
+	t.Helper()
+	return newUserWithUsername(t, s, name, unique("user"))
+}
+
+func newUserWithUsername(t *testing.T, s Store, name string, username string) *lib.User {
+	t.Helper()
+	userId, err := s.CreateUser(ctx, &dto.SignupUserRequest{
+		Name:     name,
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "correct horse battery",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.GetUserById(ctx, *userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func newChatroom(t *testing.T, s Store, users ...*lib.User) string {
+	t.Helper()
+	participants := []string{}
+	for _, u := range users {
+		participants = append(participants, u.UserId)
+	}
+	chatroomId, err := s.CreateChatRoom(ctx, dto.CreateChatRoomRequest{Name: "room", Participants: participants})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chatroomId
+}
+
+func status(err error) int {
+	if err == nil {
+		return 0
+	}
+	return lib.AsApiError(err).Status
+}
+
+func sorted(values []string) []string {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return values
+}
+
+func testUsers(t *testing.T, s Store) {
+	user := newUser(t, s, "Ada")
+
+	byEmail, err := s.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byEmail.UserId != user.UserId || byEmail.Name != "Ada" || byEmail.Id == 0 || byEmail.CreatedAt.IsZero() {
+		t.Errorf("GetUserByEmail = %+v, want %+v", byEmail, user)
+	}
+	if !lib.CheckPassword(user.PasswordHash, "correct horse battery") {
+		t.Error("the password is not hashed with lib.HashPassword")
+	}
+
+	_, err = s.CreateUser(ctx, &dto.SignupUserRequest{Name: "Ada", Email: user.Email, Password: "whatever12"})
+	if status(err) != http.StatusConflict {
+		t.Errorf("a duplicate email gave %v, want a conflict", err)
+	}
+
+	if _, err := s.GetUserById(ctx, unique("missing")); !lib.IsNoRows(err) {
+		t.Errorf("GetUserById of a missing user = %v, want no rows", err)
+	}
+	if _, err := s.GetUserByEmail(ctx, unique("missing")+"@example.com"); !lib.IsNoRows(err) {
+		t.Errorf("GetUserByEmail of a missing user = %v, want no rows", err)
+	}
+
+	if err := s.UpdatePasswordHash(ctx, user.UserId, "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if updated, _ := s.GetUserById(ctx, user.UserId); updated.PasswordHash != "new-hash" {
+		t.Errorf("password hash = %q after UpdatePasswordHash", updated.PasswordHash)
+	}
+}
+
+func testProfiles(t *testing.T, s Store) {
+	ada, bob := newUser(t, s, "Ada"), newUser(t, s, "Bob")
+
+	name, bio := "Ada L.", "Counting engines"
+	if err := s.UpdateUserProfile(ctx, ada.UserId, dto.UpdateUserRequest{Name: &name, Bio: &bio}); err != nil {
+		t.Fatal(err)
+	}
+	updated, _ := s.GetUserById(ctx, ada.UserId)
+	if updated.Name != name || updated.Bio != bio || updated.Username != ada.Username {
+		t.Errorf("GetUserById after UpdateUserProfile = %+v", updated)
+	}
+
+	// Usernames are unique regardless of case
+	taken := strings.ToUpper(bob.Username)
+	if err := s.UpdateUserProfile(ctx, ada.UserId, dto.UpdateUserRequest{Username: &taken}); !lib.IsUniqueViolation(err, "users_username_idx") {
+		t.Errorf("taking bob's username gave %v, want a users_username_idx violation", err)
+	}
+	_, err := s.CreateUser(ctx, &dto.SignupUserRequest{Name: "Bob", Username: taken, Email: unique("bob") + "@example.com", Password: "whatever12"})
+	if !lib.IsUniqueViolation(err, "users_username_idx") {
+		t.Errorf("signing up with bob's username gave %v, want a users_username_idx violation", err)
+	}
+	username := unique("ada")
+	if err := s.UpdateUserProfile(ctx, ada.UserId, dto.UpdateUserRequest{Username: &username}); err != nil {
+		t.Fatal(err)
+	}
+	if updated, _ := s.GetUserById(ctx, ada.UserId); updated.Username != username || updated.Name != name {
+		t.Errorf("GetUserById after changing the username = %+v", updated)
+	}
+
+	if err := s.UpdateUserProfile(ctx, unique("missing"), dto.UpdateUserRequest{Name: &name}); !lib.IsNoRows(err) {
+		t.Errorf("UpdateUserProfile of a missing user = %v, want no rows", err)
+	}
+
+	if err := s.UpdateProfilePicture(ctx, ada.UserId, "/avatar.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if updated, _ := s.GetUserById(ctx, ada.UserId); updated.ProfilePicture.String != "/avatar.jpg" {
+		t.Errorf("profile picture = %+v", updated.ProfilePicture)
+	}
+	if err := s.UpdateProfilePicture(ctx, ada.UserId, ""); err != nil {
+		t.Fatal(err)
+	}
+	if updated, _ := s.GetUserById(ctx, ada.UserId); updated.ProfilePicture.Valid {
+		t.Errorf("profile picture after clearing = %+v, want null", updated.ProfilePicture)
+	}
+}
+
+func testSearchUsers(t *testing.T, s Store) {
+	token := unique("zq")
+	exact := newUserWithUsername(t, s, "Exact", token)
+	prefix := newUser(t, s, token+" Prefix")
+	contains := newUser(t, s, "Has "+token)
+	caller := newUser(t, s, "Caller "+token)
+
+	results, err := s.SearchUsers(ctx, caller.UserId, token, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, r := range results {
+		ids = append(ids, r.UserId)
+	}
+	if !slices.Equal(ids, []string{exact.UserId, prefix.UserId, contains.UserId}) {
+		t.Errorf("SearchUsers = %v, want the exact username, the name prefix and the rest without the caller", ids)
+	}
+
+	// The caller is left out before the limit
+	results, err = s.SearchUsers(ctx, exact.UserId, token, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].UserId != prefix.UserId {
+		t.Errorf("SearchUsers with a limit of 1 = %+v, want the name prefix", results)
+	}
+
+	if results, _ := s.SearchUsers(ctx, caller.UserId, "", 10); len(results) != 0 {
+		t.Errorf("an empty query matched %d users", len(results))
+	}
+	if results, _ := s.SearchUsers(ctx, caller.UserId, "@example.com", 10); len(results) != 0 {
+		t.Errorf("the middle of an email matched %d users", len(results))
+	}
+
+	// An email only matches in full, and only friends see it
+	if results, _ := s.SearchUsers(ctx, caller.UserId, token[:len(token)-1], 10); slices.ContainsFunc(results, func(r dto.SearchUserResponse) bool { return r.Email != "" }) {
+		t.Errorf("SearchUsers returned the email of a stranger: %+v", results)
+	}
+	hidden := newUser(t, s, "Hidden")
+	results, err = s.SearchUsers(ctx, caller.UserId, strings.ToUpper(hidden.Email), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].UserId != hidden.UserId || results[0].Email != "" {
+		t.Errorf("SearchUsers of a full email = %+v, want the user without the email", results)
+	}
+	if results, _ := s.SearchUsers(ctx, caller.UserId, hidden.Username+"@ex", 10); len(results) != 0 {
+		t.Errorf("the start of an email matched %+v", results)
+	}
+
+	requestId, err := s.InsertFriendStatus(ctx, caller.UserId, hidden.UserId, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ChangeFriendStatus(ctx, requestId, "accepted"); err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := s.SearchUsers(ctx, caller.UserId, hidden.Email, 10); len(results) != 1 || results[0].Email != hidden.Email {
+		t.Errorf("SearchUsers of a friend = %+v, want the email", results)
+	}
+}
+
+func testChatrooms(t *testing.T, s Store) {
+	ada, bob, eve := newUser(t, s, "Ada"), newUser(t, s, "Bob"), newUser(t, s, "Eve")
+	chatroomId := newChatroom(t, s, ada, bob)
+
+	chatroom, err := s.GetChatRoomById(ctx, chatroomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chatroom.Name != "room" || chatroom.DirectMessage || chatroom.CreatedAt.IsZero() || chatroom.StreamQuality != "high" {
+		t.Errorf("GetChatRoomById = %+v", chatroom)
+	}
+	if _, err := s.GetChatRoomById(ctx, newUUID()); status(err) != http.StatusNotFound {
+		t.Errorf("GetChatRoomById of a missing chatroom = %v, want not found", err)
+	}
+
+	members, err := s.GetUsersByChatroomId(ctx, chatroomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sorted(members), sorted([]string{ada.UserId, bob.UserId})) {
+		t.Errorf("GetUsersByChatroomId = %v", members)
+	}
+
+	if in, _ := s.IsUserInChatroom(ctx, ada.UserId, chatroomId); !in {
+		t.Error("a participant is not in the chatroom")
+	}
+	if in, _ := s.IsUserInChatroom(ctx, eve.UserId, chatroomId); in {
+		t.Error("a stranger is in the chatroom")
+	}
+
+	rooms, err := s.GetChatRoomsByUserId(ctx, bob.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].Id != chatroomId {
+		t.Errorf("GetChatRoomsByUserId = %+v", rooms)
+	}
+	if rooms, _ := s.GetChatRoomsByUserId(ctx, eve.UserId); len(rooms) != 0 {
+		t.Errorf("a user without chatrooms got %d", len(rooms))
+	}
+
+	err = s.AddParticipantsToChatRoom(ctx, chatroomId, []string{bob.UserId})
+	if status(err) != http.StatusConflict {
+		t.Errorf("adding a member twice gave %v, want a conflict", err)
+	}
+	err = s.AddParticipantsToChatRoom(ctx, chatroomId, []string{eve.UserId, unique("missing")})
+	if status(err) != http.StatusNotFound {
+		t.Errorf("adding a missing user gave %v, want not found", err)
+	}
+	if in, _ := s.IsUserInChatroom(ctx, eve.UserId, chatroomId); !in {
+		t.Error("participants before the failing one were not added")
+	}
+
+	_, err = s.CreateChatRoom(ctx, dto.CreateChatRoomRequest{Name: "broken", Participants: []string{unique("missing")}})
+	if status(err) != http.StatusNotFound {
+		t.Errorf("CreateChatRoom with a missing participant gave %v, want not found", err)
+	}
+
+	mentioned, err := s.GetChatroomMembersByUsernames(ctx, chatroomId, []string{strings.ToLower(ada.Username), "nobody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mentioned) != 1 || mentioned[0].UserId != ada.UserId || mentioned[0].Username != ada.Username {
+		t.Errorf("GetChatroomMembersByUsernames = %+v", mentioned)
+	}
+}
+
+func testChatroomSettings(t *testing.T, s Store) {
+	ada, bob, eve := newUser(t, s, "Ada"), newUser(t, s, "Bob"), newUser(t, s, "Eve")
+	chatroomId := newChatroom(t, s, ada, bob)
+
+	if role, err := s.GetChatroomRole(ctx, bob.UserId, chatroomId); err != nil || role != lib.ChatroomMember {
+		t.Errorf("GetChatroomRole of a new member = %q, %v", role, err)
+	}
+	if err := s.SetChatroomRole(ctx, chatroomId, ada.UserId, lib.ChatroomOwner); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := s.GetChatroomRole(ctx, ada.UserId, chatroomId); role != lib.ChatroomOwner {
+		t.Errorf("GetChatroomRole after SetChatroomRole = %q", role)
+	}
+	if _, err := s.GetChatroomRole(ctx, eve.UserId, chatroomId); status(err) != http.StatusNotFound {
+		t.Errorf("GetChatroomRole of a stranger gave %v, want not found", err)
+	}
+	if err := s.SetChatroomRole(ctx, chatroomId, eve.UserId, lib.ChatroomOwner); status(err) != http.StatusNotFound {
+		t.Errorf("SetChatroomRole of a stranger gave %v, want not found", err)
+	}
+	if err := s.SetChatroomRole(ctx, chatroomId, bob.UserId, "admin"); status(err) != http.StatusBadRequest {
+		t.Errorf("an unknown role gave %v, want a bad request", err)
+	}
+
+	before, err := s.GetChatRoomById(ctx, chatroomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	topic, startUrl, quality := "Movie night", "https://example.com/", "medium"
+	err = s.UpdateChatroom(ctx, chatroomId, dto.UpdateChatroomRequest{Topic: &topic, DefaultStartUrl: &startUrl, StreamQuality: &quality})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.GetChatRoomById(ctx, chatroomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Name != "room" || after.Topic != topic || after.DefaultStartUrl != startUrl || after.StreamQuality != quality {
+		t.Errorf("UpdateChatroom = %+v", after)
+	}
+	if !after.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("updated_at went from %v to %v", before.UpdatedAt, after.UpdatedAt)
+	}
+
+	rooms, _ := s.GetChatRoomsByUserId(ctx, bob.UserId)
+	if len(rooms) != 1 || rooms[0].Topic != topic {
+		t.Errorf("GetChatRoomsByUserId after UpdateChatroom = %+v", rooms)
+	}
+
+	bad := "ultra"
+	if err := s.UpdateChatroom(ctx, chatroomId, dto.UpdateChatroomRequest{StreamQuality: &bad}); status(err) != http.StatusBadRequest {
+		t.Errorf("an unknown stream quality gave %v, want a bad request", err)
+	}
+	if err := s.UpdateChatroom(ctx, newUUID(), dto.UpdateChatroomRequest{Topic: &topic}); status(err) != http.StatusNotFound {
+		t.Errorf("updating a missing chatroom gave %v, want not found", err)
+	}
+
+	if err := s.UpdateChatroomPicture(ctx, chatroomId, "/pic.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := s.GetChatRoomById(ctx, chatroomId); c.ProfilePicture.String != "/pic.jpg" {
+		t.Errorf("profile picture = %+v", c.ProfilePicture)
+	}
+	if err := s.UpdateChatroomPicture(ctx, chatroomId, ""); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := s.GetChatRoomById(ctx, chatroomId); c.ProfilePicture.Valid {
+		t.Errorf("a removed picture is still %q", c.ProfilePicture.String)
+	}
+}
+
+func testMessages(t *testing.T, s Store) {
+	ada, bob := newUser(t, s, "Ada"), newUser(t, s, "Bob")
+	chatroomId := newChatroom(t, s, ada, bob)
+
+	upload := func(uploader *lib.User) string {
+		id, err := s.CreateAttachment(ctx, &lib.Attachment{
+			ChatroomId:  chatroomId,
+			Uploader:    uploader.UserId,
+			FileName:    "cat.png",
+			ContentType: "image/png",
+			Size:        42,
+			StorageKey:  "attachments/" + chatroomId + "/cat",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	adaFile, bobFile := upload(ada), upload(bob)
+
+	send := func(sender *lib.User, content string, attachments ...string) string {
+		id, err := s.StoreChatRoomMessage(ctx, StoreChatMessageDto{
+			Sender:      sender.UserId,
+			ChatroomId:  chatroomId,
+			Content:     content,
+			Attachments: attachments,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	first := send(ada, "hello", adaFile, bobFile)
+	second := send(bob, "hi @"+ada.Username)
+
+	if added, err := s.StoreMessageMentions(ctx, second, []string{ada.UserId, ada.UserId}); err != nil || !slices.Equal(added, []string{ada.UserId}) {
+		t.Fatalf("StoreMessageMentions = %v, %v", added, err)
+	}
+	// Storing them again adds nothing, a failing user stores none of them
+	if added, err := s.StoreMessageMentions(ctx, second, []string{ada.UserId}); err != nil || len(added) != 0 {
+		t.Errorf("StoreMessageMentions again = %v, %v", added, err)
+	}
+	if _, err := s.StoreMessageMentions(ctx, second, []string{bob.UserId, unique("missing")}); status(err) != http.StatusNotFound {
+		t.Errorf("a mention of a missing user gave %v, want not found", err)
+	}
+	preview := lib.LinkPreview{MessageId: first, Url: "https://example.com", Title: "Example"}
+	if err := s.StoreLinkPreviews(ctx, []lib.LinkPreview{preview, preview}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := s.GetLast50ChatRoomMessages(ctx, chatroomId, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Id != second || history[1].Id != first {
+		t.Fatalf("GetLast50ChatRoomMessages = %+v, want newest first", history)
+	}
+	if history[1].SenderName != "Ada" || history[1].Sender != ada.UserId || history[1].ChatroomId != chatroomId || history[1].Content != "hello" {
+		t.Errorf("message = %+v", history[1])
+	}
+	if len(history[1].Attachments) != 1 || history[1].Attachments[0].Id != adaFile {
+		t.Errorf("attachments = %+v, want only the sender's own upload", history[1].Attachments)
+	}
+	if len(history[1].Previews) != 1 || history[1].Previews[0].Title != "Example" {
+		t.Errorf("previews = %+v", history[1].Previews)
+	}
+	if !slices.Equal(history[0].Mentions, []string{ada.UserId}) || history[1].Mentions != nil {
+		t.Errorf("mentions = %v and %v", history[0].Mentions, history[1].Mentions)
+	}
+
+	attachment, err := s.GetAttachmentById(ctx, bobFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.MessageId.Valid || attachment.Size != 42 || attachment.StorageKey == "" {
+		t.Errorf("another user's upload = %+v", attachment)
+	}
+	if _, err := s.GetAttachmentById(ctx, newUUID()); !lib.IsNoRows(err) {
+		t.Errorf("GetAttachmentById of a missing attachment = %v, want no rows", err)
+	}
+
+	mentions, err := s.GetLast50ChatRoomMessages(ctx, chatroomId, 0, ada.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mentions) != 1 || mentions[0].Id != second {
+		t.Errorf("messages mentioning ada = %+v", mentions)
+	}
+
+	for i := 0; i < 50; i++ {
+		send(bob, "spam")
+	}
+	page, _ := s.GetLast50ChatRoomMessages(ctx, chatroomId, 1, "")
+	if len(page) != 2 || page[1].Id != first {
+		t.Errorf("the second page has %d messages, want the 2 oldest", len(page))
+	}
+
+	_, err = s.StoreChatRoomMessage(ctx, StoreChatMessageDto{Sender: ada.UserId, ChatroomId: newUUID(), Content: "lost"})
+	if status(err) != http.StatusNotFound {
+		t.Errorf("a message to a missing chatroom gave %v, want not found", err)
+	}
+}
+
+func testMessageClientIds(t *testing.T, s Store) {
+	ada, bob := newUser(t, s, "Ada"), newUser(t, s, "Bob")
+	chatroomId := newChatroom(t, s, ada, bob)
+
+	send := func(sender *lib.User, clientId string) (string, error) {
+		return s.StoreChatRoomMessage(ctx, StoreChatMessageDto{
+			Id:         clientId,
+			Sender:     sender.UserId,
+			ChatroomId: chatroomId,
+			Content:    "hello",
+		})
+	}
+
+	messageId, err := send(ada, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := send(ada, "c1"); !lib.IsUniqueViolation(err, "messages_sender_client_id_idx") {
+		t.Errorf("storing a client id twice gave %v, want a unique violation", err)
+	}
+	// Client ids only have to be unique per sender, and old clients send none
+	if _, err := send(bob, "c1"); err != nil {
+		t.Errorf("another sender reusing the client id gave %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := send(ada, ""); err != nil {
+			t.Errorf("a message without a client id gave %v", err)
+		}
+	}
+
+	found, err := s.GetMessageIdByClientId(ctx, ada.UserId, "c1")
+	if err != nil || found != messageId {
+		t.Errorf("GetMessageIdByClientId = %q, %v, want %q", found, err, messageId)
+	}
+	if _, err := s.GetMessageIdByClientId(ctx, ada.UserId, "c2"); !lib.IsNoRows(err) {
+		t.Errorf("GetMessageIdByClientId of an unknown id = %v, want no rows", err)
+	}
+
+	history, _ := s.GetLast50ChatRoomMessages(ctx, chatroomId, 0, "")
+	if len(history) != 4 || history[3].Id != messageId || history[3].ClientId != "c1" || history[0].ClientId != "" {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func testDirectMessages(t *testing.T, s Store) {
+	ada, bob, eve := newUser(t, s, "Ada"), newUser(t, s, "Bob"), newUser(t, s, "Eve")
+
+	if _, err := s.GetDirectMessage(ctx, ada.UserId, bob.UserId); !lib.IsNoRows(err) {
+		t.Errorf("GetDirectMessage before creating = %v, want no rows", err)
+	}
+
+	chatroomId, err := s.GetOrCreateDirectMessage(ctx, ada.UserId, bob.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.GetOrCreateDirectMessage(ctx, bob.UserId, ada.UserId)
+	if err != nil || again != chatroomId {
+		t.Errorf("GetOrCreateDirectMessage the other way = %q, %v, want %q", again, err, chatroomId)
+	}
+	if got, err := s.GetDirectMessage(ctx, bob.UserId, ada.UserId); err != nil || got != chatroomId {
+		t.Errorf("GetDirectMessage = %q, %v, want %q", got, err, chatroomId)
+	}
+
+	chatroom, err := s.GetChatRoomById(ctx, chatroomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chatroom.DirectMessage {
+		t.Errorf("GetChatRoomById = %+v, want a direct message room", chatroom)
+	}
+	members, _ := s.GetUsersByChatroomId(ctx, chatroomId)
+	if !slices.Equal(sorted(members), sorted([]string{ada.UserId, bob.UserId})) {
+		t.Errorf("direct message members = %v", members)
+	}
+
+	other, err := s.GetOrCreateDirectMessage(ctx, ada.UserId, eve.UserId)
+	if err != nil || other == chatroomId {
+		t.Errorf("GetOrCreateDirectMessage with eve = %q, %v, want a new room", other, err)
+	}
+
+	if _, err := s.GetOrCreateDirectMessage(ctx, ada.UserId, ada.UserId); status(err) != http.StatusBadRequest {
+		t.Errorf("a direct message with oneself gave %v, want a bad request", err)
+	}
+	if _, err := s.GetOrCreateDirectMessage(ctx, ada.UserId, unique("missing")); status(err) != http.StatusNotFound {
+		t.Errorf("a direct message with a missing user gave %v, want not found", err)
+	}
+}
+
+func testFriends(t *testing.T, s Store) {
+	ada, bob := newUser(t, s, "Ada"), newUser(t, s, "Bob")
+
+	requestId, err := s.InsertFriendStatus(ctx, ada.UserId, bob.UserId, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InsertFriendStatus(ctx, ada.UserId, bob.UserId, "pending"); status(err) != http.StatusConflict {
+		t.Errorf("a second request gave %v, want a conflict", err)
+	}
+	if _, err := s.InsertFriendStatus(ctx, bob.UserId, ada.UserId, "pending"); status(err) != http.StatusConflict {
+		t.Errorf("a request in the other direction gave %v, want a conflict", err)
+	}
+
+	between, err := s.GetFriendRelationBetween(ctx, bob.UserId, ada.UserId)
+	if err != nil || between.Id != requestId {
+		t.Errorf("GetFriendRelationBetween = %+v, %v", between, err)
+	}
+	sent, err := s.GetSentFriendRequestsByUserId(ctx, ada.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0].UserId != bob.UserId || sent[0].Name != "Bob" || sent[0].RequestId != requestId {
+		t.Errorf("GetSentFriendRequestsByUserId = %+v, want the request to bob", sent)
+	}
+
+	requests, err := s.GetFriendRequestsByUserId(ctx, bob.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].RequestId != requestId || requests[0].UserId != ada.UserId || requests[0].Name != "Ada" || requests[0].Status != "pending" {
+		t.Fatalf("GetFriendRequestsByUserId = %+v, want the request from ada", requests)
+	}
+	if requests, _ := s.GetFriendRequestsByUserId(ctx, ada.UserId); len(requests) != 0 {
+		t.Errorf("the sender sees %d incoming requests", len(requests))
+	}
+	if friends, _ := s.GetFriendsByUserId(ctx, ada.UserId); len(friends) != 0 {
+		t.Errorf("a pending request counts as %d friends", len(friends))
+	}
+
+	if err := s.ChangeFriendStatus(ctx, requestId, "accepted"); err != nil {
+		t.Fatal(err)
+	}
+	relation, err := s.GetFriendRelationById(ctx, requestId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relation.UserId1 != ada.UserId || relation.UserId2 != bob.UserId || relation.Status.String != "accepted" {
+		t.Errorf("GetFriendRelationById = %+v", relation)
+	}
+	if relation.UpdatedAt.Before(relation.CreatedAt) {
+		t.Error("updated_at is before created_at")
+	}
+	if _, err := s.GetFriendRelationById(ctx, newUUID()); !lib.IsNoRows(err) {
+		t.Errorf("GetFriendRelationById of a missing relation = %v, want no rows", err)
+	}
+
+	relations, err := s.GetFriendRelationsByUserId(ctx, bob.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(relations) != 1 || relations[0].Id != requestId {
+		t.Errorf("GetFriendRelationsByUserId = %+v", relations)
+	}
+
+	friends, err := s.GetFriendsByUserId(ctx, bob.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(friends) != 1 || friends[0].UserId != ada.UserId || friends[0].FriendshipId != requestId || friends[0].ChatroomId != "" {
+		t.Errorf("GetFriendsByUserId = %+v, want ada without a chatroom", friends)
+	}
+
+	newChatroom(t, s, ada, bob)
+	chatroomId, err := s.GetOrCreateDirectMessage(ctx, ada.UserId, bob.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	friends, _ = s.GetFriendsByUserId(ctx, ada.UserId)
+	if len(friends) != 1 || friends[0].UserId != bob.UserId || friends[0].ChatroomId != chatroomId {
+		t.Errorf("GetFriendsByUserId = %+v, want bob in the direct message room only", friends)
+	}
+
+	_, err = s.InsertFriendStatus(ctx, ada.UserId, unique("missing"), "pending")
+	if status(err) != http.StatusNotFound {
+		t.Errorf("a request to a missing user gave %v, want not found", err)
+	}
+
+	if err := s.DeleteFriendRelation(ctx, requestId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetFriendRelationBetween(ctx, ada.UserId, bob.UserId); !lib.IsNoRows(err) {
+		t.Errorf("GetFriendRelationBetween after deleting = %v, want no rows", err)
+	}
+}
+
+func befriend(t *testing.T, s Store, a *lib.User, b *lib.User) {
+	t.Helper()
+	requestId, err := s.InsertFriendStatus(ctx, a.UserId, b.UserId, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ChangeFriendStatus(ctx, requestId, "accepted"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testFriendSuggestions(t *testing.T, s Store) {
+	name := unique("Suggested")
+	ada, bob, cat := newUser(t, s, name), newUser(t, s, "Bob"), newUser(t, s, "Cat")
+	dan, eve, fay := newUser(t, s, name), newUser(t, s, name), newUser(t, s, "Fay")
+
+	// dan shares two friends with ada, eve one friend and a chatroom, fay
+	// only a chatroom
+	befriend(t, s, ada, bob)
+	befriend(t, s, ada, cat)
+	befriend(t, s, dan, bob)
+	befriend(t, s, dan, cat)
+	befriend(t, s, eve, bob)
+	newChatroom(t, s, ada, eve, fay)
+
+	// A direct message room is not a shared chatroom
+	if _, err := s.GetOrCreateDirectMessage(ctx, ada.UserId, newUser(t, s, "Gus").UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	suggestions, err := s.GetFriendSuggestions(ctx, ada.UserId, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, suggestion := range suggestions {
+		got = append(got, suggestion.UserId)
+	}
+	if want := []string{dan.UserId, eve.UserId, fay.UserId}; !slices.Equal(got, want) {
+		t.Fatalf("GetFriendSuggestions = %+v, want dan, eve then fay", suggestions)
+	}
+	if suggestions[0].MutualFriends != 2 || suggestions[1].MutualFriends != 1 || suggestions[1].SharedChatrooms != 1 || suggestions[2].SharedChatrooms != 1 {
+		t.Errorf("GetFriendSuggestions counts = %+v", suggestions)
+	}
+
+	if limited, _ := s.GetFriendSuggestions(ctx, ada.UserId, 1); len(limited) != 1 || limited[0].UserId != dan.UserId {
+		t.Errorf("GetFriendSuggestions with a limit of 1 = %+v", limited)
+	}
+
+	// Pending requests and blocks remove suggestions
+	if _, err := s.InsertFriendStatus(ctx, eve.UserId, ada.UserId, "pending"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.BlockUser(ctx, fay.UserId, ada.UserId); err != nil {
+		t.Fatal(err)
+	}
+	if suggestions, _ := s.GetFriendSuggestions(ctx, ada.UserId, 10); len(suggestions) != 1 || suggestions[0].UserId != dan.UserId {
+		t.Errorf("GetFriendSuggestions after a request and a block = %+v, want dan only", suggestions)
+	}
+
+	results, err := s.SearchUsers(ctx, ada.UserId, name, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutual := make(map[string]int)
+	for _, r := range results {
+		mutual[r.UserId] = r.MutualFriends
+	}
+	if len(results) != 2 || mutual[dan.UserId] != 2 || mutual[eve.UserId] != 1 {
+		t.Errorf("SearchUsers = %+v, want dan and eve with their mutual friends", results)
+	}
+}
+
+func testBlocks(t *testing.T, s Store) {
+	ada, bob, eve := newUser(t, s, "Ada"), newUser(t, s, "Bob"), newUser(t, s, "Eve")
+	if _, err := s.InsertFriendStatus(ctx, bob.UserId, ada.UserId, "accepted"); err != nil {
+		t.Fatal(err)
+	}
+	dm, err := s.CreateChatRoom(ctx, dto.CreateChatRoomRequest{
+		Name:          "dm",
+		DirectMessage: true,
+		Participants:  []string{ada.UserId, bob.UserId},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := newChatroom(t, s, ada, bob, eve)
+
+	for range 2 {
+		if err := s.BlockUser(ctx, ada.UserId, bob.UserId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.GetFriendRelationBetween(ctx, ada.UserId, bob.UserId); !lib.IsNoRows(err) {
+		t.Errorf("the friendship survived the block: %v", err)
+	}
+
+	for _, pair := range [][2]*lib.User{{ada, bob}, {bob, ada}} {
+		if blocked, _ := s.IsBlocked(ctx, pair[0].UserId, pair[1].UserId); !blocked {
+			t.Errorf("IsBlocked(%s, %s) = false", pair[0].Name, pair[1].Name)
+		}
+	}
+	if blocked, _ := s.IsBlocked(ctx, ada.UserId, eve.UserId); blocked {
+		t.Error("IsBlocked is true for users who didn't block each other")
+	}
+
+	if blocked, _ := s.IsDirectMessageBlocked(ctx, bob.UserId, dm); !blocked {
+		t.Error("the blocked user can still write to the direct message")
+	}
+	if blocked, _ := s.IsDirectMessageBlocked(ctx, bob.UserId, group); blocked {
+		t.Error("a block applies to group chatrooms")
+	}
+
+	blocked, err := s.GetBlockedUsers(ctx, ada.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocked) != 1 || blocked[0].UserId != bob.UserId || blocked[0].Name != "Bob" || blocked[0].BlockedAt.IsZero() {
+		t.Errorf("GetBlockedUsers = %+v", blocked)
+	}
+	if blocked, _ := s.GetBlockedUsers(ctx, bob.UserId); len(blocked) != 0 {
+		t.Errorf("the blocked user has %d blocks", len(blocked))
+	}
+
+	// Blocked users don't find each other
+	if results, _ := s.SearchUsers(ctx, bob.UserId, ada.Username, 10); len(results) != 0 {
+		t.Errorf("the blocked user found %+v", results)
+	}
+	if results, _ := s.SearchUsers(ctx, ada.UserId, bob.Username, 10); len(results) != 0 {
+		t.Errorf("the blocker found %+v", results)
+	}
+	if results, _ := s.SearchUsers(ctx, eve.UserId, bob.Username, 10); len(results) != 1 {
+		t.Errorf("a third user found %d users, want 1", len(results))
+	}
+
+	if err := s.UnblockUser(ctx, ada.UserId, bob.UserId); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := s.IsBlocked(ctx, bob.UserId, ada.UserId); blocked {
+		t.Error("still blocked after unblocking")
+	}
+}
+
+func testRemotes(t *testing.T, s Store) {
+	ada, bob := newUser(t, s, "Ada"), newUser(t, s, "Bob")
+	chatroomId := newChatroom(t, s, ada, bob)
+
+	remote, err := s.GetRemoteByChatroomId(ctx, chatroomId)
+	if err != nil || remote.UserId != "" {
+		t.Errorf("GetRemoteByChatroomId without a remote = %+v, %v", remote, err)
+	}
+
+	// Nothing to update yet
+	if err := s.UpdateRemote(ctx, chatroomId, bob.UserId); err != nil {
+		t.Fatal(err)
+	}
+	if remote, _ := s.GetRemoteByChatroomId(ctx, chatroomId); remote.UserId != "" {
+		t.Errorf("UpdateRemote created a remote for %s", remote.UserId)
+	}
+
+	if err := s.CreateRemote(ctx, chatroomId, ada.UserId); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateRemote(ctx, chatroomId, bob.UserId); status(err) != http.StatusConflict {
+		t.Errorf("a second remote gave %v, want a conflict", err)
+	}
+
+	if err := s.UpdateRemote(ctx, chatroomId, bob.UserId); err != nil {
+		t.Fatal(err)
+	}
+	remote, err = s.GetRemoteByChatroomId(ctx, chatroomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.UserId != bob.UserId || remote.Name != "Bob" || remote.Username != bob.Username {
+		t.Errorf("GetRemoteByChatroomId = %+v, want bob", remote)
+	}
+}
+
+func testSessions(t *testing.T, s Store) {
+	ada := newUser(t, s, "Ada")
+	token := unique("token")
+
+	session := &lib.Session{
+		UserId:    ada.UserId,
+		Token:     token,
+		UserAgent: sql.NullString{String: "test", Valid: true},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := s.CreateSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Id == 0 || session.CreatedAt.IsZero() {
+		t.Errorf("CreateSession did not fill in the id and creation time: %+v", session)
+	}
+	duplicate := *session
+	if err := s.CreateSession(ctx, &duplicate); status(err) != http.StatusConflict {
+		t.Errorf("a duplicate token gave %v, want a conflict", err)
+	}
+
+	newToken := unique("token")
+	rotated, err := s.RotateSession(ctx, session.Id, token, newToken, time.Now().Add(2*time.Hour))
+	if err != nil || !rotated {
+		t.Fatalf("RotateSession = %v, %v", rotated, err)
+	}
+	if rotated, _ := s.RotateSession(ctx, session.Id, token, unique("token"), time.Now().Add(time.Hour)); rotated {
+		t.Error("a replaced token rotated the session again")
+	}
+
+	byOld, err := s.GetSessionByToken(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byOld.Id != session.Id || byOld.Token != newToken || byOld.PreviousToken.String != token || !byOld.RotatedAt.Valid {
+		t.Errorf("GetSessionByToken(previous) = %+v", byOld)
+	}
+	if _, err := s.GetSessionByToken(ctx, unique("token")); !lib.IsNoRows(err) {
+		t.Errorf("GetSessionByToken of a missing token = %v, want no rows", err)
+	}
+
+	expired := &lib.Session{UserId: ada.UserId, Token: unique("token"), ExpiresAt: time.Now().Add(-time.Hour)}
+	if err := s.CreateSession(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := s.IsSessionActive(ctx, expired.Id); active {
+		t.Error("an expired session is active")
+	}
+
+	active, err := s.GetActiveSessionsByUserId(ctx, ada.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].Id != session.Id {
+		t.Errorf("GetActiveSessionsByUserId = %+v", active)
+	}
+
+	// Revoking someone else's session does nothing
+	if err := s.RevokeSession(ctx, unique("user"), session.Id); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := s.IsSessionActive(ctx, session.Id); !active {
+		t.Error("another user revoked the session")
+	}
+
+	// Changing the password keeps the session it was changed in
+	ids, err := s.RevokeUserSessions(ctx, ada.UserId, session.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []int32{expired.Id}) {
+		t.Errorf("RevokeUserSessions but the current one = %v", ids)
+	}
+	if active, _ := s.IsSessionActive(ctx, session.Id); !active {
+		t.Error("the kept session was revoked")
+	}
+	ids, err = s.RevokeUserSessions(ctx, ada.UserId, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []int32{session.Id}) {
+		t.Errorf("RevokeUserSessions = %v", ids)
+	}
+	if active, _ := s.IsSessionActive(ctx, session.Id); active {
+		t.Error("a revoked session is active")
+	}
+	if rotated, _ := s.RotateSession(ctx, session.Id, newToken, unique("token"), time.Now().Add(time.Hour)); rotated {
+		t.Error("a revoked session was rotated")
+	}
+}
+
+func testAccounts(t *testing.T, s Store) {
+	ada, bob := newUser(t, s, "Ada"), newUser(t, s, "Bob")
+	if ada.EmailVerified {
+		t.Error("a new user has a verified email")
+	}
+	if err := s.MarkEmailVerified(ctx, ada.UserId); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := s.GetUserByEmail(ctx, ada.Email); err != nil || !user.EmailVerified {
+		t.Errorf("GetUserByEmail after MarkEmailVerified = %+v, %v", user, err)
+	}
+	subject := unique("subject")
+
+	identity := &dto.OAuthUser{Provider: "github", Subject: subject}
+	if err := s.CreateIdentity(ctx, ada.UserId, identity); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateIdentity(ctx, bob.UserId, identity); status(err) != http.StatusConflict {
+		t.Errorf("linking the same identity twice gave %v, want a conflict", err)
+	}
+
+	user, err := s.GetUserByIdentity(ctx, "github", subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UserId != ada.UserId {
+		t.Errorf("GetUserByIdentity = %s, want ada", user.UserId)
+	}
+
+	identities, err := s.GetIdentitiesByUserId(ctx, ada.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Provider != "github" || identities[0].Email.Valid {
+		t.Errorf("GetIdentitiesByUserId = %+v, want github without an email", identities)
+	}
+
+	if err := s.DeleteIdentity(ctx, ada.UserId, "github"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUserByIdentity(ctx, "github", subject); !lib.IsNoRows(err) {
+		t.Errorf("GetUserByIdentity after unlinking = %v, want no rows", err)
+	}
+
+	tokenHash := unique("reset")
+	if err := s.CreatePasswordReset(ctx, ada.UserId, tokenHash, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	userId, err := s.ConsumePasswordReset(ctx, tokenHash)
+	if err != nil || userId != ada.UserId {
+		t.Errorf("ConsumePasswordReset = %q, %v", userId, err)
+	}
+	if _, err := s.ConsumePasswordReset(ctx, tokenHash); !lib.IsNoRows(err) {
+		t.Errorf("consuming a reset token twice gave %v, want no rows", err)
+	}
+
+	expired := unique("reset")
+	if err := s.CreatePasswordReset(ctx, ada.UserId, expired, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ConsumePasswordReset(ctx, expired); !lib.IsNoRows(err) {
+		t.Errorf("consuming an expired reset token gave %v, want no rows", err)
+	}
+}
+
+func testAccountDeletion(t *testing.T, s Store) {
+	ada, bob, eve := newUser(t, s, "Ada"), newUser(t, s, "Bob"), newUser(t, s, "Eve")
+	chatroomId := newChatroom(t, s, ada, bob)
+	if err := s.SetChatroomRole(ctx, chatroomId, ada.UserId, lib.ChatroomOwner); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := s.GetChatroomMembers(ctx, chatroomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].UserId != ada.UserId || members[0].Role != lib.ChatroomOwner || members[1].Role != lib.ChatroomMember {
+		t.Errorf("GetChatroomMembers = %+v, want the owner first", members)
+	}
+
+	if err := s.CreateRemote(ctx, chatroomId, ada.UserId); err != nil {
+		t.Fatal(err)
+	}
+	if remotes, _ := s.GetRemotesByUserId(ctx, ada.UserId); !slices.Equal(remotes, []string{chatroomId}) {
+		t.Errorf("GetRemotesByUserId = %v", remotes)
+	}
+
+	if _, err := s.CreateAttachment(ctx, &lib.Attachment{
+		ChatroomId: chatroomId, Uploader: ada.UserId, FileName: "a.txt", ContentType: "text/plain", Size: 1, StorageKey: "a",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := s.GetAttachmentsByUploader(ctx, ada.UserId); len(files) != 1 || files[0].FileName != "a.txt" {
+		t.Errorf("GetAttachmentsByUploader = %+v", files)
+	}
+
+	for _, content := range []string{"one", "two", "three"} {
+		if _, err := s.StoreChatRoomMessage(ctx, StoreChatMessageDto{Sender: ada.UserId, ChatroomId: chatroomId, Content: content}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := s.StoreChatRoomMessage(ctx, StoreChatMessageDto{Sender: bob.UserId, ChatroomId: chatroomId, Content: "reply"}); err != nil {
+		t.Fatal(err)
+	}
+	page, err := s.GetMessagesBySender(ctx, ada.UserId, 1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].Content != "two" || page[1].Content != "three" || page[0].SenderName != ada.Name {
+		t.Errorf("GetMessagesBySender = %+v", page)
+	}
+
+	first, err := s.ScheduleUserDeletion(ctx, ada.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(first); d < -time.Minute || d > time.Minute {
+		t.Errorf("the deletion was asked at %v", first)
+	}
+	if again, _ := s.ScheduleUserDeletion(ctx, ada.UserId); !again.Equal(first) {
+		t.Errorf("asking twice moved the request from %v to %v", first, again)
+	}
+	if _, err := s.ScheduleUserDeletion(ctx, unique("missing")); status(err) != http.StatusNotFound {
+		t.Errorf("ScheduleUserDeletion of a missing user gave %v, want not found", err)
+	}
+	if _, err := s.ScheduleUserDeletion(ctx, eve.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := s.GetUsersDueForDeletion(ctx, -time.Minute, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(due, ada.UserId) || !slices.Contains(due, eve.UserId) || slices.Contains(due, bob.UserId) {
+		t.Errorf("GetUsersDueForDeletion = %v", due)
+	}
+	if due, _ := s.GetUsersDueForDeletion(ctx, time.Minute, 1000); slices.Contains(due, ada.UserId) {
+		t.Error("a user is due before they asked")
+	}
+
+	if cancelled, err := s.CancelUserDeletion(ctx, eve.UserId); err != nil || !cancelled {
+		t.Errorf("CancelUserDeletion = %v, %v", cancelled, err)
+	}
+	if cancelled, _ := s.CancelUserDeletion(ctx, eve.UserId); cancelled {
+		t.Error("cancelling twice reported a pending deletion")
+	}
+
+	if err := s.DeleteUser(ctx, ada.UserId); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser(ctx, ada.UserId); status(err) != http.StatusNotFound {
+		t.Errorf("deleting twice gave %v, want not found", err)
+	}
+	if _, err := s.GetUserById(ctx, ada.UserId); status(err) != http.StatusNotFound {
+		t.Errorf("GetUserById of a deleted user gave %v, want not found", err)
+	}
+
+	history, err := s.GetLast50ChatRoomMessages(ctx, chatroomId, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 4 {
+		t.Fatalf("history has %d messages after the deletion, want 4", len(history))
+	}
+	for _, m := range history {
+		if m.Content != "reply" && (m.Sender != "" || m.SenderName != lib.DeletedUserName) {
+			t.Errorf("a message of the deleted user is %+v", m)
+		}
+	}
+
+	if members, _ := s.GetChatroomMembers(ctx, chatroomId); len(members) != 1 || members[0].UserId != bob.UserId {
+		t.Errorf("members after the deletion = %+v", members)
+	}
+	if remote, _ := s.GetRemoteByChatroomId(ctx, chatroomId); remote.UserId != "" {
+		t.Errorf("the remote is still held by %q", remote.UserId)
+	}
+	if files, _ := s.GetAttachmentsByUploader(ctx, ada.UserId); len(files) != 0 {
+		t.Errorf("%d attachments survived their uploader", len(files))
+	}
+	if due, _ := s.GetUsersDueForDeletion(ctx, -time.Minute, 1000); slices.Contains(due, ada.UserId) {
+		t.Error("a deleted user is still due for deletion")
+	}
+}
+
+func testNotifications(t *testing.T, s Store) {
+	ada, bob := newUser(t, s, "Ada"), newUser(t, s, "Bob")
+
+	notify := func(data map[string]any) *lib.Notification {
+		n := &lib.Notification{
+			UserId:  ada.UserId,
+			Type:    lib.NotificationFriendRequest,
+			ActorId: sql.NullString{String: bob.UserId, Valid: true},
+			Data:    data,
+		}
+		if err := s.CreateNotification(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	first := notify(map[string]any{"count": 1})
+	second := notify(nil)
+
+	notifications, err := s.GetNotificationsByUserId(ctx, ada.UserId, false, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 2 || notifications[0].Id != second.Id || notifications[1].Id != first.Id {
+		t.Fatalf("GetNotificationsByUserId = %+v, want newest first", notifications)
+	}
+	// Data round trips through JSON
+	if notifications[1].Data["count"] != float64(1) || notifications[0].Data == nil {
+		t.Errorf("data = %v and %v", notifications[1].Data, notifications[0].Data)
+	}
+	if page, _ := s.GetNotificationsByUserId(ctx, ada.UserId, false, 1, 1); len(page) != 1 || page[0].Id != first.Id {
+		t.Errorf("the second page = %+v", page)
+	}
+
+	updated, err := s.MarkNotificationsRead(ctx, ada.UserId, []string{first.Id})
+	if err != nil || updated != 1 {
+		t.Errorf("MarkNotificationsRead = %d, %v", updated, err)
+	}
+	if updated, _ := s.MarkNotificationsRead(ctx, bob.UserId, []string{second.Id}); updated != 0 {
+		t.Error("another user marked the notification as read")
+	}
+	if unread, _ := s.CountUnreadNotifications(ctx, ada.UserId); unread != 1 {
+		t.Errorf("CountUnreadNotifications = %d, want 1", unread)
+	}
+	if unread, _ := s.GetNotificationsByUserId(ctx, ada.UserId, true, 10, 0); len(unread) != 1 || unread[0].Id != second.Id {
+		t.Errorf("unread notifications = %+v", unread)
+	}
+
+	if updated, _ := s.MarkNotificationsRead(ctx, ada.UserId, nil); updated != 1 {
+		t.Errorf("marking everything read updated %d, want 1", updated)
+	}
+
+	err = s.CreateNotification(ctx, &lib.Notification{UserId: unique("missing"), Type: lib.NotificationMention})
+	if status(err) != http.StatusNotFound {
+		t.Errorf("a notification for a missing user gave %v, want not found", err)
+	}
+	err = s.CreateNotification(ctx, &lib.Notification{
+		UserId:     ada.UserId,
+		Type:       lib.NotificationMention,
+		ChatroomId: sql.NullString{String: newUUID(), Valid: true},
+	})
+	if status(err) != http.StatusNotFound {
+		t.Errorf("a notification for a missing chatroom gave %v, want not found", err)
+	}
+
+	// The chatroom comes back and only counts for the user notified
+	chatroomId := newChatroom(t, s, ada, bob)
+	mention := &lib.Notification{
+		UserId:     bob.UserId,
+		Type:       lib.NotificationMention,
+		ActorId:    sql.NullString{String: ada.UserId, Valid: true},
+		ChatroomId: sql.NullString{String: chatroomId, Valid: true},
+	}
+	if err := s.CreateNotification(ctx, mention); err != nil {
+		t.Fatal(err)
+	}
+	if unread, _ := s.CountUnreadNotifications(ctx, ada.UserId); unread != 0 {
+		t.Errorf("ada has %d unread notifications, want 0", unread)
+	}
+	got, err := s.GetNotificationsByUserId(ctx, bob.UserId, true, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ChatroomId.String != chatroomId || got[0].ActorId.String != ada.UserId || got[0].ReadAt.Valid {
+		t.Errorf("notifications of bob = %+v", got)
+	}
+	if got[0].CreatedAt.IsZero() || !got[0].CreatedAt.Equal(mention.CreatedAt) {
+		t.Errorf("created_at = %v, CreateNotification returned %v", got[0].CreatedAt, mention.CreatedAt)
+	}
+}
+
+func testStreamRooms(t *testing.T, s Store) {
+	ada := newUser(t, s, "Ada")
+	chatroomId := newChatroom(t, s, ada)
+	nodeA, nodeB := unique("node"), unique("node")
+	live := time.Minute
+
+	if _, err := s.GetStreamRoomNode(ctx, chatroomId, live); !lib.IsNoRows(err) {
+		t.Errorf("GetStreamRoomNode of an idle room = %v, want no rows", err)
+	}
+
+	if owner, err := s.ClaimStreamRoom(ctx, chatroomId, nodeA, live); err != nil || owner != nodeA {
+		t.Fatalf("ClaimStreamRoom = %q, %v", owner, err)
+	}
+	// Claiming again is a heartbeat, another node has to wait for it to go stale
+	if owner, err := s.ClaimStreamRoom(ctx, chatroomId, nodeA, live); err != nil || owner != nodeA {
+		t.Errorf("claiming twice = %q, %v", owner, err)
+	}
+	if owner, err := s.ClaimStreamRoom(ctx, chatroomId, nodeB, live); err != nil || owner != nodeA {
+		t.Errorf("claiming a live room = %q, %v, want %q", owner, err, nodeA)
+	}
+	if node, err := s.GetStreamRoomNode(ctx, chatroomId, live); err != nil || node != nodeA {
+		t.Errorf("GetStreamRoomNode = %q, %v", node, err)
+	}
+	if n, err := s.RefreshStreamRooms(ctx, nodeA, []string{chatroomId}); err != nil || n != 1 {
+		t.Errorf("RefreshStreamRooms = %d, %v", n, err)
+	}
+	if n, _ := s.RefreshStreamRooms(ctx, nodeA, []string{newChatroom(t, s, ada)}); n != 0 {
+		t.Errorf("refreshing a room the node doesn't hold counted %d", n)
+	}
+
+	// Releasing is only done by the holder
+	if err := s.ReleaseStreamRoom(ctx, chatroomId, nodeB); err != nil {
+		t.Fatal(err)
+	}
+	if node, _ := s.GetStreamRoomNode(ctx, chatroomId, live); node != nodeA {
+		t.Errorf("another node released the room of %q", nodeA)
+	}
+
+	// A node that stopped sending heartbeats loses the room. A negative ttl
+	// makes every heartbeat stale.
+	stale := -time.Minute
+	if _, err := s.GetStreamRoomNode(ctx, chatroomId, stale); !lib.IsNoRows(err) {
+		t.Errorf("GetStreamRoomNode of a stale claim = %v, want no rows", err)
+	}
+	if owner, err := s.ClaimStreamRoom(ctx, chatroomId, nodeB, stale); err != nil || owner != nodeB {
+		t.Fatalf("taking over a stale room = %q, %v", owner, err)
+	}
+	if n, _ := s.RefreshStreamRooms(ctx, nodeA, []string{chatroomId}); n != 0 {
+		t.Errorf("the old node still refreshes %d rooms", n)
+	}
+
+	if err := s.ReleaseStreamRoom(ctx, chatroomId, nodeB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetStreamRoomNode(ctx, chatroomId, live); !lib.IsNoRows(err) {
+		t.Errorf("GetStreamRoomNode after the release = %v, want no rows", err)
+	}
+
+	if _, err := s.ClaimStreamRoom(ctx, newUUID(), nodeA, live); status(err) != http.StatusNotFound {
+		t.Errorf("claiming a missing chatroom gave %v, want not found", err)
+	}
+}
+
+func testAttempts(t *testing.T, s Store) {
+	key, other := unique("login:"), unique("login:")
+	for _, k := range []string{key, key, key, other} {
+		if err := s.RecordAttempt(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count, err := s.CountAttempts(ctx, key, time.Minute); err != nil || count != 3 {
+		t.Errorf("CountAttempts = %d, %v, want 3", count, err)
+	}
+	// A negative window starts in the future, nothing is inside it
+	if count, _ := s.CountAttempts(ctx, key, -time.Minute); count != 0 {
+		t.Errorf("CountAttempts of an empty window = %d", count)
+	}
+
+	if err := s.ClearAttempts(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := s.CountAttempts(ctx, key, time.Minute); count != 0 {
+		t.Errorf("CountAttempts after clearing = %d", count)
+	}
+	if count, _ := s.CountAttempts(ctx, other, time.Minute); count != 1 {
+		t.Errorf("clearing a key cleared another, it has %d attempts", count)
+	}
+
+	if _, err := s.DeleteAttemptsOlderThan(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := s.CountAttempts(ctx, other, time.Minute); count != 1 {
+		t.Errorf("a recent attempt was swept, %d left", count)
+	}
+	if deleted, err := s.DeleteAttemptsOlderThan(ctx, -time.Minute); err != nil || deleted < 1 {
+		t.Errorf("DeleteAttemptsOlderThan = %d, %v", deleted, err)
+	}
+	if count, _ := s.CountAttempts(ctx, other, time.Hour); count != 0 {
+		t.Errorf("%d attempts are left after the sweep", count)
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ClaimStreamRoom makes nodeId the node streaming a chatroom unless another
+// node holds it with a heartbeat within ttl. It returns the node holding the
+// room afterwards, which is nodeId when the claim succeeded. The age of the
+// heartbeat is measured with the database clock that wrote it.
+func (s *PostgresStore) ClaimStreamRoom(ctx context.Context, chatroomId string, nodeId string, ttl time.Duration) (string, error) {
+	q := `INSERT INTO stream_rooms (chatroom_id, node_id) VALUES ($1, $2)
+	ON CONFLICT (chatroom_id) DO UPDATE SET
+		node_id = EXCLUDED.node_id,
+		claimed_at = CASE WHEN stream_rooms.node_id = EXCLUDED.node_id THEN stream_rooms.claimed_at ELSE CURRENT_TIMESTAMP END,
+		heartbeat_at = CURRENT_TIMESTAMP
+	WHERE stream_rooms.node_id = EXCLUDED.node_id OR stream_rooms.heartbeat_at < CURRENT_TIMESTAMP - make_interval(secs => $3)
+	RETURNING node_id`
+
+	var owner string
+	err := s.pool.QueryRow(ctx, q, chatroomId, nodeId, ttl.Seconds()).Scan(&owner)
+	if err == nil {
+		return owner, nil
+	}
+	if err != pgx.ErrNoRows {
+		log.Println("Error in Store.ClaimStreamRoom[Scan]:", err)
+		return "", err
+	}
+
+	// The room is held by a live node, nothing was returned
+	if err := s.pool.QueryRow(ctx, "SELECT node_id FROM stream_rooms WHERE chatroom_id = $1", chatroomId).Scan(&owner); err != nil {
+		log.Println("Error in Store.ClaimStreamRoom[Scan owner]:", err)
+		return "", err
+	}
+	return owner, nil
+}
+
+// RefreshStreamRooms keeps the claims of nodeId on chatroomIds alive and
+// returns how many it still holds. Claims it no longer reports go stale.
+func (s *PostgresStore) RefreshStreamRooms(ctx context.Context, nodeId string, chatroomIds []string) (int64, error) {
+	q := "UPDATE stream_rooms SET heartbeat_at = CURRENT_TIMESTAMP WHERE node_id = $1 AND chatroom_id = ANY($2::uuid[])"
+	tag, err := s.pool.Exec(ctx, q, nodeId, chatroomIds)
+	if err != nil {
+		log.Println("Error in Store.RefreshStreamRooms[Exec]:", err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ReleaseStreamRoom drops the claim of nodeId on a chatroom, a claim taken
+// over by another node is left alone
+func (s *PostgresStore) ReleaseStreamRoom(ctx context.Context, chatroomId string, nodeId string) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM stream_rooms WHERE chatroom_id = $1 AND node_id = $2", chatroomId, nodeId)
+	if err != nil {
+		log.Println("Error in Store.ReleaseStreamRoom[Exec]:", err)
+	}
+	return err
+}
+
+// GetStreamRoomNode returns the node streaming a chatroom, pgx.ErrNoRows when
+// there is none or its heartbeat is older than ttl
+func (s *PostgresStore) GetStreamRoomNode(ctx context.Context, chatroomId string, ttl time.Duration) (string, error) {
+	q := "SELECT node_id FROM stream_rooms WHERE chatroom_id = $1 AND heartbeat_at >= CURRENT_TIMESTAMP - make_interval(secs => $2)"
+
+	var nodeId string
+	if err := s.pool.QueryRow(ctx, q, chatroomId, ttl.Seconds()).Scan(&nodeId); err != nil {
+		if err != pgx.ErrNoRows {
+			log.Println("Error in Store.GetStreamRoomNode[Scan]:", err)
+		}
+		return "", err
+	}
+	return nodeId, nil
+}
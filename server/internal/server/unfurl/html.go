@@ -0,0 +1,84 @@
+package unfurl
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type headMeta struct {
+	title  string
+	props  map[string]string
+	oembed string
+}
+
+// parseHead walks the document until </head> (or <body>) collecting <title>,
+// <meta property|name=... content=...> and the oEmbed discovery link.
+func parseHead(r io.Reader) (*headMeta, error) {
+	meta := &headMeta{props: make(map[string]string)}
+	z := html.NewTokenizer(r)
+	inTitle := false
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return meta, nil
+			}
+			return meta, z.Err()
+
+		case html.TextToken:
+			if inTitle && meta.title == "" {
+				meta.title = strings.TrimSpace(string(z.Text()))
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				return meta, nil
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := string(name)
+			if tag == "body" {
+				return meta, nil
+			}
+			if tag == "title" {
+				inTitle = tt == html.StartTagToken
+				continue
+			}
+
+			attrs := map[string]string{}
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				attrs[strings.ToLower(string(k))] = string(v)
+			}
+
+			switch tag {
+			case "meta":
+				key := attrs["property"]
+				if key == "" {
+					key = attrs["name"]
+				}
+				key = strings.ToLower(key)
+				if key != "" && attrs["content"] != "" {
+					if _, ok := meta.props[key]; !ok {
+						meta.props[key] = attrs["content"]
+					}
+				}
+			case "link":
+				if strings.EqualFold(attrs["rel"], "alternate") &&
+					strings.EqualFold(attrs["type"], "application/json+oembed") {
+					meta.oembed = attrs["href"]
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package unfurl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrBlockedAddress = errors.New("address is not allowed")
+
+type Preview struct {
+	Url         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+	Type        string `json:"type,omitempty"`
+}
+
+type Config struct {
+	Timeout      time.Duration
+	MaxBodyBytes int64
+	CacheTTL     time.Duration
+	CacheSize    int
+	// Only meant for tests against a local stub server
+	AllowPrivateIPs bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout:      5 * time.Second,
+		MaxBodyBytes: 512 << 10,
+		CacheTTL:     time.Hour,
+		CacheSize:    1000,
+	}
+}
+
+type cacheEntry struct {
+	preview *Preview
+	err     error
+	expires time.Time
+}
+
+type Unfurler struct {
+	config Config
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+func New(config Config) *Unfurler {
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+	}
+	if !config.AllowPrivateIPs {
+		// Checking at connect time (after DNS resolution) also covers
+		// redirects and DNS rebinding, not just the URL we were given
+		dialer.Control = BlockPrivateAddresses
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   config.Timeout,
+		ResponseHeaderTimeout: config.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Unfurler{
+		config: config,
+		cache:  make(map[string]cacheEntry),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   config.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return fmt.Errorf("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("redirect to unsupported scheme %s", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+var privateNets = func() []*net.IPNet {
+	cidrs := []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.0.0.0/24",
+		"192.168.0.0/16",
+		"198.18.0.0/15",
+		"224.0.0.0/4",
+		"240.0.0.0/4",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+		"ff00::/8",
+	}
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, _ := net.ParseCIDR(c)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// BlockPrivateAddresses is a net.Dialer Control refusing connections to
+// private addresses. It runs once the address is resolved.
+func BlockPrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || IsPrivateIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+func IsPrivateIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var urlRegex = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// ExtractURLs returns up to limit unique http(s) urls found in text.
+func ExtractURLs(text string, limit int) []string {
+	urls := make([]string, 0)
+	for _, match := range urlRegex.FindAllString(text, -1) {
+		// Trailing punctuation is almost never part of the link
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		u, err := url.Parse(match)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if contains(urls, match) {
+			continue
+		}
+		urls = append(urls, match)
+		if len(urls) >= limit {
+			break
+		}
+	}
+	return urls
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Unfurl fetches rawUrl and extracts its OpenGraph / oEmbed metadata. Results
+// (including failures) are cached so that a link pasted many times in a busy
+// room is only fetched once.
+func (u *Unfurler) Unfurl(ctx context.Context, rawUrl string) (*Preview, error) {
+	now := time.Now()
+
+	u.mu.Lock()
+	entry, ok := u.cache[rawUrl]
+	u.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.preview, entry.err
+	}
+
+	preview, err := u.fetch(ctx, rawUrl)
+
+	ttl := u.config.CacheTTL
+	if err != nil {
+		// Don't remember failures for too long, the site might just be down
+		ttl = min(ttl, 5*time.Minute)
+	}
+	u.store(rawUrl, cacheEntry{preview: preview, err: err, expires: now.Add(ttl)})
+
+	return preview, err
+}
+
+func (u *Unfurler) store(key string, entry cacheEntry) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.cache) >= u.config.CacheSize {
+		// Drop the entry closest to expiring to make room
+		var oldestKey string
+		var oldest time.Time
+		for k, v := range u.cache {
+			if oldestKey == "" || v.expires.Before(oldest) {
+				oldestKey, oldest = k, v.expires
+			}
+		}
+		delete(u.cache, oldestKey)
+	}
+	u.cache[key] = entry
+}
+
+func (u *Unfurler) get(ctx context.Context, rawUrl string) (*http.Response, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %s", parsed.Scheme)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.config.Timeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "ShibaBot/1.0 (+link preview)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/json;q=0.9,*/*;q=0.5")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (u *Unfurler) fetch(ctx context.Context, rawUrl string) (*Preview, error) {
+	resp, err := u.get(ctx, rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "image/") {
+		return &Preview{Url: rawUrl, Image: rawUrl, Type: "image"}, nil
+	}
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("unsupported content type %s", mediaType)
+	}
+
+	meta, err := parseHead(io.LimitReader(resp.Body, u.config.MaxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &Preview{
+		Url:         first(meta.props["og:url"], rawUrl),
+		Title:       first(meta.props["og:title"], meta.props["twitter:title"], meta.title),
+		Description: first(meta.props["og:description"], meta.props["twitter:description"], meta.props["description"]),
+		Image:       first(meta.props["og:image"], meta.props["og:image:url"], meta.props["twitter:image"]),
+		SiteName:    meta.props["og:site_name"],
+		Type:        meta.props["og:type"],
+	}
+
+	if meta.oembed != "" && (preview.Title == "" || preview.Image == "") {
+		base := resp.Request.URL
+		if ref, err := base.Parse(meta.oembed); err == nil {
+			if err := u.fillFromOEmbed(ctx, ref.String(), preview); err != nil {
+				log.Println("Error in Unfurl[oEmbed]:", err)
+			}
+		}
+	}
+
+	if preview.Image != "" {
+		if ref, err := resp.Request.URL.Parse(preview.Image); err == nil {
+			preview.Image = ref.String()
+		}
+	}
+
+	if preview.Title == "" {
+		return nil, fmt.Errorf("no metadata found for %s", rawUrl)
+	}
+	return preview, nil
+}
+
+type oembedResponse struct {
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailUrl string `json:"thumbnail_url"`
+}
+
+func (u *Unfurler) fillFromOEmbed(ctx context.Context, endpoint string, preview *Preview) error {
+	resp, err := u.get(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	o := oembedResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, u.config.MaxBodyBytes)).Decode(&o); err != nil {
+		return err
+	}
+
+	preview.Title = first(preview.Title, o.Title)
+	preview.Image = first(preview.Image, o.ThumbnailUrl)
+	preview.SiteName = first(preview.SiteName, o.ProviderName)
+	preview.Type = first(preview.Type, o.Type)
+	if preview.Description == "" && o.AuthorName != "" {
+		preview.Description = "by " + o.AuthorName
+	}
+	return nil
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
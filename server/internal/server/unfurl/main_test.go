@@ -0,0 +1,152 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testConfig() Config {
+	config := DefaultConfig()
+	config.Timeout = 2 * time.Second
+	config.AllowPrivateIPs = true
+	return config
+}
+
+func TestUnfurlOpenGraph(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<!doctype html><html><head>
+			<title>Fallback title</title>
+			<meta property="og:title" content="Watch party">
+			<meta property="og:description" content="A video">
+			<meta property="og:image" content="/thumb.jpg">
+			<meta property="og:site_name" content="Stub">
+			</head><body><meta property="og:title" content="ignored"></body></html>`)
+	}))
+	defer srv.Close()
+
+	u := New(testConfig())
+	p, err := u.Unfurl(context.Background(), srv.URL+"/video")
+	if err != nil {
+		t.Fatalf("Unfurl: %v", err)
+	}
+
+	if p.Title != "Watch party" || p.Description != "A video" || p.SiteName != "Stub" {
+		t.Errorf("unexpected preview %+v", p)
+	}
+	if p.Image != srv.URL+"/thumb.jpg" {
+		t.Errorf("image not resolved against page url, got %q", p.Image)
+	}
+
+	// Second call must come from the cache
+	if _, err := u.Unfurl(context.Background(), srv.URL+"/video"); err != nil {
+		t.Fatalf("Unfurl (cached): %v", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("expected 1 request to stub, got %d", n)
+	}
+}
+
+func TestUnfurlOEmbedFallback(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><link rel="alternate" type="application/json+oembed" href="/oembed"></head></html>`)
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"type":"video","title":"From oEmbed","provider_name":"Tube","thumbnail_url":"http://example.com/t.jpg"}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	p, err := New(testConfig()).Unfurl(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Unfurl: %v", err)
+	}
+	if p.Title != "From oEmbed" || p.SiteName != "Tube" || p.Image != "http://example.com/t.jpg" || p.Type != "video" {
+		t.Errorf("unexpected preview %+v", p)
+	}
+}
+
+func TestUnfurlTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	config := testConfig()
+	config.Timeout = 100 * time.Millisecond
+	if _, err := New(config).Unfurl(context.Background(), srv.URL); err == nil {
+		t.Fatal("expected timeout error")
+	}
+}
+
+func TestUnfurlBlocksPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should never reach a loopback server")
+	}))
+	defer srv.Close()
+
+	config := testConfig()
+	config.AllowPrivateIPs = false
+	_, err := New(config).Unfurl(context.Background(), srv.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("expected ErrBlockedAddress, got %v", err)
+	}
+}
+
+func TestUnfurlBlocksHostnameResolvingToPrivateAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should never reach a loopback server")
+	}))
+	defer srv.Close()
+
+	// Going through a hostname makes sure the check runs on the resolved ip
+	parsed, _ := url.Parse(srv.URL)
+	config := testConfig()
+	config.AllowPrivateIPs = false
+	_, err := New(config).Unfurl(context.Background(), "http://localhost:"+parsed.Port())
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("expected ErrBlockedAddress, got %v", err)
+	}
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.20.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fd00::1":         true,
+		"::ffff:10.0.0.1": true,
+		"8.8.8.8":         false,
+		"2606:4700::1111": false,
+	}
+	for ip, want := range cases {
+		if got := IsPrivateIP(net.ParseIP(ip)); got != want {
+			t.Errorf("IsPrivateIP(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	got := ExtractURLs("watch https://a.com/x, and (https://b.com/y) or https://a.com/x again ftp://c.com", 5)
+	want := []string{"https://a.com/x", "https://b.com/y"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ExtractURLs = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,157 @@
+package vbrowser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type devtoolsTarget struct {
+	Id                   string `json:"id"`
+	Type                 string `json:"type"`
+	Url                  string `json:"url"`
+	WebSocketDebuggerUrl string `json:"webSocketDebuggerUrl"`
+}
+
+type devtoolsCommand struct {
+	Id     int            `json:"id"`
+	Method string         `json:"method"`
+	Params map[string]any `json:"params,omitempty"`
+}
+
+type devtoolsResponse struct {
+	Id     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Navigate loads url in the first page target of the running Chrome using
+// the DevTools protocol on DevtoolsPort.
+func (m *VbrowserManager) Navigate(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	raw, err := m.devtoolsCall(ctx, "Page.navigate", map[string]any{"url": url})
+	if err != nil {
+		log.Println("Error in Navigate[devtoolsCall]:", err)
+		return err
+	}
+
+	result := struct {
+		ErrorText string `json:"errorText"`
+	}{}
+	json.Unmarshal(raw, &result)
+	if result.ErrorText != "" {
+		return fmt.Errorf("navigation failed: %s", result.ErrorText)
+	}
+
+	m.devtoolsMu.Lock()
+	m.currentUrl = url
+	m.devtoolsMu.Unlock()
+	log.Println("🌍 Navigated browser to", url)
+	return nil
+}
+
+// devtoolsCall sends a command to the page and returns its result. The
+// connection is kept open between calls, input events come in quick
+// succession.
+func (m *VbrowserManager) devtoolsCall(ctx context.Context, method string, params map[string]any) (json.RawMessage, error) {
+	m.devtoolsMu.Lock()
+	defer m.devtoolsMu.Unlock()
+
+	if m.devtoolsConn == nil {
+		target, err := m.pageTarget(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, target.WebSocketDebuggerUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		m.devtoolsConn = conn
+	}
+	conn := m.devtoolsConn
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+
+	m.devtoolsSeq++
+	cmd := devtoolsCommand{Id: m.devtoolsSeq, Method: method, Params: params}
+	if err := conn.WriteJSON(cmd); err != nil {
+		m.closeDevtools()
+		return nil, err
+	}
+
+	// Chrome may push events before answering, skip until our id comes back
+	for {
+		resp := devtoolsResponse{}
+		if err := conn.ReadJSON(&resp); err != nil {
+			m.closeDevtools()
+			return nil, err
+		}
+		if resp.Id != cmd.Id {
+			continue
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("devtools: %s", resp.Error.Message)
+		}
+		return resp.Result, nil
+	}
+}
+
+// closeDevtools drops the DevTools connection, the next call dials again.
+// The caller holds devtoolsMu.
+func (m *VbrowserManager) closeDevtools() {
+	if m.devtoolsConn != nil {
+		m.devtoolsConn.Close()
+		m.devtoolsConn = nil
+	}
+}
+
+// CurrentUrl is the last url the browser was asked to show
+func (m *VbrowserManager) CurrentUrl() string {
+	m.devtoolsMu.Lock()
+	defer m.devtoolsMu.Unlock()
+	if m.currentUrl == "" {
+		return m.defaultUrl
+	}
+	return m.currentUrl
+}
+
+func (m *VbrowserManager) pageTarget(ctx context.Context) (*devtoolsTarget, error) {
+	endpoint := fmt.Sprintf("http://127.0.0.1:%d/json/list", m.DevtoolsPort)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	targets := make([]devtoolsTarget, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return nil, err
+	}
+
+	for _, t := range targets {
+		if t.Type == "page" && t.WebSocketDebuggerUrl != "" {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("no page target found on devtools port %d", m.DevtoolsPort)
+}
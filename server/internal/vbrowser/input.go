@@ -0,0 +1,73 @@
+package vbrowser
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+)
+
+var mouseEventTypes = map[string]string{
+	"mousemove": "mouseMoved",
+	"mousedown": "mousePressed",
+	"mouseup":   "mouseReleased",
+	"wheel":     "mouseWheel",
+}
+
+// Input replays a mouse or keyboard event of the remote holder in the page
+func (m *VbrowserManager) Input(ctx context.Context, ev dto.StreamInputPayload) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var err error
+	switch ev.Type {
+	case "mousemove", "mousedown", "mouseup", "wheel":
+		params := map[string]any{
+			"type": mouseEventTypes[ev.Type],
+			"x":    clamp(ev.X) * float64(m.Display.Width),
+			"y":    clamp(ev.Y) * float64(m.Display.Height),
+		}
+		switch ev.Type {
+		case "mousedown", "mouseup":
+			button := ev.Button
+			if button == "" {
+				button = "left"
+			}
+			params["button"] = button
+			params["clickCount"] = 1
+		case "wheel":
+			params["deltaX"] = ev.DeltaX
+			params["deltaY"] = ev.DeltaY
+		}
+		_, err = m.devtoolsCall(ctx, "Input.dispatchMouseEvent", params)
+
+	case "keydown", "keyup":
+		params := map[string]any{"type": "keyUp", "key": ev.Key, "code": ev.Code}
+		if ev.Type == "keydown" {
+			// A key that types something is a keyDown with its text, the
+			// others are raw key presses
+			params["type"] = "rawKeyDown"
+			if ev.Text != "" {
+				params["type"] = "keyDown"
+				params["text"] = ev.Text
+			}
+		}
+		_, err = m.devtoolsCall(ctx, "Input.dispatchKeyEvent", params)
+
+	case "text":
+		_, err = m.devtoolsCall(ctx, "Input.insertText", map[string]any{"text": ev.Text})
+
+	default:
+		return fmt.Errorf("unknown input event %q", ev.Type)
+	}
+	return err
+}
+
+func clamp(f float64) float64 {
+	if math.IsNaN(f) {
+		return 0
+	}
+	return math.Min(math.Max(f, 0), 1)
+}
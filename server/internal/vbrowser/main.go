@@ -2,6 +2,7 @@ package vbrowser
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
 )
 
 func (d *VbrowserManager) StartVirtualBrowser(ctx context.Context) {
@@ -45,15 +47,19 @@ func (d *VbrowserManager) StartVirtualBrowser(ctx context.Context) {
 	os.Setenv("DISPLAY", portStr)
 
 	// Start Chrome inside Xvfb
-	chromeCmd := exec.Command("google-chrome",
+	chromeArgs := []string{
 		fmt.Sprintf("--window-size=%d,%d", d.Display.Width, d.Display.Height),
 		"--no-sandbox",
 		"--disable-gpu",
 		"--new-window",
 		"--user-data-dir=./tmp/chrome-xvfb", // Separate profile
-		"--remote-debugging-port=9222",
-		d.defaultUrl,
-	)
+		fmt.Sprintf("--remote-debugging-port=%d", d.DevtoolsPort),
+	}
+	if d.ProxyUrl != "" {
+		// Chrome skips the proxy for loopback unless told otherwise
+		chromeArgs = append(chromeArgs, "--proxy-server="+d.ProxyUrl, "--proxy-bypass-list=<-loopback>")
+	}
+	chromeCmd := exec.Command("google-chrome", append(chromeArgs, d.defaultUrl)...)
 
 	chromeLog, _ := os.Create("chrome.log")
 	chromeCmd.Stdout = chromeLog
@@ -146,3 +152,95 @@ func (d *VbrowserManager) StartVideoStream(ctx context.Context) {
 		}
 	}
 }
+
+// Sink takes the encoded samples of a running stream
+type Sink interface {
+	WriteVideo(data []byte, duration time.Duration)
+	WriteAudio(data []byte, duration time.Duration)
+}
+
+// How long Stream waits for Xvfb, Chrome and the pipeline to come up
+const streamStartTimeout = 25 * time.Second
+
+// Stream starts the browser on startUrl with a quality preset and feeds the
+// samples of the pipeline to sink once it plays. Everything stops when ctx
+// is done.
+func (m *VbrowserManager) Stream(ctx context.Context, startUrl string, quality string, sink Sink) error {
+	m.Configure(startUrl, quality)
+	frameDuration := time.Second / time.Duration(m.Display.FPS)
+
+	go m.StartVirtualBrowser(ctx)
+	go m.StartVideoStream(ctx)
+
+	timeout := time.NewTimer(streamStartTimeout)
+	defer timeout.Stop()
+	for ready := false; !ready; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return errors.New("timed out starting the browser stream")
+		case step := <-m.ConnReady:
+			ready = step == StepPipelineReady
+		}
+	}
+
+	// VIDEO
+	elem, err := m.Pipeline.GetElementByName("videoSink")
+	if err != nil {
+		log.Println("Error in Stream[GetElementByName(videoSink)]:", err)
+		return err
+	}
+	videoSink := app.SinkFromElement(elem)
+	if videoSink == nil {
+		return fmt.Errorf("error in getting videoSink element from pipeline")
+	}
+	videoSink.SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(s *app.Sink) gst.FlowReturn {
+			data := pullSampleData(s)
+			if data == nil {
+				return gst.FlowEOS
+			}
+			sink.WriteVideo(data, frameDuration)
+			return gst.FlowOK
+		},
+	})
+
+	// AUDIO
+	elem, err = m.Pipeline.GetElementByName("audioSink")
+	if err != nil {
+		log.Println("Error in Stream[GetElementByName(audioSink)]:", err)
+		return err
+	}
+	audioSink := app.SinkFromElement(elem)
+	if audioSink == nil {
+		return fmt.Errorf("error in getting audioSink element from pipeline")
+	}
+	audioSink.SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(s *app.Sink) gst.FlowReturn {
+			data := pullSampleData(s)
+			if data == nil {
+				return gst.FlowEOS
+			}
+			sink.WriteAudio(data, frameDuration)
+			return gst.FlowOK
+		},
+	})
+	return nil
+}
+
+func pullSampleData(sink *app.Sink) []byte {
+	sample := sink.PullSample()
+	if sample == nil {
+		return nil
+	}
+	buffer := sample.GetBuffer()
+	if buffer == nil {
+		return nil
+	}
+	data := buffer.Bytes()
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-gst/go-gst/gst"
@@ -29,9 +30,38 @@ type VbrowserManager struct {
 	UdpAudioPort int
 	Ready        chan Step
 	ConnReady    chan Step
+	DevtoolsPort int
+	// Every connection of the browser goes through this proxy when set
+	ProxyUrl string
 
 	pid        int
 	defaultUrl string
+	currentUrl string
+	bitrate    int
+
+	// Guards the DevTools connection and currentUrl
+	devtoolsMu   sync.Mutex
+	devtoolsConn *websocket.Conn
+	devtoolsSeq  int
+}
+
+// Quality is a stream preset, chatrooms choose one by name in their settings
+type Quality struct {
+	Width   int
+	Height  int
+	FPS     int
+	Bitrate int // kbit/s
+}
+
+const DefaultQuality = "high"
+
+// The page browsers open on when the chatroom has no start url
+const homeUrl = "https://www.youtube.com/watch?v=OPK14FrnjO0&ab_channel=JackHarlow"
+
+var Qualities = map[string]Quality{
+	"low":    {Width: 1280, Height: 720, FPS: 30, Bitrate: 1500},
+	"medium": {Width: 1920, Height: 1080, FPS: 30, Bitrate: 3000},
+	"high":   {Width: 1920, Height: 1080, FPS: 60, Bitrate: 4000},
 }
 
 func NewManager(port int) *VbrowserManager {
@@ -39,10 +69,28 @@ func NewManager(port int) *VbrowserManager {
 		Display:      NewDisplay(port, 1080, 1920, 60),
 		Ready:        make(chan Step, 5),
 		ConnReady:    make(chan Step, 5),
-		defaultUrl:   "https://www.youtube.com/watch?v=OPK14FrnjO0&ab_channel=JackHarlow",
+		defaultUrl:   homeUrl,
 		UdpVideoPort: 5005,
 		UdpAudioPort: 5006,
+		DevtoolsPort: 9222,
+		bitrate:      Qualities[DefaultQuality].Bitrate,
+	}
+}
+
+// Configure sets the page the next browser opens on and the stream preset.
+// An empty url opens the home page, an unknown quality uses DefaultQuality.
+func (m *VbrowserManager) Configure(startUrl string, quality string) {
+	m.defaultUrl = startUrl
+	if startUrl == "" {
+		m.defaultUrl = homeUrl
+	}
+
+	q, ok := Qualities[quality]
+	if !ok {
+		q = Qualities[DefaultQuality]
 	}
+	m.Display.Width, m.Display.Height, m.Display.FPS = q.Width, q.Height, q.FPS
+	m.bitrate = q.Bitrate
 }
 
 func (m *VbrowserManager) SetWs(ws *websocket.Conn) {
@@ -62,9 +110,9 @@ func (m *VbrowserManager) SetupPipeline() error {
     ! video/x-raw,format=I420
     ! queue
     ! videoscale
-    ! video/x-raw,width=%d,height=%d,framerate=60/1
+    ! video/x-raw,width=%d,height=%d,framerate=%d/1
     ! queue
-    ! x264enc bitrate=4000 tune=zerolatency speed-preset=veryfast key-int-max=30
+    ! x264enc bitrate=%d tune=zerolatency speed-preset=veryfast key-int-max=30
     ! queue
     ! video/x-h264,stream-format=byte-stream
     ! queue
@@ -77,7 +125,7 @@ func (m *VbrowserManager) SetupPipeline() error {
     ! queue
     ! opusenc
     ! queue
-    ! appsink name=audioSink emit-signals=true sync=false`, m.Display.Port, width, height)
+    ! appsink name=audioSink emit-signals=true sync=false`, m.Display.Port, width, height, m.Display.FPS, m.bitrate)
 
 	pipeline, err := gst.NewPipelineFromString(pipelineStr)
 
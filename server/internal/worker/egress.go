@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"sideDesert/shiba/internal/server/unfurl"
+)
+
+const egressDialTimeout = 10 * time.Second
+
+// EgressProxy is the way out of the shared browser. The API node checks the
+// url a user opens, but the page can redirect, rebind its DNS or load from
+// any address. The proxy checks every connection once it is resolved, the
+// same way the unfurler does.
+type EgressProxy struct {
+	listener net.Listener
+	server   *http.Server
+	dialer   *net.Dialer
+	forward  *httputil.ReverseProxy
+}
+
+// ListenEgressProxy serves the proxy on addr. allowPrivate lets the browser
+// reach private addresses, like BROWSER_URL_ALLOW_PRIVATE on the API nodes.
+func ListenEgressProxy(addr string, allowPrivate bool) (*EgressProxy, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &EgressProxy{
+		listener: listener,
+		dialer:   &net.Dialer{Timeout: egressDialTimeout},
+	}
+	if !allowPrivate {
+		p.dialer.Control = unfurl.BlockPrivateAddresses
+	}
+	p.forward = &httputil.ReverseProxy{
+		// The request already names the upstream, only the hop-by-hop
+		// headers are dropped
+		Rewrite: func(*httputil.ProxyRequest) {},
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         p.dialer.DialContext,
+			TLSHandshakeTimeout: egressDialTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Println("Error in EgressProxy[forward]:", r.URL.Host, err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		},
+	}
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: egressDialTimeout}
+
+	go func() {
+		if err := p.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println("❌ Egress proxy stopped:", err)
+		}
+	}()
+	return p, nil
+}
+
+// Url is the value of the browser's --proxy-server flag
+func (p *EgressProxy) Url() string {
+	return "http://" + p.listener.Addr().String()
+}
+
+func (p *EgressProxy) Close() error {
+	return p.server.Close()
+}
+
+func (p *EgressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	p.forward.ServeHTTP(w, r)
+}
+
+// tunnel carries an https or websocket connection, the host is checked when
+// it is dialed and the bytes pass through untouched
+func (p *EgressProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dialer.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		log.Println("Error in EgressProxy[tunnel]:", r.Host, err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Println("Error in EgressProxy[Hijack]:", err)
+		upstream.Close()
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+
+	go pipe(upstream, buf.Reader, conn)
+	pipe(conn, upstream, upstream)
+}
+
+// pipe copies src to dst and closes both ends once either side is done
+func pipe(dst io.WriteCloser, src io.Reader, srcConn io.Closer) {
+	io.Copy(dst, src)
+	dst.Close()
+	srcConn.Close()
+}
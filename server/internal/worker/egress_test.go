@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func proxiedClient(t *testing.T, allowPrivate bool, upstream *httptest.Server) *http.Client {
+	proxy, err := ListenEgressProxy("127.0.0.1:0", allowPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+
+	proxyUrl, _ := url.Parse(proxy.Url())
+	transport := upstream.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyUrl)
+	return &http.Client{Transport: transport}
+}
+
+func TestEgressProxyRefusesPrivateAddresses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the proxy reached a private address")
+	}))
+	defer upstream.Close()
+	tlsUpstream := httptest.NewTLSServer(upstream.Config.Handler)
+	defer tlsUpstream.Close()
+
+	// Plain requests are forwarded, https is tunneled with CONNECT
+	for _, server := range []*httptest.Server{upstream, tlsUpstream} {
+		resp, err := proxiedClient(t, false, server).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadGateway {
+				t.Errorf("GET %s through the proxy = %d, want bad gateway", server.URL, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func TestEgressProxyAllowPrivate(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+	tlsUpstream := httptest.NewTLSServer(upstream.Config.Handler)
+	defer tlsUpstream.Close()
+
+	for _, server := range []*httptest.Server{upstream, tlsUpstream} {
+		resp, err := proxiedClient(t, true, server).Get(server.URL)
+		if err != nil {
+			t.Fatalf("GET %s through the proxy: %v", server.URL, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hello" {
+			t.Errorf("GET %s through the proxy = %q", server.URL, body)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// session is a chatroom streamed by the worker. The websockets watching it
+// can be open on any API node: their commands arrive on
+// lib.StreamCommandsSubject and the replies go to lib.ConnSubject.
+type session struct {
+	chatroomId string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	sub        *nats.Subscription
+
+	mu sync.Mutex
+	// Keyed by the id of the websocket the peer negotiated over
+	peers map[string]*peer
+}
+
+type peer struct {
+	connId string
+	userId string
+	stream *lib.StreamConfig
+}
+
+// startSession reserves a slot of the worker for chatroomId and starts
+// taking the commands for it
+func (w *Worker) startSession(chatroomId string) (*session, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &session{
+		chatroomId: chatroomId,
+		ctx:        ctx,
+		cancel:     cancel,
+		peers:      make(map[string]*peer),
+	}
+
+	w.mu.Lock()
+	switch {
+	case w.stopping || len(w.sessions) >= w.capacity:
+		w.mu.Unlock()
+		cancel()
+		return nil, errBusy
+	case w.sessions[chatroomId] != nil:
+		w.mu.Unlock()
+		cancel()
+		return nil, lib.Conflict("Streaming already taking place for this chatroom")
+	}
+	w.sessions[chatroomId] = s
+	w.mu.Unlock()
+
+	sub, err := w.nc.Subscribe(lib.StreamCommandsSubject(chatroomId), func(msg *nats.Msg) {
+		w.handleCommand(s, msg.Data)
+	})
+	if err != nil {
+		log.Println("Error in startSession[Subscribe]:", err)
+		w.stopSession(s)
+		return nil, err
+	}
+	s.sub = sub
+	return s, nil
+}
+
+// stopSession ends the stream of a session, the browser stops with its ctx
+func (w *Worker) stopSession(s *session) {
+	w.mu.Lock()
+	if w.sessions[s.chatroomId] != s {
+		w.mu.Unlock()
+		return
+	}
+	delete(w.sessions, s.chatroomId)
+	w.mu.Unlock()
+
+	if s.sub != nil {
+		s.sub.Unsubscribe()
+	}
+	s.cancel()
+	s.closePeers()
+	log.Println("⛔👍Stream Ended", s.chatroomId)
+}
+
+func (s *session) peer(connId string, userId string) *peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.peers[connId]
+	if p == nil || p.userId != userId {
+		return nil
+	}
+	return p
+}
+
+func (s *session) addPeer(p *peer) {
+	s.mu.Lock()
+	old := s.peers[p.connId]
+	s.peers[p.connId] = p
+	s.mu.Unlock()
+
+	if old != nil {
+		old.stream.PeerConnection.Close()
+	}
+}
+
+func (s *session) removePeer(connId string) {
+	s.mu.Lock()
+	p := s.peers[connId]
+	delete(s.peers, connId)
+	s.mu.Unlock()
+
+	if p != nil {
+		p.stream.PeerConnection.Close()
+	}
+}
+
+func (s *session) closePeers() {
+	s.mu.Lock()
+	peers := s.peers
+	s.peers = make(map[string]*peer)
+	s.mu.Unlock()
+
+	for _, p := range peers {
+		p.stream.PeerConnection.Close()
+	}
+}
+
+func (s *session) WriteVideo(data []byte, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.peers {
+		p.stream.VideoTrack.WriteSample(media.Sample{Data: data, Duration: duration})
+	}
+}
+
+func (s *session) WriteAudio(data []byte, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.peers {
+		p.stream.AudioTrack.WriteSample(media.Sample{Data: data, Duration: duration})
+	}
+}
+
+// handleCommand handles a command of a websocket watching the session. The
+// API node it came through already checked the user may send it.
+func (w *Worker) handleCommand(s *session, data []byte) {
+	cmd := dto.StreamCommand{}
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		log.Println("🔴 Error in handleCommand[Unmarshal]:", err)
+		return
+	}
+
+	switch cmd.Type {
+	case "join":
+		w.addPeer(s, cmd)
+
+	case "answer":
+		p := s.peer(cmd.ConnId, cmd.UserId)
+		if p == nil {
+			return
+		}
+		desc := webrtc.SessionDescription{}
+		if err := json.Unmarshal(cmd.Payload, &desc); err != nil {
+			log.Println("🔴Failed to unmarshal JSON to SessionDescription:", err)
+			return
+		}
+		if err := p.stream.PeerConnection.SetRemoteDescription(desc); err != nil {
+			log.Println("🔴Error setting remote description:", err)
+			return
+		}
+		log.Println("🔥 Webrtc Connection Established with", cmd.UserId, ":", cmd.ChatroomId)
+
+	case "ice":
+		p := s.peer(cmd.ConnId, cmd.UserId)
+		if p == nil {
+			return
+		}
+		candidate := webrtc.ICECandidateInit{}
+		if err := json.Unmarshal(cmd.Payload, &candidate); err != nil {
+			log.Println("🔴Failed to unmarshal JSON to ICECandidateInit:", err)
+			return
+		}
+		p.stream.PeerConnection.AddICECandidate(candidate)
+
+	case "disconnected":
+		if s.peer(cmd.ConnId, cmd.UserId) != nil {
+			log.Println("⭕User", cmd.UserId, "disconnected from", cmd.ChatroomId)
+			s.removePeer(cmd.ConnId)
+		}
+
+	case "input":
+		ev := dto.StreamInputPayload{}
+		if err := json.Unmarshal(cmd.Payload, &ev); err != nil {
+			log.Println("🔴 Error in handleCommand[input]:", err)
+			return
+		}
+		if err := w.browser.Input(s.ctx, ev); err != nil {
+			log.Println("🔴 Error in handleCommand[Input]:", err)
+		}
+	}
+}
+
+// addPeer creates the peer connection of a websocket joining the stream and
+// sends it the offer
+func (w *Worker) addPeer(s *session, cmd dto.StreamCommand) {
+	stream, err := lib.NewStreamConfig(cmd.UserId)
+	if err != nil {
+		log.Println("Error in addPeer[NewStreamConfig]:", err)
+		return
+	}
+	pc := stream.PeerConnection
+	suffix := s.chatroomId + "." + cmd.UserId
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		w.sendToConn(cmd.ConnId, dto.Message[webrtc.ICECandidateInit]{
+			Sender:  "server",
+			Subject: "stream.ice." + suffix,
+			Payload: candidate.ToJSON(),
+		})
+	})
+
+	sdp, err := pc.CreateOffer(&webrtc.OfferOptions{})
+	if err != nil {
+		log.Println("Error creating SDP offer:", err)
+		pc.Close()
+		return
+	}
+	if err := pc.SetLocalDescription(sdp); err != nil {
+		log.Println("Error setting local description:", err)
+		pc.Close()
+		return
+	}
+
+	s.addPeer(&peer{connId: cmd.ConnId, userId: cmd.UserId, stream: stream})
+	w.sendToConn(cmd.ConnId, dto.Message[string]{
+		Sender:  "server",
+		Subject: "stream.offer." + suffix,
+		Payload: sdp.SDP,
+	})
+	log.Println("✅ SDP offer sent to user ", cmd.UserId)
+}
@@ -0,0 +1,272 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	vb "sideDesert/shiba/internal/vbrowser"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Browser is the media side of a worker, a *vbrowser.VbrowserManager outside
+// of tests
+type Browser interface {
+	Stream(ctx context.Context, startUrl string, quality string, sink vb.Sink) error
+	Navigate(ctx context.Context, url string) error
+	Input(ctx context.Context, ev dto.StreamInputPayload) error
+}
+
+// Worker streams chatrooms for the API nodes. It takes start, stop and
+// navigate requests on lib.WorkerSubject, the signalling and input of the
+// websockets on lib.StreamCommandsSubject, and publishes its status on
+// lib.WorkerStatusSubject.
+type Worker struct {
+	id        string
+	nc        *nats.Conn
+	browser   Browser
+	capacity  int
+	startedAt time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	stopping bool
+}
+
+// New makes a worker with a single browser, which streams one chatroom at a
+// time
+func New(nc *nats.Conn, id string, browser Browser) *Worker {
+	if id == "" {
+		id = newWorkerId()
+	}
+	return &Worker{
+		id:        id,
+		nc:        nc,
+		browser:   browser,
+		capacity:  1,
+		startedAt: time.Now(),
+		sessions:  make(map[string]*session),
+	}
+}
+
+func newWorkerId() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "worker"
+	}
+	suffix, _ := lib.GenerateSecureRandomID(4)
+	return hostname + "-" + suffix
+}
+
+func (w *Worker) Id() string {
+	return w.id
+}
+
+// Run serves requests and publishes the status of the worker until ctx is
+// done, then it stops its streams
+func (w *Worker) Run(ctx context.Context) error {
+	handlers := map[string]nats.MsgHandler{
+		"start":    w.handleStart,
+		"stop":     w.handleStop,
+		"navigate": w.handleNavigate,
+	}
+	subs := make([]*nats.Subscription, 0, len(handlers))
+	defer func() {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+	}()
+	for op, handler := range handlers {
+		sub, err := w.nc.Subscribe(lib.WorkerSubject(w.id, op), handler)
+		if err != nil {
+			log.Println("Error in Worker.Run[Subscribe]:", err)
+			return err
+		}
+		subs = append(subs, sub)
+	}
+
+	log.Println("🎬 Worker", w.id, "ready")
+	w.publishStatus()
+
+	ticker := time.NewTicker(lib.WorkerStatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.publishStatus()
+		case <-ctx.Done():
+			w.shutdown()
+			return nil
+		}
+	}
+}
+
+// shutdown stops every stream and tells the API nodes the worker is gone
+func (w *Worker) shutdown() {
+	w.mu.Lock()
+	w.stopping = true
+	sessions := make([]*session, 0, len(w.sessions))
+	for _, s := range w.sessions {
+		sessions = append(sessions, s)
+	}
+	w.mu.Unlock()
+
+	for _, s := range sessions {
+		w.stopSession(s)
+	}
+	w.publishStatus()
+	w.nc.Flush()
+	log.Println("⛔ Worker", w.id, "stopped")
+}
+
+// Status is what the worker publishes about itself
+func (w *Worker) Status() dto.WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rooms := make([]string, 0, len(w.sessions))
+	for chatroomId := range w.sessions {
+		rooms = append(rooms, chatroomId)
+	}
+	return dto.WorkerStatus{
+		WorkerId:  w.id,
+		Capacity:  w.capacity,
+		Rooms:     rooms,
+		Healthy:   !w.stopping,
+		StartedAt: w.startedAt,
+	}
+}
+
+func (w *Worker) publishStatus() {
+	data, err := json.Marshal(w.Status())
+	if err != nil {
+		log.Println("Error in publishStatus[Marshal]:", err)
+		return
+	}
+	if err := w.nc.Publish(lib.WorkerStatusSubject, data); err != nil {
+		log.Println("Error in publishStatus[Publish]:", err)
+	}
+}
+
+func reply(msg *nats.Msg, err error) {
+	res := dto.WorkerReply{}
+	if err != nil {
+		var apiErr *lib.ApiError
+		if !errors.As(err, &apiErr) {
+			apiErr = lib.Unavailable(err.Error())
+		}
+		res.Status, res.Code, res.Error = apiErr.Status, apiErr.Code, apiErr.Message
+	}
+	data, _ := json.Marshal(res)
+	if err := msg.Respond(data); err != nil {
+		log.Println("Error in worker reply[Respond]:", err)
+	}
+}
+
+var (
+	errBusy         = lib.Unavailable("Streaming worker is at capacity")
+	errNotStreaming = lib.NotFound("Chatroom is not streaming on this worker")
+)
+
+// handleStart starts the browser for a chatroom and replies once it streams
+func (w *Worker) handleStart(msg *nats.Msg) {
+	req := dto.WorkerStartRequest{}
+	if err := json.Unmarshal(msg.Data, &req); err != nil || req.ChatroomId == "" {
+		reply(msg, lib.BadRequest("Invalid start request"))
+		return
+	}
+
+	s, err := w.startSession(req.ChatroomId)
+	if err != nil {
+		reply(msg, err)
+		return
+	}
+
+	if err := w.browser.Stream(s.ctx, req.StartUrl, req.Quality, s); err != nil {
+		log.Println("🔴 Error in handleStart[Stream]:", err)
+		w.stopSession(s)
+		reply(msg, err)
+		return
+	}
+
+	// The members may be connected to any API node, each one sends a join
+	// for their websockets
+	w.requestJoins(req.ChatroomId, req.MemberIds)
+	w.publishStatus()
+	reply(msg, nil)
+	log.Println("🎥 Streaming", req.ChatroomId)
+}
+
+func (w *Worker) handleStop(msg *nats.Msg) {
+	req := dto.WorkerStopRequest{}
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		reply(msg, lib.BadRequest("Invalid stop request"))
+		return
+	}
+
+	w.mu.Lock()
+	s := w.sessions[req.ChatroomId]
+	w.mu.Unlock()
+	if s == nil {
+		reply(msg, errNotStreaming)
+		return
+	}
+
+	w.stopSession(s)
+	w.publishStatus()
+	reply(msg, nil)
+}
+
+func (w *Worker) handleNavigate(msg *nats.Msg) {
+	req := dto.WorkerNavigateRequest{}
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		reply(msg, lib.BadRequest("Invalid navigate request"))
+		return
+	}
+
+	w.mu.Lock()
+	s := w.sessions[req.ChatroomId]
+	w.mu.Unlock()
+	if s == nil {
+		reply(msg, errNotStreaming)
+		return
+	}
+
+	if err := w.browser.Navigate(s.ctx, req.Url); err != nil {
+		log.Println("🔴 Error in handleNavigate[Navigate]:", err)
+		reply(msg, lib.Unavailable("Could not open url in browser"))
+		return
+	}
+	reply(msg, nil)
+}
+
+// requestJoins asks every API node for the open websockets of the members
+func (w *Worker) requestJoins(chatroomId string, memberIds []string) {
+	data, err := json.Marshal(dto.StreamJoinRequest{ChatroomId: chatroomId, MemberIds: memberIds})
+	if err != nil {
+		log.Println("Error in requestJoins[Marshal]:", err)
+		return
+	}
+	if err := w.nc.Publish(lib.StreamJoinSubject(chatroomId), data); err != nil {
+		log.Println("Error in requestJoins[Publish]:", err)
+	}
+}
+
+// sendToConn publishes a message to a websocket on any API node
+func (w *Worker) sendToConn(connId string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Error in sendToConn[Marshal]:", err)
+		return
+	}
+	if err := w.nc.Publish(lib.ConnSubject(connId), data); err != nil {
+		log.Println("Error in sendToConn[Publish]:", err)
+	}
+}
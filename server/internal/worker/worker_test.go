@@ -0,0 +1,226 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sideDesert/shiba/internal/natstest"
+	"sideDesert/shiba/internal/server/dto"
+	"sideDesert/shiba/internal/server/lib"
+	vb "sideDesert/shiba/internal/vbrowser"
+
+	"github.com/nats-io/nats.go"
+)
+
+// testBrowser streams nothing and records what the worker asks of it
+type testBrowser struct {
+	streams   chan context.Context
+	navigated chan string
+	inputs    chan dto.StreamInputPayload
+}
+
+func newTestBrowser() *testBrowser {
+	return &testBrowser{
+		streams:   make(chan context.Context, 4),
+		navigated: make(chan string, 4),
+		inputs:    make(chan dto.StreamInputPayload, 4),
+	}
+}
+
+func (b *testBrowser) Stream(ctx context.Context, startUrl string, quality string, sink vb.Sink) error {
+	b.streams <- ctx
+	return nil
+}
+
+func (b *testBrowser) Navigate(ctx context.Context, url string) error {
+	b.navigated <- url
+	return nil
+}
+
+func (b *testBrowser) Input(ctx context.Context, ev dto.StreamInputPayload) error {
+	b.inputs <- ev
+	return nil
+}
+
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+// subscribe forwards the messages on subject to a channel
+func subscribe(t *testing.T, nc *nats.Conn, subject string) chan *nats.Msg {
+	t.Helper()
+	ch := make(chan *nats.Msg, 16)
+	if _, err := nc.ChanSubscribe(subject, ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+func request(t *testing.T, nc *nats.Conn, workerId string, op string, req any) dto.WorkerReply {
+	t.Helper()
+	data, _ := json.Marshal(req)
+	msg, err := nc.Request(lib.WorkerSubject(workerId, op), data, 5*time.Second)
+	if err != nil {
+		t.Fatalf("%s request: %v", op, err)
+	}
+	res := dto.WorkerReply{}
+	if err := json.Unmarshal(msg.Data, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestWorker(t *testing.T) {
+	natsServer := natstest.NewServer(t)
+	api := natsServer.Connect(t)
+	statuses := subscribe(t, api, lib.WorkerStatusSubject)
+	joins := subscribe(t, api, lib.StreamJoinSubject("*"))
+
+	browser := newTestBrowser()
+	w := New(natsServer.Connect(t), "w1", browser)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	status := dto.WorkerStatus{}
+	json.Unmarshal(receive(t, statuses, "the first status").Data, &status)
+	if status.WorkerId != "w1" || !status.Healthy || len(status.Rooms) != 0 {
+		t.Fatalf("the worker came up with %+v", status)
+	}
+
+	const chatroomId = "room-1"
+	res := request(t, api, "w1", "start", dto.WorkerStartRequest{
+		ChatroomId: chatroomId,
+		StartUrl:   "https://example.com",
+		MemberIds:  []string{"ada", "bob"},
+	})
+	if res.Error != "" {
+		t.Fatalf("starting gave %+v", res)
+	}
+	streamCtx := receive(t, browser.streams, "the browser to stream")
+	join := dto.StreamJoinRequest{}
+	json.Unmarshal(receive(t, joins, "the join request").Data, &join)
+	if join.ChatroomId != chatroomId || !slices.Equal(join.MemberIds, []string{"ada", "bob"}) {
+		t.Errorf("the worker asked for %+v", join)
+	}
+	json.Unmarshal(receive(t, statuses, "the status").Data, &status)
+	if !slices.Equal(status.Rooms, []string{chatroomId}) {
+		t.Errorf("the status lists %v", status.Rooms)
+	}
+
+	// The worker streams a single room
+	if res := request(t, api, "w1", "start", dto.WorkerStartRequest{ChatroomId: "room-2"}); res.Status != http.StatusServiceUnavailable {
+		t.Errorf("starting a second room gave %+v", res)
+	}
+
+	// A websocket joining gets an offer on its subject
+	offers := subscribe(t, api, lib.ConnSubject("conn-1"))
+	publish := func(cmd dto.StreamCommand) {
+		data, _ := json.Marshal(cmd)
+		api.Publish(lib.StreamCommandsSubject(chatroomId), data)
+	}
+	publish(dto.StreamCommand{ConnId: "conn-1", UserId: "bob", ChatroomId: chatroomId, Type: "join"})
+	for {
+		msg := dto.Message[json.RawMessage]{}
+		json.Unmarshal(receive(t, offers, "the offer").Data, &msg)
+		if msg.Subject == "stream.offer."+chatroomId+".bob" {
+			if !strings.Contains(string(msg.Payload), "v=0") {
+				t.Errorf("the offer is %s", msg.Payload)
+			}
+			break
+		}
+	}
+	w.mu.Lock()
+	s := w.sessions[chatroomId]
+	w.mu.Unlock()
+	if s.peer("conn-1", "bob") == nil {
+		t.Error("the peer of conn-1 is missing")
+	}
+	if s.peer("conn-1", "ada") != nil {
+		t.Error("another user got the peer of conn-1")
+	}
+
+	ev := dto.StreamInputPayload{Type: "mousedown", X: 0.25, Y: 0.75, Button: "left"}
+	payload, _ := json.Marshal(ev)
+	publish(dto.StreamCommand{ConnId: "conn-2", UserId: "ada", ChatroomId: chatroomId, Type: "input", Payload: payload})
+	if got := receive(t, browser.inputs, "the input"); got != ev {
+		t.Errorf("the browser got %+v, want %+v", got, ev)
+	}
+
+	if res := request(t, api, "w1", "navigate", dto.WorkerNavigateRequest{ChatroomId: chatroomId, Url: "https://example.org"}); res.Error != "" {
+		t.Errorf("navigating gave %+v", res)
+	}
+	if url := receive(t, browser.navigated, "the navigation"); url != "https://example.org" {
+		t.Errorf("the browser opened %s", url)
+	}
+	if res := request(t, api, "w1", "navigate", dto.WorkerNavigateRequest{ChatroomId: "room-2"}); res.Status != http.StatusNotFound {
+		t.Errorf("navigating a room the worker doesn't stream gave %+v", res)
+	}
+
+	publish(dto.StreamCommand{ConnId: "conn-1", UserId: "bob", ChatroomId: chatroomId, Type: "disconnected"})
+	waitFor(t, "the peer to leave", func() bool { return s.peer("conn-1", "bob") == nil })
+
+	if res := request(t, api, "w1", "stop", dto.WorkerStopRequest{ChatroomId: chatroomId}); res.Error != "" {
+		t.Fatalf("stopping gave %+v", res)
+	}
+	select {
+	case <-streamCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("the browser kept streaming")
+	}
+	if res := request(t, api, "w1", "stop", dto.WorkerStopRequest{ChatroomId: chatroomId}); res.Status != http.StatusNotFound {
+		t.Errorf("stopping twice gave %+v", res)
+	}
+
+	// The room is free again and the worker says it's going away when it stops
+	if res := request(t, api, "w1", "start", dto.WorkerStartRequest{ChatroomId: "room-2"}); res.Error != "" {
+		t.Fatalf("starting after a stop gave %+v", res)
+	}
+	streamCtx = receive(t, browser.streams, "the browser to stream")
+	cancel()
+	if err := receive(t, done, "the worker to stop"); err != nil {
+		t.Error(err)
+	}
+	done <- nil
+	<-streamCtx.Done()
+	for {
+		json.Unmarshal(receive(t, statuses, "the last status").Data, &status)
+		if !status.Healthy {
+			break
+		}
+	}
+	if len(status.Rooms) != 0 {
+		t.Errorf("the stopped worker still lists %v", status.Rooms)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}